@@ -0,0 +1,225 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/softwok/mongo-util/match"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// accumulator accumulates the values of a $group (or $bucket) output field.
+type accumulator interface {
+	add(v interface{}) error
+	result() interface{}
+}
+
+// newAccumulator returns a new accumulator for the operator. The argument is only
+// used by accumulators that need it (e.g. $count takes an empty document).
+func newAccumulator(op string, arg interface{}) (accumulator, error) {
+	switch op {
+	case o.Sum:
+		return &sumAcc{sum: int32(0)}, nil
+	case o.Avg:
+		return &avgAcc{}, nil
+	case o.Min, o.Max:
+		return &minMaxAcc{max: op == o.Max}, nil
+	case o.First:
+		return &firstAcc{}, nil
+	case o.Last:
+		return &lastAcc{val: missing}, nil
+	case o.Push:
+		return &pushAcc{list: bson.A{}}, nil
+	case o.AddToSet:
+		return &pushAcc{list: bson.A{}, set: true}, nil
+	case o.StdDevPop, o.StdDevSamp:
+		return &stdDevAcc{samp: op == o.StdDevSamp}, nil
+	case o.MergeObjects:
+		return &mergeAcc{doc: bson.D{}}, nil
+	case o.Count:
+		if d, ok := arg.(bson.D); !ok || len(d) != 0 {
+			return nil, fmt.Errorf("aggregate: %s accumulator takes an empty document", op)
+		}
+		return &sumAcc{sum: int32(0)}, nil
+	}
+
+	return nil, fmt.Errorf("aggregate: unsupported accumulator %s", op)
+}
+
+type sumAcc struct {
+	sum interface{}
+}
+
+func (a *sumAcc) add(v interface{}) error {
+	if isNumber(v) {
+		a.sum = numOp(a.sum, v, func(x, y int64) (int64, bool) {
+			r := x + y
+			return r, (r > x) == (y > 0)
+		}, func(x, y float64) float64 { return x + y })
+	}
+	return nil
+}
+
+func (a *sumAcc) result() interface{} {
+	return a.sum
+}
+
+type avgAcc struct {
+	sum   float64
+	count int
+}
+
+func (a *avgAcc) add(v interface{}) error {
+	if isNumber(v) {
+		a.sum += toFloat(v)
+		a.count++
+	}
+	return nil
+}
+
+func (a *avgAcc) result() interface{} {
+	if a.count == 0 {
+		return nil
+	}
+	return a.sum / float64(a.count)
+}
+
+type minMaxAcc struct {
+	max bool
+	val interface{}
+}
+
+func (a *minMaxAcc) add(v interface{}) error {
+	if isNullish(v) {
+		return nil
+	}
+	if a.val == nil {
+		a.val = v
+		return nil
+	}
+	c := match.Compare(v, a.val)
+	if (a.max && c > 0) || (!a.max && c < 0) {
+		a.val = v
+	}
+	return nil
+}
+
+func (a *minMaxAcc) result() interface{} {
+	return a.val
+}
+
+type firstAcc struct {
+	set bool
+	val interface{}
+}
+
+func (a *firstAcc) add(v interface{}) error {
+	if !a.set {
+		a.val, a.set = v, true
+	}
+	return nil
+}
+
+func (a *firstAcc) result() interface{} {
+	if a.val == missing {
+		return nil
+	}
+	return a.val
+}
+
+type lastAcc struct {
+	val interface{}
+}
+
+func (a *lastAcc) add(v interface{}) error {
+	a.val = v
+	return nil
+}
+
+func (a *lastAcc) result() interface{} {
+	if a.val == missing {
+		return nil
+	}
+	return a.val
+}
+
+type pushAcc struct {
+	set  bool
+	list bson.A
+}
+
+func (a *pushAcc) add(v interface{}) error {
+	if v == missing {
+		return nil
+	}
+	if a.set {
+		for _, item := range a.list {
+			if match.Compare(item, v) == 0 {
+				return nil
+			}
+		}
+	}
+	a.list = append(a.list, v)
+	return nil
+}
+
+func (a *pushAcc) result() interface{} {
+	return a.list
+}
+
+type stdDevAcc struct {
+	samp   bool
+	values []float64
+}
+
+func (a *stdDevAcc) add(v interface{}) error {
+	if isNumber(v) {
+		a.values = append(a.values, toFloat(v))
+	}
+	return nil
+}
+
+func (a *stdDevAcc) result() interface{} {
+	n := float64(len(a.values))
+	if n == 0 || (a.samp && n < 2) {
+		return nil
+	}
+
+	mean := 0.0
+	for _, v := range a.values {
+		mean += v
+	}
+	mean /= n
+
+	variance := 0.0
+	for _, v := range a.values {
+		variance += (v - mean) * (v - mean)
+	}
+	if a.samp {
+		return math.Sqrt(variance / (n - 1))
+	}
+	return math.Sqrt(variance / n)
+}
+
+type mergeAcc struct {
+	doc bson.D
+}
+
+func (a *mergeAcc) add(v interface{}) error {
+	if isNullish(v) {
+		return nil
+	}
+	d, ok := v.(bson.D)
+	if !ok {
+		return fmt.Errorf("aggregate: %s requires object inputs", o.MergeObjects)
+	}
+	for _, e := range d {
+		a.doc = setField(a.doc, e.Key, e.Value)
+	}
+	return nil
+}
+
+func (a *mergeAcc) result() interface{} {
+	return a.doc
+}
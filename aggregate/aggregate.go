@@ -0,0 +1,849 @@
+// Package aggregate runs aggregation pipelines against in-memory collections, so
+// that pipelines built with the `builder` package can be unit-tested without a
+// MongoDB server.
+//
+// The supported stages are $match, $project, $addFields ($set), $group, $sort,
+// $sortByCount, $limit, $skip, $unwind, $count, $bucket, $facet, $replaceRoot
+// ($replaceWith), $lookup (with localField and foreignField) and $graphLookup.
+// Running a pipeline with any other stage, or with an unsupported expression
+// operator, returns an error.
+package aggregate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/softwok/mongo-util/builder"
+	f "github.com/softwok/mongo-util/field"
+	"github.com/softwok/mongo-util/match"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Evaluator runs aggregation pipelines against in-memory collections.
+type Evaluator struct {
+	colls map[string][]bson.D
+}
+
+// New returns a new evaluator without any collection.
+func New() *Evaluator {
+	return &Evaluator{colls: map[string][]bson.D{}}
+}
+
+// Insert adds the documents to the named collection. Documents can be any value that
+// can be marshaled to a BSON document (e.g. bson.M, bson.D, models).
+func (e *Evaluator) Insert(collName string, docs ...interface{}) error {
+	for _, doc := range docs {
+		d, err := match.ToDoc(doc)
+		if err != nil {
+			return err
+		}
+		e.colls[collName] = append(e.colls[collName], d)
+	}
+	return nil
+}
+
+// Run runs the pipeline against the named collection and returns the resulting documents.
+// The value of `stages` can be Operator|bson.M|bson.D, or a whole pipeline (bson.A, mongo.Pipeline).
+func (e *Evaluator) Run(collName string, stages ...interface{}) ([]bson.D, error) {
+	pipeline, err := toPipeline(stages)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]bson.D, 0, len(e.colls[collName]))
+	for _, doc := range e.colls[collName] {
+		docs = append(docs, copyDoc(doc))
+	}
+
+	for _, stage := range pipeline {
+		if len(stage) != 1 {
+			return nil, errors.New("aggregate: a pipeline stage must contain exactly one field")
+		}
+		if docs, err = e.stage(docs, stage[0].Key, stage[0].Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return docs, nil
+}
+
+// RunAll runs the pipeline against the named collection and decodes the resulting
+// documents into results, which must be a pointer to a slice.
+func (e *Evaluator) RunAll(collName string, results interface{}, stages ...interface{}) error {
+	resultsVal := reflect.ValueOf(results)
+	if resultsVal.Kind() != reflect.Ptr || resultsVal.Elem().Kind() != reflect.Slice {
+		return errors.New("aggregate: results argument must be a pointer to a slice")
+	}
+
+	docs, err := e.Run(collName, stages...)
+	if err != nil {
+		return err
+	}
+
+	sliceVal := resultsVal.Elem().Slice(0, 0)
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		elem := reflect.New(sliceVal.Type().Elem())
+		if err = bson.Unmarshal(raw, elem.Interface()); err != nil {
+			return err
+		}
+		sliceVal = reflect.Append(sliceVal, elem.Elem())
+	}
+	resultsVal.Elem().Set(sliceVal)
+
+	return nil
+}
+
+func toPipeline(stages []interface{}) ([]bson.D, error) {
+	var list bson.A
+	for _, stage := range stages {
+		switch s := stage.(type) {
+		case builder.Operator:
+			list = append(list, builder.S(s))
+		case bson.A:
+			list = append(list, s...)
+		case []interface{}:
+			list = append(list, s...)
+		case mongo.Pipeline:
+			for _, item := range s {
+				list = append(list, item)
+			}
+		case []bson.D:
+			for _, item := range s {
+				list = append(list, item)
+			}
+		default:
+			list = append(list, s)
+		}
+	}
+
+	var out struct {
+		Pipeline []bson.D `bson:"pipeline"`
+	}
+	raw, err := bson.Marshal(bson.M{"pipeline": list})
+	if err != nil {
+		return nil, err
+	}
+	if err = bson.Unmarshal(raw, &out); err != nil {
+		return nil, err
+	}
+	return out.Pipeline, nil
+}
+
+func (e *Evaluator) stage(docs []bson.D, name string, spec interface{}) ([]bson.D, error) {
+	switch name {
+	case o.Match:
+		return matchStage(docs, spec)
+	case o.Project:
+		return projectStage(docs, spec)
+	case o.AddFields, o.Set:
+		return addFieldsStage(docs, spec)
+	case o.Group:
+		return groupStage(docs, spec)
+	case o.Sort:
+		return sortStage(docs, spec)
+	case o.SortByCount:
+		return sortByCountStage(docs, spec)
+	case o.Limit:
+		n, ok := toInt(spec)
+		if !ok || n <= 0 {
+			return nil, fmt.Errorf("aggregate: %s must be a positive integer", name)
+		}
+		if n < len(docs) {
+			docs = docs[:n]
+		}
+		return docs, nil
+	case o.Skip:
+		n, ok := toInt(spec)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("aggregate: %s must be a non-negative integer", name)
+		}
+		if n >= len(docs) {
+			return []bson.D{}, nil
+		}
+		return docs[n:], nil
+	case o.Unwind:
+		return unwindStage(docs, spec)
+	case o.Count:
+		field, ok := spec.(string)
+		if !ok || field == "" || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+			return nil, fmt.Errorf("aggregate: %s must be a nonempty field name", name)
+		}
+		if len(docs) == 0 {
+			return []bson.D{}, nil
+		}
+		return []bson.D{{{Key: field, Value: int32(len(docs))}}}, nil
+	case o.Bucket:
+		return bucketStage(docs, spec)
+	case o.Facet:
+		return e.facetStage(docs, spec)
+	case o.ReplaceRoot, o.ReplaceWith:
+		return replaceRootStage(docs, name, spec)
+	case o.Lookup:
+		return e.lookupStage(docs, spec)
+	case o.GraphLookup:
+		return e.graphLookupStage(docs, spec)
+	}
+
+	return nil, fmt.Errorf("aggregate: unsupported stage %s", name)
+}
+
+func matchStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	filter, err := match.New(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		if filter.MatchDoc(doc) {
+			out = append(out, doc)
+		}
+	}
+	return out, nil
+}
+
+func addFieldsStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: %s requires a document", o.AddFields)
+	}
+
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		s := newScope(doc)
+		result := doc
+		for _, field := range fields {
+			var err error
+			if result, err = addField(s, result, field.Key, field.Value); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, result)
+	}
+	return out, nil
+}
+
+// addField sets the field to the value of the expression. Expression objects are
+// merged into the embedded documents of the field.
+func addField(s *scope, doc bson.D, key string, expr interface{}) (bson.D, error) {
+	if d, ok := expr.(bson.D); ok && len(d) > 0 && !strings.HasPrefix(d[0].Key, "$") {
+		for _, sub := range d {
+			var err error
+			if doc, err = addField(s, doc, key+"."+sub.Key, sub.Value); err != nil {
+				return nil, err
+			}
+		}
+		return doc, nil
+	}
+
+	val, err := s.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+	return setPath(doc, strings.Split(key, "."), val), nil
+}
+
+// setPath sets the value at the path. Arrays found on the path get the value set
+// in each of their documents. Missing values remove the field.
+func setPath(doc bson.D, parts []string, val interface{}) bson.D {
+	if len(parts) == 1 {
+		if val == missing {
+			return removeField(doc, parts[0])
+		}
+		return setField(doc, parts[0], val)
+	}
+
+	for i, e := range doc {
+		if e.Key != parts[0] {
+			continue
+		}
+		out := copyDoc(doc)
+		out[i].Value = setNested(e.Value, parts[1:], val)
+		return out
+	}
+	if val == missing {
+		return doc
+	}
+	return setField(doc, parts[0], setPath(bson.D{}, parts[1:], val))
+}
+
+func setNested(v interface{}, parts []string, val interface{}) interface{} {
+	switch d := v.(type) {
+	case bson.D:
+		return setPath(d, parts, val)
+	case bson.A:
+		out := make(bson.A, len(d))
+		for i, item := range d {
+			out[i] = setNested(item, parts, val)
+		}
+		return out
+	}
+	return setPath(bson.D{}, parts, val)
+}
+
+// setField sets the top-level field, keeping its position if it already exists.
+func setField(doc bson.D, key string, val interface{}) bson.D {
+	out := make(bson.D, 0, len(doc)+1)
+	found := false
+	for _, e := range doc {
+		if e.Key == key {
+			e.Value, found = val, true
+		}
+		out = append(out, e)
+	}
+	if !found {
+		out = append(out, bson.E{Key: key, Value: val})
+	}
+	return out
+}
+
+func removeField(doc bson.D, key string) bson.D {
+	out := make(bson.D, 0, len(doc))
+	for _, e := range doc {
+		if e.Key != key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func groupStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	return groupByIDs(docs, nil, spec)
+}
+
+// groupByIDs is groupStage with the group ids of the documents, if not nil, rather than those of the
+// _id expression, so that the documents don't need a field holding a computed id (e.g. for $bucket).
+func groupByIDs(docs []bson.D, ids []interface{}, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: %s requires a document", o.Group)
+	}
+
+	var idExpr interface{}
+	hasID := false
+	type output struct {
+		key  string
+		op   string
+		expr interface{}
+	}
+	var outputs []output
+	for _, field := range fields {
+		if field.Key == f.ID {
+			idExpr, hasID = field.Value, true
+			continue
+		}
+		acc, ok := field.Value.(bson.D)
+		if !ok || len(acc) != 1 {
+			return nil, fmt.Errorf("aggregate: the field '%s' must be an accumulator object", field.Key)
+		}
+		if _, err := newAccumulator(acc[0].Key, acc[0].Value); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output{key: field.Key, op: acc[0].Key, expr: acc[0].Value})
+	}
+	if !hasID {
+		return nil, fmt.Errorf("aggregate: a %s specification must include an _id", o.Group)
+	}
+
+	type group struct {
+		id   interface{}
+		accs []accumulator
+	}
+	var groups []*group
+	for n, doc := range docs {
+		s := newScope(doc)
+		var id interface{}
+		var err error
+		if ids != nil {
+			id = ids[n]
+		} else if id, err = s.eval(idExpr); err != nil {
+			return nil, err
+		}
+		if id == missing {
+			id = nil
+		}
+
+		var g *group
+		for _, existing := range groups {
+			if compareValues(existing.id, id) == 0 {
+				g = existing
+				break
+			}
+		}
+		if g == nil {
+			g = &group{id: id}
+			for _, out := range outputs {
+				acc, _ := newAccumulator(out.op, out.expr)
+				g.accs = append(g.accs, acc)
+			}
+			groups = append(groups, g)
+		}
+
+		for i, out := range outputs {
+			var val interface{} = int32(1)
+			if out.op != o.Count {
+				if val, err = s.eval(out.expr); err != nil {
+					return nil, err
+				}
+			}
+			if err = g.accs[i].add(val); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := make([]bson.D, 0, len(groups))
+	for _, g := range groups {
+		doc := bson.D{{Key: f.ID, Value: g.id}}
+		for i, out := range outputs {
+			doc = append(doc, bson.E{Key: out.key, Value: g.accs[i].result()})
+		}
+		result = append(result, doc)
+	}
+	return result, nil
+}
+
+func sortStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	keys, ok := spec.(bson.D)
+	if !ok || len(keys) == 0 {
+		return nil, fmt.Errorf("aggregate: %s requires a nonempty document", o.Sort)
+	}
+	for _, k := range keys {
+		dir, ok := toInt(k.Value)
+		if !ok || (dir != 1 && dir != -1) {
+			return nil, fmt.Errorf("aggregate: %s direction of '%s' must be 1 or -1", o.Sort, k.Key)
+		}
+	}
+
+	out := make([]bson.D, len(docs))
+	copy(out, docs)
+	sort.SliceStable(out, func(i, j int) bool {
+		for _, k := range keys {
+			dir, _ := toInt(k.Value)
+			a, b := sortValue(out[i], k.Key, dir < 0), sortValue(out[j], k.Key, dir < 0)
+			if c := match.Compare(a, b); c != 0 {
+				return c*dir < 0
+			}
+		}
+		return false
+	})
+	return out, nil
+}
+
+// sortValue returns the value used to sort by the path. Arrays are sorted by their
+// lowest element in ascending sorts, and by their highest element in descending sorts.
+func sortValue(doc bson.D, path string, desc bool) interface{} {
+	values := match.Lookup(doc, path)
+	if len(values) == 0 {
+		return nil
+	}
+	arr, ok := values[0].(bson.A)
+	if !ok || len(arr) == 0 {
+		return values[0]
+	}
+
+	best := arr[0]
+	for _, v := range arr[1:] {
+		c := match.Compare(v, best)
+		if (desc && c > 0) || (!desc && c < 0) {
+			best = v
+		}
+	}
+	return best
+}
+
+// sortByCountStage groups the documents by the expression and sorts the groups by
+// descending count. Groups with the same count keep their first-seen order.
+func sortByCountStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	if d, ok := spec.(bson.D); ok && (len(d) == 0 || !strings.HasPrefix(d[0].Key, "$")) {
+		return nil, fmt.Errorf("aggregate: %s requires a field path or an expression object", o.SortByCount)
+	}
+	if str, ok := spec.(string); ok && !strings.HasPrefix(str, "$") {
+		return nil, fmt.Errorf("aggregate: %s requires a field path or an expression object", o.SortByCount)
+	}
+
+	groups, err := groupStage(docs, bson.D{
+		{Key: f.ID, Value: spec},
+		{Key: f.Count, Value: bson.D{{Key: o.Sum, Value: int32(1)}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortStage(groups, bson.D{{Key: f.Count, Value: int32(-1)}})
+}
+
+func unwindStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	var path, indexField string
+	preserve := false
+	switch s := spec.(type) {
+	case string:
+		path = s
+	case bson.D:
+		for _, e := range s {
+			switch e.Key {
+			case f.Path:
+				path, _ = e.Value.(string)
+			case f.IncludeArrayIndex:
+				indexField, _ = e.Value.(string)
+			case f.PreserveNullAndEmptyArrays:
+				preserve = truthy(e.Value)
+			default:
+				return nil, fmt.Errorf("aggregate: unrecognized option to %s: %s", o.Unwind, e.Key)
+			}
+		}
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("aggregate: %s path must be prefixed by a '$'", o.Unwind)
+	}
+	parts := strings.Split(path[1:], ".")
+
+	var out []bson.D
+	for _, doc := range docs {
+		val := getPath(doc, parts)
+		arr, isArray := val.(bson.A)
+
+		switch {
+		case isArray && len(arr) > 0:
+			for i, item := range arr {
+				d := setPath(doc, parts, item)
+				if indexField != "" {
+					d = setPath(d, strings.Split(indexField, "."), int64(i))
+				}
+				out = append(out, d)
+			}
+		case !isArray && !isNullish(val):
+			d := doc
+			if indexField != "" {
+				d = setPath(d, strings.Split(indexField, "."), nil)
+			}
+			out = append(out, d)
+		case preserve:
+			d := doc
+			if isArray {
+				d = setPath(d, parts, missing)
+			}
+			if indexField != "" {
+				d = setPath(d, strings.Split(indexField, "."), nil)
+			}
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func bucketStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: %s requires a document", o.Bucket)
+	}
+
+	var groupBy interface{}
+	var boundaries bson.A
+	var def interface{} = missing
+	output := bson.D{{Key: f.Count, Value: bson.D{{Key: o.Sum, Value: int32(1)}}}}
+	for _, e := range fields {
+		switch e.Key {
+		case f.GroupBy:
+			groupBy = e.Value
+		case f.Boundaries:
+			boundaries, _ = e.Value.(bson.A)
+		case f.Default:
+			def = e.Value
+		case f.Output:
+			if output, ok = e.Value.(bson.D); !ok {
+				return nil, fmt.Errorf("aggregate: %s output must be a document", o.Bucket)
+			}
+		default:
+			return nil, fmt.Errorf("aggregate: unrecognized option to %s: %s", o.Bucket, e.Key)
+		}
+	}
+	if groupBy == nil || len(boundaries) < 2 {
+		return nil, fmt.Errorf("aggregate: %s requires groupBy and at least two boundaries", o.Bucket)
+	}
+	for i := 1; i < len(boundaries); i++ {
+		if match.Compare(boundaries[i-1], boundaries[i]) >= 0 {
+			return nil, fmt.Errorf("aggregate: %s boundaries must be sorted in ascending order", o.Bucket)
+		}
+	}
+
+	// Compute the bucket id of each document, then group by it.
+	ids := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		val, err := newScope(doc).eval(groupBy)
+		if err != nil {
+			return nil, err
+		}
+		if val == missing {
+			val = nil
+		}
+
+		var id interface{} = missing
+		for i := 0; i+1 < len(boundaries); i++ {
+			if match.Compare(val, boundaries[i]) >= 0 && match.Compare(val, boundaries[i+1]) < 0 {
+				id = boundaries[i]
+				break
+			}
+		}
+		if id == missing {
+			if def == missing {
+				return nil, fmt.Errorf("aggregate: %s could not find a matching branch for an input, and no default was specified", o.Bucket)
+			}
+			id = def
+		}
+		ids = append(ids, id)
+	}
+
+	groupSpec := bson.D{{Key: f.ID, Value: nil}}
+	groupSpec = append(groupSpec, output...)
+	results, err := groupByIDs(docs, ids, groupSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Buckets are returned in the boundaries order, followed by the default bucket.
+	order := func(id interface{}) int {
+		for i, b := range boundaries {
+			if match.Compare(b, id) == 0 {
+				return i
+			}
+		}
+		return len(boundaries)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return order(results[i][0].Value) < order(results[j][0].Value)
+	})
+	return results, nil
+}
+
+// facetStage runs each sub-pipeline against the documents and returns a single
+// document with a field per sub-pipeline containing its results.
+func (e *Evaluator) facetStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	facets, ok := spec.(bson.D)
+	if !ok || len(facets) == 0 {
+		return nil, fmt.Errorf("aggregate: %s requires a nonempty document", o.Facet)
+	}
+
+	result := bson.D{}
+	for _, facet := range facets {
+		stages, ok := facet.Value.(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("aggregate: %s sub-pipeline '%s' must be an array", o.Facet, facet.Key)
+		}
+
+		out := make([]bson.D, 0, len(docs))
+		for _, doc := range docs {
+			out = append(out, copyDoc(doc))
+		}
+		for _, item := range stages {
+			stage, ok := item.(bson.D)
+			if !ok || len(stage) != 1 {
+				return nil, errors.New("aggregate: a pipeline stage must contain exactly one field")
+			}
+			if stage[0].Key == o.Facet {
+				return nil, fmt.Errorf("aggregate: %s is not allowed in a %s sub-pipeline", o.Facet, o.Facet)
+			}
+			var err error
+			if out, err = e.stage(out, stage[0].Key, stage[0].Value); err != nil {
+				return nil, err
+			}
+		}
+
+		values := make(bson.A, 0, len(out))
+		for _, doc := range out {
+			values = append(values, doc)
+		}
+		result = append(result, bson.E{Key: facet.Key, Value: values})
+	}
+	return []bson.D{result}, nil
+}
+
+func replaceRootStage(docs []bson.D, name string, spec interface{}) ([]bson.D, error) {
+	newRoot := spec
+	if name == o.ReplaceRoot {
+		d, ok := spec.(bson.D)
+		if !ok || len(d) != 1 || d[0].Key != f.NewRoot {
+			return nil, fmt.Errorf("aggregate: %s requires a document with a newRoot field", name)
+		}
+		newRoot = d[0].Value
+	}
+
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		val, err := newScope(doc).eval(newRoot)
+		if err != nil {
+			return nil, err
+		}
+		d, ok := val.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("aggregate: 'newRoot' expression must evaluate to an object, but resulting value was: %v", val)
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func (e *Evaluator) lookupStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: %s requires a document", o.Lookup)
+	}
+
+	var from, localField, foreignField, as string
+	for _, field := range fields {
+		val, _ := field.Value.(string)
+		switch field.Key {
+		case f.From:
+			from = val
+		case f.LocalField:
+			localField = val
+		case f.ForeignField:
+			foreignField = val
+		case f.As:
+			as = val
+		default:
+			return nil, fmt.Errorf("aggregate: unsupported %s option %s", o.Lookup, field.Key)
+		}
+	}
+	if from == "" || localField == "" || foreignField == "" || as == "" {
+		return nil, fmt.Errorf("aggregate: %s requires from, localField, foreignField and as", o.Lookup)
+	}
+
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		local := match.Lookup(doc, localField)
+		var values bson.A
+		for _, v := range local {
+			if arr, ok := v.(bson.A); ok {
+				values = append(values, arr...)
+			} else {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			values = bson.A{nil}
+		}
+
+		filter, err := match.New(bson.D{{Key: foreignField, Value: bson.D{{Key: o.In, Value: values}}}})
+		if err != nil {
+			return nil, err
+		}
+		joined := bson.A{}
+		for _, foreign := range e.colls[from] {
+			if filter.MatchDoc(foreign) {
+				joined = append(joined, copyDoc(foreign))
+			}
+		}
+		out = append(out, setPath(doc, strings.Split(as, "."), joined))
+	}
+	return out, nil
+}
+
+func (e *Evaluator) graphLookupStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: %s requires a document", o.GraphLookup)
+	}
+
+	var from, connectFromField, connectToField, as, depthField string
+	var startWith, restrict interface{}
+	maxDepth := -1
+	for _, field := range fields {
+		val, _ := field.Value.(string)
+		switch field.Key {
+		case f.From:
+			from = val
+		case f.StartWith:
+			startWith = field.Value
+		case f.ConnectFromField:
+			connectFromField = val
+		case f.ConnectToField:
+			connectToField = val
+		case f.As:
+			as = val
+		case f.MaxDepth:
+			if maxDepth, ok = toInt(field.Value); !ok || maxDepth < 0 {
+				return nil, fmt.Errorf("aggregate: %s maxDepth must be a non-negative integer", o.GraphLookup)
+			}
+		case f.DepthField:
+			depthField = val
+		case f.RestrictSearchWithMatch:
+			restrict = field.Value
+		default:
+			return nil, fmt.Errorf("aggregate: unsupported %s option %s", o.GraphLookup, field.Key)
+		}
+	}
+	if from == "" || startWith == nil || connectFromField == "" || connectToField == "" || as == "" {
+		return nil, fmt.Errorf("aggregate: %s requires from, startWith, connectFromField, connectToField and as", o.GraphLookup)
+	}
+
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		start, err := newScope(doc).eval(startWith)
+		if err != nil {
+			return nil, err
+		}
+		frontier := appendValues(nil, start)
+
+		found := bson.A{}
+		seen := map[string]bool{}
+		for depth := 0; len(frontier) > 0 && (maxDepth < 0 || depth <= maxDepth); depth++ {
+			var filter interface{} = bson.D{{Key: connectToField, Value: bson.D{{Key: o.In, Value: frontier}}}}
+			if restrict != nil {
+				filter = bson.D{{Key: o.And, Value: bson.A{filter, restrict}}}
+			}
+			m, err := match.New(filter)
+			if err != nil {
+				return nil, err
+			}
+
+			var next bson.A
+			for _, foreign := range e.colls[from] {
+				key := fmt.Sprintf("%T:%v", match.Lookup(foreign, f.ID), match.Lookup(foreign, f.ID))
+				if seen[key] || !m.MatchDoc(foreign) {
+					continue
+				}
+				seen[key] = true
+				for _, v := range match.Lookup(foreign, connectFromField) {
+					next = appendValues(next, v)
+				}
+				result := copyDoc(foreign)
+				if depthField != "" {
+					result = setPath(result, strings.Split(depthField, "."), int64(depth))
+				}
+				found = append(found, result)
+			}
+			frontier = next
+		}
+		out = append(out, setPath(doc, strings.Split(as, "."), found))
+	}
+	return out, nil
+}
+
+// appendValues appends the value, or the elements of an array value, except null and missing values.
+func appendValues(values bson.A, v interface{}) bson.A {
+	if arr, ok := v.(bson.A); ok {
+		for _, item := range arr {
+			values = appendValues(values, item)
+		}
+		return values
+	}
+	if v == nil || v == missing {
+		return values
+	}
+	return append(values, v)
+}
+
+func copyDoc(doc bson.D) bson.D {
+	out := make(bson.D, len(doc))
+	copy(out, doc)
+	return out
+}
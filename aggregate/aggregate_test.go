@@ -0,0 +1,290 @@
+package aggregate
+
+import (
+	"testing"
+
+	"github.com/softwok/mongo-util/builder"
+	"github.com/softwok/mongo-util/internal/util"
+	o "github.com/softwok/mongo-util/operator"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newTestEvaluator(t *testing.T) *Evaluator {
+	e := New()
+	util.AssertErrIsNil(t, e.Insert("products",
+		bson.M{"_id": 1, "name": "Apple", "category": "fruit", "price": 10, "tags": bson.A{"red", "sweet"}, "supplier": "s1"},
+		bson.M{"_id": 2, "name": "Banana", "category": "fruit", "price": 5, "tags": bson.A{"yellow"}, "supplier": "s2"},
+		bson.M{"_id": 3, "name": "Carrot", "category": "vegetable", "price": 3, "tags": bson.A{}, "supplier": "s1"},
+		bson.M{"_id": 4, "name": "Daikon", "category": "vegetable", "price": 7.5, "supplier": "s3"},
+	))
+	util.AssertErrIsNil(t, e.Insert("suppliers",
+		bson.M{"_id": "s1", "name": "Farm"},
+		bson.M{"_id": "s2", "name": "Import"},
+	))
+	return e
+}
+
+func TestMatchSortLimitSkip(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	docs, err := e.Run("products",
+		builder.New(o.Match, bson.M{"price": bson.M{o.Gt: 3}}),
+		bson.M{o.Sort: bson.D{{Key: "price", Value: -1}}},
+		bson.M{o.Skip: 1},
+		bson.M{o.Limit: 1},
+	)
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, 1, len(docs))
+	assert.Equal(t, "Daikon", docs[0].Map()["name"])
+}
+
+func TestProject(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	docs, err := e.Run("products", bson.A{
+		bson.M{o.Match: bson.M{"_id": 1}},
+		bson.M{o.Project: bson.D{
+			{Key: "name", Value: 1},
+			{Key: "total", Value: bson.M{o.Multiply: bson.A{"$price", 2}}},
+			{Key: "label", Value: bson.M{o.Concat: bson.A{"$name", " (", "$category", ")"}}},
+		}},
+	})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.D{
+		{Key: "_id", Value: int32(1)},
+		{Key: "name", Value: "Apple"},
+		{Key: "total", Value: int32(20)},
+		{Key: "label", Value: "Apple (fruit)"},
+	}, docs[0])
+
+	docs, err = e.Run("products", bson.M{o.Match: bson.M{"_id": 1}}, bson.M{o.Project: bson.M{"tags": 0, "supplier": 0, "_id": 0}})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.M{"name": "Apple", "category": "fruit", "price": int32(10)}, docs[0].Map())
+
+	_, err = e.Run("products", bson.M{o.Project: bson.M{"name": 1, "price": 0}})
+	assert.NotNil(t, err)
+}
+
+func TestAddFields(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	docs, err := e.Run("products",
+		bson.M{o.Match: bson.M{"_id": 2}},
+		bson.M{o.AddFields: bson.D{
+			{Key: "discounted", Value: bson.M{o.Cond: bson.M{"if": bson.M{o.Gte: bson.A{"$price", 5}}, "then": true, "else": false}}},
+			{Key: "meta.tagCount", Value: bson.M{o.Size: "$tags"}},
+			{Key: "supplier", Value: "$$REMOVE"},
+		}},
+	)
+	util.AssertErrIsNil(t, err)
+
+	doc := docs[0].Map()
+	assert.Equal(t, true, doc["discounted"])
+	assert.Equal(t, bson.D{{Key: "tagCount", Value: int32(1)}}, doc["meta"])
+	assert.NotContains(t, doc, "supplier")
+}
+
+func TestGroup(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	docs, err := e.Run("products",
+		builder.Group("$category", bson.M{
+			"count":    bson.M{o.Sum: 1},
+			"total":    bson.M{o.Sum: "$price"},
+			"avg":      bson.M{o.Avg: "$price"},
+			"max":      bson.M{o.Max: "$price"},
+			"names":    bson.M{o.Push: "$name"},
+			"first":    bson.M{o.First: "$name"},
+			"supplier": bson.M{o.AddToSet: "$supplier"},
+		}),
+		bson.M{o.Sort: bson.M{"_id": 1}},
+	)
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, 2, len(docs))
+	fruit, vegetable := docs[0].Map(), docs[1].Map()
+	assert.Equal(t, "fruit", fruit["_id"])
+	assert.Equal(t, int32(2), fruit["count"])
+	assert.Equal(t, int32(15), fruit["total"])
+	assert.Equal(t, 7.5, fruit["avg"])
+	assert.Equal(t, int32(10), fruit["max"])
+	assert.Equal(t, bson.A{"Apple", "Banana"}, fruit["names"])
+	assert.Equal(t, "Apple", fruit["first"])
+	assert.Equal(t, 10.5, vegetable["total"])
+	assert.Equal(t, bson.A{"s1", "s3"}, vegetable["supplier"])
+
+	docs, err = e.Run("products", bson.M{o.Group: bson.M{"_id": nil, "std": bson.M{o.StdDevPop: "$price"}}})
+	util.AssertErrIsNil(t, err)
+	assert.Nil(t, docs[0].Map()["_id"])
+	assert.InDelta(t, 2.631, docs[0].Map()["std"], 0.001)
+}
+
+func TestUnwindAndCount(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	docs, err := e.Run("products", builder.Unwind("$tags", "idx", nil))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, 3, len(docs))
+	assert.Equal(t, "sweet", docs[1].Map()["tags"])
+	assert.Equal(t, int64(1), docs[1].Map()["idx"])
+
+	docs, err = e.Run("products", builder.Unwind("$tags", nil, true), bson.M{o.Count: "n"})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, []bson.D{{{Key: "n", Value: int32(5)}}}, docs)
+
+	docs, err = e.Run("products", bson.M{o.Match: bson.M{"price": 0}}, bson.M{o.Count: "n"})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, 0, len(docs))
+}
+
+func TestBucket(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	docs, err := e.Run("products", builder.Bucket("$price", bson.A{0, 5, 10}, "other", bson.M{
+		"count": bson.M{o.Sum: 1},
+		"names": bson.M{o.Push: "$name"},
+	}))
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, 3, len(docs))
+	assert.Equal(t, int32(0), docs[0].Map()["_id"])
+	assert.Equal(t, bson.A{"Carrot"}, docs[0].Map()["names"])
+	assert.Equal(t, bson.A{"Banana", "Daikon"}, docs[1].Map()["names"])
+	assert.Equal(t, "other", docs[2].Map()["_id"])
+
+	// The documents of the buckets are the input documents, unchanged.
+	docs, err = e.Run("products", builder.Bucket("$price", bson.A{0, 5}, "other", bson.M{
+		"items": bson.M{o.Push: "$$ROOT"},
+	}))
+	util.AssertErrIsNil(t, err)
+	carrot := docs[0].Map()["items"].(bson.A)[0].(bson.D)
+	assert.Equal(t, "_id", carrot[0].Key)
+	for _, e := range carrot {
+		assert.NotEqual(t, "__bucket", e.Key)
+	}
+
+	_, err = e.Run("products", builder.Bucket("$price", bson.A{0, 5}, nil, nil))
+	assert.NotNil(t, err)
+}
+
+func TestSortByCountAndFacet(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	docs, err := e.Run("products", builder.New(o.SortByCount, "$supplier"))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.D{{Key: "_id", Value: "s1"}, {Key: "count", Value: int32(2)}}, docs[0])
+	assert.Equal(t, 3, len(docs))
+
+	docs, err = e.Run("products",
+		builder.New(o.Match, bson.M{"category": "fruit"}),
+		builder.New(o.Facet, bson.D{
+			{Key: "items", Value: bson.A{bson.M{o.Sort: bson.M{"price": 1}}, bson.M{o.Project: bson.M{"name": 1, "_id": 0}}}},
+			{Key: "total", Value: bson.A{bson.M{o.Count: "count"}}},
+		}),
+	)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, []bson.D{{
+		{Key: "items", Value: bson.A{bson.D{{Key: "name", Value: "Banana"}}, bson.D{{Key: "name", Value: "Apple"}}}},
+		{Key: "total", Value: bson.A{bson.D{{Key: "count", Value: int32(2)}}}},
+	}}, docs)
+
+	_, err = e.Run("products", builder.New(o.SortByCount, "supplier"))
+	assert.NotNil(t, err)
+	_, err = e.Run("products", builder.New(o.Facet, bson.M{"nested": bson.A{bson.M{o.Facet: bson.M{}}}}))
+	assert.NotNil(t, err)
+}
+
+func TestLookupAndReplaceRoot(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	docs, err := e.Run("products",
+		builder.Lookup("suppliers", "supplier", "_id", "suppliers"),
+		bson.M{o.Sort: bson.M{"_id": 1}},
+		builder.ReplaceRoot(bson.M{"name": "$name", "suppliers": "$suppliers.name"}),
+	)
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, bson.D{{Key: "name", Value: "Apple"}, {Key: "suppliers", Value: bson.A{"Farm"}}}, docs[0])
+	assert.Equal(t, bson.A{}, docs[3].Map()["suppliers"])
+}
+
+func TestGraphLookup(t *testing.T) {
+	e := New()
+	util.AssertErrIsNil(t, e.Insert("employees",
+		bson.M{"_id": 1, "name": "Dev"},
+		bson.M{"_id": 2, "name": "Eliot", "reportsTo": 1},
+		bson.M{"_id": 3, "name": "Ron", "reportsTo": 2},
+		bson.M{"_id": 4, "name": "Andrew", "reportsTo": 2},
+		bson.M{"_id": 5, "name": "Asya", "reportsTo": 3, "active": false},
+	))
+
+	docs, err := e.Run("employees",
+		bson.M{o.Match: bson.M{"_id": 5}},
+		builder.GraphLookup(builder.GraphLookupOptions{
+			From:             "employees",
+			StartWith:        "$reportsTo",
+			ConnectFromField: "reportsTo",
+			ConnectToField:   "_id",
+			As:               "chain",
+			DepthField:       "depth",
+		}),
+		builder.ReplaceRoot(bson.M{"chain": "$chain.name", "depths": "$chain.depth"}),
+	)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.A{"Ron", "Eliot", "Dev"}, docs[0].Map()["chain"])
+	assert.Equal(t, bson.A{int64(0), int64(1), int64(2)}, docs[0].Map()["depths"])
+
+	docs, err = e.Run("employees",
+		bson.M{o.Match: bson.M{"_id": 2}},
+		builder.GraphLookup(builder.GraphLookupOptions{
+			From:                    "employees",
+			StartWith:               "$_id",
+			ConnectFromField:        "_id",
+			ConnectToField:          "reportsTo",
+			As:                      "reports",
+			MaxDepth:                1,
+			RestrictSearchWithMatch: bson.M{"active": bson.M{o.Ne: false}},
+		}),
+		builder.ReplaceRoot(bson.M{"reports": "$reports.name"}),
+	)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.A{"Ron", "Andrew"}, docs[0].Map()["reports"])
+
+	docs, err = e.Run("employees",
+		bson.M{o.Match: bson.M{"_id": 1}},
+		builder.GraphLookup(builder.GraphLookupOptions{From: "employees", StartWith: "$reportsTo", ConnectFromField: "reportsTo", ConnectToField: "_id", As: "chain"}),
+	)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.A{}, docs[0].Map()["chain"])
+}
+
+func TestRunAll(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	var results []struct {
+		Name  string  `bson:"name"`
+		Price float64 `bson:"price"`
+	}
+	err := e.RunAll("products", &results, bson.M{o.Sort: bson.M{"price": 1}})
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, 4, len(results))
+	assert.Equal(t, "Carrot", results[0].Name)
+}
+
+func TestUnsupported(t *testing.T) {
+	e := newTestEvaluator(t)
+
+	for _, stage := range []interface{}{
+		bson.M{o.Facet: bson.M{}},
+		bson.M{o.Match: bson.M{o.Expr: true}},
+		bson.M{o.Project: bson.M{"x": bson.M{o.DateToString: "$price"}}},
+		bson.M{o.Group: bson.M{"_id": nil, "x": bson.M{"$foo": 1}}},
+		bson.M{o.Lookup: bson.M{"from": "suppliers", "pipeline": bson.A{}, "as": "s"}},
+	} {
+		_, err := e.Run("products", stage)
+		assert.NotNil(t, err, "%v should be unsupported", stage)
+	}
+}
@@ -0,0 +1,751 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/softwok/mongo-util/match"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// missingValue is the value of fields that do not exist. Unlike null, missing
+// values are not added to the output documents.
+type missingValue struct{}
+
+var missing = missingValue{}
+
+// scope contains the variables available to expressions.
+type scope struct {
+	root bson.D
+	vars map[string]interface{}
+}
+
+func newScope(root bson.D) *scope {
+	return &scope{root: root}
+}
+
+// eval evaluates an aggregation expression: field paths ("$a.b"), variables
+// ("$$ROOT"), expression objects, operator expressions and literals.
+func (s *scope) eval(expr interface{}) (interface{}, error) {
+	switch e := expr.(type) {
+	case string:
+		if strings.HasPrefix(e, "$$") {
+			return s.variable(e[2:])
+		}
+		if strings.HasPrefix(e, "$") {
+			return getPath(s.root, strings.Split(e[1:], ".")), nil
+		}
+		return e, nil
+	case bson.A:
+		out := make(bson.A, 0, len(e))
+		for _, item := range e {
+			v, err := s.eval(item)
+			if err != nil {
+				return nil, err
+			}
+			if v == missing {
+				v = nil
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case bson.D:
+		if len(e) == 1 && strings.HasPrefix(e[0].Key, "$") {
+			return s.operator(e[0].Key, e[0].Value)
+		}
+		out := bson.D{}
+		for _, f := range e {
+			if strings.HasPrefix(f.Key, "$") {
+				return nil, fmt.Errorf("aggregate: unknown expression %s", f.Key)
+			}
+			v, err := s.eval(f.Value)
+			if err != nil {
+				return nil, err
+			}
+			if v != missing {
+				out = append(out, bson.E{Key: f.Key, Value: v})
+			}
+		}
+		return out, nil
+	}
+
+	return expr, nil
+}
+
+func (s *scope) variable(name string) (interface{}, error) {
+	parts := strings.Split(name, ".")
+
+	var v interface{}
+	switch parts[0] {
+	case "ROOT", "CURRENT":
+		v = s.root
+	case "REMOVE":
+		return missing, nil
+	default:
+		val, ok := s.vars[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("aggregate: undefined variable %s", parts[0])
+		}
+		v = val
+	}
+
+	if len(parts) == 1 {
+		return v, nil
+	}
+	return getPath(v, parts[1:]), nil
+}
+
+// getPath returns the value at the path. Arrays found on the path are traversed
+// and the values found in their documents are returned as an array.
+func getPath(v interface{}, parts []string) interface{} {
+	if len(parts) == 0 {
+		return v
+	}
+
+	switch d := v.(type) {
+	case bson.D:
+		for _, e := range d {
+			if e.Key == parts[0] {
+				return getPath(e.Value, parts[1:])
+			}
+		}
+	case bson.A:
+		out := bson.A{}
+		for _, item := range d {
+			if _, ok := item.(bson.D); !ok {
+				if _, ok = item.(bson.A); !ok {
+					continue
+				}
+			}
+			if val := getPath(item, parts); val != missing {
+				out = append(out, val)
+			}
+		}
+		return out
+	}
+
+	return missing
+}
+
+// args evaluates the arguments of an operator expression. A single argument
+// that is not an array is treated as an array of one argument.
+func (s *scope) args(val interface{}) ([]interface{}, error) {
+	list, ok := val.(bson.A)
+	if !ok {
+		list = bson.A{val}
+	}
+
+	out := make([]interface{}, 0, len(list))
+	for _, item := range list {
+		v, err := s.eval(item)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (s *scope) nargs(op string, val interface{}, n int) ([]interface{}, error) {
+	args, err := s.args(val)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("aggregate: %s takes exactly %d arguments", op, n)
+	}
+	return args, nil
+}
+
+func (s *scope) operator(op string, val interface{}) (interface{}, error) {
+	switch op {
+	case o.Literal:
+		return val, nil
+	case o.Add:
+		args, err := s.args(val)
+		if err != nil {
+			return nil, err
+		}
+		return add(args)
+	case o.Subtract:
+		args, err := s.nargs(op, val, 2)
+		if err != nil {
+			return nil, err
+		}
+		return subtract(args[0], args[1])
+	case o.Multiply, o.Divide, o.Mod, o.Pow:
+		return s.arithmetic(op, val)
+	case o.Abs, o.Ceil, o.Floor, o.Sqrt, o.Exp, o.Ln, o.Log10, o.Trunc, o.Round:
+		return s.math(op, val)
+	case o.Eq, o.Ne, o.Gt, o.Gte, o.Lt, o.Lte, o.Cmp:
+		args, err := s.nargs(op, val, 2)
+		if err != nil {
+			return nil, err
+		}
+		return compareOp(op, args[0], args[1]), nil
+	case o.And, o.Or:
+		args, err := s.args(val)
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args {
+			if truthy(arg) == (op == o.Or) {
+				return op == o.Or, nil
+			}
+		}
+		return op == o.And, nil
+	case o.Not:
+		args, err := s.nargs(op, val, 1)
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(args[0]), nil
+	case o.Cond:
+		return s.cond(val)
+	case o.IfNull:
+		args, err := s.args(val)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < 2 {
+			return nil, fmt.Errorf("aggregate: %s needs at least two arguments", op)
+		}
+		for _, arg := range args[:len(args)-1] {
+			if !isNullish(arg) {
+				return arg, nil
+			}
+		}
+		return args[len(args)-1], nil
+	case o.Switch:
+		return s.switchExpr(val)
+	case o.Concat:
+		args, err := s.args(val)
+		if err != nil {
+			return nil, err
+		}
+		b := strings.Builder{}
+		for _, arg := range args {
+			if isNullish(arg) {
+				return nil, nil
+			}
+			str, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("aggregate: %s only supports strings", op)
+			}
+			b.WriteString(str)
+		}
+		return b.String(), nil
+	case o.ToLower, o.ToUpper:
+		args, err := s.nargs(op, val, 1)
+		if err != nil {
+			return nil, err
+		}
+		str := toStr(args[0])
+		if op == o.ToLower {
+			return strings.ToLower(str), nil
+		}
+		return strings.ToUpper(str), nil
+	case o.StrLenCP:
+		args, err := s.nargs(op, val, 1)
+		if err != nil {
+			return nil, err
+		}
+		str, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("aggregate: %s requires a string", op)
+		}
+		return int32(len([]rune(str))), nil
+	case o.Split:
+		args, err := s.nargs(op, val, 2)
+		if err != nil {
+			return nil, err
+		}
+		if isNullish(args[0]) {
+			return nil, nil
+		}
+		str, ok1 := args[0].(string)
+		sep, ok2 := args[1].(string)
+		if !ok1 || !ok2 || sep == "" {
+			return nil, fmt.Errorf("aggregate: %s requires a string and a nonempty separator", op)
+		}
+		out := bson.A{}
+		for _, part := range strings.Split(str, sep) {
+			out = append(out, part)
+		}
+		return out, nil
+	case o.ToString:
+		args, err := s.nargs(op, val, 1)
+		if err != nil {
+			return nil, err
+		}
+		if isNullish(args[0]) {
+			return nil, nil
+		}
+		return toStr(args[0]), nil
+	case o.Size:
+		args, err := s.nargs(op, val, 1)
+		if err != nil {
+			return nil, err
+		}
+		arr, ok := args[0].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("aggregate: the argument to %s must be an array", op)
+		}
+		return int32(len(arr)), nil
+	case o.IsArray:
+		args, err := s.nargs(op, val, 1)
+		if err != nil {
+			return nil, err
+		}
+		_, ok := args[0].(bson.A)
+		return ok, nil
+	case o.ConcatArrays:
+		args, err := s.args(val)
+		if err != nil {
+			return nil, err
+		}
+		out := bson.A{}
+		for _, arg := range args {
+			if isNullish(arg) {
+				return nil, nil
+			}
+			arr, ok := arg.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("aggregate: %s only supports arrays", op)
+			}
+			out = append(out, arr...)
+		}
+		return out, nil
+	case o.In:
+		args, err := s.nargs(op, val, 2)
+		if err != nil {
+			return nil, err
+		}
+		arr, ok := args[1].(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("aggregate: the second argument of %s must be an array", op)
+		}
+		for _, item := range arr {
+			if match.Compare(item, args[0]) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case o.ArrayElemAt:
+		args, err := s.nargs(op, val, 2)
+		if err != nil {
+			return nil, err
+		}
+		if isNullish(args[0]) || isNullish(args[1]) {
+			return nil, nil
+		}
+		arr, ok := args[0].(bson.A)
+		idx, isInt := toInt(args[1])
+		if !ok || !isInt {
+			return nil, fmt.Errorf("aggregate: %s requires an array and an integer index", op)
+		}
+		if idx < 0 {
+			idx += len(arr)
+		}
+		if idx < 0 || idx >= len(arr) {
+			return missing, nil
+		}
+		return arr[idx], nil
+	case o.Sum, o.Avg, o.Min, o.Max, o.StdDevPop, o.StdDevSamp:
+		args, err := s.args(val)
+		if err != nil {
+			return nil, err
+		}
+		// With a single array argument, the accumulator applies to the array elements.
+		if arr, ok := args[0].(bson.A); ok && len(args) == 1 {
+			args = arr
+		}
+		acc, err := newAccumulator(op, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range args {
+			if err = acc.add(arg); err != nil {
+				return nil, err
+			}
+		}
+		return acc.result(), nil
+	}
+
+	return nil, fmt.Errorf("aggregate: unsupported expression operator %s", op)
+}
+
+func (s *scope) cond(val interface{}) (interface{}, error) {
+	var ifExpr, thenExpr, elseExpr interface{}
+	switch v := val.(type) {
+	case bson.A:
+		if len(v) != 3 {
+			return nil, fmt.Errorf("aggregate: %s takes exactly 3 arguments", o.Cond)
+		}
+		ifExpr, thenExpr, elseExpr = v[0], v[1], v[2]
+	case bson.D:
+		for _, e := range v {
+			switch e.Key {
+			case "if":
+				ifExpr = e.Value
+			case "then":
+				thenExpr = e.Value
+			case "else":
+				elseExpr = e.Value
+			default:
+				return nil, fmt.Errorf("aggregate: unknown %s argument %s", o.Cond, e.Key)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("aggregate: %s requires an array or a document", o.Cond)
+	}
+
+	c, err := s.eval(ifExpr)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(c) {
+		return s.eval(thenExpr)
+	}
+	return s.eval(elseExpr)
+}
+
+func (s *scope) switchExpr(val interface{}) (interface{}, error) {
+	spec, ok := val.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("aggregate: %s requires a document", o.Switch)
+	}
+
+	var branches bson.A
+	var def interface{} = missing
+	for _, e := range spec {
+		switch e.Key {
+		case "branches":
+			if branches, ok = e.Value.(bson.A); !ok {
+				return nil, fmt.Errorf("aggregate: %s branches must be an array", o.Switch)
+			}
+		case "default":
+			def = e.Value
+		}
+	}
+
+	for _, b := range branches {
+		branch, ok := b.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("aggregate: %s branches must be documents", o.Switch)
+		}
+		bm := branch.Map()
+		c, err := s.eval(bm["case"])
+		if err != nil {
+			return nil, err
+		}
+		if truthy(c) {
+			return s.eval(bm["then"])
+		}
+	}
+
+	if def == missing {
+		return nil, fmt.Errorf("aggregate: %s could not find a matching branch and no default", o.Switch)
+	}
+	return s.eval(def)
+}
+
+func (s *scope) arithmetic(op string, val interface{}) (interface{}, error) {
+	var args []interface{}
+	var err error
+	if op == o.Multiply {
+		args, err = s.args(val)
+	} else {
+		args, err = s.nargs(op, val, 2)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, arg := range args {
+		if isNullish(arg) {
+			return nil, nil
+		}
+		if !isNumber(arg) {
+			return nil, fmt.Errorf("aggregate: %s only supports numeric types", op)
+		}
+	}
+
+	switch op {
+	case o.Multiply:
+		var result interface{} = int32(1)
+		for _, arg := range args {
+			result = numOp(result, arg, func(a, b int64) (int64, bool) {
+				r := a * b
+				return r, a == 0 || r/a == b
+			}, func(a, b float64) float64 { return a * b })
+		}
+		return result, nil
+	case o.Divide:
+		if toFloat(args[1]) == 0 {
+			return nil, fmt.Errorf("aggregate: can't %s by zero", op)
+		}
+		return toFloat(args[0]) / toFloat(args[1]), nil
+	case o.Mod:
+		if toFloat(args[1]) == 0 {
+			return nil, fmt.Errorf("aggregate: can't %s by zero", op)
+		}
+		return numOp(args[0], args[1], func(a, b int64) (int64, bool) {
+			return a % b, true
+		}, math.Mod), nil
+	}
+
+	return math.Pow(toFloat(args[0]), toFloat(args[1])), nil
+}
+
+func (s *scope) math(op string, val interface{}) (interface{}, error) {
+	args, err := s.args(val)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || (len(args) > 1 && op != o.Round && op != o.Trunc) {
+		return nil, fmt.Errorf("aggregate: wrong number of arguments for %s", op)
+	}
+	if isNullish(args[0]) {
+		return nil, nil
+	}
+	if !isNumber(args[0]) {
+		return nil, fmt.Errorf("aggregate: %s only supports numeric types", op)
+	}
+
+	if n, ok := args[0].(int32); ok && (op == o.Ceil || op == o.Floor || op == o.Round || op == o.Trunc) {
+		return n, nil
+	}
+	if n, ok := args[0].(int64); ok && (op == o.Ceil || op == o.Floor || op == o.Round || op == o.Trunc) {
+		return n, nil
+	}
+
+	f := toFloat(args[0])
+	switch op {
+	case o.Abs:
+		switch n := args[0].(type) {
+		case int32:
+			if n < 0 {
+				return -int64(n), nil
+			}
+			return n, nil
+		case int64:
+			if n < 0 {
+				return -n, nil
+			}
+			return n, nil
+		}
+		return math.Abs(f), nil
+	case o.Ceil:
+		return math.Ceil(f), nil
+	case o.Floor:
+		return math.Floor(f), nil
+	case o.Sqrt:
+		return math.Sqrt(f), nil
+	case o.Exp:
+		return math.Exp(f), nil
+	case o.Ln:
+		return math.Log(f), nil
+	case o.Log10:
+		return math.Log10(f), nil
+	}
+
+	place := 0
+	if len(args) > 1 {
+		var ok bool
+		if place, ok = toInt(args[1]); !ok {
+			return nil, fmt.Errorf("aggregate: %s place must be an integer", op)
+		}
+	}
+	scale := math.Pow(10, float64(place))
+	if op == o.Trunc {
+		return math.Trunc(f*scale) / scale, nil
+	}
+	return math.RoundToEven(f*scale) / scale, nil
+}
+
+func add(args []interface{}) (interface{}, error) {
+	var result interface{} = int32(0)
+	var date *primitive.DateTime
+	for _, arg := range args {
+		if isNullish(arg) {
+			return nil, nil
+		}
+		if d, ok := arg.(primitive.DateTime); ok {
+			if date != nil {
+				return nil, fmt.Errorf("aggregate: only one date allowed in an %s expression", o.Add)
+			}
+			date = &d
+			continue
+		}
+		if !isNumber(arg) {
+			return nil, fmt.Errorf("aggregate: %s only supports numeric or date types", o.Add)
+		}
+		result = numOp(result, arg, func(a, b int64) (int64, bool) {
+			r := a + b
+			return r, (r > a) == (b > 0)
+		}, func(a, b float64) float64 { return a + b })
+	}
+
+	if date != nil {
+		return primitive.DateTime(int64(*date) + int64(math.Round(toFloat(result)))), nil
+	}
+	return result, nil
+}
+
+func subtract(a, b interface{}) (interface{}, error) {
+	if isNullish(a) || isNullish(b) {
+		return nil, nil
+	}
+
+	da, aIsDate := a.(primitive.DateTime)
+	db, bIsDate := b.(primitive.DateTime)
+	switch {
+	case aIsDate && bIsDate:
+		return int64(da) - int64(db), nil
+	case aIsDate && isNumber(b):
+		return primitive.DateTime(int64(da) - int64(math.Round(toFloat(b)))), nil
+	case isNumber(a) && isNumber(b):
+		return numOp(a, b, func(x, y int64) (int64, bool) {
+			r := x - y
+			return r, (r < x) == (y > 0)
+		}, func(x, y float64) float64 { return x - y }), nil
+	}
+
+	return nil, fmt.Errorf("aggregate: %s only supports numeric or date types", o.Subtract)
+}
+
+// numOp applies the operation keeping the MongoDB numeric type rules: integers stay
+// integers and are widened to long on overflow, any double makes the result a double.
+func numOp(a, b interface{}, intOp func(a, b int64) (int64, bool), floatOp func(a, b float64) float64) interface{} {
+	x, aIsInt := toInt64(a)
+	y, bIsInt := toInt64(b)
+	if aIsInt && bIsInt {
+		if r, ok := intOp(x, y); ok {
+			_, aIsInt32 := a.(int32)
+			_, bIsInt32 := b.(int32)
+			if aIsInt32 && bIsInt32 && r >= math.MinInt32 && r <= math.MaxInt32 {
+				return int32(r)
+			}
+			return r
+		}
+	}
+	return floatOp(toFloat(a), toFloat(b))
+}
+
+func compareOp(op string, a, b interface{}) interface{} {
+	c := compareValues(a, b)
+	switch op {
+	case o.Eq:
+		return c == 0
+	case o.Ne:
+		return c != 0
+	case o.Gt:
+		return c > 0
+	case o.Gte:
+		return c >= 0
+	case o.Lt:
+		return c < 0
+	case o.Lte:
+		return c <= 0
+	}
+	return int32(c)
+}
+
+// compareValues compares two values, missing values are lower than any other value.
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == missing && b == missing:
+		return 0
+	case a == missing:
+		return -1
+	case b == missing:
+		return 1
+	}
+	return match.Compare(a, b)
+}
+
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case nil, missingValue, primitive.Null, primitive.Undefined:
+		return false
+	}
+	if isNumber(v) {
+		return toFloat(v) != 0
+	}
+	return true
+}
+
+func isNullish(v interface{}) bool {
+	switch v.(type) {
+	case nil, missingValue, primitive.Null, primitive.Undefined:
+		return true
+	}
+	return false
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int32, int64, float64, primitive.Decimal128:
+		return true
+	}
+	return false
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toInt(v interface{}) (int, bool) {
+	if n, ok := toInt64(v); ok {
+		return int(n), true
+	}
+	if f, ok := v.(float64); ok && f == math.Trunc(f) {
+		return int(f), true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(n.String(), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	}
+	return math.NaN()
+}
+
+func toStr(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case nil, missingValue, primitive.Null:
+		return ""
+	case primitive.ObjectID:
+		return s.Hex()
+	case primitive.DateTime:
+		return s.Time().UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	return fmt.Sprint(v)
+}
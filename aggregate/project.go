@@ -0,0 +1,262 @@
+package aggregate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	f "github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type projectionKind int
+
+const (
+	projectInclude projectionKind = iota
+	projectExclude
+	projectExpr
+	projectNested
+)
+
+// projection is a node of a parsed $project specification.
+type projection struct {
+	kind     projectionKind
+	expr     interface{}
+	names    []string
+	children map[string]*projection
+}
+
+func (p *projection) child(name string) *projection {
+	if p.children == nil {
+		return nil
+	}
+	return p.children[name]
+}
+
+func (p *projection) add(path []string, kind projectionKind, expr interface{}) error {
+	node := p
+	for _, name := range path[:len(path)-1] {
+		next := node.child(name)
+		if next == nil {
+			next = &projection{kind: projectNested}
+			node.set(name, next)
+		} else if next.kind != projectNested {
+			return fmt.Errorf("aggregate: invalid %s specification, path collision at %s", o.Project, name)
+		}
+		node = next
+	}
+
+	last := path[len(path)-1]
+	if node.child(last) != nil {
+		return fmt.Errorf("aggregate: invalid %s specification, path collision at %s", o.Project, last)
+	}
+	node.set(last, &projection{kind: kind, expr: expr})
+	return nil
+}
+
+func (p *projection) set(name string, child *projection) {
+	if p.children == nil {
+		p.children = map[string]*projection{}
+	}
+	p.names = append(p.names, name)
+	p.children[name] = child
+}
+
+// parse parses the specification fields, and reports the kinds of projection used
+// by the fields other than `_id`.
+func (p *projection) parse(spec bson.D, prefix []string, kinds map[projectionKind]bool) error {
+	for _, e := range spec {
+		path := append(append([]string{}, prefix...), strings.Split(e.Key, ".")...)
+		kind := projectExpr
+		var expr interface{}
+
+		switch v := e.Value.(type) {
+		case bool:
+			kind = projectExclude
+			if v {
+				kind = projectInclude
+			}
+		case int32, int64, float64:
+			kind = projectExclude
+			if toFloat(v) != 0 {
+				kind = projectInclude
+			}
+		case bson.D:
+			if len(v) == 0 {
+				return fmt.Errorf("aggregate: an empty object is not a valid value in %s", o.Project)
+			}
+			if !strings.HasPrefix(v[0].Key, "$") {
+				if err := p.parse(v, path, kinds); err != nil {
+					return err
+				}
+				continue
+			}
+			expr = v
+		default:
+			expr = v
+		}
+
+		if len(path) != 1 || path[0] != f.ID {
+			kinds[kind] = true
+		}
+		if err := p.add(path, kind, expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func projectStage(docs []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok || len(fields) == 0 {
+		return nil, fmt.Errorf("aggregate: %s requires a nonempty document", o.Project)
+	}
+
+	root := &projection{kind: projectNested}
+	kinds := map[projectionKind]bool{}
+	if err := root.parse(fields, nil, kinds); err != nil {
+		return nil, err
+	}
+
+	exclusion := kinds[projectExclude]
+	if exclusion && (kinds[projectInclude] || kinds[projectExpr]) {
+		return nil, errors.New("aggregate: cannot mix inclusion and exclusion in a projection")
+	}
+	if len(kinds) == 0 {
+		// Only _id is projected.
+		exclusion = root.child(f.ID).kind == projectExclude
+	}
+
+	out := make([]bson.D, 0, len(docs))
+	for _, doc := range docs {
+		if exclusion {
+			out = append(out, exclude(doc, root))
+			continue
+		}
+
+		s := newScope(doc)
+		result := bson.D{}
+		idNode := root.child(f.ID)
+		if idNode == nil {
+			if id := getPath(doc, []string{f.ID}); id != missing {
+				result = append(result, bson.E{Key: f.ID, Value: id})
+			}
+		}
+		projected, err := include(s, doc, root)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, append(result, projected...))
+	}
+	return out, nil
+}
+
+// include returns the included fields of the document in the document order,
+// followed by the computed fields in the specification order.
+func include(s *scope, doc bson.D, node *projection) (bson.D, error) {
+	out := bson.D{}
+	for _, e := range doc {
+		child := node.child(e.Key)
+		if child == nil {
+			continue
+		}
+		switch child.kind {
+		case projectInclude:
+			out = append(out, e)
+		case projectNested:
+			val, err := includeNested(s, e.Value, child)
+			if err != nil {
+				return nil, err
+			}
+			if val != missing {
+				out = append(out, bson.E{Key: e.Key, Value: val})
+			}
+		}
+	}
+
+	for _, name := range node.names {
+		child := node.children[name]
+		switch child.kind {
+		case projectExpr:
+			val, err := s.eval(child.expr)
+			if err != nil {
+				return nil, err
+			}
+			if val != missing {
+				out = setField(out, name, val)
+			}
+		case projectNested:
+			if getPath(doc, []string{name}) == missing && hasExpr(child) {
+				val, err := include(s, bson.D{}, child)
+				if err != nil {
+					return nil, err
+				}
+				out = setField(out, name, val)
+			}
+		}
+	}
+	return out, nil
+}
+
+func includeNested(s *scope, val interface{}, node *projection) (interface{}, error) {
+	switch v := val.(type) {
+	case bson.D:
+		return include(s, v, node)
+	case bson.A:
+		out := bson.A{}
+		for _, item := range v {
+			switch item.(type) {
+			case bson.D, bson.A:
+				projected, err := includeNested(s, item, node)
+				if err != nil {
+					return nil, err
+				}
+				out = append(out, projected)
+			}
+		}
+		return out, nil
+	}
+
+	if hasExpr(node) {
+		return include(s, bson.D{}, node)
+	}
+	return missing, nil
+}
+
+func hasExpr(node *projection) bool {
+	for _, child := range node.children {
+		if child.kind == projectExpr || (child.kind == projectNested && hasExpr(child)) {
+			return true
+		}
+	}
+	return false
+}
+
+func exclude(doc bson.D, node *projection) bson.D {
+	out := bson.D{}
+	for _, e := range doc {
+		child := node.child(e.Key)
+		switch {
+		case child == nil, child.kind == projectInclude:
+			out = append(out, e)
+		case child.kind == projectNested:
+			out = append(out, bson.E{Key: e.Key, Value: excludeNested(e.Value, child)})
+		}
+	}
+	return out
+}
+
+func excludeNested(val interface{}, node *projection) interface{} {
+	switch v := val.(type) {
+	case bson.D:
+		return exclude(v, node)
+	case bson.A:
+		out := make(bson.A, 0, len(v))
+		for _, item := range v {
+			out = append(out, excludeNested(item, node))
+		}
+		return out
+	}
+	return val
+}
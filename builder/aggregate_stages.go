@@ -54,8 +54,48 @@ func CurrentOp(allUsers, idleConnections, idleCursors, idleSessions, localOps in
 	return New(o.CurrentOp, m)
 }
 
-// $geoNear,$graphLookup has many params, those functions
-// will have too many params and do not make readable code.
+// $geoNear has many params, it is built from GeoNearOptions, see geo.go.
+
+// GraphLookupOptions struct contains the fields of a $graphLookup stage. Nil and empty fields are omitted.
+type GraphLookupOptions struct {
+	// From is the collection to search.
+	From string
+
+	// StartWith is the expression of the values to start the search with (e.g. "$parent_id").
+	StartWith interface{}
+
+	// ConnectFromField is the field whose values are searched recursively in ConnectToField.
+	ConnectFromField string
+	ConnectToField   string
+
+	// As is the output array field of the found documents.
+	As string
+
+	// MaxDepth is the maximum recursion depth; 0 only searches the StartWith values.
+	MaxDepth interface{}
+
+	// DepthField is the field added to each found document with its recursion depth.
+	DepthField string
+
+	// RestrictSearchWithMatch is a filter the found documents must match.
+	RestrictSearchWithMatch interface{}
+}
+
+// GraphLookup function returns a mongo $graphLookup operator used in aggregations.
+func GraphLookup(opts GraphLookupOptions) Operator {
+	m := bson.M{}
+
+	appendNotEmpty(m, f.From, opts.From)
+	appendNotNull(m, f.StartWith, opts.StartWith)
+	appendNotEmpty(m, f.ConnectFromField, opts.ConnectFromField)
+	appendNotEmpty(m, f.ConnectToField, opts.ConnectToField)
+	appendNotEmpty(m, f.As, opts.As)
+	appendNotNull(m, f.MaxDepth, opts.MaxDepth)
+	appendNotEmpty(m, f.DepthField, opts.DepthField)
+	appendNotNull(m, f.RestrictSearchWithMatch, opts.RestrictSearchWithMatch)
+
+	return New(o.GraphLookup, m)
+}
 
 // Group function returns a mongo $group operator used in aggregations.
 func Group(ID interface{}, params bson.M) Operator {
@@ -0,0 +1,110 @@
+package builder
+
+import (
+	f "github.com/softwok/mongo-util/field"
+	"github.com/softwok/mongo-util/geo"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Near function returns a mongo $near operator used in filters. The geometry is usually a
+// `geo.Point`, and the distances are in meters; nil distances are omitted.
+func Near(geometry, minDistance, maxDistance interface{}) Operator {
+	return New(o.Near, nearQuery(geometry, minDistance, maxDistance))
+}
+
+// NearSphere function returns a mongo $nearSphere operator used in filters. The geometry is
+// usually a `geo.Point`, and the distances are in meters; nil distances are omitted.
+func NearSphere(geometry, minDistance, maxDistance interface{}) Operator {
+	return New(o.NearSphere, nearQuery(geometry, minDistance, maxDistance))
+}
+
+func nearQuery(geometry, minDistance, maxDistance interface{}) bson.M {
+	m := bson.M{}
+
+	appendNotNull(m, o.Geometry, geometry)
+	appendNotNull(m, o.MinDistance, minDistance)
+	appendNotNull(m, o.MaxDistance, maxDistance)
+
+	return m
+}
+
+// GeoWithin function returns a mongo $geoWithin operator used in filters, selecting the
+// geometries within a GeoJSON polygon or multi polygon.
+func GeoWithin(geometry geo.Geometry) Operator {
+	return New(o.GeoWithin, bson.M{o.Geometry: geometry})
+}
+
+// GeoWithinBox function returns a mongo $geoWithin operator used in filters, selecting the
+// legacy coordinate pairs within a rectangle of the plane.
+func GeoWithinBox(bottomLeft, upperRight geo.Position) Operator {
+	return New(o.GeoWithin, bson.M{o.Box: bson.A{bottomLeft, upperRight}})
+}
+
+// GeoWithinPolygon function returns a mongo $geoWithin operator used in filters, selecting
+// the legacy coordinate pairs within a polygon of the plane. The polygon is closed implicitly.
+func GeoWithinPolygon(points ...geo.Position) Operator {
+	return New(o.GeoWithin, bson.M{o.Polygon: points})
+}
+
+// GeoWithinCenter function returns a mongo $geoWithin operator used in filters, selecting
+// the legacy coordinate pairs within a circle of the plane.
+func GeoWithinCenter(center geo.Position, radius float64) Operator {
+	return New(o.GeoWithin, bson.M{o.Center: bson.A{center, radius}})
+}
+
+// GeoWithinCenterSphere function returns a mongo $geoWithin operator used in filters, selecting
+// the geometries within a spherical cap. The radius is in radians (see `geo.Radians`).
+func GeoWithinCenterSphere(center geo.Position, radius float64) Operator {
+	return New(o.GeoWithin, bson.M{o.CenterSphere: bson.A{center, radius}})
+}
+
+// GeoIntersects function returns a mongo $geoIntersects operator used in filters.
+func GeoIntersects(geometry geo.Geometry) Operator {
+	return New(o.GeoIntersects, bson.M{o.Geometry: geometry})
+}
+
+// GeoNearOptions struct contains the fields of a $geoNear stage. Nil and empty fields are omitted.
+type GeoNearOptions struct {
+	// Near is the point for which to find the closest documents, usually a `geo.Point`.
+	Near interface{}
+
+	// DistanceField is the output field of the calculated distance.
+	DistanceField string
+
+	// Spherical uses spherical geometry to calculate distances; it is required by 2d indexes.
+	Spherical interface{}
+
+	// MinDistance and MaxDistance are in meters for GeoJSON points, and in radians for legacy coordinate pairs.
+	MinDistance interface{}
+	MaxDistance interface{}
+
+	// Query limits the results to the documents matching the filter.
+	Query interface{}
+
+	// DistanceMultiplier multiplies the calculated distances, e.g. to convert radians to kilometers.
+	DistanceMultiplier interface{}
+
+	// IncludeLocs is the output field of the location used to calculate the distance.
+	IncludeLocs string
+
+	// Key is the geospatial indexed field to use, if the collection has several geospatial indexes.
+	Key string
+}
+
+// GeoNear function returns a mongo $geoNear operator used in aggregations.
+func GeoNear(opts GeoNearOptions) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Near, opts.Near)
+	appendNotEmpty(m, f.DistanceField, opts.DistanceField)
+	appendNotNull(m, f.Spherical, opts.Spherical)
+	appendNotNull(m, f.MinDistance, opts.MinDistance)
+	appendNotNull(m, f.MaxDistance, opts.MaxDistance)
+	appendNotNull(m, f.Query, opts.Query)
+	appendNotNull(m, f.DistanceMultiplier, opts.DistanceMultiplier)
+	appendNotEmpty(m, f.IncludeLocs, opts.IncludeLocs)
+	appendNotEmpty(m, f.Key, opts.Key)
+
+	return New(o.GeoNear, m)
+}
@@ -0,0 +1,48 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/softwok/mongo-util/geo"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNear(t *testing.T) {
+	point := geo.NewPoint(-73.97, 40.77)
+
+	assert.Equal(t, bson.M{"$near": bson.M{"$geometry": point, "$maxDistance": 1000}}, S(Near(point, nil, 1000)))
+	assert.Equal(t, bson.M{"$nearSphere": bson.M{"$geometry": point, "$minDistance": 10, "$maxDistance": 1000}}, S(NearSphere(point, 10, 1000)))
+}
+
+func TestGeoWithin(t *testing.T) {
+	assert.Equal(t,
+		bson.M{"$geoWithin": bson.M{"$box": bson.A{geo.Position{0, 0}, geo.Position{10, 10}}}},
+		S(GeoWithinBox(geo.Position{0, 0}, geo.Position{10, 10})))
+	assert.Equal(t,
+		bson.M{"$geoWithin": bson.M{"$polygon": []geo.Position{{0, 0}, {3, 6}, {6, 0}}}},
+		S(GeoWithinPolygon(geo.Position{0, 0}, geo.Position{3, 6}, geo.Position{6, 0})))
+	assert.Equal(t,
+		bson.M{"$geoWithin": bson.M{"$centerSphere": bson.A{geo.Position{-88, 30}, 0.1}}},
+		S(GeoWithinCenterSphere(geo.Position{-88, 30}, 0.1)))
+}
+
+func TestGeoNear(t *testing.T) {
+	point := geo.NewPoint(-73.97, 40.77)
+	stage := GeoNear(GeoNearOptions{
+		Near:          point,
+		DistanceField: "dist.calculated",
+		Spherical:     true,
+		MaxDistance:   2000,
+		Query:         bson.M{"category": "Parks"},
+	})
+
+	assert.Equal(t, "$geoNear", stage.GetKey())
+	assert.Equal(t, bson.M{
+		"near":          point,
+		"distanceField": "dist.calculated",
+		"spherical":     true,
+		"maxDistance":   2000,
+		"query":         bson.M{"category": "Parks"},
+	}, stage.GetVal())
+}
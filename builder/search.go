@@ -0,0 +1,255 @@
+package builder
+
+import (
+	f "github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SearchOptions struct contains the parameters of the Atlas Search $search and $searchMeta stages.
+type SearchOptions struct {
+	// Index is the name of the search index, "default" if empty.
+	Index string
+
+	// Operator is the search operator, e.g. SearchText(...) or SearchCompound(...).
+	Operator Operator
+
+	// Highlight is usually the result of SearchHighlight.
+	Highlight interface{}
+
+	// Count is e.g. `bson.M{"type": "total"}`, the count is returned in `$$SEARCH_META`.
+	Count interface{}
+
+	Sort               interface{}
+	ReturnStoredSource bool
+	ScoreDetails       bool
+}
+
+// Search function returns an Atlas Search $search stage used in aggregations.
+func Search(opts SearchOptions) Operator {
+	return New(o.Search, searchSpec(opts))
+}
+
+// SearchMeta function returns an Atlas Search $searchMeta stage used in aggregations, which
+// returns the metadata (e.g. the count) of the search results.
+func SearchMeta(opts SearchOptions) Operator {
+	return New(o.SearchMeta, searchSpec(opts))
+}
+
+func searchSpec(opts SearchOptions) bson.M {
+	m := bson.M{}
+
+	appendNotEmpty(m, f.Index, opts.Index)
+	if opts.Operator != nil {
+		m[opts.Operator.GetKey()] = opts.Operator.GetVal()
+	}
+	appendNotNull(m, f.Highlight, opts.Highlight)
+	appendNotNull(m, f.Count, opts.Count)
+	appendNotNull(m, f.Sort, opts.Sort)
+	if opts.ReturnStoredSource {
+		m[f.ReturnStoredSource] = true
+	}
+	if opts.ScoreDetails {
+		m[f.ScoreDetails] = true
+	}
+
+	return m
+}
+
+// SearchHighlight function returns the highlight option of the $search stage. The path is a
+// field name, a list of field names or a wildcard; nil limits are omitted.
+func SearchHighlight(path, maxCharsToExamine, maxNumPassages interface{}) bson.M {
+	m := bson.M{}
+
+	appendNotNull(m, f.Path, path)
+	appendNotNull(m, f.MaxCharsToExamine, maxCharsToExamine)
+	appendNotNull(m, f.MaxNumPassages, maxNumPassages)
+
+	return m
+}
+
+// SearchText function returns the text search operator, matching the analyzed terms of the
+// query. The fuzzy option is e.g. `bson.M{"maxEdits": 1}`, and synonyms is the name of a
+// synonym mapping; nil options are omitted.
+func SearchText(query, path, fuzzy, synonyms, score interface{}) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Query, query)
+	appendNotNull(m, f.Path, path)
+	appendNotNull(m, f.Fuzzy, fuzzy)
+	appendNotNull(m, f.Synonyms, synonyms)
+	appendNotNull(m, f.Score, score)
+
+	return New(f.Text, m)
+}
+
+// SearchPhrase function returns the phrase search operator, matching the terms of the query
+// in order, with at most slop other terms between them.
+func SearchPhrase(query, path, slop, score interface{}) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Query, query)
+	appendNotNull(m, f.Path, path)
+	appendNotNull(m, f.Slop, slop)
+	appendNotNull(m, f.Score, score)
+
+	return New(f.Phrase, m)
+}
+
+// SearchAutocomplete function returns the autocomplete search operator, matching the
+// incomplete words of the query in a field indexed with the autocomplete type. The token
+// order is "any" or "sequential".
+func SearchAutocomplete(query, path, tokenOrder, fuzzy, score interface{}) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Query, query)
+	appendNotNull(m, f.Path, path)
+	appendNotNull(m, f.TokenOrder, tokenOrder)
+	appendNotNull(m, f.Fuzzy, fuzzy)
+	appendNotNull(m, f.Score, score)
+
+	return New(f.Autocomplete, m)
+}
+
+// SearchRange function returns the range search operator, matching the numbers or dates of
+// the path within the bounds; nil bounds are omitted.
+func SearchRange(path, gt, gte, lt, lte, score interface{}) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Path, path)
+	appendNotNull(m, f.Gt, gt)
+	appendNotNull(m, f.Gte, gte)
+	appendNotNull(m, f.Lt, lt)
+	appendNotNull(m, f.Lte, lte)
+	appendNotNull(m, f.Score, score)
+
+	return New(f.Range, m)
+}
+
+// SearchNear function returns the near search operator, scoring the numbers, dates or
+// `geo.Point` of the path by proximity to the origin. Documents at the pivot distance get
+// half of the maximum score.
+func SearchNear(path, origin, pivot, score interface{}) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Path, path)
+	appendNotNull(m, f.Origin, origin)
+	appendNotNull(m, f.Pivot, pivot)
+	appendNotNull(m, f.Score, score)
+
+	return New(f.Near, m)
+}
+
+// CompoundOptions struct contains the clauses of the compound search operator.
+type CompoundOptions struct {
+	// Must clauses must match, and contribute to the score.
+	Must []Operator
+
+	// MustNot clauses must not match.
+	MustNot []Operator
+
+	// Should clauses contribute to the score, at least MinimumShouldMatch of them must match.
+	Should             []Operator
+	MinimumShouldMatch interface{}
+
+	// Filter clauses must match, and do not contribute to the score.
+	Filter []Operator
+
+	Score interface{}
+}
+
+// SearchCompound function returns the compound search operator, combining the clauses of
+// other search operators.
+func SearchCompound(opts CompoundOptions) Operator {
+	m := bson.M{}
+
+	appendClauses(m, f.Must, opts.Must)
+	appendClauses(m, f.MustNot, opts.MustNot)
+	appendClauses(m, f.Should, opts.Should)
+	appendClauses(m, f.Filter, opts.Filter)
+	appendNotNull(m, f.MinimumShouldMatch, opts.MinimumShouldMatch)
+	appendNotNull(m, f.Score, opts.Score)
+
+	return New(f.Compound, m)
+}
+
+func appendClauses(m bson.M, key string, clauses []Operator) {
+	if len(clauses) == 0 {
+		return
+	}
+	a := make(bson.A, 0, len(clauses))
+	for _, clause := range clauses {
+		a = append(a, S(clause))
+	}
+	m[key] = a
+}
+
+// SearchScoreBoost function returns the score option multiplying the score of the matching
+// documents by the value.
+func SearchScoreBoost(value float64) bson.M {
+	return bson.M{f.Boost: bson.M{f.Value: value}}
+}
+
+// SearchScoreBoostPath function returns the score option multiplying the score of the matching
+// documents by the numeric field, or by the undefined value if the field is missing.
+func SearchScoreBoostPath(path string, undefined interface{}) bson.M {
+	m := bson.M{f.Path: path}
+
+	appendNotNull(m, f.Undefined, undefined)
+
+	return bson.M{f.Boost: m}
+}
+
+// SearchScoreConstant function returns the score option replacing the score of the matching
+// documents by the value.
+func SearchScoreConstant(value float64) bson.M {
+	return bson.M{f.Constant: bson.M{f.Value: value}}
+}
+
+// VectorSearchOptions struct contains the parameters of the Atlas $vectorSearch stage.
+type VectorSearchOptions struct {
+	// Index is the name of the vector search index.
+	Index string
+
+	// Path is the indexed vector field.
+	Path string
+
+	// QueryVector is the vector to search, e.g. a []float64.
+	QueryVector interface{}
+
+	// NumCandidates is the number of nearest neighbors to consider, required unless Exact.
+	NumCandidates interface{}
+
+	// Limit is the number of documents to return.
+	Limit interface{}
+
+	// Filter is a filter of the indexed filter fields, e.g. `bson.M{"year": bson.M{"$gt": 2000}}`.
+	Filter interface{}
+
+	// Exact runs an exact nearest neighbors search rather than an approximate one.
+	Exact bool
+}
+
+// VectorSearch function returns an Atlas $vectorSearch stage used in aggregations. The score
+// of the documents is projected with `Meta(field.VectorSearchScore)`.
+func VectorSearch(opts VectorSearchOptions) Operator {
+	m := bson.M{}
+
+	appendNotEmpty(m, f.Index, opts.Index)
+	appendNotEmpty(m, f.Path, opts.Path)
+	appendNotNull(m, f.QueryVector, opts.QueryVector)
+	appendNotNull(m, f.NumCandidates, opts.NumCandidates)
+	appendNotNull(m, f.Limit, opts.Limit)
+	appendNotNull(m, f.Filter, opts.Filter)
+	if opts.Exact {
+		m[f.Exact] = true
+	}
+
+	return New(o.VectorSearch, m)
+}
+
+// Meta function returns a mongo $meta expression, used in projections to return the metadata
+// of the documents, e.g. `Meta(field.SearchScore)` or `Meta(field.SearchHighlights)`.
+func Meta(keyword string) Operator {
+	return New(o.Meta, keyword)
+}
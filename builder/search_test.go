@@ -0,0 +1,112 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	f "github.com/softwok/mongo-util/field"
+	"github.com/softwok/mongo-util/geo"
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// assertGolden compares the relaxed Extended JSON of the pipeline, with sorted keys, to the golden file.
+func assertGolden(t *testing.T, name string, pipeline ...Operator) {
+	t.Helper()
+	stages := bson.A{}
+	for _, stage := range pipeline {
+		stages = append(stages, S(stage))
+	}
+	data, err := bson.MarshalExtJSON(bson.M{"pipeline": stages}, false, false)
+	util.AssertErrIsNil(t, err)
+
+	// Decode with numbers as literals, so that the output keeps the Extended JSON numbers (e.g. 1.0).
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	util.AssertErrIsNil(t, dec.Decode(&doc))
+	got, err := json.MarshalIndent(doc.(map[string]interface{})["pipeline"], "", "  ")
+	util.AssertErrIsNil(t, err)
+	got = append(got, '\n')
+
+	golden := filepath.Join("testdata", "search", name+".json")
+	if *update {
+		util.AssertErrIsNil(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+		util.AssertErrIsNil(t, os.WriteFile(golden, got, 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, string(want), string(got), name)
+}
+
+func TestSearchText(t *testing.T) {
+	assertGolden(t, "text",
+		Search(SearchOptions{
+			Index:     "products",
+			Operator:  SearchText("red apple", bson.A{"name", "description"}, bson.M{"maxEdits": 1}, nil, SearchScoreBoost(2)),
+			Highlight: SearchHighlight("description", nil, 3),
+		}),
+		New("$project", bson.M{
+			"name":       1,
+			"score":      S(Meta(f.SearchScore)),
+			"highlights": S(Meta(f.SearchHighlights)),
+		}),
+	)
+}
+
+func TestSearchCompound(t *testing.T) {
+	assertGolden(t, "compound",
+		Search(SearchOptions{
+			Operator: SearchCompound(CompoundOptions{
+				Must: []Operator{SearchPhrase("organic green tea", "name", 2, nil)},
+				MustNot: []Operator{
+					SearchText("decaf", "tags", nil, nil, nil),
+				},
+				Should: []Operator{
+					SearchAutocomplete("gre", "name", "sequential", nil, SearchScoreConstant(1)),
+					SearchNear("released", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), int64(7*24*time.Hour/time.Millisecond), nil),
+				},
+				MinimumShouldMatch: 1,
+				Filter:             []Operator{SearchRange("price", nil, 5.0, 20, nil, nil)},
+			}),
+			Count:        bson.M{f.Type: "total"},
+			ScoreDetails: true,
+		}),
+		New("$project", bson.M{"meta": f.SearchMetaVar, "details": S(Meta(f.SearchScoreDetails))}),
+	)
+}
+
+func TestSearchMetaAndGeo(t *testing.T) {
+	assertGolden(t, "meta",
+		SearchMeta(SearchOptions{
+			Index:    "stores",
+			Operator: SearchNear("location", geo.NewPoint(-73.97, 40.77), 1000, SearchScoreBoostPath("rating", 1)),
+			Count:    bson.M{f.Type: "lowerBound", f.Threshold: 1000},
+		}),
+	)
+}
+
+func TestVectorSearch(t *testing.T) {
+	assertGolden(t, "vector",
+		VectorSearch(VectorSearchOptions{
+			Index:         "embeddings",
+			Path:          "plot_embedding",
+			QueryVector:   []float64{0.12, -0.5, 1},
+			NumCandidates: 100,
+			Limit:         10,
+			Filter:        bson.M{"year": bson.M{"$gt": 2000}},
+		}),
+		New("$project", bson.M{"title": 1, "score": S(Meta(f.VectorSearchScore))}),
+	)
+	assertGolden(t, "vector_exact",
+		VectorSearch(VectorSearchOptions{Index: "embeddings", Path: "plot_embedding", QueryVector: bson.A{1, 0}, Limit: 5, Exact: true}),
+	)
+}
@@ -11,3 +11,10 @@ func appendNotNull(m bson.M, key string, val interface{}) {
 		m[key] = val
 	}
 }
+
+// appendNotEmpty appends the provided key and value to the map if the value is not empty.
+func appendNotEmpty(m bson.M, key string, val string) {
+	if val != "" {
+		m[key] = val
+	}
+}
@@ -0,0 +1,137 @@
+package builder
+
+import (
+	f "github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SetWindowFields function returns a mongo $setWindowFields operator used in aggregations. The
+// output contains the window output of each field, e.g.
+// `bson.M{"total": WindowOutput(New(o.Sum, "$qty"), DocumentsWindow(f.Unbounded, f.Current))}`.
+func SetWindowFields(partitionBy, sortBy, output interface{}) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.PartitionBy, partitionBy)
+	appendNotNull(m, f.SortBy, sortBy)
+	appendNotNull(m, f.Output, output)
+
+	return New(o.SetWindowFields, m)
+}
+
+// WindowOutput function returns the output of a field of $setWindowFields, computed by the window
+// operator over the window (usually the result of DocumentsWindow or RangeWindow). A nil window is
+// omitted, e.g. for operators like $rank that don't use one.
+func WindowOutput(operator Operator, window interface{}) bson.M {
+	m := bson.M{operator.GetKey(): operator.GetVal()}
+
+	appendNotNull(m, f.Window, window)
+
+	return m
+}
+
+// DocumentsWindow function returns a window of documents, whose bounds are positions relative to
+// the current document (e.g. -1 for the previous document), `field.Current` or `field.Unbounded`.
+func DocumentsWindow(lower, upper interface{}) bson.M {
+	return bson.M{f.Documents: bson.A{lower, upper}}
+}
+
+// RangeWindow function returns a window of the documents whose sortBy field value is in the range,
+// relative to the current document's value. The bounds are numbers, `field.Current` or
+// `field.Unbounded`. The unit (e.g. `field.Day`) is required for date values, and empty otherwise.
+func RangeWindow(lower, upper interface{}, unit string) bson.M {
+	m := bson.M{f.Range: bson.A{lower, upper}}
+
+	appendNotEmpty(m, f.Unit, unit)
+
+	return m
+}
+
+// Rank function returns a mongo $rank window operator, the rank of the document in the partition
+// with gaps for ties.
+func Rank() Operator {
+	return New(o.Rank, bson.M{})
+}
+
+// DenseRank function returns a mongo $denseRank window operator, the rank of the document in the
+// partition without gaps for ties.
+func DenseRank() Operator {
+	return New(o.DenseRank, bson.M{})
+}
+
+// DocumentNumber function returns a mongo $documentNumber window operator, the position of the
+// document in the partition.
+func DocumentNumber() Operator {
+	return New(o.DocumentNumber, bson.M{})
+}
+
+// Shift function returns a mongo $shift window operator, the value of the output expression for
+// the document at the given position relative to the current document, or the default value.
+func Shift(output, by, def interface{}) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Output, output)
+	appendNotNull(m, f.By, by)
+	appendNotNull(m, f.Default, def)
+
+	return New(o.Shift, m)
+}
+
+// Derivative function returns a mongo $derivative window operator, the average rate of change of
+// the input in the window. The unit is required if the sortBy field is a date.
+func Derivative(input interface{}, unit string) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Input, input)
+	appendNotEmpty(m, f.Unit, unit)
+
+	return New(o.Derivative, m)
+}
+
+// Integral function returns a mongo $integral window operator, the area under the curve of the
+// input in the window. The unit is required if the sortBy field is a date.
+func Integral(input interface{}, unit string) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Input, input)
+	appendNotEmpty(m, f.Unit, unit)
+
+	return New(o.Integral, m)
+}
+
+// ExpMovingAvg function returns a mongo $expMovingAvg window operator, the exponential moving
+// average of the input weighted by either the number of documents n or the alpha factor; the
+// other one must be nil.
+func ExpMovingAvg(input, n, alpha interface{}) Operator {
+	m := bson.M{}
+
+	appendNotNull(m, f.Input, input)
+	appendNotNull(m, f.N, n)
+	appendNotNull(m, f.Alpha, alpha)
+
+	return New(o.ExpMovingAvg, m)
+}
+
+// CovariancePop function returns a mongo $covariancePop window operator, the population covariance
+// of the two numeric expressions in the window.
+func CovariancePop(expr1, expr2 interface{}) Operator {
+	return New(o.CovariancePop, bson.A{expr1, expr2})
+}
+
+// CovarianceSamp function returns a mongo $covarianceSamp window operator, the sample covariance
+// of the two numeric expressions in the window.
+func CovarianceSamp(expr1, expr2 interface{}) Operator {
+	return New(o.CovarianceSamp, bson.A{expr1, expr2})
+}
+
+// LinearFill function returns a mongo $linearFill window operator, filling the null and missing
+// values of the expression by linear interpolation.
+func LinearFill(expr interface{}) Operator {
+	return New(o.LinearFill, expr)
+}
+
+// Locf function returns a mongo $locf window operator, filling the null and missing values of the
+// expression with the last non-null value.
+func Locf(expr interface{}) Operator {
+	return New(o.Locf, expr)
+}
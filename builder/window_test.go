@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"testing"
+
+	f "github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSetWindowFields(t *testing.T) {
+	stage := SetWindowFields("$state", bson.D{{Key: "orderDate", Value: 1}}, bson.M{
+		"cumulativeQuantity": WindowOutput(New(o.Sum, "$quantity"), DocumentsWindow(f.Unbounded, f.Current)),
+		"weeklyAverage":      WindowOutput(New(o.Avg, "$quantity"), RangeWindow(-1, 0, f.Week)),
+		"rank":               WindowOutput(Rank(), nil),
+	})
+
+	assert.Equal(t, "$setWindowFields", stage.GetKey())
+	assert.Equal(t, bson.M{
+		"partitionBy": "$state",
+		"sortBy":      bson.D{{Key: "orderDate", Value: 1}},
+		"output": bson.M{
+			"cumulativeQuantity": bson.M{"$sum": "$quantity", "window": bson.M{"documents": bson.A{"unbounded", "current"}}},
+			"weeklyAverage":      bson.M{"$avg": "$quantity", "window": bson.M{"range": bson.A{-1, 0}, "unit": "week"}},
+			"rank":               bson.M{"$rank": bson.M{}},
+		},
+	}, stage.GetVal())
+
+	assert.Equal(t, bson.M{"range": bson.A{-10, 10}}, RangeWindow(-10, 10, ""))
+}
+
+func TestWindowOperators(t *testing.T) {
+	assert.Equal(t, bson.M{"$denseRank": bson.M{}}, S(DenseRank()))
+	assert.Equal(t, bson.M{"$documentNumber": bson.M{}}, S(DocumentNumber()))
+	assert.Equal(t, bson.M{"$shift": bson.M{"output": "$quantity", "by": -1, "default": 0}}, S(Shift("$quantity", -1, 0)))
+	assert.Equal(t, bson.M{"$derivative": bson.M{"input": "$miles", "unit": "hour"}}, S(Derivative("$miles", f.Hour)))
+	assert.Equal(t, bson.M{"$integral": bson.M{"input": "$kW"}}, S(Integral("$kW", "")))
+	assert.Equal(t, bson.M{"$expMovingAvg": bson.M{"input": "$price", "N": 2}}, S(ExpMovingAvg("$price", 2, nil)))
+	assert.Equal(t, bson.M{"$expMovingAvg": bson.M{"input": "$price", "alpha": 0.75}}, S(ExpMovingAvg("$price", nil, 0.75)))
+	assert.Equal(t, bson.M{"$covariancePop": bson.A{"$year", "$quantity"}}, S(CovariancePop("$year", "$quantity")))
+	assert.Equal(t, bson.M{"$covarianceSamp": bson.A{"$year", "$quantity"}}, S(CovarianceSamp("$year", "$quantity")))
+	assert.Equal(t, bson.M{"$linearFill": "$price"}, S(LinearFill("$price")))
+	assert.Equal(t, bson.M{"$locf": "$price"}, S(Locf("$price")))
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/softwok/mongo-util/mdu"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+)
+
+// mixins are the mdu structs that models embed. Their fields are read by reflection,
+// so the generated names always match the mdu package.
+var mixins = map[string]reflect.Type{
+	"DefaultModel":       reflect.TypeOf(mdu.DefaultModel{}),
+	"DefaultTenantModel": reflect.TypeOf(mdu.DefaultTenantModel{}),
+	"IDField":            reflect.TypeOf(mdu.IDField{}),
+	"ObjectIDField":      reflect.TypeOf(mdu.ObjectIDField{}),
+	"DateFields":         reflect.TypeOf(mdu.DateFields{}),
+	"TenantIdField":      reflect.TypeOf(mdu.TenantIdField{}),
+	"ChangeTracker":      reflect.TypeOf(mdu.ChangeTracker{}),
+}
+
+// constant is a generated field name constant.
+type constant struct {
+	name string
+	path string
+}
+
+type model struct {
+	name      string
+	constants []constant
+}
+
+// generator reads the struct types of a package and generates the field name constants of its models.
+type generator struct {
+	pkg     string
+	structs map[string]*ast.StructType
+	order   []string
+}
+
+// parseDir parses the non-test Go files of the directory, except the skipped file.
+func parseDir(dir, skip string) (*generator, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	g := &generator{structs: map[string]*ast.StructType{}}
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") || filepath.Base(path) == skip {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		if g.pkg == "" {
+			g.pkg = f.Name.Name
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if st, ok := ts.Type.(*ast.StructType); ok && ts.TypeParams == nil {
+					g.structs[ts.Name.Name] = st
+					g.order = append(g.order, ts.Name.Name)
+				}
+			}
+		}
+	}
+	if g.pkg == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+	return g, nil
+}
+
+// models returns the given types, or the struct types embedding an mdu mixin if none is given.
+func (g *generator) models(types []string) ([]model, error) {
+	if len(types) == 0 {
+		for _, name := range g.order {
+			if embedsMixin(g.structs[name]) {
+				types = append(types, name)
+			}
+		}
+	}
+
+	models := make([]model, 0, len(types))
+	for _, name := range types {
+		st, ok := g.structs[name]
+		if !ok {
+			return nil, fmt.Errorf("struct type %s not found in package %s", name, g.pkg)
+		}
+		m := model{name: name}
+		seen := map[string]bool{}
+		g.walk(st, name, "", map[string]bool{name: true}, func(c constant) {
+			if !seen[c.name] {
+				seen[c.name] = true
+				m.constants = append(m.constants, c)
+			}
+		})
+		models = append(models, m)
+	}
+	return models, nil
+}
+
+func embedsMixin(st *ast.StructType) bool {
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			if _, ok := mixins[mixinName(f.Type)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mixinName returns the name of an mdu mixin type expression (e.g. `mdu.DefaultModel`), or "".
+func mixinName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if sel, ok := expr.(*ast.SelectorExpr); ok {
+		if _, ok := mixins[sel.Sel.Name]; ok {
+			return sel.Sel.Name
+		}
+	}
+	return ""
+}
+
+// walk emits the constants of the struct fields, following the tag rules of the driver's struct codec.
+func (g *generator) walk(st *ast.StructType, name, path string, visiting map[string]bool, emit func(constant)) {
+	for _, f := range st.Fields.List {
+		tag := ""
+		if f.Tag != nil {
+			unquoted, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(unquoted).Get("bson")
+		}
+		key, opts, _ := strings.Cut(tag, ",")
+		if key == "-" && opts == "" {
+			continue
+		}
+		inline := false
+		for _, opt := range strings.Split(opts, ",") {
+			inline = inline || opt == "inline"
+		}
+
+		var goNames []string
+		if len(f.Names) == 0 {
+			goNames = []string{typeName(f.Type)}
+		} else {
+			for _, n := range f.Names {
+				goNames = append(goNames, n.Name)
+			}
+		}
+
+		for _, goName := range goNames {
+			if !ast.IsExported(goName) && len(f.Names) > 0 {
+				continue
+			}
+			if inline {
+				g.nested(f.Type, name, path, visiting, emit)
+				continue
+			}
+
+			fieldKey := key
+			if fieldKey == "" {
+				fieldKey = strings.ToLower(goName)
+			}
+			c := constant{name: name + goName, path: path + fieldKey}
+			emit(c)
+			g.nested(f.Type, c.name, c.path+".", visiting, emit)
+		}
+	}
+}
+
+// nested emits the constants of the fields of a nested struct type, including the elements of slices.
+func (g *generator) nested(expr ast.Expr, name, path string, visiting map[string]bool, emit func(constant)) {
+	for {
+		switch t := expr.(type) {
+		case *ast.StarExpr:
+			expr = t.X
+			continue
+		case *ast.ArrayType:
+			expr = t.Elt
+			continue
+		case *ast.StructType:
+			g.walk(t, name, path, visiting, emit)
+		case *ast.Ident:
+			if st, ok := g.structs[t.Name]; ok && !visiting[t.Name] {
+				visiting[t.Name] = true
+				g.walk(st, name, path, visiting, emit)
+				delete(visiting, t.Name)
+			}
+		case *ast.SelectorExpr:
+			if mixin, ok := mixins[t.Sel.Name]; ok {
+				walkMixin(mixin, name, path, emit)
+			}
+		}
+		return
+	}
+}
+
+func walkMixin(t reflect.Type, name, path string, emit func(constant)) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tags, err := bsoncodec.DefaultStructTagParser(sf)
+		if err != nil || tags.Skip || (!sf.IsExported() && !sf.Anonymous) {
+			continue
+		}
+		if tags.Inline {
+			walkMixin(sf.Type, name, path, emit)
+			continue
+		}
+		emit(constant{name: name + sf.Name, path: path + tags.Name})
+	}
+}
+
+func typeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return typeName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// generate returns the formatted source of the constants.
+func generate(pkg string, models []model, args []string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by \"%s\"; DO NOT EDIT.\n\n", strings.Join(append([]string{"mdu-gen"}, args...), " "))
+	fmt.Fprintf(&buf, "package %s\n", pkg)
+
+	for _, m := range models {
+		fmt.Fprintf(&buf, "\n// %s field names.\nconst (\n", m.name)
+		for _, c := range m.constants {
+			fmt.Fprintf(&buf, "\t%s = %q\n", c.name, c.path)
+		}
+		buf.WriteString(")\n")
+	}
+
+	return format.Source(buf.Bytes())
+}
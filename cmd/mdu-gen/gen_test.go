@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerateGolden(t *testing.T) {
+	tests := []struct {
+		golden string
+		types  []string
+		args   []string
+	}{
+		{"models.golden", nil, nil},
+		{"node.golden", []string{"Node"}, []string{"-type=Node"}},
+	}
+
+	for _, tt := range tests {
+		g, err := parseDir(filepath.Join("testdata", "models"), "")
+		util.AssertErrIsNil(t, err)
+		models, err := g.models(tt.types)
+		util.AssertErrIsNil(t, err)
+		src, err := generate(g.pkg, models, tt.args)
+		util.AssertErrIsNil(t, err)
+
+		golden := filepath.Join("testdata", tt.golden)
+		if *update {
+			util.AssertErrIsNil(t, os.WriteFile(golden, src, 0o644))
+		}
+		want, err := os.ReadFile(golden)
+		util.AssertErrIsNil(t, err)
+		assert.Equal(t, string(want), string(src))
+	}
+}
+
+func TestUnknownType(t *testing.T) {
+	g, err := parseDir(filepath.Join("testdata", "models"), "")
+	util.AssertErrIsNil(t, err)
+	_, err = g.models([]string{"Missing"})
+	assert.EqualError(t, err, "struct type Missing not found in package models")
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	src, err := os.ReadFile(filepath.Join("testdata", "models", "models.go"))
+	util.AssertErrIsNil(t, err)
+	util.AssertErrIsNil(t, os.WriteFile(filepath.Join(dir, "models.go"), src, 0o644))
+
+	util.AssertErrIsNil(t, run(dir, "Order", "fields.go", []string{"-type=Order", "-output=fields.go"}))
+	// The output file is skipped when generating again.
+	util.AssertErrIsNil(t, run(dir, "Order", "fields.go", []string{"-type=Order", "-output=fields.go"}))
+
+	out, err := os.ReadFile(filepath.Join(dir, "fields.go"))
+	util.AssertErrIsNil(t, err)
+	assert.Contains(t, string(out), `OrderCreatedAt = "created_at"`)
+}
@@ -0,0 +1,74 @@
+// Command mdu-gen generates bson field name constants for mdu models, so that filters, sorts
+// and `Patch` maps reference fields by constant rather than by raw string.
+//
+// For each model it generates a constant per persisted field, named after the model and the
+// Go field path, including the fields of embedded mdu structs and the dotted paths of nested
+// structs:
+//
+//	// Product field names.
+//	const (
+//		ProductID           = "_id"
+//		ProductCreatedAt    = "created_at"
+//		ProductUpdatedAt    = "updated_at"
+//		ProductName         = "name"
+//		ProductDetails      = "details"
+//		ProductDetailsColor = "details.color"
+//	)
+//
+// Usage, in a file of the models' package:
+//
+//	//go:generate go run github.com/softwok/mongo-util/cmd/mdu-gen -type=Product,Order
+//
+// Without -type, constants are generated for all the struct types embedding an mdu struct
+// (`DefaultModel`, `IDField`, ...).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	types := flag.String("type", "", "comma-separated list of model type names; all models if empty")
+	output := flag.String("output", "mdu_fields_gen.go", "output file name, relative to the package directory")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mdu-gen [-type=T1,T2] [-output=file.go] [dir]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	if err := run(dir, *types, *output, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "mdu-gen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, types, output string, args []string) error {
+	g, err := parseDir(dir, output)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if types != "" {
+		names = strings.Split(types, ",")
+	}
+	models, err := g.models(names)
+	if err != nil {
+		return err
+	}
+
+	src, err := generate(g.pkg, models, args)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, output), src, 0o644)
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/softwok/mongo-util/mdu"
+)
+
+type Product struct {
+	mdu.DefaultModel `bson:",inline"`
+	Name             string   `json:"name" bson:"name"`
+	Price            int      `bson:"price,omitempty"`
+	Tags             []string `bson:"tags"`
+	Details          struct {
+		Color string `bson:"color"`
+	} `bson:"details"`
+	Variants []Variant `bson:"variants"`
+	Internal string    `bson:"-"`
+	Stock    int
+	cache    string
+}
+
+type Variant struct {
+	SKU       string     `bson:"sku"`
+	Dimension *Dimension `bson:"dim"`
+}
+
+type Dimension struct {
+	Width, Height float64
+}
+
+type Order struct {
+	mdu.ObjectIDField `bson:",inline"`
+	mdu.DateFields    `bson:",inline"`
+	Audit             `bson:",inline"`
+	Number            string    `bson:"number"`
+	ShippedAt         time.Time `bson:"shipped_at"`
+}
+
+type Audit struct {
+	By string `bson:"by"`
+}
+
+// Node is not a model, but can be generated with -type.
+type Node struct {
+	Name     string  `bson:"name"`
+	Children []*Node `bson:"children"`
+}
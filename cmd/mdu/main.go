@@ -0,0 +1,27 @@
+// Command mdu runs common database tasks using the mdu connection configuration: listing
+// collections and indexes, applying declared indexes, running migrations, loading fixtures,
+// exporting and importing collections, running aggregation pipelines, and backing up and
+// restoring collections.
+//
+// Usage:
+//
+//	mdu [-uri uri] [-db name] [-timeout 30s] [-json] <command> [arguments]
+//
+// The uri and db flags default to the MDU_URI and MDU_DB environment variables. With -json,
+// results are written as JSON for scripts; documents are always written as relaxed Extended
+// JSON, one per line.
+//
+// This command has no registered models, so its declared indexes are those of the -file flag
+// of the indexes command. Programs registering their models run the same commands with
+// `cli.Run`, which also declares the indexes of the models.
+package main
+
+import (
+	"os"
+
+	"github.com/softwok/mongo-util/mdu/cli"
+)
+
+func main() {
+	os.Exit(cli.Run(nil, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
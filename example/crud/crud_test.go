@@ -1,11 +1,15 @@
 package crud
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/softwok/mongo-util/internal/util"
 	"github.com/softwok/mongo-util/mdu"
+	"github.com/softwok/mongo-util/mdu/backup"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"os"
 	"testing"
@@ -53,6 +57,25 @@ func TestCreate(t *testing.T) {
 	assert.NotNil(t, id)
 }
 
+func TestCreateWithObjectID(t *testing.T) {
+	resetCollection()
+
+	ordersColl := mdu.Coll(&order{})
+	testOrder := &order{Number: "A-1"}
+	id, err := ordersColl.Create(testOrder)
+	util.PanicErr(err)
+
+	assert.Equal(t, testOrder.ID, id)
+	assert.False(t, testOrder.ID.IsZero())
+
+	found := &order{}
+	err = ordersColl.FindByID(testOrder.ID.Hex(), found)
+	util.PanicErr(err)
+
+	assert.Equal(t, testOrder.ID, found.ID)
+	assert.Equal(t, "A-1", found.Number)
+}
+
 func TestFindById(t *testing.T) {
 	resetCollection()
 
@@ -86,6 +109,63 @@ func TestUpdate(t *testing.T) {
 	assert.NotNil(t, testProduct.ID)
 }
 
+func TestUpdateWithoutLoading(t *testing.T) {
+	productsColl := mdu.Coll(&product{})
+	testProduct := insertProduct(newProduct("TestCreate", 122))
+
+	partial := newProduct("TestUpdate", 0)
+	partial.ID = testProduct.ID
+	err := productsColl.Update(partial)
+	util.PanicErr(err)
+
+	err = productsColl.FindByID(testProduct.ID, partial)
+	util.PanicErr(err)
+
+	assert.Equal(t, "TestUpdate", partial.Name)
+	assert.Equal(t, testProduct.CreatedAt.Truncate(time.Millisecond), partial.CreatedAt)
+}
+
+func TestSave(t *testing.T) {
+	resetCollection()
+
+	productsColl := mdu.Coll(&product{})
+	testProduct := newProduct("TestSave", 120)
+	err := productsColl.Save(testProduct)
+	util.PanicErr(err)
+	assert.False(t, testProduct.CreatedAt.IsZero())
+
+	again := newProduct("TestSaveAgain", 121)
+	again.ID = testProduct.ID
+	err = productsColl.Save(again)
+	util.PanicErr(err)
+
+	err = productsColl.FindByID(testProduct.ID, again)
+	util.PanicErr(err)
+
+	assert.Equal(t, "TestSaveAgain", again.Name)
+	assert.Equal(t, testProduct.CreatedAt.Truncate(time.Millisecond), again.CreatedAt)
+}
+
+func TestFindOneAndUpdate(t *testing.T) {
+	resetCollection()
+
+	productsColl := mdu.Coll(&product{})
+	testProduct := insertProduct(newProduct("TestFindOneAndUpdate", 100))
+
+	updated := &product{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := productsColl.FindOneAndUpdate(bson.M{"name": "TestFindOneAndUpdate"}, bson.M{"$inc": bson.M{"price": 1}}, updated, opts)
+	util.PanicErr(err)
+
+	assert.Equal(t, testProduct.ID, updated.ID)
+	assert.Equal(t, 101, updated.Price)
+
+	deleted := &product{}
+	err = productsColl.FindOneAndDelete(bson.M{"name": "TestFindOneAndUpdate"}, deleted)
+	util.PanicErr(err)
+	assert.Equal(t, 101, deleted.Price)
+}
+
 func TestPatch(t *testing.T) {
 	productsColl := mdu.Coll(&product{})
 	testProduct := insertProduct(newProduct("TestCreate", 122))
@@ -125,6 +205,152 @@ func TestFindAll(t *testing.T) {
 	assert.Equal(t, 5, len(results))
 }
 
+func TestCountExistsDistinct(t *testing.T) {
+	resetCollection()
+	_ = createProduct("Product1", 100)
+	_ = createProduct("Product2", 200)
+	_ = createProduct("Product3", 200)
+
+	productsColl := mdu.Coll(&product{})
+	count, err := productsColl.Count(bson.M{"price": 200})
+	util.PanicErr(err)
+	assert.Equal(t, int64(2), count)
+
+	exists, err := productsColl.Exists(bson.M{"name": "Product1"})
+	util.PanicErr(err)
+	assert.True(t, exists)
+
+	prices, err := mdu.Distinct[int](mdu.Ctx(), productsColl, "price", bson.M{})
+	util.PanicErr(err)
+	assert.ElementsMatch(t, []int{100, 200}, prices)
+}
+
+func TestEach(t *testing.T) {
+	resetCollection()
+	_ = createProduct("Product1", 100)
+	_ = createProduct("Product2", 200)
+	_ = createProduct("Product3", 300)
+
+	productsColl := mdu.Coll(&product{})
+	total := 0
+	p := &product{}
+	err := productsColl.Each(bson.M{}, p, func() error {
+		total += p.Price
+		return nil
+	}, options.Find().SetBatchSize(1))
+	util.PanicErr(err)
+	assert.Equal(t, 600, total)
+
+	var names []string
+	err = mdu.EachBatch[product](mdu.Ctx(), productsColl, bson.M{}, 2, func(batch []*product) error {
+		for _, p := range batch {
+			names = append(names, p.Name)
+		}
+		return nil
+	})
+	util.PanicErr(err)
+	assert.Len(t, names, 3)
+}
+
+func TestFindPage(t *testing.T) {
+	resetCollection()
+	for i := 1; i <= 5; i++ {
+		_ = createProduct(fmt.Sprintf("Product%d", i), i*100)
+	}
+
+	productsColl := mdu.Coll(&product{})
+	for _, facet := range []bool{false, true} {
+		var results []product
+		page, err := productsColl.FindPage(&results, bson.M{}, 2, 2, &mdu.PageOptions{Sort: bson.M{"price": 1}, Facet: facet})
+		util.PanicErr(err)
+
+		assert.Equal(t, &mdu.Page{Page: 2, PerPage: 2, Total: 5, TotalPages: 3}, page)
+		assert.Equal(t, []int{300, 400}, []int{results[0].Price, results[1].Price})
+	}
+}
+
+func TestFindKeyset(t *testing.T) {
+	resetCollection()
+	for i := 1; i <= 5; i++ {
+		_ = createProduct(fmt.Sprintf("Product%d", i), (i%3)*100)
+	}
+
+	productsColl := mdu.Coll(&product{})
+	opts := mdu.KeysetOptions{Sort: bson.D{{Key: "price", Value: -1}}, Limit: 2}
+	var names []string
+	for {
+		var results []product
+		page, err := productsColl.FindKeyset(&results, bson.M{}, opts)
+		util.PanicErr(err)
+		for _, p := range results {
+			names = append(names, p.Name)
+		}
+		if page.Next == "" {
+			break
+		}
+		opts.After = page.Next
+	}
+
+	assert.Len(t, names, 5)
+	assert.ElementsMatch(t, []string{"Product2", "Product5"}, names[:2])
+}
+
+func TestBulk(t *testing.T) {
+	resetCollection()
+
+	productsColl := mdu.Coll(&product{})
+	products := make([]*product, 5)
+	for i := range products {
+		products[i] = newProduct(fmt.Sprintf("Product%d", i), i*100)
+	}
+	products[3].SetID(uuid.NewString())
+	products[4].SetID(products[3].ID)
+
+	res, err := productsColl.CreateMany(mdu.Models(products), mdu.BulkOpts().SetOrdered(false).SetChunkSize(2))
+	assert.Error(t, err)
+	assert.Equal(t, int64(4), res.InsertedCount)
+	assert.Len(t, res.Errors, 1)
+	assert.True(t, mongo.IsDuplicateKeyError(res.Errors[4]))
+
+	products[0].Price = 1000
+	res, err = productsColl.UpdateMany(mdu.Models(products[:4]))
+	util.PanicErr(err)
+	assert.Equal(t, int64(1), res.ModifiedCount)
+
+	res, err = productsColl.DeleteMany(mdu.Models(products[:2]))
+	util.PanicErr(err)
+	assert.Equal(t, int64(2), res.DeletedCount)
+}
+
+func TestBackup(t *testing.T) {
+	resetCollection()
+	createProduct("Backup1", 100)
+	createProduct("Backup2", 200)
+
+	productsColl := mdu.Coll(&product{})
+	_, err := productsColl.Indexes().CreateOne(mdu.Ctx(), mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}}})
+	util.PanicErr(err)
+
+	_, _, db, err := mdu.DefaultConfigs()
+	util.PanicErr(err)
+	var archive bytes.Buffer
+	res, err := backup.DumpArchive(mdu.Ctx(), db, &archive, &backup.Options{Collections: []string{productsColl.Name()}, Gzip: true})
+	util.PanicErr(err)
+	assert.Equal(t, int64(2), res.Documents[productsColl.Name()])
+
+	util.PanicErr(productsColl.Drop(mdu.Ctx()))
+	res, err = backup.RestoreArchive(mdu.Ctx(), db, &archive, &backup.RestoreOptions{Mode: backup.Drop})
+	util.PanicErr(err)
+	assert.Equal(t, int64(2), res.Documents[productsColl.Name()])
+
+	count, err := productsColl.Count(bson.M{})
+	util.PanicErr(err)
+	assert.Equal(t, int64(2), count)
+	specs, err := productsColl.Indexes().ListSpecifications(mdu.Ctx())
+	util.PanicErr(err)
+	assert.Len(t, specs, 2)
+}
+
 // -----------------
 // Helpers
 // -----------------
@@ -149,6 +375,12 @@ type product struct {
 	Price            int    `json:"price" bson:"price"`
 }
 
+type order struct {
+	mdu.ObjectIDField `bson:",inline"`
+	mdu.DateFields    `bson:",inline"`
+	Number            string `json:"number" bson:"number"`
+}
+
 func newProduct(name string, price int) *product {
 	return &product{
 		Name:  name,
@@ -174,7 +406,9 @@ func setup() {
 }
 
 func resetCollection() {
-	_, err := mdu.Coll(&product{}).DeleteMany(mdu.Ctx(), bson.M{})
+	_, err := mdu.Coll(&product{}).Collection.DeleteMany(mdu.Ctx(), bson.M{})
+	util.PanicErr(err)
 
+	_, err = mdu.Coll(&order{}).Collection.DeleteMany(mdu.Ctx(), bson.M{})
 	util.PanicErr(err)
 }
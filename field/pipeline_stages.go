@@ -110,6 +110,47 @@ const (
 	Size = "size"
 )
 
+// $setWindowFields
+const (
+	PartitionBy = "partitionBy"
+	SortBy      = "sortBy"
+	Window      = "window"
+	Documents   = "documents"
+	Unit        = "unit"
+	// Output = "output" // Declared
+	// Range  = "range" // Declared
+)
+
+// Window bounds
+const (
+	Current   = "current"
+	Unbounded = "unbounded"
+)
+
+// Window operators fields
+const (
+	Input = "input"
+	By    = "by"
+	N     = "N"
+	Alpha = "alpha"
+	// Output  = "output" // Declared
+	// Default = "default" // Declared
+	// Unit    = "unit" // Declared
+)
+
+// Time units of window ranges and of $derivative and $integral
+const (
+	Year        = "year"
+	Quarter     = "quarter"
+	Month       = "month"
+	Week        = "week"
+	Day         = "day"
+	Hour        = "hour"
+	Minute      = "minute"
+	Second      = "second"
+	Millisecond = "millisecond"
+)
+
 // $unwind
 const (
 	Path                       = "path"
@@ -0,0 +1,87 @@
+package field
+
+// Atlas Search reference: https://www.mongodb.com/docs/atlas/atlas-search/
+
+// $search and $searchMeta
+const (
+	Index              = "index"
+	Highlight          = "highlight"
+	ReturnStoredSource = "returnStoredSource"
+	ScoreDetails       = "scoreDetails"
+	Sort               = "sort"
+	Type               = "type"
+	Threshold          = "threshold"
+	// Count = "count" // Declared
+)
+
+// highlight
+const (
+	MaxCharsToExamine = "maxCharsToExamine"
+	MaxNumPassages    = "maxNumPassages"
+	// Path = "path" // Declared
+)
+
+// Search operators
+const (
+	Autocomplete = "autocomplete"
+	Compound     = "compound"
+	Phrase       = "phrase"
+	Range        = "range"
+	Text         = "text"
+	// Near = "near" // Declared
+)
+
+// Search operator fields
+const (
+	Score              = "score"
+	Fuzzy              = "fuzzy"
+	Synonyms           = "synonyms"
+	Slop               = "slop"
+	TokenOrder         = "tokenOrder"
+	Gt                 = "gt"
+	Gte                = "gte"
+	Lt                 = "lt"
+	Lte                = "lte"
+	Origin             = "origin"
+	Pivot              = "pivot"
+	Must               = "must"
+	MustNot            = "mustNot"
+	Should             = "should"
+	Filter             = "filter"
+	MinimumShouldMatch = "minimumShouldMatch"
+	// Query = "query" // Declared
+	// Path  = "path" // Declared
+)
+
+// score
+const (
+	Boost     = "boost"
+	Constant  = "constant"
+	Value     = "value"
+	Undefined = "undefined"
+	// Path = "path" // Declared
+)
+
+// $vectorSearch
+const (
+	QueryVector   = "queryVector"
+	NumCandidates = "numCandidates"
+	Limit         = "limit"
+	Exact         = "exact"
+	// Index  = "index" // Declared
+	// Path   = "path" // Declared
+	// Filter = "filter" // Declared
+)
+
+// $meta keywords
+const (
+	SearchScore        = "searchScore"
+	SearchHighlights   = "searchHighlights"
+	SearchScoreDetails = "searchScoreDetails"
+	VectorSearchScore  = "vectorSearchScore"
+	TextScore          = "textScore"
+	IndexKey           = "indexKey"
+
+	// SearchMetaVar is the variable of the $searchMeta results in the documents of a $search stage.
+	SearchMetaVar = "$$SEARCH_META"
+)
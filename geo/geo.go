@@ -0,0 +1,340 @@
+// Package geo contains GeoJSON geometry types, which are validated and marshaled to the
+// GeoJSON objects stored and queried by MongoDB.
+//
+// GeoJSON reference: https://www.mongodb.com/docs/manual/reference/geojson/
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/softwok/mongo-util/field"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// EarthRadius is the equatorial radius of the Earth in meters, to convert distances to
+// the radians of `$centerSphere` and legacy coordinate pair queries.
+const EarthRadius = 6378100.0
+
+// Radians returns the angle in radians of a distance in meters on the surface of the Earth.
+func Radians(meters float64) float64 {
+	return meters / EarthRadius
+}
+
+// Geometry is implemented by the GeoJSON geometry types.
+type Geometry interface {
+	// GeometryType returns the GeoJSON type of the geometry (e.g. `Point`).
+	GeometryType() string
+
+	// Validate returns an error if the geometry is not a valid GeoJSON geometry.
+	Validate() error
+}
+
+// Position is a pair of longitude and latitude coordinates, in this order.
+type Position [2]float64
+
+// Lng returns the longitude of the position.
+func (p Position) Lng() float64 {
+	return p[0]
+}
+
+// Lat returns the latitude of the position.
+func (p Position) Lat() float64 {
+	return p[1]
+}
+
+// Validate returns an error if the longitude is not between -180 and 180, or the latitude
+// is not between -90 and 90.
+func (p Position) Validate() error {
+	if math.IsNaN(p[0]) || p[0] < -180 || p[0] > 180 {
+		return fmt.Errorf("geo: longitude %v out of range [-180, 180]", p[0])
+	}
+	if math.IsNaN(p[1]) || p[1] < -90 || p[1] > 90 {
+		return fmt.Errorf("geo: latitude %v out of range [-90, 90]", p[1])
+	}
+	return nil
+}
+
+// Point is a GeoJSON point.
+type Point struct {
+	Coordinates Position
+}
+
+// NewPoint returns a point of the longitude and latitude.
+func NewPoint(lng, lat float64) Point {
+	return Point{Coordinates: Position{lng, lat}}
+}
+
+// LineString is a GeoJSON line string of two or more positions.
+type LineString struct {
+	Coordinates []Position
+}
+
+// Polygon is a GeoJSON polygon. The first ring is the exterior ring, and the others are holes.
+// Each ring is closed: it has at least four positions, and the last one is the first one.
+type Polygon struct {
+	Coordinates [][]Position
+}
+
+// NewPolygon returns a polygon of the rings. Rings that are not closed are closed by
+// appending their first position.
+func NewPolygon(rings ...[]Position) Polygon {
+	closed := make([][]Position, len(rings))
+	for i, ring := range rings {
+		closed[i] = ring
+		if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+			closed[i] = append(append([]Position{}, ring...), ring[0])
+		}
+	}
+	return Polygon{Coordinates: closed}
+}
+
+// MultiPoint is a GeoJSON multi point.
+type MultiPoint struct {
+	Coordinates []Position
+}
+
+// MultiLineString is a GeoJSON multi line string.
+type MultiLineString struct {
+	Coordinates [][]Position
+}
+
+// MultiPolygon is a GeoJSON multi polygon.
+type MultiPolygon struct {
+	Coordinates [][][]Position
+}
+
+// GeometryCollection is a GeoJSON geometry collection.
+type GeometryCollection struct {
+	Geometries []Geometry
+}
+
+func (Point) GeometryType() string              { return field.Point }
+func (LineString) GeometryType() string         { return field.LineString }
+func (Polygon) GeometryType() string            { return field.Polygon }
+func (MultiPoint) GeometryType() string         { return field.MultiPoint }
+func (MultiLineString) GeometryType() string    { return field.MultiLineString }
+func (MultiPolygon) GeometryType() string       { return field.MultiPolygon }
+func (GeometryCollection) GeometryType() string { return field.GeometryCollection }
+
+func (g Point) Validate() error {
+	return g.Coordinates.Validate()
+}
+
+func (g LineString) Validate() error {
+	return validateLine(g.Coordinates)
+}
+
+func (g Polygon) Validate() error {
+	return validatePolygon(g.Coordinates)
+}
+
+func (g MultiPoint) Validate() error {
+	return validatePositions(g.Coordinates)
+}
+
+func (g MultiLineString) Validate() error {
+	for i, line := range g.Coordinates {
+		if err := validateLine(line); err != nil {
+			return fmt.Errorf("line string %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (g MultiPolygon) Validate() error {
+	for i, polygon := range g.Coordinates {
+		if err := validatePolygon(polygon); err != nil {
+			return fmt.Errorf("polygon %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (g GeometryCollection) Validate() error {
+	for i, geometry := range g.Geometries {
+		if geometry == nil {
+			return fmt.Errorf("geo: geometry %d is nil", i)
+		}
+		if err := geometry.Validate(); err != nil {
+			return fmt.Errorf("geometry %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validatePositions(positions []Position) error {
+	for _, p := range positions {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLine(line []Position) error {
+	if len(line) < 2 {
+		return errors.New("geo: a line string must have at least two positions")
+	}
+	return validatePositions(line)
+}
+
+func validatePolygon(rings [][]Position) error {
+	if len(rings) == 0 {
+		return errors.New("geo: a polygon must have at least one ring")
+	}
+	for i, ring := range rings {
+		if len(ring) < 4 {
+			return fmt.Errorf("geo: ring %d must have at least four positions", i)
+		}
+		if ring[0] != ring[len(ring)-1] {
+			return fmt.Errorf("geo: ring %d is not closed", i)
+		}
+		if err := validatePositions(ring); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doc is the bson document of a geometry.
+type doc[C any] struct {
+	Type        string `bson:"type"`
+	Coordinates C      `bson:"coordinates"`
+}
+
+func marshal[C any](g Geometry, coordinates C) ([]byte, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(doc[C]{Type: g.GeometryType(), Coordinates: coordinates})
+}
+
+func unmarshal[C any](data []byte, typ string, coordinates *C) error {
+	if err := checkType(data, typ); err != nil {
+		return err
+	}
+	var d doc[C]
+	if err := bson.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	*coordinates = d.Coordinates
+	return nil
+}
+
+func checkType(data []byte, typ string) error {
+	if actual, _ := bson.Raw(data).Lookup("type").StringValueOK(); actual != typ {
+		return fmt.Errorf("geo: can not decode a %s into a %s", actual, typ)
+	}
+	return nil
+}
+
+// MarshalBSON validates the geometry and marshals it to a GeoJSON object.
+func (g Point) MarshalBSON() ([]byte, error) { return marshal(g, g.Coordinates) }
+
+// MarshalBSON validates the geometry and marshals it to a GeoJSON object.
+func (g LineString) MarshalBSON() ([]byte, error) { return marshal(g, g.Coordinates) }
+
+// MarshalBSON validates the geometry and marshals it to a GeoJSON object.
+func (g Polygon) MarshalBSON() ([]byte, error) { return marshal(g, g.Coordinates) }
+
+// MarshalBSON validates the geometry and marshals it to a GeoJSON object.
+func (g MultiPoint) MarshalBSON() ([]byte, error) { return marshal(g, g.Coordinates) }
+
+// MarshalBSON validates the geometry and marshals it to a GeoJSON object.
+func (g MultiLineString) MarshalBSON() ([]byte, error) { return marshal(g, g.Coordinates) }
+
+// MarshalBSON validates the geometry and marshals it to a GeoJSON object.
+func (g MultiPolygon) MarshalBSON() ([]byte, error) { return marshal(g, g.Coordinates) }
+
+func (g *Point) UnmarshalBSON(data []byte) error {
+	return unmarshal(data, field.Point, &g.Coordinates)
+}
+
+func (g *LineString) UnmarshalBSON(data []byte) error {
+	return unmarshal(data, field.LineString, &g.Coordinates)
+}
+
+func (g *Polygon) UnmarshalBSON(data []byte) error {
+	return unmarshal(data, field.Polygon, &g.Coordinates)
+}
+
+func (g *MultiPoint) UnmarshalBSON(data []byte) error {
+	return unmarshal(data, field.MultiPoint, &g.Coordinates)
+}
+
+func (g *MultiLineString) UnmarshalBSON(data []byte) error {
+	return unmarshal(data, field.MultiLineString, &g.Coordinates)
+}
+
+func (g *MultiPolygon) UnmarshalBSON(data []byte) error {
+	return unmarshal(data, field.MultiPolygon, &g.Coordinates)
+}
+
+// MarshalBSON validates the geometries and marshals them to a GeoJSON object.
+func (g GeometryCollection) MarshalBSON() ([]byte, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(bson.D{{Key: "type", Value: field.GeometryCollection}, {Key: "geometries", Value: g.Geometries}})
+}
+
+func (g *GeometryCollection) UnmarshalBSON(data []byte) error {
+	if err := checkType(data, field.GeometryCollection); err != nil {
+		return err
+	}
+	var d struct {
+		Geometries []bson.Raw `bson:"geometries"`
+	}
+	if err := bson.Unmarshal(data, &d); err != nil {
+		return err
+	}
+
+	g.Geometries = make([]Geometry, len(d.Geometries))
+	for i, raw := range d.Geometries {
+		geometry, err := Unmarshal(raw)
+		if err != nil {
+			return err
+		}
+		g.Geometries[i] = geometry
+	}
+	return nil
+}
+
+// Unmarshal decodes a GeoJSON object of any type.
+func Unmarshal(data []byte) (Geometry, error) {
+	typ, ok := bson.Raw(data).Lookup("type").StringValueOK()
+	if !ok {
+		return nil, errors.New("geo: missing GeoJSON type")
+	}
+
+	switch typ {
+	case field.Point:
+		return decode[Point](data)
+	case field.LineString:
+		return decode[LineString](data)
+	case field.Polygon:
+		return decode[Polygon](data)
+	case field.MultiPoint:
+		return decode[MultiPoint](data)
+	case field.MultiLineString:
+		return decode[MultiLineString](data)
+	case field.MultiPolygon:
+		return decode[MultiPolygon](data)
+	case field.GeometryCollection:
+		return decode[GeometryCollection](data)
+	}
+	return nil, fmt.Errorf("geo: unknown GeoJSON type %q", typ)
+}
+
+func decode[G Geometry, PG interface {
+	*G
+	bson.Unmarshaler
+}](data []byte) (Geometry, error) {
+	var g G
+	if err := PG(&g).UnmarshalBSON(data); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
@@ -0,0 +1,106 @@
+package geo
+
+import (
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var square = []Position{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		geometry Geometry
+		err      string
+	}{
+		{NewPoint(-73.97, 40.77), ""},
+		{NewPoint(181, 0), "longitude 181 out of range"},
+		{NewPoint(0, -91), "latitude -91 out of range"},
+		{LineString{Coordinates: []Position{{0, 0}}}, "at least two positions"},
+		{NewPolygon(square), ""},
+		{Polygon{Coordinates: [][]Position{square}}, "ring 0 is not closed"},
+		{Polygon{Coordinates: [][]Position{{{0, 0}, {1, 1}, {0, 0}}}}, "at least four positions"},
+		{Polygon{}, "at least one ring"},
+		{MultiPoint{Coordinates: []Position{{0, 0}, {0, 100}}}, "latitude 100"},
+		{MultiPolygon{Coordinates: [][][]Position{NewPolygon(square).Coordinates, {square}}}, "polygon 1: geo: ring 0 is not closed"},
+		{GeometryCollection{Geometries: []Geometry{NewPoint(0, 0), nil}}, "geometry 1 is nil"},
+	}
+
+	for _, tt := range tests {
+		err := tt.geometry.Validate()
+		if tt.err == "" {
+			assert.Nil(t, err)
+		} else if assert.NotNil(t, err, tt.err) {
+			assert.Contains(t, err.Error(), tt.err)
+		}
+	}
+}
+
+func TestNewPolygon(t *testing.T) {
+	p := NewPolygon(square, NewPolygon(square).Coordinates[0])
+	assert.Len(t, p.Coordinates[0], 5)
+	assert.Len(t, p.Coordinates[1], 5)
+	assert.Len(t, square, 4)
+}
+
+type place struct {
+	Location Point    `bson:"location"`
+	Area     *Polygon `bson:"area,omitempty"`
+}
+
+func TestMarshal(t *testing.T) {
+	data, err := bson.Marshal(place{Location: NewPoint(-73.97, 40.77)})
+	util.AssertErrIsNil(t, err)
+
+	var doc bson.M
+	util.AssertErrIsNil(t, bson.Unmarshal(data, &doc))
+	assert.Equal(t, bson.M{"type": "Point", "coordinates": bson.A{-73.97, 40.77}}, doc["location"])
+
+	area := NewPolygon(square)
+	data, err = bson.Marshal(place{Location: NewPoint(1, 2), Area: &area})
+	util.AssertErrIsNil(t, err)
+	var decoded place
+	util.AssertErrIsNil(t, bson.Unmarshal(data, &decoded))
+	assert.Equal(t, NewPoint(1, 2), decoded.Location)
+	assert.Equal(t, &area, decoded.Area)
+
+	_, err = bson.Marshal(place{Location: NewPoint(200, 0)})
+	assert.NotNil(t, err)
+
+	var wrong struct {
+		Location LineString `bson:"location"`
+	}
+	err = bson.Unmarshal(data, &wrong)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "can not decode a Point into a LineString")
+}
+
+func TestUnmarshal(t *testing.T) {
+	geometries := []Geometry{
+		NewPoint(1, 2),
+		LineString{Coordinates: []Position{{0, 0}, {1, 1}}},
+		NewPolygon(square),
+		MultiPoint{Coordinates: []Position{{0, 0}, {1, 1}}},
+		MultiLineString{Coordinates: [][]Position{{{0, 0}, {1, 1}}}},
+		MultiPolygon{Coordinates: [][][]Position{NewPolygon(square).Coordinates}},
+		GeometryCollection{Geometries: []Geometry{NewPoint(1, 2), NewPolygon(square)}},
+	}
+
+	for _, g := range geometries {
+		data, err := bson.Marshal(g)
+		util.AssertErrIsNil(t, err)
+		decoded, err := Unmarshal(data)
+		util.AssertErrIsNil(t, err)
+		assert.Equal(t, g, decoded)
+	}
+
+	data, _ := bson.Marshal(bson.M{"type": "Circle"})
+	_, err := Unmarshal(data)
+	assert.NotNil(t, err)
+}
+
+func TestRadians(t *testing.T) {
+	assert.InDelta(t, 1.0, Radians(EarthRadius), 1e-9)
+}
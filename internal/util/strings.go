@@ -19,6 +19,11 @@ func ToSnakeCase(str string) string {
 	return strings.ToLower(snake)
 }
 
+// ToKebabCase returns kebab-case of the provided value.
+func ToKebabCase(str string) string {
+	return strings.ReplaceAll(ToSnakeCase(str), "_", "-")
+}
+
 // ToCamelCase converts a string to CamelCase
 func ToCamelCase(s string) string {
 	return toCamelInitCase(s, true)
@@ -16,3 +16,9 @@ func TestToLowerCamelCase(t *testing.T) {
 	assert.Equal(t, "purchaseOrder", ToLowerCamelCase("PurchaseOrder"))
 	assert.Equal(t, "purchaseOrder", ToLowerCamelCase("PurchaseOrder"))
 }
+
+func TestToSnakeAndKebabCase(t *testing.T) {
+	assert.Equal(t, "purchase_order", ToSnakeCase("PurchaseOrder"))
+	assert.Equal(t, "purchase-order", ToKebabCase("PurchaseOrder"))
+	assert.Equal(t, "http-request-log", ToKebabCase("HTTPRequestLog"))
+}
@@ -0,0 +1,247 @@
+package match
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Compare compares two values using the MongoDB comparison order, and returns
+// -1, 0 or +1. Values of different types are compared by their type order
+// (MinKey, null, numbers, strings, documents, arrays, binary data, ObjectId,
+// booleans, dates, timestamps, regular expressions, MaxKey).
+func Compare(a, b interface{}) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		return cmpInt64(int64(ta), int64(tb))
+	}
+
+	switch av := a.(type) {
+	case int32, int64, float64, primitive.Decimal128:
+		return compareNumbers(a, b)
+	case string, primitive.Symbol:
+		return strings.Compare(toString(a), toString(b))
+	case bson.D:
+		bv := b.(bson.D)
+		for i := 0; i < len(av) && i < len(bv); i++ {
+			if c := cmpInt64(int64(typeOrder(av[i].Value)), int64(typeOrder(bv[i].Value))); c != 0 {
+				return c
+			}
+			if c := strings.Compare(av[i].Key, bv[i].Key); c != 0 {
+				return c
+			}
+			if c := Compare(av[i].Value, bv[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmpInt64(int64(len(av)), int64(len(bv)))
+	case bson.A:
+		bv := b.(bson.A)
+		for i := 0; i < len(av) && i < len(bv); i++ {
+			if c := Compare(av[i], bv[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt64(int64(len(av)), int64(len(bv)))
+	case primitive.Binary:
+		bv := b.(primitive.Binary)
+		if c := cmpInt64(int64(len(av.Data)), int64(len(bv.Data))); c != 0 {
+			return c
+		}
+		if c := cmpInt64(int64(av.Subtype), int64(bv.Subtype)); c != 0 {
+			return c
+		}
+		return bytes.Compare(av.Data, bv.Data)
+	case primitive.ObjectID:
+		bv := b.(primitive.ObjectID)
+		return bytes.Compare(av[:], bv[:])
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return cmpInt64(int64(av), int64(b.(primitive.DateTime)))
+	case primitive.Timestamp:
+		return primitive.CompareTimestamp(av, b.(primitive.Timestamp))
+	case primitive.Regex:
+		bv := b.(primitive.Regex)
+		if c := strings.Compare(av.Pattern, bv.Pattern); c != 0 {
+			return c
+		}
+		return strings.Compare(av.Options, bv.Options)
+	}
+
+	return 0
+}
+
+// typeOrder returns the order of the value's type in the MongoDB comparison order.
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return 1
+	case nil, primitive.Null, primitive.Undefined:
+		return 2
+	case int32, int64, float64, primitive.Decimal128:
+		return 3
+	case string, primitive.Symbol:
+		return 4
+	case bson.D:
+		return 5
+	case bson.A:
+		return 6
+	case primitive.Binary:
+		return 7
+	case primitive.ObjectID:
+		return 8
+	case bool:
+		return 9
+	case primitive.DateTime:
+		return 10
+	case primitive.Timestamp:
+		return 11
+	case primitive.Regex:
+		return 12
+	case primitive.MaxKey:
+		return 14
+	}
+	return 13
+}
+
+// bsonType returns the BSON type of a normalized value.
+func bsonType(v interface{}) bsontype.Type {
+	switch v.(type) {
+	case float64:
+		return bsontype.Double
+	case string:
+		return bsontype.String
+	case bson.D:
+		return bsontype.EmbeddedDocument
+	case bson.A:
+		return bsontype.Array
+	case primitive.Binary:
+		return bsontype.Binary
+	case primitive.Undefined:
+		return bsontype.Undefined
+	case primitive.ObjectID:
+		return bsontype.ObjectID
+	case bool:
+		return bsontype.Boolean
+	case primitive.DateTime:
+		return bsontype.DateTime
+	case nil, primitive.Null:
+		return bsontype.Null
+	case primitive.Regex:
+		return bsontype.Regex
+	case primitive.DBPointer:
+		return bsontype.DBPointer
+	case primitive.JavaScript:
+		return bsontype.JavaScript
+	case primitive.Symbol:
+		return bsontype.Symbol
+	case primitive.CodeWithScope:
+		return bsontype.CodeWithScope
+	case int32:
+		return bsontype.Int32
+	case primitive.Timestamp:
+		return bsontype.Timestamp
+	case int64:
+		return bsontype.Int64
+	case primitive.Decimal128:
+		return bsontype.Decimal128
+	case primitive.MinKey:
+		return bsontype.MinKey
+	case primitive.MaxKey:
+		return bsontype.MaxKey
+	}
+	return 0
+}
+
+func isNumber(v interface{}) bool {
+	return typeOrder(v) == 3
+}
+
+func isNull(v interface{}) bool {
+	return typeOrder(v) == 2
+}
+
+func compareNumbers(a, b interface{}) int {
+	// Compare integers exactly to avoid losing precision with large int64 values.
+	if x, ok := toInt64(a); ok {
+		if y, ok := toInt64(b); ok {
+			return cmpInt64(x, y)
+		}
+	}
+
+	x, y := toFloat(a), toFloat(b)
+	switch {
+	case math.IsNaN(x) && math.IsNaN(y):
+		return 0
+	case math.IsNaN(x):
+		return -1
+	case math.IsNaN(y):
+		return 1
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(n.String(), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	}
+	return math.NaN()
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case primitive.Symbol:
+		return string(s)
+	}
+	return ""
+}
+
+func cmpInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,822 @@
+// Package match evaluates MongoDB query filters against documents and models
+// in memory, without a MongoDB server.
+package match
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// options is the `$regex` options operator.
+const options = "$options"
+
+// docExpr matches a document.
+type docExpr func(doc bson.D) bool
+
+// valueExpr matches the leaves found at a path.
+type valueExpr struct {
+	expand bool
+	match  func(leaves []leaf) bool
+}
+
+// Filter is a compiled query filter.
+type Filter struct {
+	expr docExpr
+}
+
+// New compiles the filter (e.g. bson.M, bson.D). It returns an error if the
+// filter is malformed or uses an unsupported operator ($expr, $where, $text,
+// $jsonSchema and the geospatial operators are not supported).
+func New(filter interface{}) (*Filter, error) {
+	doc, err := ToDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := compileDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{expr: expr}, nil
+}
+
+// Match reports whether the document matches the filter. The document can be
+// any value that can be marshaled to a BSON document (e.g. bson.M, bson.D, models).
+func (f *Filter) Match(doc interface{}) (bool, error) {
+	d, err := ToDoc(doc)
+	if err != nil {
+		return false, err
+	}
+
+	return f.expr(d), nil
+}
+
+// MatchDoc reports whether the document matches the filter. Unlike `Match`, the
+// document is used as it is, so it must only contain values as decoded by the bson
+// package (e.g. int32 rather than int, bson.D rather than bson.M).
+func (f *Filter) MatchDoc(doc bson.D) bool {
+	return f.expr(doc)
+}
+
+// Match reports whether the document matches the filter.
+func Match(filter, doc interface{}) (bool, error) {
+	f, err := New(filter)
+	if err != nil {
+		return false, err
+	}
+
+	return f.Match(doc)
+}
+
+// ToDoc converts the value to a document using the bson codecs, so that the
+// document holds the same types as the ones decoded from a MongoDB server.
+func ToDoc(val interface{}) (bson.D, error) {
+	if val == nil {
+		return bson.D{}, nil
+	}
+
+	var raw []byte
+	switch v := val.(type) {
+	case bson.Raw:
+		raw = v
+	case []byte:
+		raw = v
+	default:
+		var err error
+		if raw, err = bson.Marshal(val); err != nil {
+			return nil, err
+		}
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func compileDoc(filter bson.D) (docExpr, error) {
+	exprs := make([]docExpr, 0, len(filter))
+	for _, e := range filter {
+		expr, err := compileElem(e)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	return func(doc bson.D) bool {
+		for _, expr := range exprs {
+			if !expr(doc) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func compileElem(e bson.E) (docExpr, error) {
+	switch e.Key {
+	case o.And, o.Or, o.Nor:
+		return compileLogical(e)
+	case o.Comment:
+		return func(bson.D) bool { return true }, nil
+	}
+
+	if strings.HasPrefix(e.Key, "$") {
+		return nil, fmt.Errorf("match: unsupported operator %s", e.Key)
+	}
+
+	expr, err := compileValue(e.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	path := e.Key
+	return func(doc bson.D) bool {
+		return expr.match(leaves(doc, path, expr.expand))
+	}, nil
+}
+
+func compileLogical(e bson.E) (docExpr, error) {
+	list, ok := e.Value.(bson.A)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("match: %s must be a nonempty array", e.Key)
+	}
+
+	exprs := make([]docExpr, 0, len(list))
+	for _, item := range list {
+		sub, ok := item.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("match: %s entries must be documents", e.Key)
+		}
+		expr, err := compileDoc(sub)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	key := e.Key
+	return func(doc bson.D) bool {
+		for _, expr := range exprs {
+			ok := expr(doc)
+			switch {
+			case key == o.And && !ok:
+				return false
+			case key == o.Or && ok:
+				return true
+			case key == o.Nor && ok:
+				return false
+			}
+		}
+		return key != o.Or
+	}, nil
+}
+
+// compileValue compiles the condition of a field, which is either an operators
+// document (e.g. {$gt: 1, $lt: 5}), a regular expression, or a value to match.
+func compileValue(val interface{}) (valueExpr, error) {
+	if cond, ok := val.(bson.D); ok && isOperatorDoc(cond) {
+		return compileOperators(cond)
+	}
+	if re, ok := val.(primitive.Regex); ok {
+		return compileRegex(re.Pattern, re.Options)
+	}
+
+	return valueExpr{expand: true, match: func(leaves []leaf) bool {
+		return anyLeaf(leaves, func(l leaf) bool { return equals(l, val) })
+	}}, nil
+}
+
+func compileOperators(cond bson.D) (valueExpr, error) {
+	exprs := make([]valueExpr, 0, len(cond))
+	for _, c := range cond {
+		if c.Key == options {
+			if !hasKey(cond, o.Regex) {
+				return valueExpr{}, errors.New("match: $options needs a $regex")
+			}
+			continue
+		}
+		expr, err := compileOperator(c, cond)
+		if err != nil {
+			return valueExpr{}, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	return allOf(exprs), nil
+}
+
+// allOf returns an expression matching when all the expressions match. Leaves are
+// expanded for each expression as the expressions may differ in array expansion.
+func allOf(exprs []valueExpr) valueExpr {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	return valueExpr{expand: false, match: func(ls []leaf) bool {
+		for _, expr := range exprs {
+			if !expr.match(reexpand(ls, expr.expand)) {
+				return false
+			}
+		}
+		return true
+	}}
+}
+
+// reexpand adds the elements of the arrays found at the end of the path to the
+// non-expanded leaves.
+func reexpand(ls []leaf, expand bool) []leaf {
+	if !expand {
+		return ls
+	}
+	var out []leaf
+	for _, l := range ls {
+		out = append(out, l)
+		if arr, ok := l.val.(bson.A); ok {
+			for _, item := range arr {
+				out = append(out, leaf{val: item})
+			}
+		}
+	}
+	return out
+}
+
+func compileOperator(c bson.E, cond bson.D) (valueExpr, error) {
+	switch c.Key {
+	case o.Eq:
+		return compileEq(c.Value), nil
+	case o.Ne:
+		return not(compileEq(c.Value)), nil
+	case o.Gt, o.Gte, o.Lt, o.Lte:
+		return compileComparison(c.Key, c.Value), nil
+	case o.In, o.Nin:
+		expr, err := compileIn(c)
+		if err != nil || c.Key == o.In {
+			return expr, err
+		}
+		return not(expr), nil
+	case o.Exists:
+		want := truthy(c.Value)
+		return valueExpr{match: func(ls []leaf) bool {
+			return anyLeaf(ls, func(l leaf) bool { return !l.missing }) == want
+		}}, nil
+	case o.Type:
+		return compileType(c.Value)
+	case o.Regex:
+		return compileRegexOperator(c.Value, cond)
+	case o.Mod:
+		return compileMod(c.Value)
+	case o.All:
+		return compileAll(c.Value)
+	case o.ElemMatch:
+		return compileElemMatch(c.Value)
+	case o.Size:
+		return compileSize(c.Value)
+	case o.BitsAllSet, o.BitsAllClear, o.BitsAnySet, o.BitsAnyClear:
+		return compileBits(c.Key, c.Value)
+	case o.Not:
+		var expr valueExpr
+		var err error
+		switch v := c.Value.(type) {
+		case bson.D:
+			if !isOperatorDoc(v) {
+				return valueExpr{}, errors.New("match: $not needs an operators document or a regex")
+			}
+			expr, err = compileOperators(v)
+		case primitive.Regex:
+			expr, err = compileRegex(v.Pattern, v.Options)
+		default:
+			return valueExpr{}, errors.New("match: $not needs an operators document or a regex")
+		}
+		if err != nil {
+			return valueExpr{}, err
+		}
+		return not(expr), nil
+	}
+
+	return valueExpr{}, fmt.Errorf("match: unsupported operator %s", c.Key)
+}
+
+func not(expr valueExpr) valueExpr {
+	return valueExpr{expand: expr.expand, match: func(ls []leaf) bool {
+		return !expr.match(ls)
+	}}
+}
+
+func compileEq(want interface{}) valueExpr {
+	return valueExpr{expand: true, match: func(ls []leaf) bool {
+		return anyLeaf(ls, func(l leaf) bool { return equals(l, want) })
+	}}
+}
+
+func compileComparison(op string, want interface{}) valueExpr {
+	return valueExpr{expand: true, match: func(ls []leaf) bool {
+		return anyLeaf(ls, func(l leaf) bool {
+			if isNull(want) {
+				// Only $gte and $lte match null and missing values.
+				return (op == o.Gte || op == o.Lte) && (l.missing || isNull(l.val))
+			}
+			if l.missing {
+				return false
+			}
+			cmp := Compare(l.val, want)
+			switch want.(type) {
+			case primitive.MinKey, primitive.MaxKey:
+			default:
+				if typeOrder(l.val) != typeOrder(want) {
+					return false
+				}
+			}
+			switch op {
+			case o.Gt:
+				return cmp > 0
+			case o.Gte:
+				return cmp >= 0
+			case o.Lt:
+				return cmp < 0
+			}
+			return cmp <= 0
+		})
+	}}
+}
+
+func compileIn(c bson.E) (valueExpr, error) {
+	list, ok := c.Value.(bson.A)
+	if !ok {
+		return valueExpr{}, fmt.Errorf("match: %s needs an array", c.Key)
+	}
+
+	var exprs []valueExpr
+	for _, item := range list {
+		if d, ok := item.(bson.D); ok && isOperatorDoc(d) {
+			return valueExpr{}, fmt.Errorf("match: cannot use operators in %s", c.Key)
+		}
+		if re, ok := item.(primitive.Regex); ok {
+			expr, err := compileRegex(re.Pattern, re.Options)
+			if err != nil {
+				return valueExpr{}, err
+			}
+			exprs = append(exprs, expr)
+			continue
+		}
+		exprs = append(exprs, compileEq(item))
+	}
+
+	return valueExpr{expand: true, match: func(ls []leaf) bool {
+		for _, expr := range exprs {
+			if expr.match(ls) {
+				return true
+			}
+		}
+		return false
+	}}, nil
+}
+
+var typeAliases = map[string]bsontype.Type{
+	"double":              bsontype.Double,
+	"string":              bsontype.String,
+	"object":              bsontype.EmbeddedDocument,
+	"array":               bsontype.Array,
+	"binData":             bsontype.Binary,
+	"undefined":           bsontype.Undefined,
+	"objectId":            bsontype.ObjectID,
+	"bool":                bsontype.Boolean,
+	"date":                bsontype.DateTime,
+	"null":                bsontype.Null,
+	"regex":               bsontype.Regex,
+	"dbPointer":           bsontype.DBPointer,
+	"javascript":          bsontype.JavaScript,
+	"symbol":              bsontype.Symbol,
+	"javascriptWithScope": bsontype.CodeWithScope,
+	"int":                 bsontype.Int32,
+	"timestamp":           bsontype.Timestamp,
+	"long":                bsontype.Int64,
+	"decimal":             bsontype.Decimal128,
+	"minKey":              bsontype.MinKey,
+	"maxKey":              bsontype.MaxKey,
+}
+
+func compileType(val interface{}) (valueExpr, error) {
+	list, ok := val.(bson.A)
+	if !ok {
+		list = bson.A{val}
+	}
+
+	var types []bsontype.Type
+	number := false
+	for _, item := range list {
+		switch t := item.(type) {
+		case string:
+			if t == "number" {
+				number = true
+				continue
+			}
+			bt, ok := typeAliases[t]
+			if !ok {
+				return valueExpr{}, fmt.Errorf("match: unknown $type alias %q", t)
+			}
+			types = append(types, bt)
+		case int32, int64, float64:
+			code, _ := toInt64(item)
+			if f, ok := t.(float64); ok {
+				code = int64(f)
+			}
+			if code == -1 {
+				types = append(types, bsontype.MinKey)
+			} else {
+				types = append(types, bsontype.Type(code))
+			}
+		default:
+			return valueExpr{}, errors.New("match: $type needs a type alias or number")
+		}
+	}
+
+	return valueExpr{expand: true, match: func(ls []leaf) bool {
+		return anyLeaf(ls, func(l leaf) bool {
+			if l.missing {
+				return false
+			}
+			if number && isNumber(l.val) {
+				return true
+			}
+			bt := bsonType(l.val)
+			for _, t := range types {
+				if t == bt {
+					return true
+				}
+			}
+			return false
+		})
+	}}, nil
+}
+
+func compileRegexOperator(val interface{}, cond bson.D) (valueExpr, error) {
+	var pattern, opts string
+	switch v := val.(type) {
+	case string:
+		pattern = v
+	case primitive.Regex:
+		pattern, opts = v.Pattern, v.Options
+	default:
+		return valueExpr{}, errors.New("match: $regex needs a string or a regular expression")
+	}
+	for _, c := range cond {
+		if c.Key == options {
+			s, ok := c.Value.(string)
+			if !ok {
+				return valueExpr{}, errors.New("match: $options needs a string")
+			}
+			opts = s
+		}
+	}
+	return compileRegex(pattern, opts)
+}
+
+func compileRegex(pattern, opts string) (valueExpr, error) {
+	flags := ""
+	for _, f := range opts {
+		switch f {
+		case 'i', 'm', 's':
+			flags += string(f)
+		case 'x':
+			pattern = stripExtended(pattern)
+		case 'u':
+		default:
+			return valueExpr{}, fmt.Errorf("match: invalid regex option %q", f)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return valueExpr{}, fmt.Errorf("match: %w", err)
+	}
+
+	return valueExpr{expand: true, match: func(ls []leaf) bool {
+		return anyLeaf(ls, func(l leaf) bool {
+			switch v := l.val.(type) {
+			case string:
+				return re.MatchString(v)
+			case primitive.Symbol:
+				return re.MatchString(string(v))
+			}
+			return false
+		})
+	}}, nil
+}
+
+// stripExtended removes the whitespaces and comments of an extended regular expression.
+func stripExtended(pattern string) string {
+	b := strings.Builder{}
+	escaped, comment := false, false
+	for _, r := range pattern {
+		switch {
+		case comment:
+			comment = r != '\n'
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			b.WriteRune(r)
+			escaped = true
+		case r == '#':
+			comment = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func compileMod(val interface{}) (valueExpr, error) {
+	args, ok := val.(bson.A)
+	if !ok || len(args) != 2 || !isNumber(args[0]) || !isNumber(args[1]) {
+		return valueExpr{}, errors.New("match: $mod needs an array of two numbers")
+	}
+	divisor, remainder := int64(toFloat(args[0])), int64(toFloat(args[1]))
+	if divisor == 0 {
+		return valueExpr{}, errors.New("match: $mod divisor cannot be 0")
+	}
+
+	return valueExpr{expand: true, match: func(ls []leaf) bool {
+		return anyLeaf(ls, func(l leaf) bool {
+			if l.missing || !isNumber(l.val) {
+				return false
+			}
+			f := toFloat(l.val)
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return false
+			}
+			n, ok := toInt64(l.val)
+			if !ok {
+				n = int64(f)
+			}
+			return n%divisor == remainder
+		})
+	}}, nil
+}
+
+func compileAll(val interface{}) (valueExpr, error) {
+	list, ok := val.(bson.A)
+	if !ok {
+		return valueExpr{}, errors.New("match: $all needs an array")
+	}
+
+	var exprs []valueExpr
+	for _, item := range list {
+		if d, ok := item.(bson.D); ok && len(d) == 1 && d[0].Key == o.ElemMatch {
+			expr, err := compileElemMatch(d[0].Value)
+			if err != nil {
+				return valueExpr{}, err
+			}
+			exprs = append(exprs, expr)
+			continue
+		}
+		if d, ok := item.(bson.D); ok && isOperatorDoc(d) {
+			return valueExpr{}, errors.New("match: no operators other than $elemMatch are allowed in $all")
+		}
+		expr, err := compileValue(item)
+		if err != nil {
+			return valueExpr{}, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	return valueExpr{expand: false, match: func(ls []leaf) bool {
+		if len(exprs) == 0 {
+			return false
+		}
+		for _, expr := range exprs {
+			if !expr.match(reexpand(ls, expr.expand)) {
+				return false
+			}
+		}
+		return true
+	}}, nil
+}
+
+func compileElemMatch(val interface{}) (valueExpr, error) {
+	cond, ok := val.(bson.D)
+	if !ok {
+		return valueExpr{}, errors.New("match: $elemMatch needs a document")
+	}
+
+	var matchElem func(item interface{}) bool
+	if isOperatorDoc(cond) && !isLogicalDoc(cond) {
+		expr, err := compileOperators(cond)
+		if err != nil {
+			return valueExpr{}, err
+		}
+		matchElem = func(item interface{}) bool {
+			return expr.match(reexpand([]leaf{{val: item}}, expr.expand))
+		}
+	} else {
+		expr, err := compileDoc(cond)
+		if err != nil {
+			return valueExpr{}, err
+		}
+		matchElem = func(item interface{}) bool {
+			d, ok := item.(bson.D)
+			return ok && expr(d)
+		}
+	}
+
+	return valueExpr{expand: false, match: func(ls []leaf) bool {
+		return anyLeaf(ls, func(l leaf) bool {
+			arr, ok := l.val.(bson.A)
+			if !ok {
+				return false
+			}
+			for _, item := range arr {
+				if matchElem(item) {
+					return true
+				}
+			}
+			return false
+		})
+	}}, nil
+}
+
+func compileSize(val interface{}) (valueExpr, error) {
+	if !isNumber(val) {
+		return valueExpr{}, errors.New("match: $size needs a number")
+	}
+	f := toFloat(val)
+	if f != math.Trunc(f) || f < 0 {
+		return valueExpr{}, errors.New("match: $size needs a non-negative integer")
+	}
+	size := int(f)
+
+	return valueExpr{expand: false, match: func(ls []leaf) bool {
+		return anyLeaf(ls, func(l leaf) bool {
+			arr, ok := l.val.(bson.A)
+			return ok && len(arr) == size
+		})
+	}}, nil
+}
+
+func compileBits(op string, val interface{}) (valueExpr, error) {
+	var mask []byte
+	switch v := val.(type) {
+	case bson.A:
+		for _, item := range v {
+			pos, ok := toInt64(item)
+			if f, isFloat := item.(float64); isFloat && f == math.Trunc(f) {
+				pos, ok = int64(f), true
+			}
+			if !ok || pos < 0 {
+				return valueExpr{}, fmt.Errorf("match: %s bit positions must be non-negative integers", op)
+			}
+			for int64(len(mask)) <= pos/8 {
+				mask = append(mask, 0)
+			}
+			mask[pos/8] |= 1 << (pos % 8)
+		}
+	case primitive.Binary:
+		mask = v.Data
+	default:
+		n, ok := integral(val)
+		if !ok || n < 0 {
+			return valueExpr{}, fmt.Errorf("match: %s needs a non-negative integer bitmask, positions or binary data", op)
+		}
+		mask = littleEndian(n)
+	}
+
+	return valueExpr{expand: true, match: func(ls []leaf) bool {
+		return anyLeaf(ls, func(l leaf) bool {
+			var data []byte
+			if b, ok := l.val.(primitive.Binary); ok {
+				data = b.Data
+			} else if n, ok := integral(l.val); ok {
+				data = littleEndian(n)
+				if n < 0 {
+					// Negative numbers are sign-extended.
+					for len(data) < len(mask) {
+						data = append(data, 0xff)
+					}
+				}
+			} else {
+				return false
+			}
+			return bitsMatch(op, data, mask)
+		})
+	}}, nil
+}
+
+func bitsMatch(op string, data, mask []byte) bool {
+	for i, m := range mask {
+		var d byte
+		if i < len(data) {
+			d = data[i]
+		}
+		switch op {
+		case o.BitsAllSet:
+			if d&m != m {
+				return false
+			}
+		case o.BitsAllClear:
+			if d&m != 0 {
+				return false
+			}
+		case o.BitsAnySet:
+			if d&m != 0 {
+				return true
+			}
+		case o.BitsAnyClear:
+			if d&m != m {
+				return true
+			}
+		}
+	}
+	return op == o.BitsAllSet || op == o.BitsAllClear
+}
+
+// integral returns the value as an int64 if it is a number representable as an int64.
+func integral(v interface{}) (int64, bool) {
+	if n, ok := toInt64(v); ok {
+		return n, true
+	}
+	if f, ok := v.(float64); ok && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return int64(f), true
+	}
+	return 0, false
+}
+
+func littleEndian(n int64) []byte {
+	data := make([]byte, 8)
+	for i := range data {
+		data[i] = byte(uint64(n) >> (8 * i))
+	}
+	return data
+}
+
+// equals reports whether the leaf equals the value. Null equals missing leaves.
+func equals(l leaf, want interface{}) bool {
+	if isNull(want) {
+		return l.missing || isNull(l.val)
+	}
+	if l.missing {
+		return false
+	}
+	if _, ok := want.(primitive.Undefined); ok {
+		return false
+	}
+	return typeOrder(l.val) == typeOrder(want) && Compare(l.val, want) == 0
+}
+
+func anyLeaf(ls []leaf, fn func(l leaf) bool) bool {
+	for _, l := range ls {
+		if fn(l) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case nil, primitive.Null, primitive.Undefined:
+		return false
+	}
+	if isNumber(v) {
+		return toFloat(v) != 0
+	}
+	return true
+}
+
+func isOperatorDoc(d bson.D) bool {
+	return len(d) > 0 && strings.HasPrefix(d[0].Key, "$")
+}
+
+func isLogicalDoc(d bson.D) bool {
+	for _, e := range d {
+		switch e.Key {
+		case o.And, o.Or, o.Nor:
+			return true
+		}
+	}
+	return false
+}
+
+func hasKey(d bson.D, key string) bool {
+	for _, e := range d {
+		if e.Key == key {
+			return true
+		}
+	}
+	return false
+}
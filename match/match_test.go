@@ -0,0 +1,340 @@
+package match
+
+import (
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// conformance contains filters with the documents they match and do not match
+// on a MongoDB server.
+var conformance = []struct {
+	name    string
+	filter  bson.M
+	match   []bson.M
+	noMatch []bson.M
+}{
+	{
+		name:    "implicit eq",
+		filter:  bson.M{"a": 1},
+		match:   []bson.M{{"a": 1}, {"a": 1.0}, {"a": int64(1)}, {"a": bson.A{2, 1}}},
+		noMatch: []bson.M{{"a": 2}, {"a": "1"}, {}, {"a": bson.A{bson.A{1}}}},
+	},
+	{
+		name:    "eq array",
+		filter:  bson.M{"a": bson.A{1, 2}},
+		match:   []bson.M{{"a": bson.A{1, 2}}, {"a": bson.A{bson.A{1, 2}, 3}}},
+		noMatch: []bson.M{{"a": bson.A{2, 1}}, {"a": 1}},
+	},
+	{
+		name:    "eq document",
+		filter:  bson.M{"a": bson.D{{Key: "b", Value: 1}, {Key: "c", Value: 2}}},
+		match:   []bson.M{{"a": bson.D{{Key: "b", Value: 1}, {Key: "c", Value: 2}}}},
+		noMatch: []bson.M{{"a": bson.D{{Key: "c", Value: 2}, {Key: "b", Value: 1}}}, {"a": bson.M{"b": 1}}},
+	},
+	{
+		name:    "eq null",
+		filter:  bson.M{"a": nil},
+		match:   []bson.M{{"a": nil}, {}, {"a": bson.A{1, nil}}},
+		noMatch: []bson.M{{"a": 0}, {"a": bson.A{1}}},
+	},
+	{
+		name:    "dotted path",
+		filter:  bson.M{"a.b": 1},
+		match:   []bson.M{{"a": bson.M{"b": 1}}, {"a": bson.A{bson.M{"b": 2}, bson.M{"b": 1}}}, {"a": bson.M{"b": bson.A{1}}}},
+		noMatch: []bson.M{{"a": bson.M{"b": 2}}, {"a": 1}, {"a": bson.A{1}}},
+	},
+	{
+		name:    "dotted null through array",
+		filter:  bson.M{"a.b": nil},
+		match:   []bson.M{{"a": bson.A{bson.M{"b": 1}, bson.M{}}}, {"a": 1}, {}},
+		noMatch: []bson.M{{"a": bson.A{bson.M{"b": 1}}}},
+	},
+	{
+		name:    "array index",
+		filter:  bson.M{"a.1": "y"},
+		match:   []bson.M{{"a": bson.A{"x", "y"}}, {"a": bson.M{"1": "y"}}},
+		noMatch: []bson.M{{"a": bson.A{"y", "x"}}},
+	},
+	{
+		name:    "ne",
+		filter:  bson.M{"a": bson.M{"$ne": 1}},
+		match:   []bson.M{{"a": 2}, {}, {"a": bson.A{2, 3}}},
+		noMatch: []bson.M{{"a": 1}, {"a": bson.A{2, 1}}},
+	},
+	{
+		name:    "gt same type bracket",
+		filter:  bson.M{"a": bson.M{"$gt": 5}},
+		match:   []bson.M{{"a": 6}, {"a": 5.5}, {"a": bson.A{1, 10}}},
+		noMatch: []bson.M{{"a": 5}, {"a": "6"}, {}, {"a": nil}},
+	},
+	{
+		name:    "range on array elements",
+		filter:  bson.M{"a": bson.M{"$gt": 5, "$lt": 10}},
+		match:   []bson.M{{"a": 7}, {"a": bson.A{1, 12}}},
+		noMatch: []bson.M{{"a": 12}, {"a": bson.A{1, 3}}},
+	},
+	{
+		name:    "gte lte null",
+		filter:  bson.M{"a": bson.M{"$gte": nil}},
+		match:   []bson.M{{"a": nil}, {}},
+		noMatch: []bson.M{{"a": 1}},
+	},
+	{
+		name:    "lt string",
+		filter:  bson.M{"a": bson.M{"$lt": "b"}},
+		match:   []bson.M{{"a": "a"}, {"a": "B"}},
+		noMatch: []bson.M{{"a": "b"}, {"a": 1}},
+	},
+	{
+		name:    "lte date",
+		filter:  bson.M{"a": bson.M{"$lte": primitive.DateTime(1000)}},
+		match:   []bson.M{{"a": primitive.DateTime(1000)}, {"a": primitive.DateTime(0)}},
+		noMatch: []bson.M{{"a": primitive.DateTime(1001)}, {"a": 1}},
+	},
+	{
+		name:    "in",
+		filter:  bson.M{"a": bson.M{"$in": bson.A{1, "x", nil}}},
+		match:   []bson.M{{"a": 1}, {"a": "x"}, {}, {"a": bson.A{3, "x"}}},
+		noMatch: []bson.M{{"a": 2}, {"a": bson.A{3, 4}}},
+	},
+	{
+		name:    "in regex",
+		filter:  bson.M{"a": bson.M{"$in": bson.A{primitive.Regex{Pattern: "^ab"}}}},
+		match:   []bson.M{{"a": "abc"}},
+		noMatch: []bson.M{{"a": "cab"}},
+	},
+	{
+		name:    "nin",
+		filter:  bson.M{"a": bson.M{"$nin": bson.A{1, 2}}},
+		match:   []bson.M{{"a": 3}, {}, {"a": bson.A{3, 4}}},
+		noMatch: []bson.M{{"a": 1}, {"a": bson.A{3, 2}}},
+	},
+	{
+		name:    "and",
+		filter:  bson.M{"$and": bson.A{bson.M{"a": 1}, bson.M{"b": 2}}},
+		match:   []bson.M{{"a": 1, "b": 2}},
+		noMatch: []bson.M{{"a": 1}, {"b": 2}},
+	},
+	{
+		name:    "or",
+		filter:  bson.M{"$or": bson.A{bson.M{"a": 1}, bson.M{"b": 2}}},
+		match:   []bson.M{{"a": 1}, {"b": 2}},
+		noMatch: []bson.M{{"a": 2, "b": 1}},
+	},
+	{
+		name:    "nor",
+		filter:  bson.M{"$nor": bson.A{bson.M{"a": 1}, bson.M{"b": 2}}},
+		match:   []bson.M{{"a": 2}, {}},
+		noMatch: []bson.M{{"a": 1}, {"b": 2}},
+	},
+	{
+		name:    "not",
+		filter:  bson.M{"a": bson.M{"$not": bson.M{"$gt": 5}}},
+		match:   []bson.M{{"a": 5}, {"a": "9"}, {}},
+		noMatch: []bson.M{{"a": 6}, {"a": bson.A{1, 6}}},
+	},
+	{
+		name:    "not regex",
+		filter:  bson.M{"a": bson.M{"$not": primitive.Regex{Pattern: "^a"}}},
+		match:   []bson.M{{"a": "ba"}, {}},
+		noMatch: []bson.M{{"a": "ab"}},
+	},
+	{
+		name:    "exists",
+		filter:  bson.M{"a.b": bson.M{"$exists": true}},
+		match:   []bson.M{{"a": bson.M{"b": nil}}, {"a": bson.A{bson.M{}, bson.M{"b": 1}}}},
+		noMatch: []bson.M{{"a": bson.M{}}, {"a": bson.A{1}}, {}},
+	},
+	{
+		name:    "not exists",
+		filter:  bson.M{"a": bson.M{"$exists": false}},
+		match:   []bson.M{{}, {"b": 1}},
+		noMatch: []bson.M{{"a": nil}, {"a": bson.A{}}},
+	},
+	{
+		name:    "type alias",
+		filter:  bson.M{"a": bson.M{"$type": "string"}},
+		match:   []bson.M{{"a": "x"}, {"a": bson.A{1, "x"}}},
+		noMatch: []bson.M{{"a": 1}, {}},
+	},
+	{
+		name:    "type number and codes",
+		filter:  bson.M{"a": bson.M{"$type": bson.A{"number", 8}}},
+		match:   []bson.M{{"a": 1}, {"a": 1.5}, {"a": int64(1)}, {"a": true}},
+		noMatch: []bson.M{{"a": "1"}, {"a": nil}},
+	},
+	{
+		name:    "type array",
+		filter:  bson.M{"a": bson.M{"$type": "array"}},
+		match:   []bson.M{{"a": bson.A{}}, {"a": bson.A{1}}},
+		noMatch: []bson.M{{"a": 1}},
+	},
+	{
+		name:    "regex with options",
+		filter:  bson.M{"a": bson.M{"$regex": "^ab", "$options": "i"}},
+		match:   []bson.M{{"a": "ABc"}, {"a": bson.A{"x", "abx"}}},
+		noMatch: []bson.M{{"a": "cab"}, {"a": 1}},
+	},
+	{
+		name:    "regex literal",
+		filter:  bson.M{"a": primitive.Regex{Pattern: "b$", Options: "m"}},
+		match:   []bson.M{{"a": "ab\nc"}},
+		noMatch: []bson.M{{"a": "ba"}},
+	},
+	{
+		name:    "mod",
+		filter:  bson.M{"a": bson.M{"$mod": bson.A{4, 0}}},
+		match:   []bson.M{{"a": 8}, {"a": 8.5}, {"a": int64(12)}, {"a": bson.A{1, 4}}},
+		noMatch: []bson.M{{"a": 5}, {"a": "8"}},
+	},
+	{
+		name:    "all",
+		filter:  bson.M{"a": bson.M{"$all": bson.A{"x", "y"}}},
+		match:   []bson.M{{"a": bson.A{"y", "z", "x"}}},
+		noMatch: []bson.M{{"a": bson.A{"x"}}, {"a": "x"}},
+	},
+	{
+		name:    "all single value",
+		filter:  bson.M{"a": bson.M{"$all": bson.A{"x"}}},
+		match:   []bson.M{{"a": "x"}, {"a": bson.A{"x"}}},
+		noMatch: []bson.M{{"a": "y"}},
+	},
+	{
+		name:    "all empty",
+		filter:  bson.M{"a": bson.M{"$all": bson.A{}}},
+		noMatch: []bson.M{{"a": bson.A{}}, {"a": bson.A{1}}},
+	},
+	{
+		name: "all elemMatch",
+		filter: bson.M{"a": bson.M{"$all": bson.A{
+			bson.M{"$elemMatch": bson.M{"b": 1}},
+			bson.M{"$elemMatch": bson.M{"b": 2}},
+		}}},
+		match:   []bson.M{{"a": bson.A{bson.M{"b": 1}, bson.M{"b": 2}}}},
+		noMatch: []bson.M{{"a": bson.A{bson.M{"b": 1}}}},
+	},
+	{
+		name:    "elemMatch operators",
+		filter:  bson.M{"a": bson.M{"$elemMatch": bson.M{"$gte": 80, "$lt": 85}}},
+		match:   []bson.M{{"a": bson.A{82, 90}}},
+		noMatch: []bson.M{{"a": bson.A{79, 90}}, {"a": 82}},
+	},
+	{
+		name:    "elemMatch document",
+		filter:  bson.M{"a": bson.M{"$elemMatch": bson.M{"b": 1, "c": bson.M{"$gt": 1}}}},
+		match:   []bson.M{{"a": bson.A{bson.M{"b": 1, "c": 2}}}},
+		noMatch: []bson.M{{"a": bson.A{bson.M{"b": 1, "c": 1}, bson.M{"b": 2, "c": 2}}}},
+	},
+	{
+		name:    "size",
+		filter:  bson.M{"a": bson.M{"$size": 2}},
+		match:   []bson.M{{"a": bson.A{1, 2}}, {"a": bson.A{bson.A{1}, 2}}},
+		noMatch: []bson.M{{"a": bson.A{1}}, {"a": bson.A{bson.A{1, 2}}}, {}},
+	},
+	{
+		name:    "bitsAllSet mask",
+		filter:  bson.M{"a": bson.M{"$bitsAllSet": 0b0101}},
+		match:   []bson.M{{"a": 0b1101}, {"a": 5.0}},
+		noMatch: []bson.M{{"a": 0b0100}, {"a": 5.5}, {"a": "5"}},
+	},
+	{
+		name:    "bitsAllClear positions",
+		filter:  bson.M{"a": bson.M{"$bitsAllClear": bson.A{1, 5}}},
+		match:   []bson.M{{"a": 0b000101}},
+		noMatch: []bson.M{{"a": 0b100000}},
+	},
+	{
+		name:    "bitsAnySet binary",
+		filter:  bson.M{"a": bson.M{"$bitsAnySet": primitive.Binary{Data: []byte{0x03}}}},
+		match:   []bson.M{{"a": 2}, {"a": primitive.Binary{Data: []byte{0x01}}}},
+		noMatch: []bson.M{{"a": 4}},
+	},
+	{
+		name:    "bitsAnyClear negative",
+		filter:  bson.M{"a": bson.M{"$bitsAnyClear": bson.A{0, 40}}},
+		match:   []bson.M{{"a": 1}},
+		noMatch: []bson.M{{"a": -1}},
+	},
+	{
+		name:    "comment",
+		filter:  bson.M{"a": 1, "$comment": "ignored"},
+		match:   []bson.M{{"a": 1}},
+		noMatch: []bson.M{{"a": 2}},
+	},
+}
+
+func TestConformance(t *testing.T) {
+	for _, test := range conformance {
+		f, err := New(test.filter)
+		util.AssertErrIsNil(t, err)
+
+		for _, doc := range test.match {
+			ok, err := f.Match(doc)
+			util.AssertErrIsNil(t, err)
+			assert.True(t, ok, "%s: %v should match %v", test.name, test.filter, doc)
+		}
+		for _, doc := range test.noMatch {
+			ok, err := f.Match(doc)
+			util.AssertErrIsNil(t, err)
+			assert.False(t, ok, "%s: %v should not match %v", test.name, test.filter, doc)
+		}
+	}
+}
+
+func TestMatchModel(t *testing.T) {
+	type item struct {
+		Name string `bson:"name"`
+		Qty  int    `bson:"qty"`
+	}
+	type order struct {
+		ID    string `bson:"_id"`
+		Items []item `bson:"items"`
+	}
+
+	ok, err := Match(bson.M{"items.qty": bson.M{"$gte": 5}}, &order{ID: "1", Items: []item{{"a", 1}, {"b", 5}}})
+	util.AssertErrIsNil(t, err)
+	assert.True(t, ok)
+}
+
+func TestUnsupported(t *testing.T) {
+	for _, filter := range []bson.M{
+		{"$where": "this.a == 1"},
+		{"$expr": bson.M{"$eq": bson.A{"$a", 1}}},
+		{"a": bson.M{"$near": bson.A{0, 0}}},
+		{"a": bson.M{"$foo": 1}},
+		{"$and": bson.A{}},
+		{"a": bson.M{"$in": 1}},
+		{"a": bson.M{"$mod": bson.A{0, 1}}},
+		{"a": bson.M{"$type": "foo"}},
+		{"a": bson.M{"$regex": "("}},
+	} {
+		_, err := New(filter)
+		assert.NotNil(t, err, "%v should not compile", filter)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []interface{}{
+		primitive.MinKey{}, nil, int32(-1), 0.5, int64(2), "a", "b", bson.D{}, bson.A{},
+		primitive.Binary{Data: []byte{1}}, primitive.NewObjectID(), false, true,
+		primitive.DateTime(0), primitive.Timestamp{T: 1}, primitive.Regex{Pattern: "a"}, primitive.MaxKey{},
+	}
+
+	for i := 1; i < len(ordered); i++ {
+		assert.Equal(t, -1, Compare(ordered[i-1], ordered[i]), "%v < %v", ordered[i-1], ordered[i])
+		assert.Equal(t, 1, Compare(ordered[i], ordered[i-1]), "%v > %v", ordered[i], ordered[i-1])
+	}
+	assert.Equal(t, 0, Compare(int32(1), 1.0))
+}
+
+func TestLookup(t *testing.T) {
+	doc, err := ToDoc(bson.M{"a": bson.A{bson.M{"b": 1}, bson.M{"b": bson.A{2, 3}}, bson.M{}}})
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, []interface{}{int32(1), bson.A{int32(2), int32(3)}}, Lookup(doc, "a.b"))
+	assert.Nil(t, Lookup(doc, "c"))
+}
@@ -0,0 +1,76 @@
+package match
+
+import (
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// leaf is a value found at the end of a path. Missing leaves are produced when
+// the path does not exist, so that `null` equality matches missing fields.
+type leaf struct {
+	val     interface{}
+	missing bool
+}
+
+// Lookup returns the values found at the dotted path of the document. Arrays found
+// on the path are traversed, so that `items.qty` returns the `qty` value of every
+// document in the `items` array. Arrays found at the end of the path are returned
+// as they are.
+func Lookup(doc bson.D, path string) []interface{} {
+	var values []interface{}
+	for _, l := range leaves(doc, path, false) {
+		if !l.missing {
+			values = append(values, l.val)
+		}
+	}
+	return values
+}
+
+// leaves returns the leaves of the path. When expand is true the elements of arrays
+// found at the end of the path are returned in addition to the arrays themselves.
+func leaves(doc bson.D, path string, expand bool) []leaf {
+	var out []leaf
+	walk(doc, strings.Split(path, "."), expand, &out)
+	if len(out) == 0 {
+		out = append(out, leaf{missing: true})
+	}
+	return out
+}
+
+func walk(v interface{}, parts []string, expand bool, out *[]leaf) {
+	if len(parts) == 0 {
+		*out = append(*out, leaf{val: v})
+		if arr, ok := v.(bson.A); ok && expand {
+			for _, item := range arr {
+				*out = append(*out, leaf{val: item})
+			}
+		}
+		return
+	}
+
+	switch d := v.(type) {
+	case bson.D:
+		for _, e := range d {
+			if e.Key == parts[0] {
+				walk(e.Value, parts[1:], expand, out)
+				return
+			}
+		}
+		*out = append(*out, leaf{missing: true})
+	case bson.A:
+		if i, err := strconv.Atoi(parts[0]); err == nil && i >= 0 {
+			if i < len(d) {
+				walk(d[i], parts[1:], expand, out)
+			}
+		}
+		for _, item := range d {
+			if sub, ok := item.(bson.D); ok {
+				walk(sub, parts, expand, out)
+			}
+		}
+	default:
+		*out = append(*out, leaf{missing: true})
+	}
+}
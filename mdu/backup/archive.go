@@ -0,0 +1,270 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// magicNumber starts the archives of mongodump.
+const magicNumber uint32 = 0x8199e26d
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// archiveHeader is the first document of an archive prelude.
+type archiveHeader struct {
+	ConcurrentCollections int32  `bson:"concurrent_collections"`
+	FormatVersion         string `bson:"version"`
+	ServerVersion         string `bson:"server_version"`
+	ToolVersion           string `bson:"tool_version"`
+}
+
+// archiveCollection describes a collection in the archive prelude.
+type archiveCollection struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Metadata   string `bson:"metadata"`
+	Size       int64  `bson:"size"`
+	Type       string `bson:"type"`
+}
+
+// namespaceHeader starts a block of documents of a collection in the archive body. The
+// last block of a collection has EOF set and the CRC-64 of all its documents.
+type namespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// archiveWriter writes the archive format of mongodump: a prelude describing the
+// collections, followed by a block of documents per collection.
+type archiveWriter struct {
+	bw   *bufio.Writer
+	gw   *gzip.Writer
+	out  io.Writer
+	db   string
+	term [4]byte
+}
+
+func newArchiveWriter(w io.Writer, compress bool, db, serverVersion string, metas []*Metadata) (*archiveWriter, error) {
+	aw := &archiveWriter{bw: bufio.NewWriter(w), db: db}
+	aw.out = aw.bw
+	if compress {
+		aw.gw = gzip.NewWriter(aw.bw)
+		aw.out = aw.gw
+	}
+	binary.LittleEndian.PutUint32(aw.term[:], uint32(0xffffffff))
+
+	var magic [4]byte
+	binary.LittleEndian.PutUint32(magic[:], magicNumber)
+	if _, err := aw.out.Write(magic[:]); err != nil {
+		return nil, err
+	}
+	header := archiveHeader{ConcurrentCollections: 1, FormatVersion: "0.1", ServerVersion: serverVersion, ToolVersion: "mdu"}
+	if err := aw.writeDoc(header); err != nil {
+		return nil, err
+	}
+	for _, meta := range metas {
+		data, err := bson.MarshalExtJSON(meta, true, false)
+		if err != nil {
+			return nil, err
+		}
+		typ := meta.Type
+		if typ == "" {
+			typ = "collection"
+		}
+		if err = aw.writeDoc(archiveCollection{Database: db, Collection: meta.CollectionName, Metadata: string(data), Type: typ}); err != nil {
+			return nil, err
+		}
+	}
+	_, err := aw.out.Write(aw.term[:])
+	return aw, err
+}
+
+func (aw *archiveWriter) writeDoc(v interface{}) error {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = aw.out.Write(data)
+	return err
+}
+
+func (aw *archiveWriter) writeCollection(meta *Metadata, docs docs) (int64, error) {
+	ns := namespaceHeader{Database: aw.db, Collection: meta.CollectionName}
+	if err := aw.writeDoc(ns); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	crc := crc64.New(crcTable)
+	err := docs(func(doc bson.Raw) error {
+		n++
+		crc.Write(doc)
+		_, err := aw.out.Write(doc)
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	if _, err = aw.out.Write(aw.term[:]); err != nil {
+		return n, err
+	}
+
+	ns.EOF = true
+	ns.CRC = int64(crc.Sum64())
+	if err = aw.writeDoc(ns); err != nil {
+		return n, err
+	}
+	_, err = aw.out.Write(aw.term[:])
+	return n, err
+}
+
+// Close flushes the archive.
+func (aw *archiveWriter) Close() error {
+	if aw.gw != nil {
+		if err := aw.gw.Close(); err != nil {
+			return err
+		}
+	}
+	return aw.bw.Flush()
+}
+
+// readArchive restores the collections of the database db of an archive, compressed or not.
+// The blocks of documents of different collections may be interleaved.
+func readArchive(ctx context.Context, r io.Reader, db string, names []string, t target) error {
+	br := bufio.NewReader(r)
+	in := io.Reader(br)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		in = bufio.NewReader(gr)
+	}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(in, magic[:]); err != nil || binary.LittleEndian.Uint32(magic[:]) != magicNumber {
+		return errors.New("backup: not a mongodump archive")
+	}
+
+	// Prelude.
+	doc, err := readDoc(in)
+	if err != nil || doc == nil {
+		return fmt.Errorf("backup: invalid archive header: %v", err)
+	}
+	var metas []*Metadata
+	for {
+		doc, err = readDoc(in)
+		if err != nil {
+			return fmt.Errorf("backup: invalid archive prelude: %w", err)
+		}
+		if doc == nil {
+			break
+		}
+		var coll archiveCollection
+		if err = bson.Unmarshal(doc, &coll); err != nil {
+			return fmt.Errorf("backup: invalid archive prelude: %w", err)
+		}
+		if coll.Database != db || !includes(names, coll.Collection) {
+			continue
+		}
+		meta := &Metadata{}
+		if coll.Metadata != "" {
+			if err = bson.UnmarshalExtJSON([]byte(coll.Metadata), false, meta); err != nil {
+				return fmt.Errorf("backup: %s: %w", coll.Collection, err)
+			}
+		}
+		meta.CollectionName = coll.Collection
+		if meta.Type == "" && coll.Type != "collection" {
+			meta.Type = coll.Type
+		}
+		metas = append(metas, meta)
+	}
+	if len(metas) == 0 {
+		return fmt.Errorf("backup: no collections of database %s to restore in the archive", db)
+	}
+
+	crcs := map[string]hash.Hash64{}
+	for _, meta := range metas {
+		if err = t.begin(ctx, meta); err != nil {
+			return fmt.Errorf("backup: %s: %w", meta.CollectionName, err)
+		}
+		crcs[meta.CollectionName] = crc64.New(crcTable)
+	}
+
+	// Body.
+	for {
+		doc, err = readDoc(in)
+		if err == io.EOF {
+			break
+		}
+		if err != nil || doc == nil {
+			return fmt.Errorf("backup: invalid namespace header: %v", err)
+		}
+		var ns namespaceHeader
+		if err = bson.Unmarshal(doc, &ns); err != nil {
+			return fmt.Errorf("backup: invalid namespace header: %w", err)
+		}
+		crc, selected := crcs[ns.Collection]
+		selected = selected && ns.Database == db
+
+		if ns.EOF {
+			if doc, err = readDoc(in); err != nil || doc != nil {
+				return fmt.Errorf("backup: %s: missing terminator: %v", ns.Collection, err)
+			}
+			if !selected {
+				continue
+			}
+			if int64(crc.Sum64()) != ns.CRC {
+				return fmt.Errorf("backup: %s: checksum mismatch", ns.Collection)
+			}
+			if err = t.end(ctx, ns.Collection); err != nil {
+				return fmt.Errorf("backup: %s: %w", ns.Collection, err)
+			}
+			delete(crcs, ns.Collection)
+			continue
+		}
+
+		for {
+			doc, err = readDoc(in)
+			if err != nil {
+				return fmt.Errorf("backup: %s: %w", ns.Collection, err)
+			}
+			if doc == nil {
+				break
+			}
+			if !selected {
+				continue
+			}
+			crc.Write(doc)
+			if err = t.insert(ctx, ns.Collection, doc); err != nil {
+				return fmt.Errorf("backup: %s: %w", ns.Collection, err)
+			}
+		}
+	}
+
+	// Views have no documents, so mongodump does not write their blocks.
+	for _, meta := range metas {
+		if _, ok := crcs[meta.CollectionName]; !ok {
+			continue
+		}
+		if !meta.isView() {
+			return fmt.Errorf("backup: %s: truncated archive", meta.CollectionName)
+		}
+		if err = t.end(ctx, meta.CollectionName); err != nil {
+			return fmt.Errorf("backup: %s: %w", meta.CollectionName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Package backup dumps the documents and metadata (indexes, validators and options) of the
+// collections of a database, and restores them, in the directory and archive formats of
+// mongodump and mongorestore, optionally gzip compressed.
+package backup
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Metadata struct contains the metadata of a collection, as written in the
+// `<collection>.metadata.json` files of mongodump.
+type Metadata struct {
+	Options        bson.D   `bson:"options,omitempty"`
+	Indexes        []bson.D `bson:"indexes"`
+	UUID           string   `bson:"uuid,omitempty"`
+	CollectionName string   `bson:"collectionName"`
+	Type           string   `bson:"type,omitempty"`
+}
+
+func (m *Metadata) isView() bool {
+	return m.Type == "view"
+}
+
+// Options struct contains the options of a dump.
+type Options struct {
+	// Collections are the names of the dumped collections and views; all of them, except
+	// the system collections, if empty.
+	Collections []string
+
+	// Gzip compresses each file of a directory dump, or the whole archive.
+	Gzip bool
+}
+
+// Result struct contains the number of dumped or restored documents of each collection.
+type Result struct {
+	Documents map[string]int64
+}
+
+// docs calls fn for each document of a collection.
+type docs func(fn func(doc bson.Raw) error) error
+
+// dumpWriter writes collections in a dump format.
+type dumpWriter interface {
+	writeCollection(meta *Metadata, docs docs) (int64, error)
+}
+
+// Dump writes the collections of the database to `<dir>/<database>/`, with a `<collection>.bson`
+// file of documents and a `<collection>.metadata.json` file per collection, as mongodump does.
+func Dump(ctx context.Context, db *mongo.Database, dir string, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	metas, err := collections(ctx, db, opts.Collections)
+	if err != nil {
+		return nil, err
+	}
+	return dump(ctx, db, &dirWriter{dir: filepath.Join(dir, db.Name()), gzip: opts.Gzip}, metas)
+}
+
+// DumpArchive writes the collections of the database to w in the archive format of `mongodump --archive`.
+func DumpArchive(ctx context.Context, db *mongo.Database, w io.Writer, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	metas, err := collections(ctx, db, opts.Collections)
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		Version string `bson:"version"`
+	}
+	// The server version is informative only.
+	_ = db.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+
+	aw, err := newArchiveWriter(w, opts.Gzip, db.Name(), info.Version, metas)
+	if err != nil {
+		return nil, err
+	}
+	res, err := dump(ctx, db, aw, metas)
+	if err != nil {
+		return res, err
+	}
+	return res, aw.Close()
+}
+
+func dump(ctx context.Context, db *mongo.Database, w dumpWriter, metas []*Metadata) (*Result, error) {
+	res := &Result{Documents: map[string]int64{}}
+	for _, meta := range metas {
+		n, err := w.writeCollection(meta, func(fn func(doc bson.Raw) error) error {
+			if meta.isView() {
+				return nil
+			}
+			cur, err := db.Collection(meta.CollectionName).Find(ctx, bson.D{})
+			if err != nil {
+				return err
+			}
+			defer cur.Close(ctx)
+			for cur.Next(ctx) {
+				if err = fn(cur.Current); err != nil {
+					return err
+				}
+			}
+			return cur.Err()
+		})
+		if err != nil {
+			return res, fmt.Errorf("backup: %s: %w", meta.CollectionName, err)
+		}
+		res.Documents[meta.CollectionName] = n
+	}
+	return res, nil
+}
+
+// collections returns the metadata of the named collections, or of all the collections of the database.
+func collections(ctx context.Context, db *mongo.Database, names []string) ([]*Metadata, error) {
+	filter := bson.D{}
+	if len(names) > 0 {
+		filter = bson.D{{Key: "name", Value: bson.D{{Key: "$in", Value: names}}}}
+	}
+	specs, err := db.ListCollectionSpecifications(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 0 && len(specs) != len(names) {
+		found := map[string]bool{}
+		for _, spec := range specs {
+			found[spec.Name] = true
+		}
+		for _, name := range names {
+			if !found[name] {
+				return nil, fmt.Errorf("backup: collection %s not found", name)
+			}
+		}
+	}
+
+	var metas []*Metadata
+	for _, spec := range specs {
+		if strings.HasPrefix(spec.Name, "system.") {
+			continue
+		}
+		meta := &Metadata{CollectionName: spec.Name, Type: spec.Type, Indexes: []bson.D{}}
+		if spec.UUID != nil {
+			meta.UUID = hex.EncodeToString(spec.UUID.Data)
+		}
+		if spec.Options != nil {
+			if err = bson.Unmarshal(spec.Options, &meta.Options); err != nil {
+				return nil, err
+			}
+		}
+		if !meta.isView() {
+			cur, err := db.Collection(spec.Name).Indexes().List(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if err = cur.All(ctx, &meta.Indexes); err != nil {
+				return nil, err
+			}
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].CollectionName < metas[j].CollectionName
+	})
+	return metas, nil
+}
+
+func includes(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"hash/crc64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// recorder records the collections read from a dump.
+type recorder struct {
+	metas map[string]*Metadata
+	docs  map[string][]bson.Raw
+	ended []string
+}
+
+func newRecorder() *recorder {
+	return &recorder{metas: map[string]*Metadata{}, docs: map[string][]bson.Raw{}}
+}
+
+func (r *recorder) begin(ctx context.Context, meta *Metadata) error {
+	r.metas[meta.CollectionName] = meta
+	return nil
+}
+
+func (r *recorder) insert(ctx context.Context, coll string, doc bson.Raw) error {
+	r.docs[coll] = append(r.docs[coll], doc)
+	return nil
+}
+
+func (r *recorder) end(ctx context.Context, coll string) error {
+	r.ended = append(r.ended, coll)
+	return nil
+}
+
+var testMetas = []*Metadata{
+	{
+		CollectionName: "orders",
+		Type:           "collection",
+		UUID:           "0123456789abcdef0123456789abcdef",
+		Options:        bson.D{{Key: "validator", Value: bson.D{{Key: "total", Value: bson.D{{Key: "$gte", Value: int32(0)}}}}}},
+		Indexes: []bson.D{
+			{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "_id", Value: int32(1)}}}, {Key: "name", Value: "_id_"}},
+			{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "number", Value: int32(1)}}}, {Key: "name", Value: "number_1"}, {Key: "unique", Value: true}},
+		},
+	},
+	{CollectionName: "empty", Type: "collection", Indexes: []bson.D{}},
+	{CollectionName: "big_orders", Type: "view", Options: bson.D{{Key: "viewOn", Value: "orders"}, {Key: "pipeline", Value: bson.A{}}}, Indexes: []bson.D{}},
+}
+
+func testDocs(coll string) []bson.Raw {
+	if coll != "orders" {
+		return nil
+	}
+	var docs []bson.Raw
+	for i := 0; i < 3; i++ {
+		doc, _ := bson.Marshal(bson.D{{Key: "_id", Value: i}, {Key: "number", Value: i * 10}, {Key: "total", Value: 1.5}})
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func writeAll(t *testing.T, w dumpWriter) {
+	for _, meta := range testMetas {
+		n, err := w.writeCollection(meta, func(fn func(doc bson.Raw) error) error {
+			for _, doc := range testDocs(meta.CollectionName) {
+				if err := fn(doc); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		util.AssertErrIsNil(t, err)
+		assert.Equal(t, int64(len(testDocs(meta.CollectionName))), n)
+	}
+}
+
+func assertRestored(t *testing.T, r *recorder) {
+	assert.Len(t, r.metas, len(testMetas))
+	for _, meta := range testMetas {
+		assert.Equal(t, meta, r.metas[meta.CollectionName])
+		assert.Equal(t, testDocs(meta.CollectionName), r.docs[meta.CollectionName])
+		assert.Contains(t, r.ended, meta.CollectionName)
+	}
+}
+
+func TestDir(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := filepath.Join(t.TempDir(), "shop")
+		writeAll(t, &dirWriter{dir: dir, gzip: compress})
+
+		name := "orders.bson"
+		if compress {
+			name += ".gz"
+		}
+		_, err := os.Stat(filepath.Join(dir, name))
+		util.AssertErrIsNil(t, err)
+
+		r := newRecorder()
+		util.AssertErrIsNil(t, readDir(context.Background(), dir, nil, r))
+		assertRestored(t, r)
+		assert.Equal(t, []string{"big_orders", "empty", "orders"}, r.ended)
+
+		r = newRecorder()
+		util.AssertErrIsNil(t, readDir(context.Background(), dir, []string{"orders"}, r))
+		assert.Equal(t, []string{"orders"}, r.ended)
+	}
+}
+
+func TestDirMetadataFormat(t *testing.T) {
+	dir := t.TempDir()
+	writeAll(t, &dirWriter{dir: dir})
+
+	data, err := os.ReadFile(filepath.Join(dir, "empty.metadata.json"))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, `{"indexes":[],"collectionName":"empty","type":"collection"}`, string(data))
+}
+
+func TestArchive(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		aw, err := newArchiveWriter(&buf, compress, "shop", "6.0.4", testMetas)
+		util.AssertErrIsNil(t, err)
+		writeAll(t, aw)
+		util.AssertErrIsNil(t, aw.Close())
+		archive := buf.Bytes()
+
+		r := newRecorder()
+		util.AssertErrIsNil(t, readArchive(context.Background(), bytes.NewReader(archive), "shop", nil, r))
+		assertRestored(t, r)
+
+		r = newRecorder()
+		util.AssertErrIsNil(t, readArchive(context.Background(), bytes.NewReader(archive), "shop", []string{"empty"}, r))
+		assert.Equal(t, []string{"empty"}, r.ended)
+
+		err = readArchive(context.Background(), bytes.NewReader(archive), "other", nil, newRecorder())
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "no collections of database other")
+	}
+}
+
+// TestArchiveInterleaved reads an archive with interleaved blocks of documents, as mongodump
+// writes them when it dumps collections concurrently.
+func TestArchiveInterleaved(t *testing.T) {
+	var buf bytes.Buffer
+	metas := []*Metadata{{CollectionName: "a", Indexes: []bson.D{}}, {CollectionName: "b", Indexes: []bson.D{}}}
+	aw, err := newArchiveWriter(&buf, false, "shop", "", metas)
+	util.AssertErrIsNil(t, err)
+
+	docA, _ := bson.Marshal(bson.D{{Key: "_id", Value: "a"}})
+	docB, _ := bson.Marshal(bson.D{{Key: "_id", Value: "b"}})
+	block := func(coll string, docs ...[]byte) {
+		util.AssertErrIsNil(t, aw.writeDoc(namespaceHeader{Database: "shop", Collection: coll}))
+		for _, doc := range docs {
+			aw.out.Write(doc)
+		}
+		aw.out.Write(aw.term[:])
+	}
+	eof := func(coll string, docs ...[]byte) {
+		crc := crc64.New(crcTable)
+		for _, doc := range docs {
+			crc.Write(doc)
+		}
+		util.AssertErrIsNil(t, aw.writeDoc(namespaceHeader{Database: "shop", Collection: coll, EOF: true, CRC: int64(crc.Sum64())}))
+		aw.out.Write(aw.term[:])
+	}
+	block("a", docA)
+	block("b", docB)
+	block("a", docA)
+	eof("b", docB)
+	eof("a", docA, docA)
+	util.AssertErrIsNil(t, aw.Close())
+
+	r := newRecorder()
+	util.AssertErrIsNil(t, readArchive(context.Background(), bytes.NewReader(buf.Bytes()), "shop", nil, r))
+	assert.Len(t, r.docs["a"], 2)
+	assert.Len(t, r.docs["b"], 1)
+	assert.Equal(t, []string{"b", "a"}, r.ended)
+}
+
+func TestArchiveErrors(t *testing.T) {
+	ctx := context.Background()
+	err := readArchive(ctx, bytes.NewReader([]byte("not an archive")), "shop", nil, newRecorder())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "not a mongodump archive")
+
+	var buf bytes.Buffer
+	aw, err := newArchiveWriter(&buf, false, "shop", "", testMetas)
+	util.AssertErrIsNil(t, err)
+	writeAll(t, aw)
+	util.AssertErrIsNil(t, aw.Close())
+	archive := buf.Bytes()
+
+	// Corrupt the last document of orders, which is written last.
+	corrupted := append([]byte{}, archive...)
+	doc := testDocs("orders")[2]
+	i := bytes.LastIndex(corrupted, doc)
+	corrupted[i+len(doc)-2] ^= 0xff
+	err = readArchive(ctx, bytes.NewReader(corrupted), "shop", nil, newRecorder())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+
+	// Truncate the archive before the end of orders.
+	err = readArchive(ctx, bytes.NewReader(archive[:i]), "shop", nil, newRecorder())
+	assert.NotNil(t, err)
+}
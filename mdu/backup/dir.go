@@ -0,0 +1,230 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	bsonExt     = ".bson"
+	metadataExt = ".metadata.json"
+	gzipExt     = ".gz"
+)
+
+// dirWriter writes a collection per file, in the directory layout of mongodump.
+type dirWriter struct {
+	dir  string
+	gzip bool
+}
+
+func (w *dirWriter) writeCollection(meta *Metadata, docs docs) (int64, error) {
+	if err := os.MkdirAll(w.dir, 0o755); err != nil {
+		return 0, err
+	}
+
+	data, err := bson.MarshalExtJSON(meta, true, false)
+	if err != nil {
+		return 0, err
+	}
+	if err = w.writeFile(meta.CollectionName+metadataExt, func(out io.Writer) error {
+		_, err := out.Write(data)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	if meta.isView() {
+		return 0, nil
+	}
+
+	var n int64
+	err = w.writeFile(meta.CollectionName+bsonExt, func(out io.Writer) error {
+		return docs(func(doc bson.Raw) error {
+			n++
+			_, err := out.Write(doc)
+			return err
+		})
+	})
+	return n, err
+}
+
+func (w *dirWriter) writeFile(name string, write func(out io.Writer) error) (err error) {
+	if w.gzip {
+		name += gzipExt
+	}
+	f, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	if !w.gzip {
+		if err = write(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	gw := gzip.NewWriter(bw)
+	if err = write(gw); err != nil {
+		return err
+	}
+	if err = gw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readDir restores the collections of a dump directory, compressed or not.
+func readDir(ctx context.Context, dir string, names []string, t target) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]map[string]string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), gzipExt)
+		for _, ext := range []string{metadataExt, bsonExt} {
+			if !entry.IsDir() && strings.HasSuffix(name, ext) {
+				coll := strings.TrimSuffix(name, ext)
+				if files[coll] == nil {
+					files[coll] = map[string]string{}
+				}
+				files[coll][ext] = filepath.Join(dir, entry.Name())
+				break
+			}
+		}
+	}
+
+	colls := make([]string, 0, len(files))
+	for coll := range files {
+		if includes(names, coll) && !strings.HasPrefix(coll, "system.") {
+			colls = append(colls, coll)
+		}
+	}
+	if len(colls) == 0 {
+		return fmt.Errorf("backup: no collections to restore in %s", dir)
+	}
+	sort.Strings(colls)
+
+	for _, coll := range colls {
+		meta := &Metadata{CollectionName: coll}
+		if path, ok := files[coll][metadataExt]; ok {
+			if err = readFile(path, func(r io.Reader) error {
+				return readMetadata(r, meta)
+			}); err != nil {
+				return fmt.Errorf("backup: %s: %w", path, err)
+			}
+			meta.CollectionName = coll
+		}
+		if err = t.begin(ctx, meta); err != nil {
+			return fmt.Errorf("backup: %s: %w", coll, err)
+		}
+		if path, ok := files[coll][bsonExt]; ok {
+			if err = readFile(path, func(r io.Reader) error {
+				return readDocs(r, func(doc bson.Raw) error {
+					return t.insert(ctx, coll, doc)
+				})
+			}); err != nil {
+				return fmt.Errorf("backup: %s: %w", path, err)
+			}
+		}
+		if err = t.end(ctx, coll); err != nil {
+			return fmt.Errorf("backup: %s: %w", coll, err)
+		}
+	}
+	return nil
+}
+
+func readFile(path string, read func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := io.Reader(bufio.NewReader(f))
+	if strings.HasSuffix(path, gzipExt) {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+	return read(r)
+}
+
+func readMetadata(r io.Reader, meta *Metadata) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return bson.UnmarshalExtJSON(data, false, meta)
+}
+
+// readDocs calls fn for each document of a stream of bson documents.
+func readDocs(r io.Reader, fn func(doc bson.Raw) error) error {
+	for {
+		doc, err := readDoc(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			return errors.New("unexpected terminator")
+		}
+		if err = fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// terminator is the int32 -1 ending the sections of an archive.
+const terminator = -1
+
+// maxDocSize is larger than the maximum size of bson documents, to detect corrupted input.
+const maxDocSize = 64 * 1024 * 1024
+
+// readDoc reads a bson document. It returns io.EOF at the end of the input, and a nil
+// document if it reads an archive terminator.
+func readDoc(r io.Reader) (bson.Raw, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.LittleEndian.Uint32(header[:]))
+	if size == terminator {
+		return nil, nil
+	}
+	if size < 5 || size > maxDocSize {
+		return nil, fmt.Errorf("invalid bson document size %d", size)
+	}
+
+	doc := make([]byte, size)
+	copy(doc, header[:])
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return doc, bson.Raw(doc).Validate()
+}
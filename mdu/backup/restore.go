@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultBatchSize is the default number of documents inserted at once during restores.
+const DefaultBatchSize = 1000
+
+// Mode is the way restored collections are merged with the existing ones.
+type Mode int
+
+const (
+	// Merge inserts the documents into the existing collections, and keeps the existing
+	// documents that have the same ids.
+	Merge Mode = iota
+
+	// Drop drops the existing collections before restoring them.
+	Drop
+)
+
+// RestoreOptions struct contains the options of a restore.
+type RestoreOptions struct {
+	// Collections are the names of the restored collections; all of them if empty.
+	Collections []string
+
+	// Mode is `Merge` by default.
+	Mode Mode
+
+	// SourceDB is the name of the dumped database, if it is not the name of the target database.
+	SourceDB string
+
+	// BatchSize is the number of documents inserted at once (`DefaultBatchSize` by default).
+	BatchSize int
+}
+
+// target receives the collections read from a dump.
+type target interface {
+	// begin is called before the documents of a collection.
+	begin(ctx context.Context, meta *Metadata) error
+	insert(ctx context.Context, coll string, doc bson.Raw) error
+	// end is called after the documents of a collection.
+	end(ctx context.Context, coll string) error
+}
+
+// Restore restores the collections of a `Dump` directory to the database: it creates the
+// collections with their options, inserts their documents, and creates their indexes.
+func Restore(ctx context.Context, db *mongo.Database, dir string, opts *RestoreOptions) (*Result, error) {
+	opts, t := newDBTarget(db, opts)
+	err := readDir(ctx, filepath.Join(dir, opts.SourceDB), opts.Collections, t)
+	return t.res, err
+}
+
+// RestoreArchive restores the collections of a `DumpArchive` archive, compressed or not, to the database.
+func RestoreArchive(ctx context.Context, db *mongo.Database, r io.Reader, opts *RestoreOptions) (*Result, error) {
+	opts, t := newDBTarget(db, opts)
+	err := readArchive(ctx, r, opts.SourceDB, opts.Collections, t)
+	return t.res, err
+}
+
+// dbTarget restores the collections to a database.
+type dbTarget struct {
+	db        *mongo.Database
+	mode      Mode
+	batchSize int
+	metas     map[string]*Metadata
+	batches   map[string][]interface{}
+	res       *Result
+}
+
+func newDBTarget(db *mongo.Database, opts *RestoreOptions) (*RestoreOptions, *dbTarget) {
+	o := RestoreOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.SourceDB == "" {
+		o.SourceDB = db.Name()
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	return &o, &dbTarget{
+		db:        db,
+		mode:      o.Mode,
+		batchSize: o.BatchSize,
+		metas:     map[string]*Metadata{},
+		batches:   map[string][]interface{}{},
+		res:       &Result{Documents: map[string]int64{}},
+	}
+}
+
+func (t *dbTarget) begin(ctx context.Context, meta *Metadata) error {
+	t.metas[meta.CollectionName] = meta
+	t.res.Documents[meta.CollectionName] = 0
+
+	coll := t.db.Collection(meta.CollectionName)
+	if t.mode == Drop {
+		if err := coll.Drop(ctx); err != nil {
+			return err
+		}
+	}
+
+	cmd := append(bson.D{{Key: "create", Value: meta.CollectionName}}, meta.Options...)
+	err := t.db.RunCommand(ctx, cmd).Err()
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceExists" && t.mode == Merge {
+		return nil
+	}
+	return err
+}
+
+func (t *dbTarget) insert(ctx context.Context, coll string, doc bson.Raw) error {
+	t.batches[coll] = append(t.batches[coll], doc)
+	if len(t.batches[coll]) < t.batchSize {
+		return nil
+	}
+	return t.flush(ctx, coll)
+}
+
+func (t *dbTarget) flush(ctx context.Context, coll string) error {
+	docs := t.batches[coll]
+	if len(docs) == 0 {
+		return nil
+	}
+	t.batches[coll] = nil
+
+	_, err := t.db.Collection(coll).InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		t.res.Documents[coll] += int64(len(docs))
+		return nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) {
+		return err
+	}
+	t.res.Documents[coll] += int64(len(docs) - len(bulkErr.WriteErrors))
+	if t.mode != Merge || bulkErr.WriteConcernError != nil {
+		return err
+	}
+	// Keep the existing documents.
+	for _, e := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(e) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *dbTarget) end(ctx context.Context, coll string) error {
+	if err := t.flush(ctx, coll); err != nil {
+		return err
+	}
+
+	meta := t.metas[coll]
+	var indexes bson.A
+	for _, index := range meta.Indexes {
+		spec := bson.D{}
+		isID := false
+		for _, e := range index {
+			switch e.Key {
+			case "ns":
+				continue
+			case "name":
+				isID = e.Value == "_id_"
+			}
+			spec = append(spec, e)
+		}
+		if !isID {
+			indexes = append(indexes, spec)
+		}
+	}
+	if len(indexes) == 0 || meta.isView() {
+		return nil
+	}
+	return t.db.RunCommand(ctx, bson.D{{Key: "createIndexes", Value: coll}, {Key: "indexes", Value: indexes}}).Err()
+}
@@ -0,0 +1,371 @@
+package mdu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/softwok/mongo-util/field"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultBulkChunkSize is the default maximum number of operations sent in a single bulk write.
+const DefaultBulkChunkSize = 1000
+
+// ErrNotExecuted is reported for the operations of an ordered bulk write that were not
+// executed because a previous operation failed.
+var ErrNotExecuted = errors.New("not executed because a previous operation failed")
+
+// BulkWriter is implemented by `Collection` (through the driver's collection)
+// and by the in-memory `mdutest.Collection`.
+type BulkWriter interface {
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+// BulkOptions struct contains the options of bulk writes.
+type BulkOptions struct {
+	// Ordered stops the bulk write at the first failed operation (true by default).
+	Ordered *bool
+
+	// ChunkSize is the maximum number of operations sent in a single bulk write
+	// (`DefaultBulkChunkSize` by default).
+	ChunkSize int
+}
+
+// BulkOpts returns new bulk options.
+func BulkOpts() *BulkOptions {
+	return &BulkOptions{}
+}
+
+// SetOrdered sets the value of the Ordered field.
+func (o *BulkOptions) SetOrdered(ordered bool) *BulkOptions {
+	o.Ordered = &ordered
+	return o
+}
+
+// SetChunkSize sets the value of the ChunkSize field.
+func (o *BulkOptions) SetChunkSize(size int) *BulkOptions {
+	o.ChunkSize = size
+	return o
+}
+
+// BulkResult struct contains the result of a bulk write.
+type BulkResult struct {
+	InsertedCount int64
+	MatchedCount  int64
+	ModifiedCount int64
+	DeletedCount  int64
+	UpsertedCount int64
+
+	// Errors maps the index of each failed operation to its error: a hook error,
+	// a write error, or `ErrNotExecuted`.
+	Errors map[int]error
+}
+
+// BulkError is returned by bulk writes having failed operations.
+type BulkError struct {
+	Errors map[int]error
+}
+
+func (e *BulkError) Error() string {
+	indexes := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return fmt.Sprintf("%d bulk write operations failed, first at index %d: %v", len(indexes), indexes[0], e.Errors[indexes[0]])
+}
+
+// Models converts a slice of models to a slice of the Model interface.
+func Models[T Model](models []T) []Model {
+	out := make([]Model, len(models))
+	for i, m := range models {
+		out[i] = m
+	}
+	return out
+}
+
+type bulkOpKind int
+
+const (
+	bulkCreate bulkOpKind = iota
+	bulkUpdate
+	bulkDelete
+	bulkWrite
+)
+
+type bulkOp struct {
+	kind  bulkOpKind
+	model Model
+	write mongo.WriteModel
+}
+
+// Bulk is a builder of bulk writes calling the models' hooks. The before hooks of an operation are
+// called when it is prepared, and the after hooks only if it succeeded. Bulk writes do not report
+// per-operation results, so the updated and deleted hooks receive a nil result.
+type Bulk struct {
+	c   BulkWriter
+	ctx func() context.Context
+	ops []bulkOp
+}
+
+// NewBulk returns a new bulk write builder for any collection having a driver-like `BulkWrite` method.
+// Its `Run` method uses a background context.
+func NewBulk(c BulkWriter) *Bulk {
+	return &Bulk{c: c, ctx: context.Background}
+}
+
+// Bulk returns a new bulk write builder.
+func (c *Collection) Bulk() *Bulk {
+	b := NewBulk(c)
+	b.ctx = ctx
+	return b
+}
+
+// Create adds the insertion of the model.
+func (b *Bulk) Create(models ...Model) *Bulk {
+	for _, m := range models {
+		b.ops = append(b.ops, bulkOp{kind: bulkCreate, model: m})
+	}
+	return b
+}
+
+// Update adds the update of the model's changes (see `UpdateDocument`). Unchanged models are skipped.
+func (b *Bulk) Update(models ...Model) *Bulk {
+	for _, m := range models {
+		b.ops = append(b.ops, bulkOp{kind: bulkUpdate, model: m})
+	}
+	return b
+}
+
+// Delete adds the deletion of the model.
+func (b *Bulk) Delete(models ...Model) *Bulk {
+	for _, m := range models {
+		b.ops = append(b.ops, bulkOp{kind: bulkDelete, model: m})
+	}
+	return b
+}
+
+// Write adds a driver write model, without hooks.
+func (b *Bulk) Write(models ...mongo.WriteModel) *Bulk {
+	for _, w := range models {
+		b.ops = append(b.ops, bulkOp{kind: bulkWrite, write: w})
+	}
+	return b
+}
+
+// Len returns the number of operations.
+func (b *Bulk) Len() int {
+	return len(b.ops)
+}
+
+// Run executes the operations in chunks. The before hooks of the operations of a chunk are called
+// before it is written. It returns a `*BulkError` if any operation failed, along with the result;
+// other errors (e.g. network errors) are reported for each operation of the failed chunk.
+//
+// Each chunk gets a new context from the builder (with the `CtxTimeout` of the configuration for
+// `Collection.Bulk`), so that long runs don't share a single deadline.
+func (b *Bulk) Run(opts ...*BulkOptions) (*BulkResult, error) {
+	return b.run(b.ctx, opts...)
+}
+
+// RunWithCtx is like Run, but all the chunks use the context.
+func (b *Bulk) RunWithCtx(ctx context.Context, opts ...*BulkOptions) (*BulkResult, error) {
+	return b.run(func() context.Context { return ctx }, opts...)
+}
+
+// run executes the operations, calling newCtx for the context of each chunk.
+func (b *Bulk) run(newCtx func() context.Context, opts ...*BulkOptions) (*BulkResult, error) {
+	ctx := newCtx()
+	opt := mergeBulkOptions(opts...)
+	ordered := opt.Ordered == nil || *opt.Ordered
+	res := &BulkResult{Errors: map[int]error{}}
+
+	var chunk []int
+	var writes []mongo.WriteModel
+	stopped := false
+
+	for i := 0; i < len(b.ops) && !stopped; i++ {
+		write, err := b.prepare(ctx, b.ops[i])
+		if err != nil {
+			res.Errors[i] = err
+			stopped = ordered
+		} else if write != nil {
+			chunk = append(chunk, i)
+			writes = append(writes, write)
+		}
+
+		if len(chunk) > 0 && (len(chunk) == opt.ChunkSize || stopped || i == len(b.ops)-1) {
+			if !b.flush(ctx, chunk, writes, ordered, res) && ordered {
+				stopped = true
+			}
+			chunk, writes = nil, nil
+			if i < len(b.ops)-1 {
+				ctx = newCtx()
+			}
+		}
+
+		if stopped {
+			for j := i + 1; j < len(b.ops); j++ {
+				res.Errors[j] = ErrNotExecuted
+			}
+		}
+	}
+
+	if len(res.Errors) > 0 {
+		return res, &BulkError{Errors: res.Errors}
+	}
+	return res, nil
+}
+
+// prepare calls the before hooks of the operation and returns its write model,
+// or nil if there is nothing to write.
+func (b *Bulk) prepare(ctx context.Context, op bulkOp) (mongo.WriteModel, error) {
+	switch op.kind {
+	case bulkCreate:
+		if err := PrepareNewID(op.model); err != nil {
+			return nil, err
+		}
+		if err := BeforeCreateHooks(ctx, op.model); err != nil {
+			return nil, err
+		}
+		return mongo.NewInsertOneModel().SetDocument(op.model), nil
+
+	case bulkUpdate:
+		if upd, err := UpdateDocument(op.model); err != nil || upd == nil {
+			return nil, err
+		}
+		if err := BeforeUpdateHooks(ctx, op.model); err != nil {
+			return nil, err
+		}
+		upd, err := UpdateDocument(op.model)
+		if err != nil {
+			return nil, err
+		}
+		return mongo.NewUpdateOneModel().SetFilter(bson.M{field.ID: op.model.GetID()}).SetUpdate(upd), nil
+
+	case bulkDelete:
+		if err := BeforeDeleteHooks(ctx, op.model); err != nil {
+			return nil, err
+		}
+		return mongo.NewDeleteOneModel().SetFilter(bson.M{field.ID: op.model.GetID()}), nil
+	}
+
+	return op.write, nil
+}
+
+// flush writes a chunk, records its results, and calls the after hooks of the succeeded
+// operations. It returns false if any write failed.
+func (b *Bulk) flush(ctx context.Context, chunk []int, writes []mongo.WriteModel, ordered bool, res *BulkResult) bool {
+	writeRes, err := b.c.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(ordered))
+	if writeRes != nil {
+		res.InsertedCount += writeRes.InsertedCount
+		res.MatchedCount += writeRes.MatchedCount
+		res.ModifiedCount += writeRes.ModifiedCount
+		res.DeletedCount += writeRes.DeletedCount
+		res.UpsertedCount += writeRes.UpsertedCount
+	}
+
+	failed := make(map[int]error)
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || len(bulkErr.WriteErrors) == 0 {
+			// The whole chunk failed (e.g. network or write concern error).
+			for _, i := range chunk {
+				res.Errors[i] = err
+			}
+			return false
+		}
+
+		first := len(chunk)
+		for _, we := range bulkErr.WriteErrors {
+			failed[we.Index] = we
+			if we.Index < first {
+				first = we.Index
+			}
+		}
+		if ordered {
+			for k := first + 1; k < len(chunk); k++ {
+				failed[k] = ErrNotExecuted
+			}
+		}
+	}
+
+	for k, i := range chunk {
+		if err, ok := failed[k]; ok {
+			res.Errors[i] = err
+			continue
+		}
+		if err := b.succeeded(ctx, b.ops[i]); err != nil {
+			res.Errors[i] = err
+		}
+	}
+	return len(failed) == 0
+}
+
+// succeeded takes the snapshot of a written model and calls its after hooks.
+func (b *Bulk) succeeded(ctx context.Context, op bulkOp) error {
+	switch op.kind {
+	case bulkCreate:
+		if err := TakeSnapshot(op.model); err != nil {
+			return err
+		}
+		return AfterCreateHooks(ctx, op.model)
+	case bulkUpdate:
+		if err := TakeSnapshot(op.model); err != nil {
+			return err
+		}
+		return AfterUpdateHooks(ctx, nil, op.model)
+	case bulkDelete:
+		return AfterDeleteHooks(ctx, nil, op.model)
+	}
+	return nil
+}
+
+func mergeBulkOptions(opts ...*BulkOptions) *BulkOptions {
+	merged := &BulkOptions{ChunkSize: DefaultBulkChunkSize}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Ordered != nil {
+			merged.Ordered = opt.Ordered
+		}
+		if opt.ChunkSize > 0 {
+			merged.ChunkSize = opt.ChunkSize
+		}
+	}
+	return merged
+}
+
+// CreateMany inserts the models with bulk writes, calling their create hooks (see `Bulk`).
+func (c *Collection) CreateMany(models []Model, opts ...*BulkOptions) (*BulkResult, error) {
+	return c.Bulk().Create(models...).Run(opts...)
+}
+
+func (c *Collection) CreateManyWithCtx(ctx context.Context, models []Model, opts ...*BulkOptions) (*BulkResult, error) {
+	return c.Bulk().Create(models...).RunWithCtx(ctx, opts...)
+}
+
+// UpdateMany persists the changes of the models with bulk writes, calling their update hooks (see `Bulk`).
+// It shadows the driver's method, which remains available as `c.Collection.UpdateMany`.
+func (c *Collection) UpdateMany(models []Model, opts ...*BulkOptions) (*BulkResult, error) {
+	return c.Bulk().Update(models...).Run(opts...)
+}
+
+func (c *Collection) UpdateManyWithCtx(ctx context.Context, models []Model, opts ...*BulkOptions) (*BulkResult, error) {
+	return c.Bulk().Update(models...).RunWithCtx(ctx, opts...)
+}
+
+// DeleteMany deletes the models with bulk writes, calling their delete hooks (see `Bulk`).
+// It shadows the driver's method, which remains available as `c.Collection.DeleteMany`.
+func (c *Collection) DeleteMany(models []Model, opts ...*BulkOptions) (*BulkResult, error) {
+	return c.Bulk().Delete(models...).Run(opts...)
+}
+
+func (c *Collection) DeleteManyWithCtx(ctx context.Context, models []Model, opts ...*BulkOptions) (*BulkResult, error) {
+	return c.Bulk().Delete(models...).RunWithCtx(ctx, opts...)
+}
@@ -0,0 +1,50 @@
+package mdu
+
+import (
+	"context"
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type chunkKey struct{}
+
+// ctxRecorder records the chunk number of the context of each bulk write.
+type ctxRecorder struct {
+	chunks []interface{}
+}
+
+func (r *ctxRecorder) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	r.chunks = append(r.chunks, ctx.Value(chunkKey{}))
+	return &mongo.BulkWriteResult{InsertedCount: int64(len(models))}, nil
+}
+
+func TestBulkContextPerChunk(t *testing.T) {
+	inserts := make([]mongo.WriteModel, 5)
+	for i := range inserts {
+		inserts[i] = mongo.NewInsertOneModel().SetDocument(bson.M{"n": i})
+	}
+
+	w := &ctxRecorder{}
+	b := NewBulk(w).Write(inserts...)
+	n := 0
+	b.ctx = func() context.Context {
+		n++
+		return context.WithValue(context.Background(), chunkKey{}, n)
+	}
+	res, err := b.Run(BulkOpts().SetChunkSize(2))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(5), res.InsertedCount)
+	assert.Equal(t, []interface{}{1, 2, 3}, w.chunks)
+
+	// RunWithCtx uses the caller's context for all the chunks.
+	w.chunks = nil
+	ctx := context.WithValue(context.Background(), chunkKey{}, "caller")
+	_, err = NewBulk(w).Write(inserts...).RunWithCtx(ctx, BulkOpts().SetChunkSize(2))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, []interface{}{"caller", "caller", "caller"}, w.chunks)
+}
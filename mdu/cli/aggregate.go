@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// parsePipeline parses an Extended JSON array of pipeline stages.
+func parsePipeline(data []byte) ([]bson.D, error) {
+	var wrapper struct {
+		Pipeline []bson.D `bson:"pipeline"`
+	}
+	doc := append(append([]byte(`{"pipeline": `), data...), '}')
+	if err := bson.UnmarshalExtJSON(doc, false, &wrapper); err != nil {
+		return nil, err
+	}
+	return wrapper.Pipeline, nil
+}
+
+func aggregateCmd(a *app, args []string) error {
+	fs := a.flags("aggregate")
+	coll := fs.String("c", "", "collection name")
+	file := fs.String("file", "", "Extended JSON pipeline file, or - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *coll == "" || *file == "" {
+		fmt.Fprintln(a.stderr, "mdu aggregate: the collection name (-c) and the pipeline file (-file) are required")
+		return errUsage
+	}
+
+	data, err := a.readInput(*file)
+	if err != nil {
+		return err
+	}
+	pipeline, err := parsePipeline(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *file, err)
+	}
+
+	ctx, cancel := a.opCtx()
+	defer cancel()
+
+	cur, err := a.db.Collection(*coll).Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	w := bufio.NewWriter(a.stdout)
+	for cur.Next(ctx) {
+		if err = writeDoc(w, cur.Current); err != nil {
+			return err
+		}
+	}
+	if err = cur.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/softwok/mongo-util/mdu/backup"
+)
+
+func dumpCmd(a *app, args []string) error {
+	fs := a.flags("dump")
+	out := fs.String("out", "dump", "output directory")
+	archive := fs.String("archive", "", "output archive file, or - for stdout, instead of a directory")
+	gzip := fs.Bool("gzip", false, "compress the output")
+	colls := fs.String("c", "", "comma-separated collection names; all if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := &backup.Options{Collections: splitList(*colls), Gzip: *gzip}
+	var (
+		res *backup.Result
+		err error
+	)
+	switch *archive {
+	case "":
+		res, err = backup.Dump(a.ctx, a.db, *out, opts)
+	case "-":
+		_, err = backup.DumpArchive(a.ctx, a.db, a.stdout, opts)
+		return err
+	default:
+		var f *os.File
+		if f, err = os.Create(*archive); err != nil {
+			return err
+		}
+		res, err = backup.DumpArchive(a.ctx, a.db, f, opts)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return a.printResult("dumped", res)
+}
+
+func restoreCmd(a *app, args []string) error {
+	fs := a.flags("restore")
+	dir := fs.String("dir", "dump", "dump directory")
+	archive := fs.String("archive", "", "archive file, or - for stdin, instead of a directory")
+	drop := fs.Bool("drop", false, "drop the collections before restoring them; otherwise merge the documents")
+	from := fs.String("from", "", "name of the dumped database, if it is not the -db database")
+	colls := fs.String("c", "", "comma-separated collection names; all if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	opts := &backup.RestoreOptions{Collections: splitList(*colls), SourceDB: *from}
+	if *drop {
+		opts.Mode = backup.Drop
+	}
+	var (
+		res *backup.Result
+		err error
+	)
+	switch *archive {
+	case "":
+		res, err = backup.Restore(a.ctx, a.db, *dir, opts)
+	case "-":
+		res, err = backup.RestoreArchive(a.ctx, a.db, a.stdin, opts)
+	default:
+		var f *os.File
+		if f, err = os.Open(*archive); err != nil {
+			return err
+		}
+		defer f.Close()
+		res, err = backup.RestoreArchive(a.ctx, a.db, f, opts)
+	}
+	if printErr := a.printResult("restored", res); err == nil {
+		err = printErr
+	}
+	return err
+}
+
+func (a *app) printResult(verb string, res *backup.Result) error {
+	if res == nil {
+		return nil
+	}
+	colls := make([]string, 0, len(res.Documents))
+	for coll := range res.Documents {
+		colls = append(colls, coll)
+	}
+	sort.Strings(colls)
+	return a.print(res.Documents, func(w io.Writer) {
+		for _, coll := range colls {
+			fmt.Fprintf(w, "%s\t%s\t%d documents\n", verb, coll, res.Documents[coll])
+		}
+	})
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,200 @@
+// Package cli implements the mdu command line, which runs common database tasks: listing
+// collections and indexes, applying the declared indexes, running migrations, loading fixtures,
+// exporting and importing collections, running aggregation pipelines, and backing up and
+// restoring collections.
+//
+// The indexes of the models registered with `mdu.Register` are declared indexes, in the
+// collections named by the naming strategy of the configuration. Programs declare them by
+// registering their models before calling Run:
+//
+//	func main() {
+//		mdu.MustRegister(&Product{}, &Order{})
+//		os.Exit(cli.Run(&mdu.Config{NamingStrategy: naming}, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+//	}
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/softwok/mongo-util/mdu"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// app contains the state shared by the commands.
+type app struct {
+	ctx     context.Context
+	timeout time.Duration
+	db      *mongo.Database
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	json    bool
+}
+
+type command struct {
+	usage string
+	run   func(a *app, args []string) error
+}
+
+var commands = map[string]command{
+	"collections": {"list the collections with their document count and indexes", collectionsCmd},
+	"indexes":     {"status|apply [-file indexes.json]: compare or create the indexes of the models and file", indexesCmd},
+	"migrate":     {"status|up|down [-dir migrations]: run or roll back migrations", migrateCmd},
+	"fixtures":    {"load [-reset] dir|file...: load fixture files", fixturesCmd},
+	"export":      {"-c collection [-filter json] [-format jsonl|json|csv] [-out file]: export documents", exportCmd},
+	"import":      {"-c collection [-in file] [-format jsonl|json|csv] [-drop]: import documents", importCmd},
+	"aggregate":   {"-c collection -file pipeline.json: run an aggregation pipeline", aggregateCmd},
+	"dump":        {"[-out dir | -archive file] [-gzip] [-c collections]: back up collections like mongodump", dumpCmd},
+	"restore":     {"[-dir dir | -archive file] [-drop] [-c collections]: restore a dump", restoreCmd},
+}
+
+// errUsage is returned for invalid command lines, after the usage was printed.
+var errUsage = errors.New("invalid usage")
+
+// Run runs the command line arguments (without the program name) with the configuration, or the
+// default one if nil, and returns the exit status: 0 on success, 1 on errors and 2 on invalid
+// usage. The -timeout flag is the timeout of the connection (it overrides the CtxTimeout of the
+// configuration) and of each database operation, except for the migrations, exports, imports, dumps
+// and restores, which run until they are done or interrupted. The connection messages are written
+// to stderr.
+//
+//	mdu [-uri uri] [-db name] [-timeout 30s] [-json] <command> [arguments]
+//
+// The uri and db flags default to the MDU_URI and MDU_DB environment variables. With -json,
+// results are written as JSON for scripts; documents are always written as relaxed Extended
+// JSON, one per line.
+func Run(conf *mdu.Config, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mdu", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	uri := fs.String("uri", envOr("MDU_URI", "mongodb://localhost:27017"), "MongoDB connection string (MDU_URI)")
+	dbName := fs.String("db", os.Getenv("MDU_DB"), "database name (MDU_DB)")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout of each database operation, except the migrations, exports, imports, dumps and restores")
+	jsonOut := fs.Bool("json", false, "write machine-readable JSON output")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: mdu [flags] <command> [arguments]\n\nCommands:\n")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %-12s %s\n", name, commands[name].usage)
+		}
+		fmt.Fprintf(stderr, "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "mdu: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+	if *dbName == "" {
+		fmt.Fprintln(stderr, "mdu: the database name is required (-db or MDU_DB)")
+		return 2
+	}
+
+	c := mdu.Config{}
+	if conf != nil {
+		c = *conf
+	}
+	c.CtxTimeout = *timeout
+	c.Log = stderr
+	err := mdu.Init(&c, *dbName, options.Client().ApplyURI(*uri))
+	if err != nil {
+		fmt.Fprintf(stderr, "mdu: %v\n", err)
+		return 1
+	}
+	defer mdu.Disconnect()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	_, _, db, _ := mdu.DefaultConfigs()
+	a := &app{ctx: ctx, timeout: *timeout, db: db, stdin: stdin, stdout: stdout, stderr: stderr, json: *jsonOut}
+	if err = cmd.run(a, fs.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+			return 2
+		}
+		fmt.Fprintf(stderr, "mdu: %s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	return 0
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// opCtx returns the context of a database operation, canceled after the -timeout. The
+// migrations, exports, imports, dumps and restores use a.ctx, as their duration depends on the data.
+func (a *app) opCtx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(a.ctx, a.timeout)
+}
+
+// flags returns a new flag set of a command, writing its errors to stderr.
+func (a *app) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("mdu "+name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	return fs
+}
+
+// print writes v as JSON with -json, or as a table written by the table function otherwise.
+func (a *app) print(v interface{}, table func(w io.Writer)) error {
+	if a.json {
+		return json.NewEncoder(a.stdout).Encode(v)
+	}
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// writeDoc writes the document as relaxed Extended JSON on a line.
+func writeDoc(w io.Writer, doc interface{}) error {
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// extJSON returns the relaxed Extended JSON of a document, for tables and JSON output.
+func extJSON(doc interface{}) json.RawMessage {
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return json.RawMessage(`null`)
+	}
+	return data
+}
+
+// readInput returns the content of the file, or of stdin if the path is "-".
+func (a *app) readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(a.stdin)
+	}
+	return os.ReadFile(path)
+}
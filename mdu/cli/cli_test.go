@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/softwok/mongo-util/mdu"
+	"github.com/softwok/mongo-util/mdu/transfer"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestRunUsage(t *testing.T) {
+	t.Setenv("MDU_DB", "")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "Usage: mdu"},
+		{[]string{"unknown"}, `unknown command "unknown"`},
+		{[]string{"collections"}, "the database name is required"},
+		{[]string{"-timeout", "x", "collections"}, "invalid value"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		assert.Equal(t, 2, Run(nil, tt.args, nil, &stdout, &stderr))
+		assert.Contains(t, stderr.String(), tt.want)
+		assert.Empty(t, stdout.String())
+	}
+}
+
+func TestParseIndexFile(t *testing.T) {
+	specs, err := parseIndexFile([]byte(`{
+		"products": [
+			{"keys": {"sku": 1, "variant": -1}, "unique": true},
+			{"name": "expiry", "keys": {"expires_at": 1}, "expireAfterSeconds": 0}
+		]
+	}`))
+	assert.Nil(t, err)
+	assert.Len(t, specs["products"], 2)
+	assert.Equal(t, bson.D{{Key: "sku", Value: int32(1)}, {Key: "variant", Value: int32(-1)}}, specs["products"][0].Keys)
+	assert.True(t, specs["products"][0].Unique)
+	assert.Equal(t, "expiry", specs["products"][1].Name)
+	assert.Equal(t, int32(0), *specs["products"][1].ExpireAfterSeconds)
+	assert.Equal(t, `{"sku":1,"variant":-1}`, string(extJSON(specs["products"][0].Keys)))
+
+	_, err = parseIndexFile([]byte(`{"products": [{"unique": true}]}`))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "has no keys")
+}
+
+func TestParsePipeline(t *testing.T) {
+	pipeline, err := parsePipeline([]byte(`[
+		{"$match": {"created_at": {"$gte": {"$date": "2023-01-01T00:00:00Z"}}}},
+		{"$group": {"_id": "$status", "count": {"$sum": 1}}}
+	]`))
+	assert.Nil(t, err)
+	assert.Len(t, pipeline, 2)
+	assert.Equal(t, "$match", pipeline[0][0].Key)
+	assert.Equal(t, "$group", pipeline[1][0].Key)
+
+	_, err = parsePipeline([]byte(`{"$match": {}}`))
+	assert.NotNil(t, err)
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	keys := bson.D{{Key: "a", Value: 1.0}, {Key: "b", Value: int64(-1)}, {Key: "c", Value: "text"}}
+	assert.Equal(t, `{"a":1,"b":-1,"c":"text"}`, string(extJSON(normalizeKeys(keys))))
+}
+
+func TestFileFormat(t *testing.T) {
+	tests := []struct {
+		format, path string
+		want         transfer.Format
+	}{
+		{"", "-", transfer.JSONLines},
+		{"", "products.csv", transfer.CSV},
+		{"json", "-", transfer.ExtJSON},
+		{"csv", "products.jsonl", transfer.CSV},
+	}
+
+	for _, tt := range tests {
+		format, err := fileFormat(tt.format, tt.path)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, format)
+	}
+
+	_, err := fileFormat("", "products.txt")
+	assert.NotNil(t, err)
+}
+
+type indexedProduct struct {
+	mdu.DefaultModel `bson:",inline"`
+	SKU              string `bson:"sku"`
+}
+
+func (p *indexedProduct) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sku", Value: 1}}, Options: options.Index().SetName("sku").SetUnique(true)},
+		{Keys: bson.M{"created_at": -1}},
+	}
+}
+
+func TestDeclaredIndexes(t *testing.T) {
+	mdu.ResetRegistry()
+	defer mdu.ResetRegistry()
+	mdu.MustRegister(&indexedProduct{})
+
+	declared, err := declaredIndexes(map[string][]indexSpec{
+		"indexedProducts": {{Keys: bson.D{{Key: "sku", Value: int32(1)}}}, {Keys: bson.D{{Key: "name", Value: "text"}}}},
+	})
+	assert.Nil(t, err)
+	indexes := declared["indexedProducts"]
+	assert.Len(t, indexes, 3)
+	assert.Equal(t, "sku", indexes[0].name)
+	assert.True(t, *indexes[0].model.Options.Unique)
+	assert.Equal(t, `{"sku":1}`, string(extJSON(indexes[0].keys)))
+	assert.Equal(t, `{"created_at":-1}`, string(extJSON(indexes[1].keys)))
+	assert.Equal(t, `{"name":"text"}`, string(extJSON(indexes[2].keys)))
+}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type indexInfo struct {
+	Name   string          `json:"name"`
+	Keys   json.RawMessage `json:"keys"`
+	Unique bool            `json:"unique,omitempty"`
+}
+
+type collectionInfo struct {
+	Name      string      `json:"name"`
+	Documents int64       `json:"documents"`
+	Indexes   []indexInfo `json:"indexes"`
+}
+
+func collectionsCmd(a *app, args []string) error {
+	fs := a.flags("collections")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := a.opCtx()
+	names, err := a.db.ListCollectionNames(ctx, bson.M{"type": "collection"})
+	cancel()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	infos := make([]collectionInfo, 0, len(names))
+	for _, name := range names {
+		coll := a.db.Collection(name)
+		ctx, cancel := a.opCtx()
+		count, err := coll.EstimatedDocumentCount(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		indexes, err := listIndexes(a, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		infos = append(infos, collectionInfo{Name: name, Documents: count, Indexes: indexes})
+	}
+
+	return a.print(infos, func(w io.Writer) {
+		fmt.Fprintln(w, "COLLECTION\tDOCUMENTS\tINDEX\tKEYS\tUNIQUE")
+		for _, info := range infos {
+			for i, index := range info.Indexes {
+				if i == 0 {
+					fmt.Fprintf(w, "%s\t%d\t", info.Name, info.Documents)
+				} else {
+					fmt.Fprint(w, "\t\t")
+				}
+				fmt.Fprintf(w, "%s\t%s\t%t\n", index.Name, index.Keys, index.Unique)
+			}
+			if len(info.Indexes) == 0 {
+				fmt.Fprintf(w, "%s\t%d\t\t\t\n", info.Name, info.Documents)
+			}
+		}
+	})
+}
+
+// listIndexes returns the indexes of the collection, sorted by name.
+func listIndexes(a *app, collName string) ([]indexInfo, error) {
+	ctx, cancel := a.opCtx()
+	defer cancel()
+
+	cur, err := a.db.Collection(collName).Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var specs []struct {
+		Name   string `bson:"name"`
+		Key    bson.D `bson:"key"`
+		Unique bool   `bson:"unique"`
+	}
+	if err = cur.All(ctx, &specs); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]indexInfo, 0, len(specs))
+	for _, spec := range specs {
+		indexes = append(indexes, indexInfo{Name: spec.Name, Keys: extJSON(normalizeKeys(spec.Key)), Unique: spec.Unique})
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i].Name < indexes[j].Name
+	})
+	return indexes, nil
+}
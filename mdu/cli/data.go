@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/softwok/mongo-util/mdu"
+	"github.com/softwok/mongo-util/mdu/transfer"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func exportCmd(a *app, args []string) error {
+	fs := a.flags("export")
+	coll := fs.String("c", "", "collection name")
+	filter := fs.String("filter", "{}", "Extended JSON query filter")
+	projection := fs.String("projection", "", "Extended JSON projection document")
+	sortDoc := fs.String("sort", "", "Extended JSON sort document")
+	limit := fs.Int64("limit", 0, "maximum number of documents; all if 0")
+	fields := fs.String("fields", "", "csv: comma-separated dotted paths of the columns; the fields of the first document if empty")
+	format := fs.String("format", "", "jsonl, json or csv; inferred from the -out extension, jsonl by default")
+	out := fs.String("out", "-", "output file, or - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *coll == "" {
+		fmt.Fprintln(a.stderr, "mdu export: the collection name is required (-c)")
+		return errUsage
+	}
+
+	opts := &transfer.ExportOptions{Limit: *limit}
+	var err error
+	if opts.Format, err = fileFormat(*format, *out); err != nil {
+		return err
+	}
+	if opts.Filter, err = parseDoc("filter", *filter); err != nil {
+		return err
+	}
+	if opts.Projection, err = parseDoc("projection", *projection); err != nil {
+		return err
+	}
+	if opts.Sort, err = parseDoc("sort", *sortDoc); err != nil {
+		return err
+	}
+	if *fields != "" {
+		opts.Fields = strings.Split(*fields, ",")
+	}
+
+	w := a.stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := transfer.Export(a.ctx, mdu.CollectionByName(*coll), w, opts)
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		return nil
+	}
+	return a.print(map[string]int64{"exported": n}, func(w io.Writer) {
+		fmt.Fprintf(w, "exported %d documents from %s\n", n, *coll)
+	})
+}
+
+func importCmd(a *app, args []string) error {
+	fs := a.flags("import")
+	coll := fs.String("c", "", "collection name")
+	in := fs.String("in", "-", "input file, or - for stdin")
+	format := fs.String("format", "", "jsonl, json or csv; inferred from the -in extension, jsonl by default")
+	drop := fs.Bool("drop", false, "drop the collection before importing")
+	batch := fs.Int("batch", transfer.DefaultBatchSize, "number of documents inserted at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *coll == "" {
+		fmt.Fprintln(a.stderr, "mdu import: the collection name is required (-c)")
+		return errUsage
+	}
+
+	opts := &transfer.ImportOptions{BatchSize: *batch}
+	var err error
+	if opts.Format, err = fileFormat(*format, *in); err != nil {
+		return err
+	}
+
+	r := a.stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	c := mdu.CollectionByName(*coll)
+	if *drop {
+		ctx, cancel := a.opCtx()
+		err = c.Drop(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := transfer.Import(a.ctx, c, r, opts)
+	if printErr := a.print(map[string]int64{"imported": n}, func(w io.Writer) {
+		fmt.Fprintf(w, "imported %d documents into %s\n", n, *coll)
+	}); err == nil {
+		err = printErr
+	}
+	return err
+}
+
+// fileFormat returns the given format, or the format of the file extension, or JSON Lines.
+func fileFormat(format, path string) (transfer.Format, error) {
+	if format != "" {
+		return transfer.Format(format), nil
+	}
+	if path == "-" {
+		return transfer.JSONLines, nil
+	}
+	return transfer.FormatOf(path)
+}
+
+// parseDoc parses an Extended JSON document flag, and returns nil if it is empty.
+func parseDoc(name, s string) (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(s), false, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return doc, nil
+}
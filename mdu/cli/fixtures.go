@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/softwok/mongo-util/mdu/fixtures"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func fixturesCmd(a *app, args []string) error {
+	fs := a.flags("fixtures")
+	reset := fs.Bool("reset", false, "delete the documents of the fixture collections before loading")
+	if len(args) == 0 || args[0] != "load" {
+		fmt.Fprintln(a.stderr, "Usage: mdu fixtures load [-reset] dir|file...")
+		return errUsage
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(a.stderr, "Usage: mdu fixtures load [-reset] dir|file...")
+		return errUsage
+	}
+
+	var paths []string
+	for _, arg := range fs.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		var matches []string
+		for _, ext := range []string{"*.json", "*.yaml", "*.yml"} {
+			m, err := filepath.Glob(filepath.Join(arg, ext))
+			if err != nil {
+				return err
+			}
+			matches = append(matches, m...)
+		}
+		sort.Strings(matches)
+		paths = append(paths, matches...)
+	}
+
+	colls := make([]string, 0, len(paths))
+	for _, path := range paths {
+		name := filepath.Base(path)
+		colls = append(colls, strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+
+	if *reset {
+		for _, coll := range colls {
+			ctx, cancel := a.opCtx()
+			_, err := a.db.Collection(coll).DeleteMany(ctx, bson.M{})
+			cancel()
+			if err != nil {
+				return fmt.Errorf("%s: %w", coll, err)
+			}
+		}
+	}
+	ctx, cancel := a.opCtx()
+	defer cancel()
+	if err := fixtures.New().LoadFiles(ctx, paths...); err != nil {
+		return err
+	}
+
+	return a.print(map[string][]string{"loaded": colls}, func(w io.Writer) {
+		for i, coll := range colls {
+			fmt.Fprintf(w, "loaded\t%s\t%s\n", coll, paths[i])
+		}
+	})
+}
@@ -0,0 +1,269 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/softwok/mongo-util/mdu"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index states reported by `indexes status`.
+const (
+	indexPresent    = "present"
+	indexMissing    = "missing"
+	indexUndeclared = "undeclared"
+)
+
+// indexSpec is an index declared in an indexes file.
+type indexSpec struct {
+	Name                    string `bson:"name"`
+	Keys                    bson.D `bson:"keys"`
+	Unique                  bool   `bson:"unique"`
+	Sparse                  bool   `bson:"sparse"`
+	ExpireAfterSeconds      *int32 `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.D `bson:"partialFilterExpression"`
+}
+
+func (s indexSpec) model() mongo.IndexModel {
+	opts := options.Index()
+	if s.Name != "" {
+		opts.SetName(s.Name)
+	}
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.Sparse {
+		opts.SetSparse(true)
+	}
+	if s.ExpireAfterSeconds != nil {
+		opts.SetExpireAfterSeconds(*s.ExpireAfterSeconds)
+	}
+	if s.PartialFilterExpression != nil {
+		opts.SetPartialFilterExpression(s.PartialFilterExpression)
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+// normalizeKeys returns the index keys with integral directions as int32, since the
+// server and the shells may report `1` as a double or a long.
+func normalizeKeys(keys bson.D) bson.D {
+	norm := make(bson.D, 0, len(keys))
+	for _, e := range keys {
+		switch v := e.Value.(type) {
+		case int64:
+			e.Value = int32(v)
+		case float64:
+			if v == float64(int32(v)) {
+				e.Value = int32(v)
+			}
+		}
+		norm = append(norm, e)
+	}
+	return norm
+}
+
+type indexStatus struct {
+	Collection string          `json:"collection"`
+	Name       string          `json:"name"`
+	Keys       json.RawMessage `json:"keys"`
+	State      string          `json:"state"`
+}
+
+// parseIndexFile parses an Extended JSON document mapping collection names to their declared indexes:
+//
+//	{"products": [{"keys": {"sku": 1}, "unique": true}, {"keys": {"name": "text"}}]}
+func parseIndexFile(data []byte) (map[string][]indexSpec, error) {
+	var specs map[string][]indexSpec
+	if err := bson.UnmarshalExtJSON(data, false, &specs); err != nil {
+		return nil, err
+	}
+	for coll, indexes := range specs {
+		for i, index := range indexes {
+			if len(index.Keys) == 0 {
+				return nil, fmt.Errorf("%s: index %d has no keys", coll, i)
+			}
+		}
+	}
+	return specs, nil
+}
+
+// declaredIndex is an index declared by a registered model or in an indexes file.
+type declaredIndex struct {
+	name  string
+	keys  bson.D
+	model mongo.IndexModel
+}
+
+// declaredIndexes returns the indexes of the registered models implementing `mdu.IndexesGetter`,
+// by the collection names of the naming strategy, followed by the indexes of the file. An index
+// with the same keys as a previous one of its collection is ignored.
+func declaredIndexes(specs map[string][]indexSpec) (map[string][]declaredIndex, error) {
+	declared := map[string][]declaredIndex{}
+	add := func(coll string, index declaredIndex) {
+		index.keys = normalizeKeys(index.keys)
+		keys := string(extJSON(index.keys))
+		for _, other := range declared[coll] {
+			if string(extJSON(other.keys)) == keys {
+				return
+			}
+		}
+		declared[coll] = append(declared[coll], index)
+	}
+
+	for _, info := range mdu.Registered() {
+		for _, model := range info.Indexes {
+			keys, err := indexKeys(model.Keys)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", info.Collection, info.Type, err)
+			}
+			index := declaredIndex{keys: keys, model: model}
+			if model.Options != nil && model.Options.Name != nil {
+				index.name = *model.Options.Name
+			}
+			add(info.Collection, index)
+		}
+	}
+	for coll, indexes := range specs {
+		for _, spec := range indexes {
+			add(coll, declaredIndex{name: spec.Name, keys: spec.Keys, model: spec.model()})
+		}
+	}
+	return declared, nil
+}
+
+// indexKeys returns the keys of an index model, e.g. a `bson.D` or a `bson.M`, as a document.
+func indexKeys(keys interface{}) (bson.D, error) {
+	data, err := bson.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	var d bson.D
+	if err = bson.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	if len(d) == 0 {
+		return nil, errors.New("index has no keys")
+	}
+	return d, nil
+}
+
+func indexesCmd(a *app, args []string) error {
+	fs := a.flags("indexes")
+	file := fs.String("file", "", "indexes file, or - for stdin, declaring indexes besides those of the registered models")
+	if len(args) == 0 || (args[0] != "status" && args[0] != "apply") {
+		fmt.Fprintln(a.stderr, "Usage: mdu indexes status|apply [-file indexes.json]")
+		return errUsage
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var specs map[string][]indexSpec
+	if *file != "" {
+		data, err := a.readInput(*file)
+		if err != nil {
+			return err
+		}
+		if specs, err = parseIndexFile(data); err != nil {
+			return fmt.Errorf("%s: %w", *file, err)
+		}
+	}
+	declared, err := declaredIndexes(specs)
+	if err != nil {
+		return err
+	}
+	if len(declared) == 0 {
+		return errors.New("no declared indexes: use -file, or register the models with cli.Run")
+	}
+
+	statuses, err := indexStatuses(a, declared)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "apply" {
+		var errs []error
+		for i, s := range statuses {
+			if s.State != indexMissing {
+				continue
+			}
+			ctx, cancel := a.opCtx()
+			name, err := a.db.Collection(s.Collection).Indexes().CreateOne(ctx, declared[s.Collection][s.index].model)
+			cancel()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.Collection, err))
+				continue
+			}
+			statuses[i].Name = name
+			statuses[i].State = indexPresent
+		}
+		if err = a.printIndexes(statuses); err != nil {
+			return err
+		}
+		return errors.Join(errs...)
+	}
+
+	return a.printIndexes(statuses)
+}
+
+type declaredStatus struct {
+	indexStatus
+	index int
+}
+
+// indexStatuses compares the declared indexes to the existing ones by their keys.
+func indexStatuses(a *app, declared map[string][]declaredIndex) ([]declaredStatus, error) {
+	colls := make([]string, 0, len(declared))
+	for coll := range declared {
+		colls = append(colls, coll)
+	}
+	sort.Strings(colls)
+
+	var statuses []declaredStatus
+	for _, coll := range colls {
+		existing, err := listIndexes(a, coll)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", coll, err)
+		}
+		byKeys := map[string]indexInfo{}
+		for _, index := range existing {
+			byKeys[string(index.Keys)] = index
+		}
+
+		for i, index := range declared[coll] {
+			keys := extJSON(index.keys)
+			s := declaredStatus{indexStatus{Collection: coll, Name: index.name, Keys: keys, State: indexMissing}, i}
+			if index, ok := byKeys[string(keys)]; ok {
+				s.Name = index.Name
+				s.State = indexPresent
+				delete(byKeys, string(keys))
+			}
+			statuses = append(statuses, s)
+		}
+		for _, index := range existing {
+			if _, ok := byKeys[string(index.Keys)]; ok && index.Name != "_id_" {
+				statuses = append(statuses, declaredStatus{indexStatus{Collection: coll, Name: index.Name, Keys: index.Keys, State: indexUndeclared}, -1})
+			}
+		}
+	}
+	return statuses, nil
+}
+
+func (a *app) printIndexes(statuses []declaredStatus) error {
+	out := make([]indexStatus, 0, len(statuses))
+	for _, s := range statuses {
+		out = append(out, s.indexStatus)
+	}
+	return a.print(out, func(w io.Writer) {
+		fmt.Fprintln(w, "COLLECTION\tINDEX\tKEYS\tSTATE")
+		for _, s := range out {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Collection, s.Name, s.Keys, s.State)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/softwok/mongo-util/mdu/migrate"
+)
+
+type migrationResult struct {
+	Version     int64  `json:"version"`
+	Description string `json:"description"`
+}
+
+func migrateCmd(a *app, args []string) error {
+	fs := a.flags("migrate")
+	dir := fs.String("dir", "migrations", "migrations directory")
+	coll := fs.String("collection", migrate.DefaultCollection, "collection recording the applied migrations")
+	to := fs.Int64("to", 0, "up: last version to apply; all pending migrations if 0")
+	steps := fs.Int("steps", 1, "down: number of migrations to roll back")
+	if len(args) == 0 || (args[0] != "status" && args[0] != "up" && args[0] != "down") {
+		fmt.Fprintln(a.stderr, "Usage: mdu migrate status|up|down [-dir migrations] [-collection migrations] [-to version] [-steps 1]")
+		return errUsage
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	migrations, err := migrate.LoadDir(*dir)
+	if err != nil {
+		return err
+	}
+	m, err := migrate.New(a.db, migrations...)
+	if err != nil {
+		return err
+	}
+	m.SetCollection(*coll)
+
+	var done []migrate.Migration
+	switch args[0] {
+	case "status":
+		ctx, cancel := a.opCtx()
+		defer cancel()
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		return a.print(statuses, func(w io.Writer) {
+			fmt.Fprintln(w, "VERSION\tDESCRIPTION\tAPPLIED AT")
+			for _, s := range statuses {
+				appliedAt := "pending"
+				if s.AppliedAt != nil {
+					appliedAt = s.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Description, appliedAt)
+			}
+		})
+	case "up":
+		done, err = m.Up(a.ctx, *to)
+	case "down":
+		done, err = m.Down(a.ctx, *steps)
+	}
+
+	results := make([]migrationResult, 0, len(done))
+	for _, mig := range done {
+		results = append(results, migrationResult{Version: mig.Version, Description: mig.Description})
+	}
+	if printErr := a.print(results, func(w io.Writer) {
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", args[0], r.Version, r.Description)
+		}
+	}); printErr != nil && err == nil {
+		err = printErr
+	}
+	return err
+}
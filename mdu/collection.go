@@ -2,6 +2,7 @@ package mdu
 
 import (
 	"context"
+	"errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -16,14 +17,22 @@ type Collection struct {
 }
 
 func (c *Collection) FindByIDWithCtx(ctx context.Context, id interface{}, model Model, opts ...*options.FindOneOptions) error {
-	return first(ctx, c, bson.M{field.ID: id}, model, opts...)
+	return findByID(ctx, c, id, model, opts...)
 }
 
 // FindByID method finds a doc and decodes it to a model, otherwise returns an error.
 // The id field can be any value that if passed to the `PrepareID` method, it returns
 // a valid ID (e.g.string, bson.ObjectId).
 func (c *Collection) FindByID(id interface{}, model Model, opts ...*options.FindOneOptions) error {
-	return first(ctx(), c, bson.M{field.ID: id}, model, opts...)
+	return findByID(ctx(), c, id, model, opts...)
+}
+
+func findByID(ctx context.Context, c *Collection, id interface{}, model Model, opts ...*options.FindOneOptions) error {
+	id, err := model.PrepareID(id)
+	if err != nil {
+		return err
+	}
+	return first(ctx, c, bson.M{field.ID: id}, model, opts...)
 }
 
 // First method searches and returns the first document in the search results.
@@ -45,12 +54,9 @@ func (c *Collection) CreateWithCtx(ctx context.Context, model Model, opts ...*op
 }
 
 func createWithCtx(ctx context.Context, c *Collection, model Model, opts ...*options.InsertOneOptions) (interface{}, error) {
-	id, err := model.PrepareID(model.GetID())
-
-	if err != nil {
+	if err := PrepareNewID(model); err != nil {
 		return nil, err
 	}
-	model.SetID(id)
 	return create(ctx, c, model, opts...)
 }
 
@@ -76,6 +82,74 @@ func (c *Collection) PatchWithCtx(ctx context.Context, model Model, fields map[s
 	return patch(ctx, c, model, fields, opts...)
 }
 
+// Save method upserts a model by its natural key (see `NaturalKeyGetter`), or by its id.
+// The model's mdu creating, created, saving and saved hooks are called when the document
+// is inserted, and the updating, updated, saving and saved hooks otherwise. Creation-only
+// fields (`created_at`, see `CreateOnlyFieldsGetter`) are only written on insert.
+//
+// The write only inserts if the lookup found no document, and only updates otherwise. If another
+// writer created or deleted the document in the meantime, nothing is written and Save starts over,
+// calling the other before hooks, so the after hooks always match the write. It returns
+// `ErrSaveConflict` after 3 attempts.
+func (c *Collection) Save(model Model, opts ...*options.UpdateOptions) error {
+	return save(ctx(), c, model, opts...)
+}
+
+func (c *Collection) SaveWithCtx(ctx context.Context, model Model, opts ...*options.UpdateOptions) error {
+	return save(ctx, c, model, opts...)
+}
+
+// ApplyUpdate applies an update document (e.g. the result of `Diff`) to the model's document.
+// Calling this method also invokes the model's mdu updating, updated,
+// saving, and saved hooks. A nil or empty update does nothing.
+func (c *Collection) ApplyUpdate(model Model, update bson.D, opts ...*options.UpdateOptions) error {
+	return applyUpdate(ctx(), c, model, update, opts...)
+}
+
+func (c *Collection) ApplyUpdateWithCtx(ctx context.Context, model Model, update bson.D, opts ...*options.UpdateOptions) error {
+	return applyUpdate(ctx, c, model, update, opts...)
+}
+
+// FindOneAndUpdate atomically updates the first document matching the filter, and decodes
+// the document returned (before or after the update, see `options.FindOneAndUpdate`) into the model.
+// The model's mdu updating and saving hooks are called on the model as given, and the fields they
+// change (e.g. `updated_at`) are added to the update (see `HookedUpdate`). The updated and saved
+// hooks are called on the decoded model, whose changes are only tracked from the document after
+// the update. It returns `mongo.ErrNoDocuments` if no document was returned.
+// It shadows the driver's method, which remains available as `c.Collection.FindOneAndUpdate`.
+func (c *Collection) FindOneAndUpdate(filter interface{}, update interface{}, model Model, opts ...*options.FindOneAndUpdateOptions) error {
+	return findOneAndUpdate(ctx(), c, filter, update, model, opts...)
+}
+
+func (c *Collection) FindOneAndUpdateWithCtx(ctx context.Context, filter interface{}, update interface{}, model Model, opts ...*options.FindOneAndUpdateOptions) error {
+	return findOneAndUpdate(ctx, c, filter, update, model, opts...)
+}
+
+// FindOneAndReplace atomically replaces the first document matching the filter with the model,
+// and decodes the document returned (before or after the replacement, see `options.FindOneAndReplace`)
+// into the model. The model's mdu updating, updated, saving and saved hooks are called, and its
+// changes are only tracked from the document after the replacement.
+// It shadows the driver's method, which remains available as `c.Collection.FindOneAndReplace`.
+func (c *Collection) FindOneAndReplace(filter interface{}, model Model, opts ...*options.FindOneAndReplaceOptions) error {
+	return findOneAndReplace(ctx(), c, filter, model, opts...)
+}
+
+func (c *Collection) FindOneAndReplaceWithCtx(ctx context.Context, filter interface{}, model Model, opts ...*options.FindOneAndReplaceOptions) error {
+	return findOneAndReplace(ctx, c, filter, model, opts...)
+}
+
+// FindOneAndDelete atomically deletes the first document matching the filter, and decodes it into
+// the model. The model's mdu deleting hook is called on the model as given, and the deleted hook
+// on the decoded model. It returns `mongo.ErrNoDocuments` if no document was deleted.
+// It shadows the driver's method, which remains available as `c.Collection.FindOneAndDelete`.
+func (c *Collection) FindOneAndDelete(filter interface{}, model Model, opts ...*options.FindOneAndDeleteOptions) error {
+	return findOneAndDelete(ctx(), c, filter, model, opts...)
+}
+
+func (c *Collection) FindOneAndDeleteWithCtx(ctx context.Context, filter interface{}, model Model, opts ...*options.FindOneAndDeleteOptions) error {
+	return findOneAndDelete(ctx, c, filter, model, opts...)
+}
+
 // Delete method deletes a model (doc) from a collection using the specified context.
 // To perform additional operations when deleting a model
 // you should use hooks rather than overriding this method.
@@ -96,6 +170,44 @@ func (c *Collection) FindAllWithCtx(ctx context.Context, results interface{}, fi
 	return findAll(ctx, c, results, filter, opts...)
 }
 
+// Count returns the number of documents matching the filter. mdu defines no soft-delete or tenant
+// scope, so the filter is used as given: include the `tenantId` (or a deleted flag) in it to scope the count.
+func (c *Collection) Count(filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.CountDocuments(ctx(), filter, opts...)
+}
+
+func (c *Collection) CountWithCtx(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.CountDocuments(ctx, filter, opts...)
+}
+
+// Exists returns whether a document matches the filter. Only the `_id` of the first
+// matching document is fetched. Like Count, the filter is not scoped.
+func (c *Collection) Exists(filter interface{}) (bool, error) {
+	return exists(ctx(), c, filter)
+}
+
+func (c *Collection) ExistsWithCtx(ctx context.Context, filter interface{}) (bool, error) {
+	return exists(ctx, c, filter)
+}
+
+func exists(ctx context.Context, c *Collection, filter interface{}) (bool, error) {
+	err := c.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{field.ID: 1})).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// EstimatedCount returns an estimate of the number of documents in the collection, using its metadata.
+// It counts all the documents, whatever their tenant; use Count to count the documents of a tenant.
+func (c *Collection) EstimatedCount(opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
+	return c.EstimatedDocumentCount(ctx(), opts...)
+}
+
+func (c *Collection) EstimatedCountWithCtx(ctx context.Context, opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
+	return c.EstimatedDocumentCount(ctx, opts...)
+}
+
 func findAll(ctx context.Context, c *Collection, results interface{}, filter interface{}, opts ...*options.FindOptions) error {
 	cur, err := c.Find(ctx, filter, opts...)
 
@@ -103,7 +215,13 @@ func findAll(ctx context.Context, c *Collection, results interface{}, filter int
 		return err
 	}
 
-	return cur.All(ctx, results)
+	if err = cur.All(ctx, results); err != nil {
+		return err
+	}
+
+	return eachElem(results, func(elem interface{}) error {
+		return loaded(ctx, elem)
+	})
 }
 
 //--------------------------------
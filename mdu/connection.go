@@ -8,6 +8,8 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"io"
+	"os"
 	"time"
 )
 
@@ -19,6 +21,22 @@ var db *mongo.Database
 type Config struct {
 	// Set to 10 second (10*time.Second) for example.
 	CtxTimeout time.Duration
+
+	// IDGenerator generates the ids of new models, UUIDv4 strings if nil.
+	// Models implementing `IDGeneratorGetter` use their own generator.
+	IDGenerator IDGenerator
+
+	// PageTokenSecret signs the keyset pagination tokens. If empty, a random secret is
+	// used, and tokens are only valid for the process that issued them.
+	PageTokenSecret []byte
+
+	// NamingStrategy infers the collection names of models, `Naming{}` if nil
+	// (pluralized lowerCamelCase).
+	NamingStrategy NamingStrategy
+
+	// Log receives the connection messages of `Init` and `Disconnect`, os.Stdout if nil.
+	// Set it to io.Discard to silence them.
+	Log io.Writer
 }
 
 // NewCtx function creates and returns a new context with the specified timeout.
@@ -65,6 +83,7 @@ func NewCollection(db *mongo.Database, name string, opts ...*options.CollectionO
 // ResetDefaultConfig resets the configuration values, client and database.
 func ResetDefaultConfig() {
 	config = nil
+	_ = refreshRegistry()
 	client = nil
 	db = nil
 }
@@ -78,13 +97,16 @@ func Init(conf *Config, dbName string, opts ...*options.ClientOptions) (err erro
 		conf = defaultConf()
 	}
 	config = conf
+	if err = refreshRegistry(); err != nil {
+		return err
+	}
 	if client, err = NewClient(opts...); err != nil {
 		return err
 	}
 
 	db = client.Database(dbName)
 
-	fmt.Println("database connected.")
+	logln("database connected.")
 	return nil
 }
 
@@ -94,10 +116,19 @@ func Disconnect() {
 	}
 	err := client.Disconnect(Ctx())
 	if err != nil {
-		fmt.Println("failed to disconnect from database.")
+		logln("failed to disconnect from database.")
 		return
 	}
-	fmt.Println("database disconnected.")
+	logln("database disconnected.")
+}
+
+// logln writes a connection message to the configured log writer.
+func logln(msg string) {
+	var w io.Writer = os.Stdout
+	if config != nil && config.Log != nil {
+		w = config.Log
+	}
+	fmt.Fprintln(w, msg)
 }
 
 // CollectionByName returns a new collection using the current configuration values.
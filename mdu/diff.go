@@ -0,0 +1,172 @@
+package mdu
+
+import (
+	"reflect"
+	"strconv"
+
+	"github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Diff returns the update document that changes the old model's document into the new
+// model's document, or nil if they are equal. The models are compared as marshalled to
+// bson, so bson tags, `omitempty`, inline structs and custom marshalers are honored.
+//
+// Changed paths are updated with `$set` and `$unset`. Slices that only had elements
+// appended are updated with `$push` (`$each`), and slices that only had values removed
+// with `$pull` (`$in`). The `_id` and `created_at` fields are ignored. A nil old model
+// is considered empty. Use `ApplyUpdate` to persist the result with the model's hooks.
+func Diff(old, new Model) (bson.D, error) {
+	oldDoc := bson.D{}
+	if old != nil {
+		var err error
+		if oldDoc, err = toDoc(old); err != nil {
+			return nil, err
+		}
+	}
+	newDoc, err := toDoc(new)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &differ{slices: true}
+	d.docs("", oldDoc, newDoc)
+	return d.update(), nil
+}
+
+func toDoc(val interface{}) (bson.D, error) {
+	raw, err := bson.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	return doc, bson.Unmarshal(raw, &doc)
+}
+
+// differ collects the update operations changing a document into another. Arrays of
+// the same length are diffed by index, and other changed arrays are replaced, unless
+// slices is set and the change can be expressed as a `$push` or a `$pull`.
+type differ struct {
+	slices                 bool
+	set, unset, push, pull bson.D
+}
+
+func (d *differ) update() bson.D {
+	update := bson.D{}
+	for _, op := range []bson.E{{Key: o.Set, Value: d.set}, {Key: o.Unset, Value: d.unset}, {Key: o.Push, Value: d.push}, {Key: o.Pull, Value: d.pull}} {
+		if len(op.Value.(bson.D)) > 0 {
+			update = append(update, op)
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	return update
+}
+
+func (d *differ) docs(prefix string, old, new bson.D) {
+	ignored := func(key string) bool {
+		return prefix == "" && (key == field.ID || key == CreatedAtField)
+	}
+
+	oldValues := make(map[string]interface{}, len(old))
+	for _, e := range old {
+		oldValues[e.Key] = e.Value
+	}
+
+	for _, e := range new {
+		if ignored(e.Key) {
+			continue
+		}
+		oldVal, ok := oldValues[e.Key]
+		delete(oldValues, e.Key)
+		if !ok {
+			d.set = append(d.set, bson.E{Key: prefix + e.Key, Value: e.Value})
+			continue
+		}
+		d.values(prefix+e.Key, oldVal, e.Value)
+	}
+
+	for _, e := range old {
+		if _, ok := oldValues[e.Key]; ok && !ignored(e.Key) {
+			d.unset = append(d.unset, bson.E{Key: prefix + e.Key, Value: ""})
+		}
+	}
+}
+
+func (d *differ) values(path string, old, new interface{}) {
+	if reflect.DeepEqual(old, new) {
+		return
+	}
+
+	switch n := new.(type) {
+	case bson.D:
+		if old, ok := old.(bson.D); ok {
+			d.docs(path+".", old, n)
+			return
+		}
+	case bson.A:
+		if old, ok := old.(bson.A); ok {
+			d.arrays(path, old, n)
+			return
+		}
+	}
+
+	d.set = append(d.set, bson.E{Key: path, Value: new})
+}
+
+func (d *differ) arrays(path string, old, new bson.A) {
+	if d.slices {
+		if len(new) > len(old) && reflect.DeepEqual(old, new[:len(old)]) {
+			d.push = append(d.push, bson.E{Key: path, Value: bson.M{o.Each: new[len(old):]}})
+			return
+		}
+		if removed := pulled(old, new); len(removed) > 0 {
+			d.pull = append(d.pull, bson.E{Key: path, Value: bson.M{o.In: removed}})
+			return
+		}
+	}
+
+	if len(old) == len(new) {
+		for i := range new {
+			d.values(path+"."+strconv.Itoa(i), old[i], new[i])
+		}
+		return
+	}
+	d.set = append(d.set, bson.E{Key: path, Value: new})
+}
+
+// pulled returns the distinct values whose removal from the old array results in the
+// new array, or nil if there are none.
+func pulled(old, new bson.A) bson.A {
+	var removed bson.A
+	for _, v := range old {
+		if !contains(new, v) && !contains(removed, v) {
+			removed = append(removed, v)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	kept := bson.A{}
+	for _, v := range old {
+		if !contains(removed, v) {
+			kept = append(kept, v)
+		}
+	}
+	if !reflect.DeepEqual(kept, new) {
+		return nil
+	}
+	return removed
+}
+
+func contains(arr bson.A, val interface{}) bool {
+	for _, v := range arr {
+		if reflect.DeepEqual(v, val) {
+			return true
+		}
+	}
+	return false
+}
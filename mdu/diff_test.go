@@ -0,0 +1,97 @@
+package mdu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+type upperString string
+
+func (s upperString) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(strings.ToUpper(string(s)))
+}
+
+type diffAddress struct {
+	City string `bson:"city"`
+	Zip  string `bson:"zip,omitempty"`
+}
+
+type diffModel struct {
+	DefaultModel `bson:",inline"`
+	Name         string        `bson:"name"`
+	Nickname     string        `bson:"nickname,omitempty"`
+	Code         upperString   `bson:"code"`
+	Tags         []string      `bson:"tags"`
+	Scores       []int         `bson:"scores"`
+	Addresses    []diffAddress `bson:"addresses"`
+	Address      diffAddress   `bson:"address"`
+}
+
+func newDiffModel() *diffModel {
+	m := &diffModel{
+		Name:      "a",
+		Nickname:  "nick",
+		Code:      "x",
+		Tags:      []string{"a", "b", "a", "c"},
+		Scores:    []int{1, 2},
+		Addresses: []diffAddress{{City: "Paris"}, {City: "Rome"}},
+		Address:   diffAddress{City: "Oslo", Zip: "0150"},
+	}
+	m.ID = "1"
+	return m
+}
+
+func TestDiff(t *testing.T) {
+	old := newDiffModel()
+	upd, err := Diff(old, newDiffModel())
+	util.AssertErrIsNil(t, err)
+	assert.Nil(t, upd)
+
+	tests := []struct {
+		name     string
+		change   func(m *diffModel)
+		expected bson.D
+	}{
+		{"set", func(m *diffModel) { m.Name = "b"; m.Code = "y" }, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "name", Value: "b"}, {Key: "code", Value: "Y"}}},
+		}},
+		{"unset omitempty", func(m *diffModel) { m.Nickname = ""; m.Address.Zip = "" }, bson.D{
+			{Key: "$unset", Value: bson.D{{Key: "address.zip", Value: ""}, {Key: "nickname", Value: ""}}},
+		}},
+		{"push", func(m *diffModel) { m.Scores = append(m.Scores, 3, 4) }, bson.D{
+			{Key: "$push", Value: bson.D{{Key: "scores", Value: bson.M{"$each": bson.A{int32(3), int32(4)}}}}},
+		}},
+		{"pull", func(m *diffModel) { m.Tags = []string{"b", "c"} }, bson.D{
+			{Key: "$pull", Value: bson.D{{Key: "tags", Value: bson.M{"$in": bson.A{"a"}}}}},
+		}},
+		{"array element", func(m *diffModel) { m.Addresses[1].City = "Milan" }, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "addresses.1.city", Value: "Milan"}}},
+		}},
+		{"array replaced", func(m *diffModel) { m.Tags = []string{"c", "a"}; m.Scores = []int{2, 1, 3} }, bson.D{
+			{Key: "$set", Value: bson.D{{Key: "tags", Value: bson.A{"c", "a"}}, {Key: "scores", Value: bson.A{int32(2), int32(1), int32(3)}}}},
+		}},
+		{"ignored", func(m *diffModel) { m.ID = "2"; m.CreatedAt = m.CreatedAt.AddDate(1, 0, 0) }, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newDiffModel()
+			tc.change(m)
+			upd, err := Diff(old, m)
+			util.AssertErrIsNil(t, err)
+			assert.Equal(t, tc.expected, upd)
+		})
+	}
+}
+
+func TestDiffNilOld(t *testing.T) {
+	upd, err := Diff(nil, &PurchaseOrder{})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, "$set", upd[0].Key)
+	assert.Equal(t, "updated_at", upd[0].Value.(bson.D)[0].Key)
+}
@@ -0,0 +1,40 @@
+package mdu
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DistinctSource is implemented by `Collection` (through the driver's collection)
+// and by the in-memory `mdutest.Collection`.
+type DistinctSource interface {
+	Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error)
+}
+
+// Distinct returns the distinct values of the field in the documents matching the filter,
+// decoded to T (e.g. `mdu.Distinct[string](ctx, mdu.Coll(&product{}), "category", bson.M{})`).
+// Like `Collection.Count`, the filter is not scoped to a tenant.
+func Distinct[T any](ctx context.Context, c DistinctSource, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]T, error) {
+	values, err := c.Distinct(ctx, fieldName, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]T, 0, len(values))
+	for _, val := range values {
+		raw, err := bson.Marshal(bson.D{{Key: "v", Value: val}})
+		if err != nil {
+			return nil, err
+		}
+		var decoded struct {
+			V T `bson:"v"`
+		}
+		if err = bson.Unmarshal(raw, &decoded); err != nil {
+			return nil, err
+		}
+		results = append(results, decoded.V)
+	}
+	return results, nil
+}
@@ -0,0 +1,272 @@
+// Package facet runs faceted searches: the page of the documents matching a filter, their
+// total count and the counts of named facets (terms and ranges) in a single `$facet`
+// aggregation.
+package facet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/softwok/mongo-util/builder"
+	"github.com/softwok/mongo-util/mdu"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Names of the `$facet` fields of the page and the total count, which facets can't use.
+const (
+	itemsFacet = "__items"
+	totalFacet = "__total"
+)
+
+// Aggregator is implemented by `mdu.Collection` and `mdutest.Collection`.
+type Aggregator interface {
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+}
+
+type kind int
+
+const (
+	termsKind kind = iota
+	rangeKind
+	autoRangeKind
+)
+
+// Facet is a facet of a search, created with Terms, Range or AutoRange.
+type Facet struct {
+	kind       kind
+	stages     bson.A
+	boundaries []interface{}
+}
+
+// Terms returns a facet counting the documents by value of the field, using `$sortByCount`.
+// The elements of array fields are counted separately, and documents without the field are
+// counted with a nil value. At most limit values, the most frequent first, are returned if
+// limit is positive.
+func Terms(field string, limit int64) Facet {
+	stages := bson.A{
+		builder.S(builder.Unwind("$"+field, nil, true)),
+		bson.M{o.SortByCount: "$" + field},
+	}
+	if limit > 0 {
+		stages = append(stages, bson.M{o.Limit: limit})
+	}
+	return Facet{kind: termsKind, stages: stages}
+}
+
+// Range returns a facet counting the documents by range of the field, using `$bucket`. The
+// boundaries are sorted in ascending order, and each range includes its lower boundary and
+// excludes its upper one. Documents outside the boundaries are counted with the default value;
+// the search fails if some are found and def is nil.
+func Range(field string, boundaries []interface{}, def interface{}) Facet {
+	return Facet{
+		kind:       rangeKind,
+		stages:     bson.A{builder.S(builder.Bucket("$"+field, bson.A(boundaries), def, nil))},
+		boundaries: boundaries,
+	}
+}
+
+// AutoRange returns a facet counting the documents in the given number of ranges of the field
+// with evenly distributed counts, using `$bucketAuto`. The granularity is an optional preferred
+// number series (e.g. "R5", "1-2-5", "POWERSOF2").
+func AutoRange(field string, buckets int, granularity string) Facet {
+	var g interface{}
+	if granularity != "" {
+		g = granularity
+	}
+	return Facet{kind: autoRangeKind, stages: bson.A{builder.S(builder.BucketAuto("$"+field, buckets, nil, g))}}
+}
+
+// Query struct contains the parameters of a search.
+type Query struct {
+	// Filter selects the documents of the page and of the facet counts.
+	Filter interface{}
+
+	// Page starts at 1.
+	Page    int64
+	PerPage int64
+
+	Sort       interface{}
+	Projection interface{}
+
+	// Facets are the facets to count, by name.
+	Facets map[string]Facet
+}
+
+// Count struct contains the number of documents of a facet value or range.
+type Count struct {
+	// Value is the value of a terms facet, or the default value of a range facet.
+	Value interface{} `json:"value,omitempty"`
+
+	// Min and Max are the bounds of a range. Max is excluded, except for the last range of an
+	// auto range facet.
+	Min interface{} `json:"min,omitempty"`
+	Max interface{} `json:"max,omitempty"`
+
+	Count int64 `json:"count"`
+}
+
+// Result struct contains the page of the documents and the facet counts of a search.
+type Result[T any] struct {
+	Items  []T                `json:"items"`
+	Page   mdu.Page           `json:"page"`
+	Facets map[string][]Count `json:"facets"`
+}
+
+// Search runs the query in a single aggregation, decodes the documents of the page into T and
+// calls their loaded hooks (e.g. `facet.Search[product](ctx, mdu.Coll(&product{}), query)`).
+func Search[T any](ctx context.Context, c Aggregator, q Query) (*Result[T], error) {
+	if q.Page < 1 || q.PerPage < 1 {
+		return nil, errors.New("page and perPage must be positive")
+	}
+	filter := q.Filter
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	items := bson.A{}
+	if q.Sort != nil {
+		items = append(items, bson.M{o.Sort: q.Sort})
+	}
+	items = append(items, bson.M{o.Skip: (q.Page - 1) * q.PerPage}, bson.M{o.Limit: q.PerPage})
+	if q.Projection != nil {
+		items = append(items, bson.M{o.Project: q.Projection})
+	}
+
+	facets := bson.D{
+		{Key: itemsFacet, Value: items},
+		{Key: totalFacet, Value: bson.A{bson.M{o.Count: "count"}}},
+	}
+	names := make([]string, 0, len(q.Facets))
+	for name := range q.Facets {
+		if name == "" || strings.HasPrefix(name, "$") || strings.Contains(name, ".") || name == itemsFacet || name == totalFacet {
+			return nil, fmt.Errorf("invalid facet name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		facets = append(facets, bson.E{Key: name, Value: q.Facets[name].stages})
+	}
+
+	cur, err := c.Aggregate(ctx, bson.A{
+		builder.S(builder.New(o.Match, filter)),
+		builder.S(builder.New(o.Facet, facets)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.Raw
+	if err = cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	if len(docs) != 1 {
+		return nil, fmt.Errorf("expected a single %s result, got %d", o.Facet, len(docs))
+	}
+	return decode[T](ctx, docs[0], q, names)
+}
+
+func decode[T any](ctx context.Context, doc bson.Raw, q Query, names []string) (*Result[T], error) {
+	var raw struct {
+		Items []bson.Raw `bson:"__items"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"__total"`
+	}
+	if err := bson.Unmarshal(doc, &raw); err != nil {
+		return nil, err
+	}
+
+	res := &Result[T]{Items: make([]T, len(raw.Items)), Facets: make(map[string][]Count, len(names))}
+	for i, item := range raw.Items {
+		if err := bson.Unmarshal(item, &res.Items[i]); err != nil {
+			return nil, err
+		}
+		if err := mdu.AfterLoadHooks(ctx, &res.Items[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var total int64
+	if len(raw.Total) > 0 {
+		total = raw.Total[0].Count
+	}
+	res.Page = mdu.Page{Page: q.Page, PerPage: q.PerPage, Total: total, TotalPages: (total + q.PerPage - 1) / q.PerPage}
+
+	for _, name := range names {
+		buckets, err := doc.LookupErr(name)
+		if err != nil {
+			return nil, err
+		}
+		arr, ok := buckets.ArrayOK()
+		if !ok {
+			return nil, fmt.Errorf("facet %s: expected an array", name)
+		}
+		values, err := arr.Values()
+		if err != nil {
+			return nil, err
+		}
+
+		counts := make([]Count, 0, len(values))
+		for _, v := range values {
+			count, err := q.Facets[name].count(v.Document())
+			if err != nil {
+				return nil, fmt.Errorf("facet %s: %w", name, err)
+			}
+			counts = append(counts, count)
+		}
+		res.Facets[name] = counts
+	}
+	return res, nil
+}
+
+// count decodes a document of the facet results.
+func (f Facet) count(doc bson.Raw) (Count, error) {
+	var bucket struct {
+		ID    bson.RawValue `bson:"_id"`
+		Count int64         `bson:"count"`
+	}
+	if err := bson.Unmarshal(doc, &bucket); err != nil {
+		return Count{}, err
+	}
+	count := Count{Count: bucket.Count}
+
+	switch f.kind {
+	case rangeKind:
+		for i := 0; i+1 < len(f.boundaries); i++ {
+			if equal(bucket.ID, f.boundaries[i]) {
+				count.Min, count.Max = f.boundaries[i], f.boundaries[i+1]
+				return count, nil
+			}
+		}
+	case autoRangeKind:
+		var bounds struct {
+			Min interface{} `bson:"min"`
+			Max interface{} `bson:"max"`
+		}
+		if err := bucket.ID.Unmarshal(&bounds); err != nil {
+			return Count{}, err
+		}
+		count.Min, count.Max = bounds.Min, bounds.Max
+		return count, nil
+	}
+
+	if bucket.ID.Type != bsontype.Null {
+		if err := bucket.ID.Unmarshal(&count.Value); err != nil {
+			return Count{}, err
+		}
+	}
+	return count, nil
+}
+
+// equal returns whether the raw value is the marshaled value.
+func equal(raw bson.RawValue, v interface{}) bool {
+	t, data, err := bson.MarshalValue(v)
+	return err == nil && raw.Equal(bson.RawValue{Type: t, Value: data})
+}
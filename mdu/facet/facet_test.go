@@ -0,0 +1,114 @@
+package facet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/softwok/mongo-util/mdu"
+	"github.com/softwok/mongo-util/mdu/mdutest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type product struct {
+	mdu.DefaultModel `bson:",inline"`
+	Name             string   `bson:"name"`
+	Category         string   `bson:"category,omitempty"`
+	Price            float64  `bson:"price"`
+	Tags             []string `bson:"tags,omitempty"`
+
+	loaded bool
+}
+
+func (p *product) Loaded(ctx context.Context) error {
+	p.loaded = true
+	return nil
+}
+
+func newProducts(t *testing.T) *mdutest.Collection {
+	coll := mdutest.Coll(&product{})
+	for _, p := range []*product{
+		{Name: "Apple", Category: "fruit", Price: 1.5, Tags: []string{"red", "sweet"}},
+		{Name: "Banana", Category: "fruit", Price: 0.5, Tags: []string{"sweet"}},
+		{Name: "Cherry", Category: "fruit", Price: 12},
+		{Name: "Carrot", Category: "vegetable", Price: 0.8},
+		{Name: "Truffle", Price: 250},
+	} {
+		_, err := coll.Create(p)
+		util.AssertErrIsNil(t, err)
+	}
+	return coll
+}
+
+func TestSearch(t *testing.T) {
+	coll := newProducts(t)
+
+	res, err := Search[product](context.Background(), coll, Query{
+		Filter:  bson.M{"price": bson.M{"$lt": 100}},
+		Page:    2,
+		PerPage: 3,
+		Sort:    bson.D{{Key: "price", Value: 1}},
+		Facets: map[string]Facet{
+			"category": Terms("category", 0),
+			"tags":     Terms("tags", 2),
+			"price":    Range("price", []interface{}{0, 1, 10}, "10+"),
+		},
+	})
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, mdu.Page{Page: 2, PerPage: 3, Total: 4, TotalPages: 2}, res.Page)
+	assert.Equal(t, 1, len(res.Items))
+	assert.Equal(t, "Cherry", res.Items[0].Name)
+	assert.True(t, res.Items[0].loaded)
+
+	assert.Equal(t, []Count{{Value: "fruit", Count: 3}, {Value: "vegetable", Count: 1}}, res.Facets["category"])
+	assert.Equal(t, []Count{{Value: "sweet", Count: 2}, {Count: 2}}, res.Facets["tags"])
+	assert.Equal(t, []Count{
+		{Min: 0, Max: 1, Count: 2},
+		{Min: 1, Max: 10, Count: 1},
+		{Value: "10+", Count: 1},
+	}, res.Facets["price"])
+}
+
+func TestSearchEmpty(t *testing.T) {
+	coll := newProducts(t)
+
+	res, err := Search[product](context.Background(), coll, Query{
+		Filter:  bson.M{"price": bson.M{"$gt": 1000}},
+		Page:    1,
+		PerPage: 10,
+		Facets:  map[string]Facet{"category": Terms("category", 0)},
+	})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, mdu.Page{Page: 1, PerPage: 10}, res.Page)
+	assert.Empty(t, res.Items)
+	assert.Empty(t, res.Facets["category"])
+}
+
+func TestSearchErrors(t *testing.T) {
+	coll := newProducts(t)
+	ctx := context.Background()
+
+	_, err := Search[product](ctx, coll, Query{Page: 0, PerPage: 10})
+	assert.NotNil(t, err)
+
+	_, err = Search[product](ctx, coll, Query{Page: 1, PerPage: 10, Facets: map[string]Facet{"__items": Terms("name", 0)}})
+	assert.NotNil(t, err)
+
+	// Documents outside the boundaries without a default range.
+	_, err = Search[product](ctx, coll, Query{Page: 1, PerPage: 10, Facets: map[string]Facet{"price": Range("price", []interface{}{0, 10}, nil)}})
+	assert.NotNil(t, err)
+}
+
+func TestAutoRangeCount(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{"_id": bson.M{"min": 1, "max": 5}, "count": int32(3)})
+	util.AssertErrIsNil(t, err)
+
+	count, err := AutoRange("price", 4, "").count(raw)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, Count{Min: int32(1), Max: int32(5), Count: 3}, count)
+
+	stage := AutoRange("price", 4, "R5").stages[0].(bson.M)["$bucketAuto"]
+	assert.Equal(t, bson.M{"groupBy": "$price", "buckets": 4, "granularity": "R5"}, stage)
+}
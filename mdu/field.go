@@ -2,14 +2,25 @@ package mdu
 
 import (
 	"context"
-	"github.com/google/uuid"
+	"fmt"
+	"strconv"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// IDField struct contains a model's string ID field. New ids are UUIDv4 strings
+// unless another `IDGenerator` is configured.
 type IDField struct {
 	ID string `json:"id" bson:"_id,omitempty"`
 }
 
+// ObjectIDField struct contains a model's ObjectID field. New ids are generated
+// by the `ObjectIDGenerator`.
+type ObjectIDField struct {
+	ID primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+}
+
 // DateFields struct contains the `created_at` and `updated_at`
 // fields that autofill when inserting or updating a model.
 type DateFields struct {
@@ -21,23 +32,68 @@ type TenantIdField struct {
 	TenantId string `json:"tenantId" bson:"tenantId,omitempty"`
 }
 
-// PrepareID method prepares the ID value to be used for filtering
-// generates uuid if not given id is empty
-func (f *IDField) PrepareID(id string) (string, error) {
-	if id != "" {
-		return id, nil
+// PrepareID method prepares the ID value to be used for filtering.
+// Strings are returned as is, ObjectIDs are converted to hex strings
+// and integers to decimal strings.
+func (f *IDField) PrepareID(id interface{}) (interface{}, error) {
+	switch v := id.(type) {
+	case string:
+		return v, nil
+	case primitive.ObjectID:
+		return v.Hex(), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	return nil, fmt.Errorf("invalid id %v of type %T, expected a string", id, id)
+}
+
+// GetID method returns a model's ID
+func (f *IDField) GetID() interface{} {
+	return f.ID
+}
+
+// SetID sets the value of a model's ID field. Values that can not be
+// converted by `PrepareID` are ignored.
+func (f *IDField) SetID(id interface{}) {
+	if v, err := f.PrepareID(id); err == nil {
+		f.ID, _ = v.(string)
+	}
+}
+
+// PrepareID method prepares the ID value to be used for filtering,
+// converting hex strings to ObjectIDs.
+func (f *ObjectIDField) PrepareID(id interface{}) (interface{}, error) {
+	switch v := id.(type) {
+	case primitive.ObjectID:
+		return v, nil
+	case string:
+		return primitive.ObjectIDFromHex(v)
 	}
-	return uuid.NewString(), nil
+	return nil, fmt.Errorf("invalid id %v of type %T, expected an ObjectID", id, id)
 }
 
 // GetID method returns a model's ID
-func (f *IDField) GetID() string {
+func (f *ObjectIDField) GetID() interface{} {
 	return f.ID
 }
 
-// SetID sets the value of a model's ID field.
-func (f *IDField) SetID(id string) {
-	f.ID = id
+// SetID sets the value of a model's ID field. Values that can not be
+// converted by `PrepareID` are ignored.
+func (f *ObjectIDField) SetID(id interface{}) {
+	if v, err := f.PrepareID(id); err == nil {
+		f.ID, _ = v.(primitive.ObjectID)
+	}
+}
+
+// IDGenerator returns the `ObjectIDGenerator`.
+func (f *ObjectIDField) IDGenerator() IDGenerator {
+	return ObjectIDGenerator
 }
 
 // GetTenantId method returns a model's TenantId
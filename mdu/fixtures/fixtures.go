@@ -0,0 +1,328 @@
+// Package fixtures loads seed documents from Extended JSON and YAML files and
+// inserts them through mdu collections, so model hooks run as they would in
+// the application.
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/softwok/mongo-util/mdu"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/yaml.v3"
+)
+
+// Loader loads fixture files, one file per collection. The file name without
+// its extension is the collection name (e.g. `products.json`, `orders.yaml`).
+//
+// Files are rendered as Go templates before they are parsed, with the following functions:
+//   - id "name": returns the generated id of the named fixture, so fixtures can reference each other
+//     across files regardless of the loading order.
+//   - oid "name": same as id but returns an ObjectID hex string.
+//   - now: returns the current time in RFC 3339 format (e.g. `{"$date": "{{ now }}"}`).
+//   - nowAdd "-24h": returns the current time shifted by the given duration in RFC 3339 format.
+type Loader struct {
+	models map[string]reflect.Type
+	loaded map[string]bool
+	ids    map[string]string
+	oids   map[string]string
+	mu     sync.Mutex
+}
+
+// New returns a new loader. Documents of collections that belong to one of the given
+// models are decoded to the model and inserted using `Collection.Create`, so the model's
+// creating, created, saving and saved hooks are called. Documents of any other collection
+// are inserted as they are.
+func New(models ...mdu.Model) *Loader {
+	l := &Loader{
+		models: map[string]reflect.Type{},
+		loaded: map[string]bool{},
+		ids:    map[string]string{},
+		oids:   map[string]string{},
+	}
+
+	for _, m := range models {
+		l.models[mdu.CollName(m)] = reflect.TypeOf(m).Elem()
+	}
+
+	return l
+}
+
+// ID returns the id generated for the named fixture.
+func (l *Loader) ID(name string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.ids[name]; !ok {
+		l.ids[name] = uuid.NewString()
+	}
+	return l.ids[name]
+}
+
+// ObjectID returns the ObjectID generated for the named fixture.
+func (l *Loader) ObjectID(name string) primitive.ObjectID {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.oids[name]; !ok {
+		l.oids[name] = primitive.NewObjectID().Hex()
+	}
+	id, _ := primitive.ObjectIDFromHex(l.oids[name])
+	return id
+}
+
+// LoadDir loads all the `.json`, `.yaml` and `.yml` files of the given directory in name order.
+func (l *Loader) LoadDir(ctx context.Context, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isFixtureFile(entry.Name()) {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+
+	return l.LoadFiles(ctx, paths...)
+}
+
+// LoadFiles loads the given fixture files.
+func (l *Loader) LoadFiles(ctx context.Context, paths ...string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Base(path)
+		if err = l.Load(ctx, strings.TrimSuffix(name, filepath.Ext(name)), filepath.Ext(name), data); err != nil {
+			return fmt.Errorf("fixtures: %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// Load loads fixture documents of the given format (`.json`, `.yaml` or `.yml`) into the collection.
+func (l *Loader) Load(ctx context.Context, collName, format string, data []byte) error {
+	docs, err := l.parse(format, data)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.loaded[collName] = true
+	l.mu.Unlock()
+
+	for _, doc := range docs {
+		if err = l.insert(ctx, collName, doc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset deletes all the documents of the registered collections and of every
+// collection loaded by this loader, in the collections the fixtures are inserted into. Generated ids are kept, so the same fixtures
+// get the same ids when they are loaded again.
+func (l *Loader) Reset(ctx context.Context) error {
+	l.mu.Lock()
+	names := map[string]bool{}
+	for name := range l.models {
+		names[name] = true
+	}
+	for name := range l.loaded {
+		names[name] = true
+	}
+	l.mu.Unlock()
+
+	for name := range names {
+		if _, err := l.collection(name).Collection.DeleteMany(ctx, bson.M{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Loader) insert(ctx context.Context, collName string, doc bson.D) error {
+	t, ok := l.models[collName]
+	if !ok {
+		_, err := l.collection(collName).InsertOne(ctx, doc)
+		return err
+	}
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	model := reflect.New(t).Interface().(mdu.Model)
+	if err = bson.Unmarshal(raw, model); err != nil {
+		return err
+	}
+
+	_, err = mdu.Coll(model).CreateWithCtx(ctx, model)
+	return err
+}
+
+// collection returns the collection of the model registered for the name (see `mdu.Coll`),
+// or the collection of the configured database for the collections loaded without a model.
+func (l *Loader) collection(name string) *mdu.Collection {
+	if t, ok := l.models[name]; ok {
+		return mdu.Coll(reflect.New(t).Interface().(mdu.Model))
+	}
+	return mdu.CollectionByName(name)
+}
+
+// parse renders the template and decodes the fixture documents.
+func (l *Loader) parse(format string, data []byte) ([]bson.D, error) {
+	rendered, err := l.render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(format) {
+	case ".json":
+	case ".yaml", ".yml":
+		if rendered, err = yamlToJSON(rendered); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported fixture format %q", format)
+	}
+
+	// Extended JSON must be a document, so wrap the list of fixtures.
+	wrapped := append(append([]byte(`{"docs":`), rendered...), '}')
+	var out struct {
+		Docs []bson.D `bson:"docs"`
+	}
+	if err = bson.UnmarshalExtJSON(wrapped, false, &out); err != nil {
+		return nil, err
+	}
+
+	return out.Docs, nil
+}
+
+func (l *Loader) render(data []byte) ([]byte, error) {
+	tmpl, err := template.New("fixture").Funcs(template.FuncMap{
+		"id": l.ID,
+		"oid": func(name string) string {
+			return l.ObjectID(name).Hex()
+		},
+		"now": func() string {
+			return time.Now().UTC().Format(time.RFC3339Nano)
+		},
+		"nowAdd": func(d string) (string, error) {
+			duration, err := time.ParseDuration(d)
+			if err != nil {
+				return "", err
+			}
+			return time.Now().UTC().Add(duration).Format(time.RFC3339Nano), nil
+		},
+	}).Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err = tmpl.Execute(buf, nil); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// yamlToJSON converts a YAML document to JSON keeping the keys order, so that
+// Extended JSON keys (e.g. `$oid`, `$date`) can be used in YAML fixtures too.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if len(node.Content) == 0 {
+		buf.WriteString("[]")
+		return buf.Bytes(), nil
+	}
+	if err := writeJSON(buf, node.Content[0]); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return writeJSON(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err = writeJSON(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, child := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, child); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		var val interface{}
+		if err := node.Decode(&val); err != nil {
+			return err
+		}
+		if t, ok := val.(time.Time); ok {
+			val = map[string]string{"$date": t.UTC().Format(time.RFC3339Nano)}
+		}
+		b, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+
+	return nil
+}
+
+func isFixtureFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package fixtures
+
+import (
+	"testing"
+	"time"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/softwok/mongo-util/mdu"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestParseJSON(t *testing.T) {
+	l := New()
+	docs, err := l.parse(".json", []byte(`[
+		{"_id": "{{ id "apple" }}", "name": "Apple", "price": {"$numberLong": "100"}},
+		{"_id": "{{ id "order" }}", "product": "{{ id "apple" }}", "at": {"$date": "{{ now }}"}}
+	]`))
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, 2, len(docs))
+	assert.Equal(t, l.ID("apple"), docs[0].Map()["_id"])
+	assert.Equal(t, int64(100), docs[0].Map()["price"])
+	assert.Equal(t, l.ID("apple"), docs[1].Map()["product"])
+	assert.IsType(t, primitive.DateTime(0), docs[1].Map()["at"])
+}
+
+func TestParseYAML(t *testing.T) {
+	l := New()
+	docs, err := l.parse(".yaml", []byte(`
+- _id: {{ id "apple" }}
+  name: Apple
+  price: 100
+  tags: [fruit, red]
+- _id: {{ oid "order" }}
+  product: {{ id "apple" }}
+  owner:
+    $oid: {{ oid "owner" }}
+  at: 2023-01-02T10:00:00Z
+`))
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, 2, len(docs))
+	assert.Equal(t, "_id", docs[0][0].Key)
+	assert.Equal(t, "Apple", docs[0].Map()["name"])
+	assert.Equal(t, int32(100), docs[0].Map()["price"])
+	assert.Equal(t, l.ID("apple"), docs[1].Map()["product"])
+	assert.Equal(t, l.ObjectID("owner"), docs[1].Map()["owner"])
+	assert.Equal(t, primitive.NewDateTimeFromTime(time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)), docs[1].Map()["at"])
+}
+
+func TestParseUnsupportedFormat(t *testing.T) {
+	_, err := New().parse(".xml", []byte(`<docs/>`))
+	assert.NotNil(t, err)
+}
+
+var archiveDB *mongo.Database
+
+// archivedOrder is stored in another database than the configured one.
+type archivedOrder struct {
+	mdu.DefaultModel `bson:",inline"`
+}
+
+func (o *archivedOrder) Collection() *mdu.Collection {
+	return mdu.NewCollection(archiveDB, "orders")
+}
+
+func TestCollection(t *testing.T) {
+	client, err := mongo.NewClient()
+	util.AssertErrIsNil(t, err)
+	archiveDB = client.Database("archive")
+
+	// Reset and insert use the model's own collection.
+	coll := New(&archivedOrder{}).collection(mdu.CollName(&archivedOrder{}))
+	assert.Equal(t, "archive", coll.Database().Name())
+	assert.Equal(t, "orders", coll.Name())
+}
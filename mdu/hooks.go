@@ -2,6 +2,12 @@ package mdu
 
 import (
 	"context"
+	"errors"
+	"strings"
+
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -20,7 +26,8 @@ type UpdatingHook interface {
 	Updating(context.Context) error
 }
 
-// UpdatedHook is called after a model is updated
+// UpdatedHook is called after a model is updated. The result is nil for bulk writes,
+// which do not report per-model results.
 type UpdatedHook interface {
 	Updated(ctx context.Context, result *mongo.UpdateResult) error
 }
@@ -40,12 +47,19 @@ type DeletingHook interface {
 	Deleting(context.Context) error
 }
 
-// DeletedHook is called after a model is deleted
+// DeletedHook is called after a model is deleted. The result is nil for bulk writes,
+// which do not report per-model results.
 type DeletedHook interface {
 	Deleted(ctx context.Context, result *mongo.DeleteResult) error
 }
 
-func beforeCreateHooks(ctx context.Context, model Model) error {
+// LoadedHook is called after a model is decoded from the database
+type LoadedHook interface {
+	Loaded(context.Context) error
+}
+
+// BeforeCreateHooks calls the model's creating and saving hooks.
+func BeforeCreateHooks(ctx context.Context, model Model) error {
 	if hook, ok := model.(CreatingHook); ok {
 		if err := hook.Creating(ctx); err != nil {
 			return err
@@ -61,7 +75,8 @@ func beforeCreateHooks(ctx context.Context, model Model) error {
 	return nil
 }
 
-func beforeUpdateHooks(ctx context.Context, model Model) error {
+// BeforeUpdateHooks calls the model's updating and saving hooks.
+func BeforeUpdateHooks(ctx context.Context, model Model) error {
 	if hook, ok := model.(UpdatingHook); ok {
 		if err := hook.Updating(ctx); err != nil {
 			return err
@@ -77,7 +92,98 @@ func beforeUpdateHooks(ctx context.Context, model Model) error {
 	return nil
 }
 
-func afterCreateHooks(ctx context.Context, model Model) error {
+// HookedUpdate calls the model's updating and saving hooks, and returns the update document (or
+// pipeline) with a `$set` of the fields changed by the hooks, e.g. `updated_at`, so that they are
+// persisted by operations that send an update rather than the model (`FindOneAndUpdate`). The
+// fields already modified by the update are left to it.
+func HookedUpdate(ctx context.Context, model Model, update interface{}) (interface{}, error) {
+	before, err := toDoc(model)
+	if err != nil {
+		return nil, err
+	}
+	if err = BeforeUpdateHooks(ctx, model); err != nil {
+		return nil, err
+	}
+	after, err := toDoc(model)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &differ{}
+	d.docs("", before, after)
+	if len(d.set) == 0 {
+		return update, nil
+	}
+
+	var wrapper struct {
+		U bson.RawValue `bson:"u"`
+	}
+	raw, err := bson.Marshal(bson.M{"u": update})
+	if err != nil {
+		return nil, err
+	}
+	if err = bson.Unmarshal(raw, &wrapper); err != nil {
+		return nil, err
+	}
+
+	switch wrapper.U.Type {
+	case bsontype.Array:
+		// The hooks' fields are set first, so that the stages of the pipeline can override them.
+		var stages bson.A
+		if err = wrapper.U.Unmarshal(&stages); err != nil {
+			return nil, err
+		}
+		return append(bson.A{bson.D{{Key: o.Set, Value: d.set}}}, stages...), nil
+	case bsontype.EmbeddedDocument:
+		var ops bson.D
+		if err = wrapper.U.Unmarshal(&ops); err != nil {
+			return nil, err
+		}
+		return mergeSet(ops, d.set), nil
+	}
+	return nil, errors.New("update must be a document or a pipeline")
+}
+
+// mergeSet adds the fields to the `$set` operator of the update document, except the fields
+// whose path conflicts with a path modified by the update.
+func mergeSet(update bson.D, set bson.D) bson.D {
+	var modified []string
+	for _, op := range update {
+		if fields, ok := op.Value.(bson.D); ok {
+			for _, e := range fields {
+				modified = append(modified, e.Key)
+			}
+		}
+	}
+	conflicts := func(path string) bool {
+		for _, m := range modified {
+			if m == path || strings.HasPrefix(m, path+".") || strings.HasPrefix(path, m+".") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var added bson.D
+	for _, e := range set {
+		if !conflicts(e.Key) {
+			added = append(added, e)
+		}
+	}
+	if len(added) == 0 {
+		return update
+	}
+	for i, op := range update {
+		if fields, ok := op.Value.(bson.D); ok && op.Key == o.Set {
+			update[i].Value = append(fields, added...)
+			return update
+		}
+	}
+	return append(update, bson.E{Key: o.Set, Value: added})
+}
+
+// AfterCreateHooks calls the model's created and saved hooks.
+func AfterCreateHooks(ctx context.Context, model Model) error {
 	if hook, ok := model.(CreatedHook); ok {
 		if err := hook.Created(ctx); err != nil {
 			return err
@@ -93,7 +199,8 @@ func afterCreateHooks(ctx context.Context, model Model) error {
 	return nil
 }
 
-func afterUpdateHooks(ctx context.Context, updateResult *mongo.UpdateResult, model Model) error {
+// AfterUpdateHooks calls the model's updated and saved hooks.
+func AfterUpdateHooks(ctx context.Context, updateResult *mongo.UpdateResult, model Model) error {
 	if hook, ok := model.(UpdatedHook); ok {
 		if err := hook.Updated(ctx, updateResult); err != nil {
 			return err
@@ -109,7 +216,8 @@ func afterUpdateHooks(ctx context.Context, updateResult *mongo.UpdateResult, mod
 	return nil
 }
 
-func beforeDeleteHooks(ctx context.Context, model Model) error {
+// BeforeDeleteHooks calls the model's deleting hook.
+func BeforeDeleteHooks(ctx context.Context, model Model) error {
 	if hook, ok := model.(DeletingHook); ok {
 		if err := hook.Deleting(ctx); err != nil {
 			return err
@@ -119,7 +227,8 @@ func beforeDeleteHooks(ctx context.Context, model Model) error {
 	return nil
 }
 
-func afterDeleteHooks(ctx context.Context, deleteResult *mongo.DeleteResult, model Model) error {
+// AfterDeleteHooks calls the model's deleted hook.
+func AfterDeleteHooks(ctx context.Context, deleteResult *mongo.DeleteResult, model Model) error {
 	if hook, ok := model.(DeletedHook); ok {
 		if err := hook.Deleted(ctx, deleteResult); err != nil {
 			return err
@@ -128,3 +237,14 @@ func afterDeleteHooks(ctx context.Context, deleteResult *mongo.DeleteResult, mod
 
 	return nil
 }
+
+// AfterLoadHooks calls the model's loaded hook.
+func AfterLoadHooks(ctx context.Context, model interface{}) error {
+	if hook, ok := model.(LoadedHook); ok {
+		if err := hook.Loaded(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
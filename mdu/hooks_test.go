@@ -0,0 +1,47 @@
+package mdu
+
+import (
+	"context"
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestHookedUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	upd, err := HookedUpdate(ctx, &PurchaseOrder{}, bson.M{"$inc": bson.M{"seq": 1}})
+	util.AssertErrIsNil(t, err)
+	doc := upd.(bson.D)
+	assert.Equal(t, "$inc", doc[0].Key)
+	assert.Equal(t, "$set", doc[1].Key)
+	set := doc[1].Value.(bson.D)
+	assert.Equal(t, 1, len(set))
+	assert.Equal(t, "updated_at", set[0].Key)
+
+	// The update's own value of updated_at is kept.
+	upd, err = HookedUpdate(ctx, &PurchaseOrder{}, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "a"}, {Key: "updated_at", Value: 1}}}})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: "a"}, {Key: "updated_at", Value: int32(1)}}}}, upd)
+
+	// Pipelines get a first $set stage.
+	upd, err = HookedUpdate(ctx, &PurchaseOrder{}, mongo.Pipeline{{{Key: "$set", Value: bson.M{"n": 1}}}})
+	util.AssertErrIsNil(t, err)
+	stages := upd.(bson.A)
+	assert.Equal(t, 2, len(stages))
+	assert.Equal(t, "updated_at", stages[0].(bson.D)[0].Value.(bson.D)[0].Key)
+
+	// Models without hooks are unchanged.
+	plain := bson.M{"$set": bson.M{"n": 1}}
+	upd, err = HookedUpdate(ctx, &plainModel{}, plain)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, plain, upd)
+}
+
+type plainModel struct {
+	IDField `bson:",inline"`
+	N       int `bson:"n"`
+}
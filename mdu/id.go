@@ -0,0 +1,193 @@
+package mdu
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IDGenerator interface generates the ids of new models. The generated id is passed to the
+// model's `PrepareID` method, so a generator can be used by any model whose id type
+// accepts the generated value (e.g. an ObjectID can be used by an `IDField` as its hex string).
+type IDGenerator interface {
+	NewID() (interface{}, error)
+}
+
+// IDGeneratorFunc type is an adapter to use an ordinary function as an IDGenerator.
+type IDGeneratorFunc func() (interface{}, error)
+
+// NewID calls fn().
+func (fn IDGeneratorFunc) NewID() (interface{}, error) {
+	return fn()
+}
+
+// IDGeneratorGetter interface contains a method to return the id generator of a model.
+// It takes precedence over the generator set in the configuration.
+type IDGeneratorGetter interface {
+	IDGenerator() IDGenerator
+}
+
+var (
+	// UUIDv4Generator generates random (version 4) UUID strings. This is the default generator.
+	UUIDv4Generator IDGenerator = IDGeneratorFunc(func() (interface{}, error) {
+		return uuid.NewString(), nil
+	})
+
+	// UUIDv7Generator generates time-ordered (version 7) UUID strings.
+	UUIDv7Generator IDGenerator = IDGeneratorFunc(func() (interface{}, error) {
+		return newUUIDv7(time.Now())
+	})
+
+	// ULIDGenerator generates time-ordered ULID strings.
+	ULIDGenerator IDGenerator = IDGeneratorFunc(func() (interface{}, error) {
+		return newULID(time.Now())
+	})
+
+	// ObjectIDGenerator generates `primitive.ObjectID` values.
+	ObjectIDGenerator IDGenerator = IDGeneratorFunc(func() (interface{}, error) {
+		return primitive.NewObjectID(), nil
+	})
+
+	// ObjectIDHexGenerator generates ObjectIDs as hex strings.
+	ObjectIDHexGenerator IDGenerator = IDGeneratorFunc(func() (interface{}, error) {
+		return primitive.NewObjectID().Hex(), nil
+	})
+)
+
+// PrepareNewID sets a new id on the model, unless the model already has one. The id is
+// generated by the model's generator (see `IDGeneratorGetter`), the generator set in the
+// configuration, or `UUIDv4Generator`, then converted by the model's `PrepareID` method.
+func PrepareNewID(model Model) error {
+	id := model.GetID()
+	if isZeroID(id) {
+		var err error
+		if id, err = idGenerator(model).NewID(); err != nil {
+			return err
+		}
+	}
+
+	id, err := model.PrepareID(id)
+	if err != nil {
+		return err
+	}
+	model.SetID(id)
+	return nil
+}
+
+func idGenerator(model Model) IDGenerator {
+	if getter, ok := model.(IDGeneratorGetter); ok {
+		if gen := getter.IDGenerator(); gen != nil {
+			return gen
+		}
+	}
+	if config != nil && config.IDGenerator != nil {
+		return config.IDGenerator
+	}
+	return UUIDv4Generator
+}
+
+func isZeroID(id interface{}) bool {
+	return id == nil || reflect.ValueOf(id).IsZero()
+}
+
+// newUUIDv7 returns a version 7 UUID: a 48 bit unix timestamp in milliseconds
+// followed by 74 random bits.
+func newUUIDv7(t time.Time) (string, error) {
+	var u uuid.UUID
+	if _, err := rand.Read(u[6:]); err != nil {
+		return "", err
+	}
+
+	ms := uint64(t.UnixMilli())
+	u[0], u[1], u[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	u[3], u[4], u[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+
+	return u.String(), nil
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID: a 48 bit unix timestamp in milliseconds followed by
+// 80 random bits, encoded as 26 Crockford base32 characters.
+func newULID(t time.Time) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	ms := uint64(t.UnixMilli())
+	binary.BigEndian.PutUint16(b[0:], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:], uint32(ms))
+
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	snowflakeMaxNode      = 1<<snowflakeNodeBits - 1
+	snowflakeMaxSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// SnowflakeEpoch is the default epoch of the Snowflake generator (2020-01-01 UTC).
+var SnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator generates Snowflake-style int64 ids: 41 bits of milliseconds since
+// the epoch, 10 bits of node id and a 12 bit sequence. Use a distinct node id for each
+// process generating ids concurrently.
+type SnowflakeGenerator struct {
+	mu       sync.Mutex
+	epoch    time.Time
+	node     int64
+	lastMs   int64
+	sequence int64
+}
+
+// NewSnowflakeGenerator returns a new Snowflake generator for the node, using `SnowflakeEpoch`.
+func NewSnowflakeGenerator(node int64) (*SnowflakeGenerator, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("snowflake node must be between 0 and %d", snowflakeMaxNode)
+	}
+	return &SnowflakeGenerator{epoch: SnowflakeEpoch, node: node}, nil
+}
+
+// NewID returns the next id. It waits for the next millisecond when the sequence
+// of the current one is exhausted.
+func (g *SnowflakeGenerator) NewID() (interface{}, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := time.Since(g.epoch).Milliseconds()
+	if ms < g.lastMs {
+		return nil, errors.New("snowflake: clock moved backwards")
+	}
+	if ms == g.lastMs {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSequence
+		if g.sequence == 0 {
+			for ms <= g.lastMs {
+				time.Sleep(100 * time.Microsecond)
+				ms = time.Since(g.epoch).Milliseconds()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMs = ms
+
+	return ms<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence, nil
+}
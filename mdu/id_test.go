@@ -0,0 +1,139 @@
+package mdu
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type objectIDModel struct {
+	ObjectIDField `bson:",inline"`
+}
+
+type customIDModel struct {
+	IDField `bson:",inline"`
+}
+
+func (m *customIDModel) IDGenerator() IDGenerator {
+	return IDGeneratorFunc(func() (interface{}, error) {
+		return "custom", nil
+	})
+}
+
+func TestIDGenerators(t *testing.T) {
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	uuidV7 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulid := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	hex := regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+	for _, tc := range []struct {
+		gen     IDGenerator
+		pattern *regexp.Regexp
+	}{
+		{UUIDv4Generator, uuidV4},
+		{UUIDv7Generator, uuidV7},
+		{ULIDGenerator, ulid},
+		{ObjectIDHexGenerator, hex},
+	} {
+		id, err := tc.gen.NewID()
+		util.AssertErrIsNil(t, err)
+		assert.Regexp(t, tc.pattern, id)
+	}
+
+	id, err := ObjectIDGenerator.NewID()
+	util.AssertErrIsNil(t, err)
+	assert.IsType(t, primitive.ObjectID{}, id)
+}
+
+func TestTimeOrderedIDs(t *testing.T) {
+	t1 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Millisecond)
+
+	a, err := newUUIDv7(t1)
+	util.AssertErrIsNil(t, err)
+	b, err := newUUIDv7(t2)
+	util.AssertErrIsNil(t, err)
+	assert.Less(t, a, b)
+	assert.Equal(t, "01856aa0-c800", a[:13])
+
+	a, err = newULID(t1)
+	util.AssertErrIsNil(t, err)
+	b, err = newULID(t2)
+	util.AssertErrIsNil(t, err)
+	assert.Less(t, a, b)
+	assert.Equal(t, "01GNNA1J00", a[:10])
+}
+
+func TestSnowflakeGenerator(t *testing.T) {
+	_, err := NewSnowflakeGenerator(1024)
+	assert.NotNil(t, err)
+
+	gen, err := NewSnowflakeGenerator(3)
+	util.AssertErrIsNil(t, err)
+
+	var last int64
+	for i := 0; i < 5000; i++ {
+		id, err := gen.NewID()
+		util.AssertErrIsNil(t, err)
+		assert.Greater(t, id.(int64), last)
+		assert.Equal(t, int64(3), id.(int64)>>snowflakeSequenceBits&snowflakeMaxNode)
+		last = id.(int64)
+	}
+}
+
+func TestPrepareNewID(t *testing.T) {
+	m := &PurchaseOrder{}
+	util.AssertErrIsNil(t, PrepareNewID(m))
+	assert.Len(t, m.ID, 36)
+
+	m = &PurchaseOrder{}
+	m.ID = "given"
+	util.AssertErrIsNil(t, PrepareNewID(m))
+	assert.Equal(t, "given", m.ID)
+
+	oid := &objectIDModel{}
+	util.AssertErrIsNil(t, PrepareNewID(oid))
+	assert.False(t, oid.ID.IsZero())
+
+	custom := &customIDModel{}
+	util.AssertErrIsNil(t, PrepareNewID(custom))
+	assert.Equal(t, "custom", custom.ID)
+
+	defer func(gen IDGenerator) { config.IDGenerator = gen }(config.IDGenerator)
+	config.IDGenerator = ObjectIDGenerator
+	m = &PurchaseOrder{}
+	util.AssertErrIsNil(t, PrepareNewID(m))
+	assert.Len(t, m.ID, 24)
+}
+
+func TestPrepareID(t *testing.T) {
+	hex := "63f8a0a1b2c3d4e5f6a7b8c9"
+	oid, err := primitive.ObjectIDFromHex(hex)
+	util.AssertErrIsNil(t, err)
+
+	idField := &IDField{}
+	id, err := idField.PrepareID(oid)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, hex, id)
+	id, err = idField.PrepareID(int64(42))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, "42", id)
+	_, err = idField.PrepareID(1.5)
+	assert.NotNil(t, err)
+
+	oidField := &ObjectIDField{}
+	id, err = oidField.PrepareID(hex)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, oid, id)
+	_, err = oidField.PrepareID("invalid id")
+	assert.NotNil(t, err)
+
+	oidField.SetID(hex)
+	assert.Equal(t, oid, oidField.GetID())
+	oidField.SetID(42)
+	assert.Equal(t, oid, oidField.GetID())
+}
@@ -0,0 +1,237 @@
+package mdu
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrStopIteration can be returned by the function passed to `Each` or `EachBatch`
+// to stop the iteration without error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// closeCursorTimeout is the timeout of closing a cursor whose context is done.
+const closeCursorTimeout = 5 * time.Second
+
+// Finder is implemented by `Collection` (through the driver's collection)
+// and by the in-memory `mdutest.Collection`.
+type Finder interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+}
+
+// ModelPtr is a constraint for pointers to model types, used by the typed iteration functions.
+type ModelPtr[T any] interface {
+	*T
+	Model
+}
+
+// Each decodes the documents matching the filter into the model one at a time, and calls fn after
+// each of them. The model is reset before each document is decoded, and its loaded hook is called.
+// The iteration stops at the first error returned by fn, which is returned unless it is `ErrStopIteration`.
+func (c *Collection) Each(filter interface{}, model Model, fn func() error, opts ...*options.FindOptions) error {
+	return EachModel(ctx(), c, filter, model, fn, opts...)
+}
+
+func (c *Collection) EachWithCtx(ctx context.Context, filter interface{}, model Model, fn func() error, opts ...*options.FindOptions) error {
+	return EachModel(ctx, c, filter, model, fn, opts...)
+}
+
+// EachModel implements `Collection.Each` for any collection having a driver-like `Find` method.
+func EachModel(ctx context.Context, c Finder, filter interface{}, model Model, fn func() error, opts ...*options.FindOptions) (err error) {
+	cur, err := c.Find(ctx, filter, opts...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := closeCursor(ctx, cur); err == nil {
+			err = closeErr
+		}
+	}()
+
+	val := reflect.ValueOf(model).Elem()
+	zero := reflect.Zero(val.Type())
+	for cur.Next(ctx) {
+		val.Set(zero)
+		if err = cur.Decode(model); err != nil {
+			return err
+		}
+		if err = loaded(ctx, model); err != nil {
+			return err
+		}
+		if err = fn(); err != nil {
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// Iterator is a typed iterator over a cursor. Call `Close` when done,
+// unless `Next` returned false.
+type Iterator[T any, PT ModelPtr[T]] struct {
+	ctx context.Context
+	cur *mongo.Cursor
+	val PT
+	err error
+}
+
+// Iter returns a typed iterator over the documents matching the filter
+// (e.g. `mdu.Iter[product](ctx, coll, filter)`).
+func Iter[T any, PT ModelPtr[T]](ctx context.Context, c Finder, filter interface{}, opts ...*options.FindOptions) (*Iterator[T, PT], error) {
+	cur, err := c.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewIterator[T, PT](ctx, cur), nil
+}
+
+// NewIterator returns a typed iterator over a cursor, e.g. one returned by `SimpleAggregateCursor`.
+func NewIterator[T any, PT ModelPtr[T]](ctx context.Context, cur *mongo.Cursor) *Iterator[T, PT] {
+	return &Iterator[T, PT]{ctx: ctx, cur: cur}
+}
+
+// Next decodes the next document into a new model, and calls its loaded hook. It returns false
+// when there are no more documents or on error, and then closes the cursor.
+func (it *Iterator[T, PT]) Next() bool {
+	if it.err != nil || it.cur == nil {
+		return false
+	}
+
+	if !it.cur.Next(it.ctx) {
+		it.err = it.cur.Err()
+		it.close()
+		return false
+	}
+
+	val := PT(new(T))
+	if it.err = it.cur.Decode(val); it.err == nil {
+		it.err = loaded(it.ctx, val)
+	}
+	if it.err != nil {
+		it.close()
+		return false
+	}
+	it.val = val
+	return true
+}
+
+// Value returns the model decoded by the last call to `Next`.
+func (it *Iterator[T, PT]) Value() PT {
+	return it.val
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator[T, PT]) Err() error {
+	return it.err
+}
+
+// Close closes the cursor. It can be called more than once.
+func (it *Iterator[T, PT]) Close() error {
+	if it.cur == nil {
+		return nil
+	}
+	err := closeCursor(it.ctx, it.cur)
+	it.cur = nil
+	return err
+}
+
+func (it *Iterator[T, PT]) close() {
+	if err := it.Close(); it.err == nil {
+		it.err = err
+	}
+}
+
+// EachBatch calls fn with the models matching the filter, n at a time (the last batch may be smaller).
+// The cursor batch size is set to n unless set in the options. The iteration stops at the first error
+// returned by fn, which is returned unless it is `ErrStopIteration`.
+func EachBatch[T any, PT ModelPtr[T]](ctx context.Context, c Finder, filter interface{}, n int, fn func(batch []PT) error, opts ...*options.FindOptions) error {
+	if n <= 0 {
+		return errors.New("batch size must be positive")
+	}
+	opts = append([]*options.FindOptions{options.Find().SetBatchSize(int32(n))}, opts...)
+
+	it, err := Iter[T, PT](ctx, c, filter, opts...)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	batch := make([]PT, 0, n)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := fn(batch)
+		batch = make([]PT, 0, n)
+		return err
+	}
+
+	for it.Next() {
+		if batch = append(batch, it.Value()); len(batch) == n {
+			if err = flush(); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		if err = it.Err(); err == nil {
+			err = flush()
+		}
+	}
+	if errors.Is(err, ErrStopIteration) {
+		return nil
+	}
+	return err
+}
+
+// Stream sends the models matching the filter to the returned channel, which has the given buffer
+// size, so that at most buffer models are read ahead of the receiver. The channel is closed when
+// the iteration ends; the error channel then receives the error that ended it, if any (including
+// the context's error when the context is done). Cancel the context to stop the stream early.
+func Stream[T any, PT ModelPtr[T]](ctx context.Context, c Finder, filter interface{}, buffer int, opts ...*options.FindOptions) (<-chan PT, <-chan error) {
+	out := make(chan PT, buffer)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(out)
+
+		it, err := Iter[T, PT](ctx, c, filter, opts...)
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer it.Close()
+
+		for it.Next() {
+			select {
+			case out <- it.Value():
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+		if err = it.Err(); err != nil {
+			errc <- err
+		}
+	}()
+
+	return out, errc
+}
+
+// closeCursor closes the cursor, using a new context if the given one is done,
+// so that the cursor is killed on the server.
+func closeCursor(ctx context.Context, cur *mongo.Cursor) error {
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), closeCursorTimeout)
+		defer cancel()
+	}
+	return cur.Close(ctx)
+}
@@ -0,0 +1,70 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Checkpoint stores the offset of the inputs committed by a loader.
+type Checkpoint interface {
+	// Load returns the stored offset, or 0 if none was stored.
+	Load(ctx context.Context) (int64, error)
+	Save(ctx context.Context, offset int64) error
+}
+
+// FileCheckpoint stores the offset in the file at the given path. The file is replaced
+// atomically on each save.
+type FileCheckpoint string
+
+func (f FileCheckpoint) Load(ctx context.Context) (int64, error) {
+	data, err := os.ReadFile(string(f))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (f FileCheckpoint) Save(ctx context.Context, offset int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), filepath.Base(string(f))+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.WriteString(strconv.FormatInt(offset, 10) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+// MemoryCheckpoint stores the offset in memory.
+type MemoryCheckpoint struct {
+	offset int64
+	mu     sync.Mutex
+}
+
+func (m *MemoryCheckpoint) Load(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.offset, nil
+}
+
+func (m *MemoryCheckpoint) Save(ctx context.Context, offset int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.offset = offset
+	return nil
+}
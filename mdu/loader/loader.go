@@ -0,0 +1,364 @@
+// Package loader inserts high volumes of models through mdu collections, with concurrent
+// bulk writers, bounded memory, retries of transient failures, progress reporting and
+// resumable checkpoints.
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/softwok/mongo-util/mdu"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultBatchSize    = 1000
+	DefaultWorkers      = 4
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// Writer is implemented by `mdu.Collection` (through the driver's collection) and `mdutest.Collection`.
+type Writer interface {
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+// Options struct contains the options of a loader. Zero values are replaced by the defaults.
+type Options struct {
+	// BatchSize is the number of models per bulk write.
+	BatchSize int
+
+	// Workers is the number of concurrent bulk writers. At most 2*Workers+1 batches are held in memory.
+	Workers int
+
+	// MaxRetries is the maximum number of retries of the models failing with a transient error
+	// (see `IsTransient`). The backoff starts at RetryBackoff and doubles after each retry.
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// Checkpoint stores the offset of the inputs committed so far. When set, `Run` skips
+	// the inputs before the stored offset, and stores the new offset after each batch.
+	Checkpoint Checkpoint
+
+	// OnProgress is called after each batch is written. Calls are not concurrent.
+	OnProgress func(Progress)
+
+	// OnError is called for each model that failed with a permanent error (e.g. a hook or
+	// validation error), and the loading goes on. When nil, such an error stops the loading.
+	// Calls may be concurrent.
+	OnError func(offset int64, model mdu.Model, err error)
+}
+
+// Progress struct contains the progress of a loading.
+type Progress struct {
+	// Offset is the checkpoint: all the inputs before Offset have been written or reported as failed.
+	Offset int64
+
+	Written int64
+	Failed  int64
+	Retries int64
+	Elapsed time.Duration
+}
+
+// Rate returns the number of models written per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Written) / p.Elapsed.Seconds()
+}
+
+// Loader inserts the models of a stream with concurrent bulk writes, calling their create hooks.
+type Loader struct {
+	w    Writer
+	opts Options
+}
+
+// New returns a new loader writing to w.
+func New(w Writer, opts *Options) *Loader {
+	l := &Loader{w: w}
+	if opts != nil {
+		l.opts = *opts
+	}
+	if l.opts.BatchSize <= 0 {
+		l.opts.BatchSize = DefaultBatchSize
+	}
+	if l.opts.Workers <= 0 {
+		l.opts.Workers = DefaultWorkers
+	}
+	if l.opts.MaxRetries < 0 {
+		l.opts.MaxRetries = 0
+	} else if l.opts.MaxRetries == 0 {
+		l.opts.MaxRetries = DefaultMaxRetries
+	}
+	if l.opts.RetryBackoff <= 0 {
+		l.opts.RetryBackoff = DefaultRetryBackoff
+	}
+	return l
+}
+
+type batch struct {
+	start  int64
+	models []mdu.Model
+}
+
+type batchResult struct {
+	start   int64
+	size    int64
+	written int64
+	failed  int64
+	retries int64
+	err     error
+}
+
+// Run inserts the models received from the channel until it is closed, and returns the final progress.
+// The offset of a model is its position in the stream, starting at 0, so a resumed stream must send the
+// same models in the same order. Run returns on the first fatal error (an exhausted retry, a permanent
+// error without `Options.OnError`, or the context's error) after the batches in flight are done; the
+// caller should then stop sending models.
+//
+// Batches are written concurrently, so after a fatal error some models after the checkpoint may already
+// be written: give the models deterministic ids to detect them with duplicate key errors on resume.
+// Retried models keep their id and are not prepared again, so their before hooks are called once; a
+// duplicate key error on a retry counts as written.
+func (l *Loader) Run(ctx context.Context, models <-chan mdu.Model) (Progress, error) {
+	begin := time.Now()
+	progress := Progress{}
+
+	if l.opts.Checkpoint != nil {
+		offset, err := l.opts.Checkpoint.Load(ctx)
+		if err != nil {
+			return progress, err
+		}
+		progress.Offset = offset
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan batch, l.opts.Workers)
+	results := make(chan batchResult, l.opts.Workers)
+
+	go l.batch(ctx, models, progress.Offset, batches)
+
+	var wg sync.WaitGroup
+	for i := 0; i < l.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				results <- l.write(ctx, b)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var err error
+	done := map[int64]int64{}
+	for res := range results {
+		progress.Written += res.written
+		progress.Failed += res.failed
+		progress.Retries += res.retries
+		progress.Elapsed = time.Since(begin)
+
+		if res.err != nil {
+			if err == nil {
+				err = res.err
+				cancel()
+			}
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		// Batches complete out of order: the checkpoint only moves past contiguous batches.
+		done[res.start] = res.size
+		offset := progress.Offset
+		for size, ok := done[offset]; ok; size, ok = done[offset] {
+			delete(done, offset)
+			offset += size
+		}
+		if offset != progress.Offset {
+			progress.Offset = offset
+			if l.opts.Checkpoint != nil {
+				if saveErr := l.opts.Checkpoint.Save(ctx, offset); saveErr != nil {
+					err = saveErr
+					cancel()
+					continue
+				}
+			}
+		}
+
+		if l.opts.OnProgress != nil {
+			l.opts.OnProgress(progress)
+		}
+	}
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	return progress, err
+}
+
+// batch sends the models from the offset on in batches, until the channel is closed or the context is done.
+func (l *Loader) batch(ctx context.Context, models <-chan mdu.Model, offset int64, batches chan<- batch) {
+	defer close(batches)
+
+	var n int64
+	b := batch{start: offset}
+	send := func() bool {
+		select {
+		case batches <- b:
+			b = batch{start: n}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case m, ok := <-models:
+			if !ok {
+				if len(b.models) > 0 {
+					send()
+				}
+				return
+			}
+			n++
+			if n <= offset {
+				continue
+			}
+			b.models = append(b.models, m)
+			if len(b.models) == l.opts.BatchSize && !send() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// write inserts a batch, retrying the models failing with a transient error. The models are
+// prepared once (their id and before hooks), and the retries write the same documents.
+func (l *Loader) write(ctx context.Context, b batch) batchResult {
+	res := batchResult{start: b.start, size: int64(len(b.models))}
+	inserts := make([]mongo.WriteModel, len(b.models))
+	var pending []int
+	for i, m := range b.models {
+		if err := prepare(ctx, m); err != nil {
+			if !l.fail(&res, b.start+int64(i), m, err) {
+				return res
+			}
+			continue
+		}
+		inserts[i] = mongo.NewInsertOneModel().SetDocument(m)
+		pending = append(pending, i)
+	}
+
+	backoff := l.opts.RetryBackoff
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if err := ctx.Err(); err != nil {
+			res.err = err
+			return res
+		}
+
+		writes := make([]mongo.WriteModel, len(pending))
+		for k, i := range pending {
+			writes[k] = inserts[i]
+		}
+		bulkRes, _ := mdu.NewBulk(l.w).Write(writes...).
+			RunWithCtx(ctx, mdu.BulkOpts().SetOrdered(false).SetChunkSize(len(writes)))
+
+		var retry []int
+		for k, i := range pending {
+			itemErr := bulkRes.Errors[k]
+			switch {
+			case itemErr == nil, attempt > 0 && mongo.IsDuplicateKeyError(itemErr):
+				if err := created(ctx, b.models[i]); err != nil {
+					if !l.fail(&res, b.start+int64(i), b.models[i], err) {
+						return res
+					}
+					continue
+				}
+				res.written++
+			case ctx.Err() != nil:
+				res.err = ctx.Err()
+				return res
+			case IsTransient(itemErr):
+				if attempt == l.opts.MaxRetries {
+					res.err = fmt.Errorf("model at offset %d: %w", b.start+int64(i), itemErr)
+					return res
+				}
+				retry = append(retry, i)
+			default:
+				if !l.fail(&res, b.start+int64(i), b.models[i], itemErr) {
+					return res
+				}
+			}
+		}
+		if len(retry) == 0 {
+			return res
+		}
+
+		res.retries++
+		pending = retry
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			res.err = ctx.Err()
+			return res
+		}
+	}
+	return res
+}
+
+// fail reports a model failing with a permanent error to `Options.OnError`. Without OnError, the
+// error becomes the error of the batch, and fail returns false.
+func (l *Loader) fail(res *batchResult, offset int64, model mdu.Model, err error) bool {
+	if l.opts.OnError == nil {
+		res.err = fmt.Errorf("model at offset %d: %w", offset, err)
+		return false
+	}
+	res.failed++
+	l.opts.OnError(offset, model, err)
+	return true
+}
+
+// prepare sets the id of a new model and calls its before create hooks.
+func prepare(ctx context.Context, m mdu.Model) error {
+	if err := mdu.PrepareNewID(m); err != nil {
+		return err
+	}
+	return mdu.BeforeCreateHooks(ctx, m)
+}
+
+// created takes the snapshot of a written model and calls its after create hooks.
+func created(ctx context.Context, m mdu.Model) error {
+	if err := mdu.TakeSnapshot(m); err != nil {
+		return err
+	}
+	return mdu.AfterCreateHooks(ctx, m)
+}
+
+// IsTransient reports whether a write error is worth retrying: network errors, timeouts,
+// and server errors labeled as retryable.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var se mongo.ServerError
+	return errors.As(err, &se) && (se.HasErrorLabel("RetryableWriteError") || se.HasErrorLabel("TransientTransactionError"))
+}
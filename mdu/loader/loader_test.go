@@ -0,0 +1,210 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/softwok/mongo-util/mdu"
+	"github.com/softwok/mongo-util/mdu/mdutest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type record struct {
+	mdu.DefaultModel `bson:",inline"`
+	N                int `bson:"n"`
+
+	creating int
+}
+
+func (r *record) Creating(ctx context.Context) error {
+	r.creating++
+	if r.N < 0 {
+		return errors.New("n must be positive")
+	}
+	return nil
+}
+
+// writerFunc adapts a function to the Writer interface.
+type writerFunc func(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+
+func (f writerFunc) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	return f(ctx, models, opts...)
+}
+
+// doc returns the document of an insert write model.
+func doc(w mongo.WriteModel) *record {
+	return w.(*mongo.InsertOneModel).Document.(*record)
+}
+
+func records(n int, from int) <-chan mdu.Model {
+	models := make(chan mdu.Model)
+	go func() {
+		defer close(models)
+		for i := from; i < n; i++ {
+			models <- &record{N: i}
+		}
+	}()
+	return models
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	coll := mdutest.Coll(&record{})
+	checkpoint := &MemoryCheckpoint{}
+
+	calls := 0
+	progress, err := New(coll, &Options{
+		BatchSize:  100,
+		Workers:    4,
+		Checkpoint: checkpoint,
+		OnProgress: func(p Progress) {
+			calls++
+		},
+	}).Run(context.Background(), records(1050, 0))
+	util.AssertErrIsNil(t, err)
+
+	assert.Equal(t, int64(1050), progress.Written)
+	assert.Equal(t, int64(1050), progress.Offset)
+	assert.Equal(t, 11, calls)
+	assert.Equal(t, 1050, coll.Len())
+
+	offset, err := checkpoint.Load(context.Background())
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(1050), offset)
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	t.Parallel()
+	coll := mdutest.Coll(&record{})
+
+	var mu sync.Mutex
+	calls := 0
+	w := writerFunc(func(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+		res, err := coll.BulkWrite(ctx, models, opts...)
+		mu.Lock()
+		defer mu.Unlock()
+		if calls++; calls%2 == 1 {
+			// The models were written, but the acknowledgement was lost.
+			return nil, mongo.CommandError{Labels: []string{"NetworkError"}}
+		}
+		return res, err
+	})
+
+	models := make(chan mdu.Model, 95)
+	var sent []*record
+	for i := 0; i < 95; i++ {
+		r := &record{N: i}
+		sent = append(sent, r)
+		models <- r
+	}
+	close(models)
+
+	progress, err := New(w, &Options{BatchSize: 10, Workers: 1, RetryBackoff: time.Millisecond}).
+		Run(context.Background(), models)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(95), progress.Written)
+	assert.Equal(t, int64(10), progress.Retries)
+	assert.Equal(t, 95, coll.Len())
+
+	// The retries write the prepared documents again, without calling the before hooks.
+	for _, r := range sent {
+		assert.Equal(t, 1, r.creating)
+		assert.NotNil(t, r.Snapshot())
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	t.Parallel()
+
+	w := writerFunc(func(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+		return nil, mongo.CommandError{Labels: []string{"RetryableWriteError"}}
+	})
+
+	progress, err := New(w, &Options{BatchSize: 10, Workers: 1, MaxRetries: 2, RetryBackoff: time.Millisecond}).
+		Run(context.Background(), records(10, 0))
+	assert.Contains(t, err.Error(), "model at offset 0")
+	assert.Equal(t, int64(0), progress.Offset)
+	assert.Equal(t, int64(2), progress.Retries)
+}
+
+func TestPermanentErrors(t *testing.T) {
+	t.Parallel()
+	coll := mdutest.Coll(&record{})
+
+	models := make(chan mdu.Model, 5)
+	for _, n := range []int{1, -2, 3, -4, 5} {
+		models <- &record{N: n}
+	}
+	close(models)
+
+	var failed []int64
+	progress, err := New(coll, &Options{
+		BatchSize: 2,
+		Workers:   1,
+		OnError: func(offset int64, model mdu.Model, err error) {
+			failed = append(failed, offset)
+			assert.EqualError(t, err, "n must be positive")
+		},
+	}).Run(context.Background(), models)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, []int64{1, 3}, failed)
+	assert.Equal(t, int64(3), progress.Written)
+	assert.Equal(t, int64(2), progress.Failed)
+	assert.Equal(t, int64(5), progress.Offset)
+
+	_, err = New(coll, nil).Run(context.Background(), records(0, -1))
+	assert.EqualError(t, err, "model at offset 0: n must be positive")
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+	coll := mdutest.Coll(&record{})
+	checkpoint := FileCheckpoint(filepath.Join(t.TempDir(), "checkpoint"))
+
+	w := writerFunc(func(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+		if doc(models[0]).N >= 200 {
+			return nil, errors.New("disk full")
+		}
+		return coll.BulkWrite(ctx, models, opts...)
+	})
+
+	progress, err := New(w, &Options{BatchSize: 100, Workers: 1, Checkpoint: checkpoint}).
+		Run(context.Background(), records(500, 0))
+	assert.EqualError(t, err, "model at offset 200: disk full")
+	assert.Equal(t, int64(200), progress.Offset)
+	assert.Equal(t, 200, coll.Len())
+
+	offset, err := checkpoint.Load(context.Background())
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(200), offset)
+
+	// The stream is replayed from the start, and the committed models are skipped.
+	progress, err = New(coll, &Options{BatchSize: 100, Checkpoint: checkpoint}).
+		Run(context.Background(), records(500, 0))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(300), progress.Written)
+	assert.Equal(t, int64(500), progress.Offset)
+	assert.Equal(t, 500, coll.Len())
+}
+
+func TestCancel(t *testing.T) {
+	t.Parallel()
+	coll := mdutest.Coll(&record{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	models := make(chan mdu.Model)
+	go func() {
+		models <- &record{N: 1}
+		cancel()
+	}()
+
+	_, err := New(coll, &Options{BatchSize: 10}).Run(ctx, models)
+	assert.Equal(t, context.Canceled, err)
+}
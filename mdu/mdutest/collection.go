@@ -0,0 +1,1302 @@
+// Package mdutest provides an in-memory implementation of `mdu.Repository`, so that
+// code using mdu collections can be unit-tested without a running MongoDB server.
+package mdutest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/softwok/mongo-util/aggregate"
+	"github.com/softwok/mongo-util/field"
+	"github.com/softwok/mongo-util/match"
+	"github.com/softwok/mongo-util/mdu"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKeyCode is the MongoDB server error code of duplicate key errors.
+const duplicateKeyCode = 11000
+
+// Collection is an in-memory collection that implements the model operations of `mdu.Collection`,
+// including the model hooks, the common query operators, sort, limit and skip, and runs aggregations
+// with the `aggregate` package. Projections are not supported and are ignored. A Collection is safe
+// for concurrent use.
+type Collection struct {
+	name string
+	docs []bson.D
+	mu   sync.RWMutex
+}
+
+// NewCollection returns a new empty in-memory collection.
+func NewCollection(name string) *Collection {
+	return &Collection{name: name}
+}
+
+// Coll returns a new empty in-memory collection named after the model's collection.
+func Coll(m mdu.Model) *Collection {
+	return NewCollection(mdu.CollName(m))
+}
+
+// Name returns the name of the collection.
+func (c *Collection) Name() string {
+	return c.name
+}
+
+// Len returns the number of documents in the collection.
+func (c *Collection) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.docs)
+}
+
+// Reset deletes all the documents in the collection.
+func (c *Collection) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.docs = nil
+}
+
+// FindByID method finds a doc and decodes it to a model, otherwise returns `mongo.ErrNoDocuments`.
+func (c *Collection) FindByID(id interface{}, model mdu.Model, opts ...*options.FindOneOptions) error {
+	return c.FindByIDWithCtx(context.Background(), id, model, opts...)
+}
+
+func (c *Collection) FindByIDWithCtx(ctx context.Context, id interface{}, model mdu.Model, opts ...*options.FindOneOptions) error {
+	id, err := model.PrepareID(id)
+	if err != nil {
+		return err
+	}
+	return c.FirstWithCtx(ctx, bson.M{field.ID: id}, model, opts...)
+}
+
+// First method searches and returns the first document in the search results.
+func (c *Collection) First(filter interface{}, model mdu.Model, opts ...*options.FindOneOptions) error {
+	return c.FirstWithCtx(context.Background(), filter, model, opts...)
+}
+
+func (c *Collection) FirstWithCtx(ctx context.Context, filter interface{}, model mdu.Model, opts ...*options.FindOneOptions) error {
+	opt := options.MergeFindOneOptions(opts...)
+	docs, err := c.find(filter, opt.Sort, opt.Skip, nil)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	if err = decode(docs[0], model); err != nil {
+		return err
+	}
+	return loaded(ctx, model)
+}
+
+// FindAll finds, decodes and returns the results.
+func (c *Collection) FindAll(results interface{}, filter interface{}, opts ...*options.FindOptions) error {
+	return c.FindAllWithCtx(context.Background(), results, filter, opts...)
+}
+
+func (c *Collection) FindAllWithCtx(ctx context.Context, results interface{}, filter interface{}, opts ...*options.FindOptions) error {
+	resultsVal := reflect.ValueOf(results)
+	if resultsVal.Kind() != reflect.Ptr || resultsVal.Elem().Kind() != reflect.Slice {
+		return errors.New("results argument must be a pointer to a slice")
+	}
+
+	opt := options.MergeFindOptions(opts...)
+	docs, err := c.find(filter, opt.Sort, opt.Skip, opt.Limit)
+	if err != nil {
+		return err
+	}
+
+	sliceVal := resultsVal.Elem()
+	sliceVal = sliceVal.Slice(0, 0)
+	for _, doc := range docs {
+		elem := reflect.New(sliceVal.Type().Elem())
+		if err = decode(doc, elem.Interface()); err != nil {
+			return err
+		}
+		if err = loaded(ctx, elem.Interface()); err != nil {
+			return err
+		}
+		sliceVal = reflect.Append(sliceVal, elem.Elem())
+	}
+	resultsVal.Elem().Set(sliceVal)
+
+	return nil
+}
+
+// Find returns a cursor over the documents matching the filter.
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	opt := options.MergeFindOptions(opts...)
+	docs, err := c.find(filter, opt.Sort, opt.Skip, opt.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		values[i] = copyDoc(doc)
+	}
+	return mongo.NewCursorFromDocuments(values, nil, nil)
+}
+
+// Aggregate runs the pipeline against the documents of the collection using the `aggregate`
+// package, and returns a cursor over the resulting documents. Lookup stages can only join
+// the collection with itself.
+func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	e := aggregate.New()
+	c.mu.RLock()
+	docs := make([]interface{}, len(c.docs))
+	for i, doc := range c.docs {
+		docs[i] = doc
+	}
+	err := e.Insert(c.name, docs...)
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := e.Run(c.name, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(results))
+	for i, doc := range results {
+		values[i] = doc
+	}
+	return mongo.NewCursorFromDocuments(values, nil, nil)
+}
+
+// Each decodes the documents matching the filter into the model one at a time, and calls fn after each of them.
+func (c *Collection) Each(filter interface{}, model mdu.Model, fn func() error, opts ...*options.FindOptions) error {
+	return mdu.EachModel(context.Background(), c, filter, model, fn, opts...)
+}
+
+func (c *Collection) EachWithCtx(ctx context.Context, filter interface{}, model mdu.Model, fn func() error, opts ...*options.FindOptions) error {
+	return mdu.EachModel(ctx, c, filter, model, fn, opts...)
+}
+
+// Count returns the number of documents matching the filter.
+func (c *Collection) Count(filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.CountWithCtx(context.Background(), filter, opts...)
+}
+
+func (c *Collection) CountWithCtx(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	opt := options.MergeCountOptions(opts...)
+	docs, err := c.find(filter, nil, opt.Skip, opt.Limit)
+	return int64(len(docs)), err
+}
+
+// Exists returns whether a document matches the filter.
+func (c *Collection) Exists(filter interface{}) (bool, error) {
+	return c.ExistsWithCtx(context.Background(), filter)
+}
+
+func (c *Collection) ExistsWithCtx(ctx context.Context, filter interface{}) (bool, error) {
+	one := int64(1)
+	docs, err := c.find(filter, nil, nil, &one)
+	return len(docs) > 0, err
+}
+
+// EstimatedCount returns the number of documents in the collection.
+func (c *Collection) EstimatedCount(opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
+	return c.EstimatedCountWithCtx(context.Background(), opts...)
+}
+
+func (c *Collection) EstimatedCountWithCtx(ctx context.Context, opts ...*options.EstimatedDocumentCountOptions) (int64, error) {
+	return int64(c.Len()), nil
+}
+
+// Distinct returns the distinct values of the field in the documents matching the filter.
+// Array values are flattened, as with the `distinct` command.
+func (c *Collection) Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error) {
+	docs, err := c.find(filter, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	add := func(val interface{}) {
+		for _, v := range values {
+			if match.Compare(v, val) == 0 {
+				return
+			}
+		}
+		values = append(values, val)
+	}
+	for _, doc := range docs {
+		for _, val := range match.Lookup(doc, fieldName) {
+			if arr, ok := val.(bson.A); ok {
+				for _, item := range arr {
+					add(item)
+				}
+				continue
+			}
+			add(val)
+		}
+	}
+	return values, nil
+}
+
+// Create method inserts a new model into the collection.
+func (c *Collection) Create(model mdu.Model, opts ...*options.InsertOneOptions) (interface{}, error) {
+	return c.CreateWithCtx(context.Background(), model, opts...)
+}
+
+func (c *Collection) CreateWithCtx(ctx context.Context, model mdu.Model, opts ...*options.InsertOneOptions) (interface{}, error) {
+	if err := mdu.PrepareNewID(model); err != nil {
+		return nil, err
+	}
+
+	if err := mdu.BeforeCreateHooks(ctx, model); err != nil {
+		return nil, err
+	}
+
+	doc, err := match.ToDoc(model)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.indexOf(model.GetID()) >= 0 {
+		c.mu.Unlock()
+		return nil, duplicateKeyError(model.GetID())
+	}
+	c.docs = append(c.docs, doc)
+	c.mu.Unlock()
+
+	if err = mdu.TakeSnapshot(model); err != nil {
+		return nil, err
+	}
+
+	if err = mdu.AfterCreateHooks(ctx, model); err != nil {
+		return nil, err
+	}
+	return model.GetID(), nil
+}
+
+// Update function persists the changes made to a model to the collection.
+func (c *Collection) Update(model mdu.Model, opts ...*options.UpdateOptions) error {
+	return c.UpdateWithCtx(context.Background(), model, opts...)
+}
+
+func (c *Collection) UpdateWithCtx(ctx context.Context, model mdu.Model, opts ...*options.UpdateOptions) error {
+	if upd, err := mdu.UpdateDocument(model); err != nil || upd == nil {
+		return err
+	}
+
+	if err := mdu.BeforeUpdateHooks(ctx, model); err != nil {
+		return err
+	}
+
+	upd, err := mdu.UpdateDocument(model)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.update(model.GetID(), upd, options.MergeUpdateOptions(opts...))
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount+res.UpsertedCount > 0 {
+		if err = mdu.TakeSnapshot(model); err != nil {
+			return err
+		}
+	}
+
+	return mdu.AfterUpdateHooks(ctx, res, model)
+}
+
+// Patch function persists the given fields in a model to the collection.
+func (c *Collection) Patch(model mdu.Model, fields map[string]interface{}, opts ...*options.UpdateOptions) error {
+	return c.PatchWithCtx(context.Background(), model, fields, opts...)
+}
+
+func (c *Collection) PatchWithCtx(ctx context.Context, model mdu.Model, fields map[string]interface{}, opts ...*options.UpdateOptions) error {
+	if err := mdu.BeforeUpdateHooks(ctx, model); err != nil {
+		return err
+	}
+
+	res, err := c.update(model.GetID(), bson.M{o.Set: fields}, options.MergeUpdateOptions(opts...))
+	if err != nil {
+		return err
+	}
+
+	return mdu.AfterUpdateHooks(ctx, res, model)
+}
+
+// Save method upserts a model by its natural key (see `mdu.NaturalKeyGetter`), or by its id,
+// calling the create or the update hooks (see `mdu.Collection.Save`).
+func (c *Collection) Save(model mdu.Model, opts ...*options.UpdateOptions) error {
+	return c.SaveWithCtx(context.Background(), model, opts...)
+}
+
+func (c *Collection) SaveWithCtx(ctx context.Context, model mdu.Model, opts ...*options.UpdateOptions) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		done, err := c.trySave(ctx, model, opts...)
+		if done || err != nil {
+			return err
+		}
+	}
+	return mdu.ErrSaveConflict
+}
+
+// trySave saves the model like `mdu.Collection.Save`, and returns false if the document was
+// created or deleted between its lookup and its write.
+func (c *Collection) trySave(ctx context.Context, model mdu.Model, opts ...*options.UpdateOptions) (bool, error) {
+	filter := mdu.NaturalKeyFilter(model)
+	naturalKey := filter != nil
+	if !naturalKey {
+		filter = bson.M{field.ID: model.GetID()}
+	}
+
+	docs, err := c.find(filter, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if len(docs) > 0 {
+		if ids := match.Lookup(docs[0], field.ID); len(ids) > 0 {
+			model.SetID(ids[0])
+		}
+		if err = mdu.BeforeUpdateHooks(ctx, model); err != nil {
+			return false, err
+		}
+		upd, err := mdu.SaveDocument(model)
+		if err != nil {
+			return false, err
+		}
+
+		res, err := c.update(model.GetID(), upd, options.MergeUpdateOptions(opts...).SetUpsert(false))
+		if err != nil || res.MatchedCount == 0 {
+			return false, err
+		}
+		if err = mdu.TakeSnapshot(model); err != nil {
+			return true, err
+		}
+		return true, mdu.AfterUpdateHooks(ctx, res, model)
+	}
+
+	if err = mdu.PrepareNewID(model); err != nil {
+		return false, err
+	}
+	if err = mdu.BeforeCreateHooks(ctx, model); err != nil {
+		return false, err
+	}
+	upd, err := mdu.SaveInsertDocument(model)
+	if err != nil {
+		return false, err
+	}
+
+	// Like the upsert of `mdu.Collection.Save` by the natural key, nothing is inserted if another
+	// document with the key was created in the meantime.
+	if !naturalKey {
+		filter = bson.M{field.ID: model.GetID()}
+	}
+	res, err := c.upsert(filter, model.GetID(), upd, options.MergeUpdateOptions(opts...).SetUpsert(true))
+	if err != nil || res.UpsertedCount == 0 {
+		return false, err
+	}
+	if err = mdu.TakeSnapshot(model); err != nil {
+		return true, err
+	}
+	return true, mdu.AfterCreateHooks(ctx, model)
+}
+
+// ApplyUpdate applies the update document to the model's document in the collection.
+// Only the `$set`, `$setOnInsert`, `$unset`, `$inc`, `$push` and `$pull` operators are supported.
+func (c *Collection) ApplyUpdate(model mdu.Model, update bson.D, opts ...*options.UpdateOptions) error {
+	return c.ApplyUpdateWithCtx(context.Background(), model, update, opts...)
+}
+
+func (c *Collection) ApplyUpdateWithCtx(ctx context.Context, model mdu.Model, update bson.D, opts ...*options.UpdateOptions) error {
+	if len(update) == 0 {
+		return nil
+	}
+
+	if err := mdu.BeforeUpdateHooks(ctx, model); err != nil {
+		return err
+	}
+
+	res, err := c.update(model.GetID(), update, options.MergeUpdateOptions(opts...))
+	if err != nil {
+		return err
+	}
+
+	return mdu.AfterUpdateHooks(ctx, res, model)
+}
+
+// BulkWrite executes the insert-one, update-one, replace-one and delete-one write models,
+// in order, and returns a `mongo.BulkWriteException` with the failed writes. Ordered bulk
+// writes (the default) stop at the first failed write.
+func (c *Collection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	opt := options.MergeBulkWriteOptions(opts...)
+	ordered := opt.Ordered == nil || *opt.Ordered
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := &mongo.BulkWriteResult{UpsertedIDs: map[int64]interface{}{}}
+	var writeErrs []mongo.BulkWriteError
+	for i, m := range models {
+		err := c.writeLocked(int64(i), m, res)
+		if err == nil {
+			continue
+		}
+
+		writeErr := mongo.WriteError{Message: err.Error()}
+		var we mongo.WriteException
+		if errors.As(err, &we) && len(we.WriteErrors) > 0 {
+			writeErr = we.WriteErrors[0]
+		}
+		writeErr.Index = i
+		writeErrs = append(writeErrs, mongo.BulkWriteError{WriteError: writeErr, Request: m})
+		if ordered {
+			break
+		}
+	}
+
+	if len(writeErrs) > 0 {
+		return res, mongo.BulkWriteException{WriteErrors: writeErrs}
+	}
+	return res, nil
+}
+
+// CreateMany inserts the models with bulk writes, calling their create hooks (see `mdu.Bulk`).
+func (c *Collection) CreateMany(models []mdu.Model, opts ...*mdu.BulkOptions) (*mdu.BulkResult, error) {
+	return c.CreateManyWithCtx(context.Background(), models, opts...)
+}
+
+func (c *Collection) CreateManyWithCtx(ctx context.Context, models []mdu.Model, opts ...*mdu.BulkOptions) (*mdu.BulkResult, error) {
+	return c.Bulk().Create(models...).RunWithCtx(ctx, opts...)
+}
+
+// UpdateMany persists the changes of the models with bulk writes, calling their update hooks (see `mdu.Bulk`).
+func (c *Collection) UpdateMany(models []mdu.Model, opts ...*mdu.BulkOptions) (*mdu.BulkResult, error) {
+	return c.UpdateManyWithCtx(context.Background(), models, opts...)
+}
+
+func (c *Collection) UpdateManyWithCtx(ctx context.Context, models []mdu.Model, opts ...*mdu.BulkOptions) (*mdu.BulkResult, error) {
+	return c.Bulk().Update(models...).RunWithCtx(ctx, opts...)
+}
+
+// DeleteMany deletes the models with bulk writes, calling their delete hooks (see `mdu.Bulk`).
+func (c *Collection) DeleteMany(models []mdu.Model, opts ...*mdu.BulkOptions) (*mdu.BulkResult, error) {
+	return c.DeleteManyWithCtx(context.Background(), models, opts...)
+}
+
+func (c *Collection) DeleteManyWithCtx(ctx context.Context, models []mdu.Model, opts ...*mdu.BulkOptions) (*mdu.BulkResult, error) {
+	return c.Bulk().Delete(models...).RunWithCtx(ctx, opts...)
+}
+
+// Bulk returns a new bulk write builder.
+func (c *Collection) Bulk() *mdu.Bulk {
+	return mdu.NewBulk(c)
+}
+
+// writeLocked executes a write model of a bulk write, holding the lock.
+func (c *Collection) writeLocked(i int64, m mongo.WriteModel, res *mongo.BulkWriteResult) error {
+	one := int64(1)
+	switch m := m.(type) {
+	case *mongo.InsertOneModel:
+		doc, err := match.ToDoc(m.Document)
+		if err != nil {
+			return err
+		}
+		ids := match.Lookup(doc, field.ID)
+		if len(ids) == 0 {
+			doc = append(bson.D{{Key: field.ID, Value: primitive.NewObjectID()}}, doc...)
+		} else if c.indexOf(ids[0]) >= 0 {
+			return duplicateKeyError(ids[0])
+		}
+		c.docs = append(c.docs, doc)
+		res.InsertedCount++
+
+	case *mongo.UpdateOneModel:
+		docs, err := c.findLocked(m.Filter, nil, nil, &one)
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			upd, err := c.updateLocked(match.Lookup(docs[0], field.ID)[0], m.Update, &options.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+			res.MatchedCount += upd.MatchedCount
+			res.ModifiedCount += upd.ModifiedCount
+		} else if m.Upsert != nil && *m.Upsert {
+			seed, err := upsertDoc(m.Filter)
+			if err != nil {
+				return err
+			}
+			ops, err := match.ToDoc(m.Update)
+			if err != nil {
+				return err
+			}
+			doc, err := applyUpdate(seed, ops, true)
+			if err != nil {
+				return err
+			}
+			c.docs = append(c.docs, doc)
+			res.UpsertedCount++
+			res.UpsertedIDs[i] = seed[0].Value
+		}
+
+	case *mongo.ReplaceOneModel:
+		docs, err := c.findLocked(m.Filter, nil, nil, &one)
+		if err != nil {
+			return err
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+		id := match.Lookup(docs[0], field.ID)[0]
+		replacement, err := match.ToDoc(m.Replacement)
+		if err != nil {
+			return err
+		}
+		doc := bson.D{{Key: field.ID, Value: id}}
+		for _, e := range replacement {
+			if e.Key != field.ID {
+				doc = append(doc, e)
+			}
+		}
+		c.docs[c.indexOf(id)] = doc
+		res.MatchedCount++
+		res.ModifiedCount++
+
+	case *mongo.DeleteOneModel:
+		docs, err := c.findLocked(m.Filter, nil, nil, &one)
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			j := c.indexOf(match.Lookup(docs[0], field.ID)[0])
+			c.docs = append(c.docs[:j], c.docs[j+1:]...)
+			res.DeletedCount++
+		}
+
+	default:
+		return fmt.Errorf("mdutest: unsupported write model %T", m)
+	}
+	return nil
+}
+
+// FindOneAndUpdate atomically updates the first document matching the filter, and decodes the
+// document returned (before or after the update) into the model, calling the update hooks. The
+// fields changed by the updating and saving hooks are added to the update (see `mdu.HookedUpdate`).
+// Upserted documents get the equality fields of the filter, and a new ObjectID if the filter has no `_id`.
+func (c *Collection) FindOneAndUpdate(filter interface{}, update interface{}, model mdu.Model, opts ...*options.FindOneAndUpdateOptions) error {
+	return c.FindOneAndUpdateWithCtx(context.Background(), filter, update, model, opts...)
+}
+
+func (c *Collection) FindOneAndUpdateWithCtx(ctx context.Context, filter interface{}, update interface{}, model mdu.Model, opts ...*options.FindOneAndUpdateOptions) error {
+	update, err := mdu.HookedUpdate(ctx, model, update)
+	if err != nil {
+		return err
+	}
+
+	opt := options.MergeFindOneAndUpdateOptions(opts...)
+	after := opt.ReturnDocument != nil && *opt.ReturnDocument == options.After
+	doc, err := c.findOneAndModify(filter, opt.Sort, func(doc bson.D) (bson.D, error) {
+		id := match.Lookup(doc, field.ID)
+		if len(id) == 0 {
+			return nil, errors.New("mdutest: document without _id")
+		}
+		if _, err := c.updateLocked(id[0], update, &options.UpdateOptions{}); err != nil {
+			return nil, err
+		}
+		if after {
+			return c.docs[c.indexOf(id[0])], nil
+		}
+		return doc, nil
+	}, func() (bson.D, error) {
+		if opt.Upsert == nil || !*opt.Upsert {
+			return nil, nil
+		}
+		seed, err := upsertDoc(filter)
+		if err != nil {
+			return nil, err
+		}
+		ops, err := match.ToDoc(update)
+		if err != nil {
+			return nil, err
+		}
+		inserted, err := applyUpdate(seed, ops, true)
+		if err != nil {
+			return nil, err
+		}
+		c.docs = append(c.docs, inserted)
+		if after {
+			return inserted, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = decode(doc, model); err != nil {
+		return err
+	}
+	if err = returned(ctx, model, after); err != nil {
+		return err
+	}
+	return mdu.AfterUpdateHooks(ctx, &mongo.UpdateResult{MatchedCount: 1}, model)
+}
+
+// FindOneAndReplace atomically replaces the first document matching the filter with the model, and
+// decodes the document returned (before or after the replacement) into the model, calling the update hooks.
+func (c *Collection) FindOneAndReplace(filter interface{}, model mdu.Model, opts ...*options.FindOneAndReplaceOptions) error {
+	return c.FindOneAndReplaceWithCtx(context.Background(), filter, model, opts...)
+}
+
+func (c *Collection) FindOneAndReplaceWithCtx(ctx context.Context, filter interface{}, model mdu.Model, opts ...*options.FindOneAndReplaceOptions) error {
+	if err := mdu.BeforeUpdateHooks(ctx, model); err != nil {
+		return err
+	}
+
+	replacement, err := match.ToDoc(model)
+	if err != nil {
+		return err
+	}
+
+	opt := options.MergeFindOneAndReplaceOptions(opts...)
+	after := opt.ReturnDocument != nil && *opt.ReturnDocument == options.After
+	replace := func(id interface{}) (bson.D, error) {
+		doc := bson.D{{Key: field.ID, Value: id}}
+		for _, e := range replacement {
+			if e.Key != field.ID {
+				doc = append(doc, e)
+			} else if match.Compare(e.Value, id) != 0 {
+				return nil, errors.New("the _id field cannot be changed by a replacement")
+			}
+		}
+		return doc, nil
+	}
+
+	doc, err := c.findOneAndModify(filter, opt.Sort, func(doc bson.D) (bson.D, error) {
+		id := match.Lookup(doc, field.ID)
+		if len(id) == 0 {
+			return nil, errors.New("mdutest: document without _id")
+		}
+		replaced, err := replace(id[0])
+		if err != nil {
+			return nil, err
+		}
+		c.docs[c.indexOf(id[0])] = replaced
+		if after {
+			return replaced, nil
+		}
+		return doc, nil
+	}, func() (bson.D, error) {
+		if opt.Upsert == nil || !*opt.Upsert {
+			return nil, nil
+		}
+		seed, err := upsertDoc(filter)
+		if err != nil {
+			return nil, err
+		}
+		id := seed[0].Value
+		if ids := match.Lookup(replacement, field.ID); len(ids) > 0 {
+			id = ids[0]
+		}
+		inserted, err := replace(id)
+		if err != nil {
+			return nil, err
+		}
+		c.docs = append(c.docs, inserted)
+		if after {
+			return inserted, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = decode(doc, model); err != nil {
+		return err
+	}
+	if err = returned(ctx, model, after); err != nil {
+		return err
+	}
+	return mdu.AfterUpdateHooks(ctx, &mongo.UpdateResult{MatchedCount: 1}, model)
+}
+
+// FindOneAndDelete atomically deletes the first document matching the filter, and decodes it
+// into the model, calling the delete hooks.
+func (c *Collection) FindOneAndDelete(filter interface{}, model mdu.Model, opts ...*options.FindOneAndDeleteOptions) error {
+	return c.FindOneAndDeleteWithCtx(context.Background(), filter, model, opts...)
+}
+
+func (c *Collection) FindOneAndDeleteWithCtx(ctx context.Context, filter interface{}, model mdu.Model, opts ...*options.FindOneAndDeleteOptions) error {
+	if err := mdu.BeforeDeleteHooks(ctx, model); err != nil {
+		return err
+	}
+
+	opt := options.MergeFindOneAndDeleteOptions(opts...)
+	doc, err := c.findOneAndModify(filter, opt.Sort, func(doc bson.D) (bson.D, error) {
+		id := match.Lookup(doc, field.ID)
+		if len(id) == 0 {
+			return nil, errors.New("mdutest: document without _id")
+		}
+		i := c.indexOf(id[0])
+		c.docs = append(c.docs[:i], c.docs[i+1:]...)
+		return doc, nil
+	}, func() (bson.D, error) {
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = decode(doc, model); err != nil {
+		return err
+	}
+	if err = mdu.AfterLoadHooks(ctx, model); err != nil {
+		return err
+	}
+	return mdu.AfterDeleteHooks(ctx, &mongo.DeleteResult{DeletedCount: 1}, model)
+}
+
+// findOneAndModify calls modify with the first document matching the filter, or insert if there
+// is none, holding the lock. It returns the document returned by the function called, or
+// `mongo.ErrNoDocuments` if it is nil.
+func (c *Collection) findOneAndModify(filter, sortSpec interface{}, modify func(doc bson.D) (bson.D, error), insert func() (bson.D, error)) (bson.D, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	one := int64(1)
+	docs, err := c.findLocked(filter, sortSpec, nil, &one)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	if len(docs) > 0 {
+		doc, err = modify(docs[0])
+	} else {
+		doc, err = insert()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return copyDoc(doc), nil
+}
+
+// upsertDoc returns the document seeding an upsert: the `_id` of the filter, or a new ObjectID,
+// followed by the other equality fields of the filter.
+func upsertDoc(filter interface{}) (bson.D, error) {
+	doc, err := match.ToDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := bson.D{{Key: field.ID, Value: primitive.NewObjectID()}}
+	for _, e := range doc {
+		if strings.HasPrefix(e.Key, "$") {
+			continue
+		}
+		if d, ok := e.Value.(bson.D); ok && len(d) > 0 && strings.HasPrefix(d[0].Key, "$") {
+			continue
+		}
+		if e.Key == field.ID {
+			seed[0].Value = e.Value
+		} else if seed, err = setPath(seed, e.Key, e.Value); err != nil {
+			return nil, err
+		}
+	}
+	return seed, nil
+}
+
+// Delete method deletes a model (doc) from the collection.
+func (c *Collection) Delete(model mdu.Model) error {
+	return c.DeleteWithCtx(context.Background(), model)
+}
+
+func (c *Collection) DeleteWithCtx(ctx context.Context, model mdu.Model) error {
+	if err := mdu.BeforeDeleteHooks(ctx, model); err != nil {
+		return err
+	}
+
+	res := &mongo.DeleteResult{}
+	c.mu.Lock()
+	if i := c.indexOf(model.GetID()); i >= 0 {
+		c.docs = append(c.docs[:i], c.docs[i+1:]...)
+		res.DeletedCount = 1
+	}
+	c.mu.Unlock()
+
+	return mdu.AfterDeleteHooks(ctx, res, model)
+}
+
+// find returns the matching documents, sorted, skipped and limited.
+func (c *Collection) find(filter interface{}, sortSpec interface{}, skip, limit *int64) ([]bson.D, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.findLocked(filter, sortSpec, skip, limit)
+}
+
+// findLocked is find for callers holding the lock.
+func (c *Collection) findLocked(filter interface{}, sortSpec interface{}, skip, limit *int64) ([]bson.D, error) {
+	f, err := match.New(filter)
+	if err != nil {
+		return nil, err
+	}
+	sortDoc, err := match.ToDoc(sortSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []bson.D
+	for _, doc := range c.docs {
+		if f.MatchDoc(doc) {
+			docs = append(docs, doc)
+		}
+	}
+
+	if len(sortDoc) > 0 {
+		sort.SliceStable(docs, func(i, j int) bool {
+			return lessBySort(docs[i], docs[j], sortDoc)
+		})
+	}
+
+	if skip != nil && *skip > 0 {
+		if *skip >= int64(len(docs)) {
+			docs = nil
+		} else {
+			docs = docs[*skip:]
+		}
+	}
+	if limit != nil && *limit != 0 {
+		n := *limit
+		if n < 0 {
+			n = -n
+		}
+		if n < int64(len(docs)) {
+			docs = docs[:n]
+		}
+	}
+
+	return docs, nil
+}
+
+// update applies the update document to the document with the given id.
+func (c *Collection) update(id interface{}, update interface{}, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.updateLocked(id, update, opt)
+}
+
+// upsert applies the update to the first document matching the filter, or inserts a document
+// with the id if none matches and the upsert option is set.
+func (c *Collection) upsert(filter interface{}, id interface{}, update interface{}, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	one := int64(1)
+	docs, err := c.findLocked(filter, nil, nil, &one)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) > 0 {
+		if ids := match.Lookup(docs[0], field.ID); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	return c.updateLocked(id, update, opt)
+}
+
+// updateLocked is update for callers holding the lock.
+func (c *Collection) updateLocked(id interface{}, update interface{}, opt *options.UpdateOptions) (*mongo.UpdateResult, error) {
+	idDoc, err := match.ToDoc(bson.M{field.ID: id})
+	if err != nil {
+		return nil, err
+	}
+	ops, err := match.ToDoc(update)
+	if err != nil {
+		return nil, err
+	}
+
+	i := c.indexOf(idDoc[0].Value)
+	if i < 0 {
+		if opt.Upsert == nil || !*opt.Upsert {
+			return &mongo.UpdateResult{}, nil
+		}
+		doc, err := applyUpdate(idDoc, ops, true)
+		if err != nil {
+			return nil, err
+		}
+		c.docs = append(c.docs, doc)
+		return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: idDoc[0].Value}, nil
+	}
+
+	doc, err := applyUpdate(copyDoc(c.docs[i]), ops, false)
+	if err != nil {
+		return nil, err
+	}
+	if ids := match.Lookup(doc, field.ID); len(ids) != 1 || match.Compare(ids[0], idDoc[0].Value) != 0 {
+		return nil, errors.New("performing an update on the path '_id' would modify the immutable field '_id'")
+	}
+
+	res := &mongo.UpdateResult{MatchedCount: 1}
+	if match.Compare(doc, c.docs[i]) != 0 {
+		res.ModifiedCount = 1
+	}
+	c.docs[i] = doc
+
+	return res, nil
+}
+
+// applyUpdate applies the `$set`, `$unset`, `$inc`, `$push` and `$pull` operators of the update document,
+// and the `$setOnInsert` operator if the document is being inserted.
+func applyUpdate(doc bson.D, ops bson.D, insert bool) (bson.D, error) {
+	var err error
+	for _, op := range ops {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("modifiers operate on fields but we found type %T instead", op.Value)
+		}
+		for _, f := range fields {
+			switch op.Key {
+			case o.Set:
+				doc, err = setPath(doc, f.Key, f.Value)
+			case o.SetOnInsert:
+				if insert {
+					doc, err = setPath(doc, f.Key, f.Value)
+				}
+			case o.Unset:
+				doc = unsetPath(doc, f.Key)
+			case o.Inc:
+				doc, err = incPath(doc, f.Key, f.Value)
+			case o.Push:
+				doc, err = pushPath(doc, f.Key, f.Value)
+			case o.Pull:
+				doc, err = pullPath(doc, f.Key, f.Value)
+			default:
+				return nil, fmt.Errorf("mdutest: unsupported update operator %s", op.Key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+// arrayAt returns the array at the path, or nil if the path is missing.
+func arrayAt(doc bson.D, path string) (bson.A, error) {
+	values := match.Lookup(doc, path)
+	if len(values) == 0 || values[0] == nil {
+		return nil, nil
+	}
+	arr, ok := values[0].(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("the field '%s' must be an array but is of type %T", path, values[0])
+	}
+	return arr, nil
+}
+
+// incPath increments the number at the path by the value, setting it if the path is missing.
+func incPath(doc bson.D, path string, val interface{}) (bson.D, error) {
+	values := match.Lookup(doc, path)
+	if len(values) == 0 {
+		return setPath(doc, path, val)
+	}
+
+	// int32 values stay int32 unless the sum overflows.
+	if cur, ok := values[0].(int32); ok {
+		if v, ok := val.(int32); ok {
+			sum := int64(cur) + int64(v)
+			if sum == int64(int32(sum)) {
+				return setPath(doc, path, int32(sum))
+			}
+			return setPath(doc, path, sum)
+		}
+	}
+
+	a, aInt := toInt64(values[0])
+	b, bInt := toInt64(val)
+	if aInt && bInt {
+		return setPath(doc, path, a+b)
+	}
+	x, xOk := toFloat64(values[0])
+	y, yOk := toFloat64(val)
+	if !xOk || !yOk {
+		return nil, fmt.Errorf("cannot apply %s to a value of non-numeric type %T", o.Inc, values[0])
+	}
+	return setPath(doc, path, x+y)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// pushPath appends the value, or the values of its `$each` modifier, to the array at the path.
+func pushPath(doc bson.D, path string, val interface{}) (bson.D, error) {
+	arr, err := arrayAt(doc, path)
+	if err != nil {
+		return nil, err
+	}
+
+	items := bson.A{val}
+	if d, ok := val.(bson.D); ok && len(d) > 0 && d[0].Key == o.Each {
+		if items, ok = d[0].Value.(bson.A); !ok {
+			return nil, fmt.Errorf("the argument to %s in %s must be an array", o.Each, o.Push)
+		}
+	}
+
+	return setPath(doc, path, append(append(bson.A{}, arr...), items...))
+}
+
+// pullPath removes the elements of the array at the path equal to the value,
+// or matching it when the value is a query operator document (e.g. `$in`).
+func pullPath(doc bson.D, path string, val interface{}) (bson.D, error) {
+	arr, err := arrayAt(doc, path)
+	if err != nil || arr == nil {
+		return doc, err
+	}
+
+	matches := func(item interface{}) bool { return match.Compare(item, val) == 0 }
+	if d, ok := val.(bson.D); ok && len(d) > 0 && strings.HasPrefix(d[0].Key, "$") {
+		filter, err := match.New(bson.D{{Key: "v", Value: d}})
+		if err != nil {
+			return nil, err
+		}
+		matches = func(item interface{}) bool { return filter.MatchDoc(bson.D{{Key: "v", Value: item}}) }
+	}
+
+	kept := bson.A{}
+	for _, item := range arr {
+		if !matches(item) {
+			kept = append(kept, item)
+		}
+	}
+	return setPath(doc, path, kept)
+}
+
+func (c *Collection) indexOf(id interface{}) int {
+	idDoc, err := match.ToDoc(bson.M{field.ID: id})
+	if err != nil {
+		return -1
+	}
+	for i, doc := range c.docs {
+		for _, e := range doc {
+			if e.Key == field.ID && match.Compare(e.Value, idDoc[0].Value) == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// setPath sets the value at the dotted path, creating the missing embedded documents.
+func setPath(doc bson.D, path string, val interface{}) (bson.D, error) {
+	parts := strings.SplitN(path, ".", 2)
+
+	for i, e := range doc {
+		if e.Key != parts[0] {
+			continue
+		}
+		if len(parts) == 1 {
+			doc[i].Value = val
+			return doc, nil
+		}
+		next, err := setNested(e.Value, parts[1], val)
+		if err != nil {
+			return nil, err
+		}
+		doc[i].Value = next
+		return doc, nil
+	}
+
+	if len(parts) == 1 {
+		return append(doc, bson.E{Key: parts[0], Value: val}), nil
+	}
+	sub, err := setPath(bson.D{}, parts[1], val)
+	if err != nil {
+		return nil, err
+	}
+	return append(doc, bson.E{Key: parts[0], Value: sub}), nil
+}
+
+// unsetPath removes the field at the dotted path. Array elements are set to null.
+func unsetPath(doc bson.D, path string) bson.D {
+	parts := strings.SplitN(path, ".", 2)
+	for i, e := range doc {
+		if e.Key != parts[0] {
+			continue
+		}
+		if len(parts) == 1 {
+			return append(doc[:i:i], doc[i+1:]...)
+		}
+		doc[i].Value = unsetNested(e.Value, parts[1])
+		return doc
+	}
+	return doc
+}
+
+func unsetNested(cur interface{}, path string) interface{} {
+	switch v := cur.(type) {
+	case bson.D:
+		return unsetPath(v, path)
+	case bson.A:
+		parts := strings.SplitN(path, ".", 2)
+		i, err := strconv.Atoi(parts[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return v
+		}
+		if len(parts) == 1 {
+			v[i] = nil
+		} else {
+			v[i] = unsetNested(v[i], parts[1])
+		}
+		return v
+	}
+	return cur
+}
+
+func setNested(cur interface{}, path string, val interface{}) (interface{}, error) {
+	switch v := cur.(type) {
+	case bson.D:
+		return setPath(v, path, val)
+	case bson.A:
+		parts := strings.SplitN(path, ".", 2)
+		i, err := strconv.Atoi(parts[0])
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("cannot create field '%s' in an array", parts[0])
+		}
+		for len(v) <= i {
+			v = append(v, nil)
+		}
+		if len(parts) == 1 {
+			v[i] = val
+			return v, nil
+		}
+		if v[i] == nil {
+			v[i] = bson.D{}
+		}
+		next, err := setNested(v[i], parts[1], val)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = next
+		return v, nil
+	}
+	return nil, fmt.Errorf("cannot create field '%s' in a non-document value", path)
+}
+
+func lessBySort(a, b bson.D, sortDoc bson.D) bool {
+	for _, s := range sortDoc {
+		desc := match.Compare(s.Value, int32(0)) < 0
+		c := match.Compare(sortValue(a, s.Key, desc), sortValue(b, s.Key, desc))
+		if c == 0 {
+			continue
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// sortValue returns the value used to sort by the path. Arrays are sorted by their
+// lowest element in ascending sorts, and by their highest element in descending sorts.
+func sortValue(doc bson.D, path string, desc bool) interface{} {
+	values := match.Lookup(doc, path)
+	if len(values) == 0 {
+		return nil
+	}
+	arr, ok := values[0].(bson.A)
+	if !ok || len(arr) == 0 {
+		return values[0]
+	}
+
+	best := arr[0]
+	for _, v := range arr[1:] {
+		c := match.Compare(v, best)
+		if (desc && c > 0) || (!desc && c < 0) {
+			best = v
+		}
+	}
+	return best
+}
+
+// loaded takes the snapshot of a decoded model, and calls its loaded hook.
+func loaded(ctx context.Context, model interface{}) error {
+	if err := mdu.TakeSnapshot(model); err != nil {
+		return err
+	}
+	return mdu.AfterLoadHooks(ctx, model)
+}
+
+// returned calls the loaded hook of a model decoded from a find and modify operation. Only the
+// document after the modification is the current state, so the snapshot is dropped otherwise.
+func returned(ctx context.Context, model mdu.Model, after bool) error {
+	if after {
+		return loaded(ctx, model)
+	}
+	if tracker, ok := model.(mdu.Tracker); ok {
+		tracker.SetSnapshot(nil)
+	}
+	return mdu.AfterLoadHooks(ctx, model)
+}
+
+func duplicateKeyError(id interface{}) error {
+	return mongo.WriteException{WriteErrors: mongo.WriteErrors{{
+		Code:    duplicateKeyCode,
+		Message: fmt.Sprintf("E11000 duplicate key error collection dup key: { _id: %v }", id),
+	}}}
+}
+
+func copyDoc(doc bson.D) bson.D {
+	cp, _ := match.ToDoc(doc)
+	return cp
+}
+
+func decode(doc bson.D, val interface{}) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, val)
+}
+
+// Ensure that the Collection implements the mdu.Repository interface
+var _ mdu.Repository = &Collection{}
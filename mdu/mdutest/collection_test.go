@@ -0,0 +1,740 @@
+package mdutest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/softwok/mongo-util/mdu"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestCreateAndFindByID(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	testProduct := &product{Name: "TestCreate", Price: 124}
+	id, err := coll.Create(testProduct)
+	util.AssertErrIsNil(t, err)
+
+	found := &product{}
+	util.AssertErrIsNil(t, coll.FindByID(id, found))
+
+	assert.Equal(t, "products", coll.Name())
+	assert.Equal(t, testProduct.ID, found.ID)
+	assert.Equal(t, "TestCreate", found.Name)
+	assert.False(t, found.CreatedAt.IsZero())
+	assert.Equal(t, 1, testProduct.created)
+}
+
+func TestCreateDuplicateID(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	_, err := coll.Create(&product{DefaultModel: mdu.DefaultModel{IDField: mdu.IDField{ID: "1"}}})
+	util.AssertErrIsNil(t, err)
+	_, err = coll.Create(&product{DefaultModel: mdu.DefaultModel{IDField: mdu.IDField{ID: "1"}}})
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+}
+
+func TestUpdatePatchDelete(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	testProduct := &product{Name: "TestCreate", Price: 122}
+	_, err := coll.Create(testProduct)
+	util.AssertErrIsNil(t, err)
+
+	testProduct.Name = "TestUpdate"
+	util.AssertErrIsNil(t, coll.Update(testProduct))
+	assert.Equal(t, int64(1), testProduct.updated)
+
+	util.AssertErrIsNil(t, coll.Patch(testProduct, map[string]interface{}{"price": 200, "details.color": "red"}))
+
+	found := &product{}
+	util.AssertErrIsNil(t, coll.FindByID(testProduct.ID, found))
+	assert.Equal(t, "TestUpdate", found.Name)
+	assert.Equal(t, 200, found.Price)
+	assert.Equal(t, "red", found.Details.Color)
+
+	util.AssertErrIsNil(t, coll.Delete(testProduct))
+	assert.Equal(t, mongo.ErrNoDocuments, coll.FindByID(testProduct.ID, found))
+	assert.Equal(t, 0, coll.Len())
+}
+
+func TestUpdateMissing(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	missing := &product{DefaultModel: mdu.DefaultModel{IDField: mdu.IDField{ID: "missing"}}}
+	util.AssertErrIsNil(t, coll.Update(missing))
+	assert.Equal(t, 0, coll.Len())
+
+	util.AssertErrIsNil(t, coll.Update(missing, mdu.UpsertTrueOption()))
+	assert.Equal(t, 1, coll.Len())
+}
+
+func TestUpdateChanges(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	testProduct := &product{Name: "TestCreate", Price: 122, Tags: []string{"a", "b"}}
+	_, err := coll.Create(testProduct)
+	util.AssertErrIsNil(t, err)
+
+	// Nothing changed: no hooks, no update.
+	util.AssertErrIsNil(t, coll.Update(testProduct))
+	assert.Equal(t, int64(0), testProduct.updated)
+
+	testProduct.Tags[1] = "c"
+	testProduct.Details.Color = "red"
+	upd, err := mdu.UpdateDocument(testProduct)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "tags.1", Value: "c"},
+		{Key: "details.color", Value: "red"},
+	}}}, upd)
+	util.AssertErrIsNil(t, coll.Update(testProduct))
+	assert.Equal(t, int64(1), testProduct.updated)
+
+	testProduct.Details.Color = ""
+	upd, err = mdu.UpdateDocument(testProduct)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.D{{Key: "$unset", Value: bson.D{{Key: "details.color", Value: ""}}}}, upd)
+
+	// A model that was not loaded does not reset created_at.
+	partial := &product{Name: "TestPartial"}
+	partial.ID = testProduct.ID
+	util.AssertErrIsNil(t, coll.Update(partial))
+
+	found := &product{}
+	util.AssertErrIsNil(t, coll.FindByID(testProduct.ID, found))
+	assert.Equal(t, "TestPartial", found.Name)
+	assert.Equal(t, testProduct.CreatedAt.Truncate(time.Millisecond), found.CreatedAt)
+}
+
+func TestApplyDiff(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	old := &product{Name: "TestCreate", Price: 122, Tags: []string{"a", "b", "c"}}
+	_, err := coll.Create(old)
+	util.AssertErrIsNil(t, err)
+
+	put := &product{Name: "TestPut", Price: 122, Tags: []string{"a", "c"}}
+	put.ID = old.ID
+	upd, err := mdu.Diff(old, put)
+	util.AssertErrIsNil(t, err)
+	util.AssertErrIsNil(t, coll.ApplyUpdate(put, upd))
+	assert.Equal(t, int64(1), put.updated)
+
+	found := &product{}
+	util.AssertErrIsNil(t, coll.FindByID(old.ID, found))
+	assert.Equal(t, "TestPut", found.Name)
+	assert.Equal(t, []string{"a", "c"}, found.Tags)
+	assert.False(t, found.CreatedAt.IsZero())
+
+	upd, err = mdu.Diff(found, &product{DefaultModel: found.DefaultModel, Name: "TestPut", Price: 122, Tags: []string{"a", "c", "d"}})
+	util.AssertErrIsNil(t, err)
+	util.AssertErrIsNil(t, coll.ApplyUpdate(found, upd))
+	util.AssertErrIsNil(t, coll.FindByID(old.ID, found))
+	assert.Equal(t, []string{"a", "c", "d"}, found.Tags)
+}
+
+type sku struct {
+	mdu.DefaultModel `bson:",inline"`
+	Code             string `bson:"code"`
+	Stock            int    `bson:"stock"`
+	Origin           string `bson:"origin"`
+
+	created, updated int
+}
+
+func (s *sku) NaturalKey() bson.M {
+	return bson.M{"code": s.Code}
+}
+
+func (s *sku) CreateOnlyFields() []string {
+	return []string{"origin"}
+}
+
+func (s *sku) Created(ctx context.Context) error {
+	s.created++
+	return nil
+}
+
+func (s *sku) Updated(ctx context.Context, result *mongo.UpdateResult) error {
+	s.updated++
+	return nil
+}
+
+func TestSave(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	testProduct := &product{Name: "TestSave", Price: 100}
+	util.AssertErrIsNil(t, coll.Save(testProduct))
+	assert.Equal(t, 1, testProduct.created)
+	assert.NotEmpty(t, testProduct.ID)
+	assert.False(t, testProduct.CreatedAt.IsZero())
+
+	again := &product{Name: "TestSaveAgain", Price: 100}
+	again.ID = testProduct.ID
+	util.AssertErrIsNil(t, coll.Save(again))
+	assert.Equal(t, 0, again.created)
+	assert.Equal(t, int64(1), again.updated)
+
+	found := &product{}
+	util.AssertErrIsNil(t, coll.FindByID(testProduct.ID, found))
+	assert.Equal(t, 1, coll.Len())
+	assert.Equal(t, "TestSaveAgain", found.Name)
+	assert.Equal(t, testProduct.CreatedAt.Truncate(time.Millisecond), found.CreatedAt)
+
+	// The caller's options are left as they are.
+	opts := make([]*options.UpdateOptions, 1, 2)
+	opts[0] = options.Update()
+	util.AssertErrIsNil(t, coll.Save(&product{Name: "TestSaveOpts"}, opts...))
+	assert.Nil(t, opts[0].Upsert)
+	assert.Nil(t, opts[:2][1])
+}
+
+func TestSaveByNaturalKey(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&sku{})
+
+	first := &sku{Code: "A-1", Stock: 5, Origin: "import"}
+	util.AssertErrIsNil(t, coll.Save(first))
+	assert.Equal(t, 1, first.created)
+
+	second := &sku{Code: "A-1", Stock: 3, Origin: "local"}
+	util.AssertErrIsNil(t, coll.Save(second))
+	assert.Equal(t, 0, second.created)
+	assert.Equal(t, 1, second.updated)
+	assert.Equal(t, first.ID, second.ID)
+
+	found := &sku{}
+	util.AssertErrIsNil(t, coll.First(bson.M{"code": "A-1"}, found))
+	assert.Equal(t, 1, coll.Len())
+	assert.Equal(t, 3, found.Stock)
+	assert.Equal(t, "import", found.Origin)
+	assert.False(t, found.CreatedAt.IsZero())
+}
+
+// racingProduct is created by another writer while its creating hook runs.
+type racingProduct struct {
+	mdu.DefaultModel `bson:",inline"`
+	Name             string `bson:"name"`
+
+	coll                       *Collection
+	created, updating, updated int
+}
+
+func (p *racingProduct) Creating(ctx context.Context) error {
+	if coll := p.coll; coll != nil {
+		p.coll = nil
+		other := &product{Name: "other"}
+		other.ID = p.ID
+		_, err := coll.Create(other)
+		return err
+	}
+	return nil
+}
+
+func (p *racingProduct) Updating(ctx context.Context) error {
+	p.updating++
+	return nil
+}
+
+func (p *racingProduct) Created(ctx context.Context) error {
+	p.created++
+	return nil
+}
+
+func (p *racingProduct) Updated(ctx context.Context, result *mongo.UpdateResult) error {
+	p.updated++
+	return nil
+}
+
+func TestSaveConflict(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&racingProduct{})
+
+	p := &racingProduct{Name: "TestSaveConflict", coll: coll}
+	util.AssertErrIsNil(t, coll.Save(p))
+	// The document was created before the write, which updates it with the update hooks.
+	assert.Equal(t, 0, p.created)
+	assert.Equal(t, 1, p.updating)
+	assert.Equal(t, 1, p.updated)
+
+	found := &product{}
+	util.AssertErrIsNil(t, coll.FindByID(p.ID, found))
+	assert.Equal(t, 1, coll.Len())
+	assert.Equal(t, "TestSaveConflict", found.Name)
+}
+
+// racingSKU is created by another writer while its creating hook runs, and deleted while its
+// updating hook runs, as long as races remain.
+type racingSKU struct {
+	mdu.DefaultModel `bson:",inline"`
+	Code             string `bson:"code"`
+
+	coll            *Collection
+	races, updating int
+}
+
+func (s *racingSKU) NaturalKey() bson.M {
+	return bson.M{"code": s.Code}
+}
+
+func (s *racingSKU) Creating(ctx context.Context) error {
+	if s.races == 0 {
+		return nil
+	}
+	s.races--
+	_, err := s.coll.Create(&sku{Code: s.Code})
+	return err
+}
+
+func (s *racingSKU) Updating(ctx context.Context) error {
+	s.updating++
+	if s.races == 0 {
+		return nil
+	}
+	s.races--
+	other := &sku{}
+	other.ID = s.ID
+	return s.coll.Delete(other)
+}
+
+func TestSaveConflictByNaturalKey(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&racingSKU{})
+
+	s := &racingSKU{Code: "B-1", coll: coll, races: 1}
+	util.AssertErrIsNil(t, coll.Save(s))
+	assert.Equal(t, 1, s.updating)
+	assert.Equal(t, 1, coll.Len())
+
+	found := &sku{}
+	util.AssertErrIsNil(t, coll.First(bson.M{"code": "B-1"}, found))
+	assert.Equal(t, found.ID, s.ID)
+
+	s = &racingSKU{Code: "B-2", coll: coll, races: 3}
+	assert.True(t, errors.Is(coll.Save(s), mdu.ErrSaveConflict))
+}
+
+func TestFindAll(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	for i, name := range []string{"Product1", "Product2", "Product3", "Product4", "Product5"} {
+		_, err := coll.Create(&product{Name: name, Price: (i + 1) * 100, Tags: []string{"all", name}})
+		util.AssertErrIsNil(t, err)
+	}
+
+	tests := []struct {
+		name   string
+		filter interface{}
+		opts   *options.FindOptions
+		want   []string
+	}{
+		{"all", bson.M{}, nil, []string{"Product1", "Product2", "Product3", "Product4", "Product5"}},
+		{"eq", bson.M{"name": "Product2"}, nil, []string{"Product2"}},
+		{"array element", bson.M{"tags": "Product3"}, nil, []string{"Product3"}},
+		{"gt", bson.M{"price": bson.M{"$gt": 300}}, nil, []string{"Product4", "Product5"}},
+		{"range", bson.M{"price": bson.M{"$gte": 200, "$lt": 400}}, nil, []string{"Product2", "Product3"}},
+		{"in", bson.M{"name": bson.M{"$in": bson.A{"Product1", "Product5"}}}, nil, []string{"Product1", "Product5"}},
+		{"nin", bson.M{"price": bson.M{"$nin": bson.A{100, 200, 300}}}, nil, []string{"Product4", "Product5"}},
+		{"or", bson.M{"$or": bson.A{bson.M{"price": 100}, bson.M{"name": "Product2"}}}, nil, []string{"Product1", "Product2"}},
+		{"not", bson.M{"price": bson.M{"$not": bson.M{"$gt": 100}}}, nil, []string{"Product1"}},
+		{"regex", bson.M{"name": bson.M{"$regex": "[45]$"}}, nil, []string{"Product4", "Product5"}},
+		{"exists", bson.M{"details.color": bson.M{"$exists": true}}, nil, nil},
+		{"sort desc", bson.M{}, options.Find().SetSort(bson.D{{Key: "price", Value: -1}}).SetLimit(2), []string{"Product5", "Product4"}},
+		{"skip", bson.M{}, options.Find().SetSort(bson.D{{Key: "price", Value: 1}}).SetSkip(3), []string{"Product4", "Product5"}},
+	}
+
+	for _, test := range tests {
+		var results []product
+		err := coll.FindAllWithCtx(context.Background(), &results, test.filter, test.opts)
+		util.AssertErrIsNil(t, err)
+
+		var names []string
+		for _, p := range results {
+			names = append(names, p.Name)
+		}
+		assert.Equal(t, test.want, names, test.name)
+	}
+
+	var results []product
+	assert.NotNil(t, coll.FindAll(&results, bson.M{"$where": "true"}))
+}
+
+func TestAggregate(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	for i, name := range []string{"Product1", "Product2", "Product3"} {
+		_, err := coll.Create(&product{Name: name, Price: (i + 1) * 100, Tags: []string{"all"}})
+		util.AssertErrIsNil(t, err)
+	}
+
+	cur, err := coll.Aggregate(context.Background(), bson.A{
+		bson.M{"$match": bson.M{"price": bson.M{"$gte": 200}}},
+		bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$price"}}},
+	})
+	util.AssertErrIsNil(t, err)
+
+	var results []bson.M
+	util.AssertErrIsNil(t, cur.All(context.Background(), &results))
+	assert.Equal(t, 1, len(results))
+	assert.EqualValues(t, 500, results[0]["total"])
+
+	_, err = coll.Aggregate(context.Background(), bson.A{bson.M{"$out": "other"}})
+	assert.NotNil(t, err)
+}
+
+type product struct {
+	mdu.DefaultModel `bson:",inline"`
+	Name             string   `json:"name" bson:"name"`
+	Price            int      `json:"price" bson:"price"`
+	Tags             []string `json:"tags" bson:"tags"`
+	Details          struct {
+		Color string `json:"color" bson:"color,omitempty"`
+	} `json:"details" bson:"details"`
+
+	created int
+	updated int64
+}
+
+func (p *product) Created(ctx context.Context) error {
+	p.created++
+	return nil
+}
+
+func (p *product) Updated(ctx context.Context, result *mongo.UpdateResult) error {
+	p.updated += result.MatchedCount
+	return nil
+}
+
+type job struct {
+	mdu.DefaultModel `bson:",inline"`
+	Status           string `bson:"status"`
+	Priority         int    `bson:"priority"`
+
+	updating int
+}
+
+func (j *job) Updating(ctx context.Context) error {
+	j.updating++
+	return nil
+}
+
+type counter struct {
+	mdu.IDField `bson:",inline"`
+	Seq         int64 `bson:"seq"`
+}
+
+func TestFindOneAndUpdate(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&job{})
+
+	for i, status := range []string{"pending", "pending", "done"} {
+		_, err := coll.Create(&job{Status: status, Priority: i})
+		util.AssertErrIsNil(t, err)
+	}
+
+	claimed := &job{}
+	after := options.FindOneAndUpdate().SetSort(bson.M{"priority": -1}).SetReturnDocument(options.After)
+	err := coll.FindOneAndUpdate(bson.M{"status": "pending"}, bson.M{"$set": bson.M{"status": "running"}}, claimed, after)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, "running", claimed.Status)
+	assert.Equal(t, 1, claimed.Priority)
+	assert.Equal(t, 1, claimed.updating)
+	assert.NotNil(t, claimed.Snapshot())
+
+	// The updated_at field set by the saving hook is persisted.
+	_, err = coll.BulkWrite(context.Background(), []mongo.WriteModel{
+		mongo.NewInsertOneModel().SetDocument(bson.M{"_id": "raw", "status": "imported"}),
+	})
+	util.AssertErrIsNil(t, err)
+	util.AssertErrIsNil(t, coll.FindOneAndUpdate(bson.M{"_id": "raw"}, bson.M{"$set": bson.M{"status": "queued"}}, &job{}))
+	stored := &job{}
+	util.AssertErrIsNil(t, coll.FindByID("raw", stored))
+	assert.Equal(t, "queued", stored.Status)
+	assert.False(t, stored.UpdatedAt.IsZero())
+
+	before := &job{}
+	err = coll.FindOneAndUpdate(bson.M{"status": "pending", "priority": 0}, bson.M{"$set": bson.M{"status": "running"}}, before)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, "pending", before.Status)
+	// The document before the update is not the current state of the model.
+	assert.Nil(t, before.Snapshot())
+
+	// Fields modified by the update are left to it.
+	fixed := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	err = coll.FindOneAndUpdate(bson.M{"_id": claimed.ID}, bson.M{"$set": bson.M{"updated_at": fixed}}, &job{}, after)
+	util.AssertErrIsNil(t, err)
+	util.AssertErrIsNil(t, coll.FindByID(claimed.ID, stored))
+	assert.Equal(t, fixed, stored.UpdatedAt)
+
+	err = coll.FindOneAndUpdate(bson.M{"status": "pending"}, bson.M{"$set": bson.M{"status": "running"}}, &job{})
+	assert.Equal(t, mongo.ErrNoDocuments, err)
+
+	seqs := Coll(&counter{})
+	upsert := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	for i := int64(1); i <= 3; i++ {
+		c := &counter{}
+		err = seqs.FindOneAndUpdate(bson.M{"_id": "orders"}, bson.M{"$inc": bson.M{"seq": int64(1)}}, c, upsert)
+		util.AssertErrIsNil(t, err)
+		assert.Equal(t, "orders", c.ID)
+		assert.Equal(t, i, c.Seq)
+	}
+}
+
+func TestFindOneAndReplaceAndDelete(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&job{})
+
+	_, err := coll.Create(&job{Status: "pending", Priority: 1})
+	util.AssertErrIsNil(t, err)
+
+	replaced := &job{Status: "replaced", Priority: 2}
+	err = coll.FindOneAndReplace(bson.M{"status": "pending"}, replaced, options.FindOneAndReplace().SetReturnDocument(options.After))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, "replaced", replaced.Status)
+	assert.NotEmpty(t, replaced.ID)
+
+	deleted := &job{}
+	util.AssertErrIsNil(t, coll.FindOneAndDelete(bson.M{"status": "replaced"}, deleted))
+	assert.Equal(t, replaced.ID, deleted.ID)
+	assert.Equal(t, 0, coll.Len())
+	assert.Equal(t, mongo.ErrNoDocuments, coll.FindOneAndDelete(bson.M{}, deleted))
+}
+
+func TestCountExistsDistinct(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&product{})
+
+	for i, tags := range [][]string{{"a", "b"}, {"b", "c"}, {"c"}} {
+		_, err := coll.Create(&product{Name: "Product", Price: i * 100, Tags: tags})
+		util.AssertErrIsNil(t, err)
+	}
+
+	count, err := coll.Count(bson.M{"price": bson.M{"$gt": 0}})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = coll.EstimatedCount()
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(3), count)
+
+	exists, err := coll.Exists(bson.M{"tags": "c"})
+	util.AssertErrIsNil(t, err)
+	assert.True(t, exists)
+	exists, err = coll.Exists(bson.M{"tags": "d"})
+	util.AssertErrIsNil(t, err)
+	assert.False(t, exists)
+
+	tags, err := mdu.Distinct[string](context.Background(), coll, "tags", bson.M{})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, tags)
+
+	prices, err := mdu.Distinct[int](context.Background(), coll, "price", bson.M{"tags": "c"})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, []int{100, 200}, prices)
+}
+
+type item struct {
+	mdu.DefaultModel `bson:",inline"`
+	N                int `bson:"n"`
+
+	loaded bool
+}
+
+func (i *item) Loaded(ctx context.Context) error {
+	i.loaded = true
+	return nil
+}
+
+func newItems(t *testing.T, n int) *Collection {
+	coll := Coll(&item{})
+	for i := 0; i < n; i++ {
+		_, err := coll.Create(&item{N: i})
+		util.AssertErrIsNil(t, err)
+	}
+	return coll
+}
+
+func TestEach(t *testing.T) {
+	t.Parallel()
+	coll := newItems(t, 5)
+
+	var seen []int
+	model := &item{}
+	err := coll.Each(bson.M{"n": bson.M{"$gte": 1}}, model, func() error {
+		assert.True(t, model.loaded)
+		seen = append(seen, model.N)
+		if model.N == 3 {
+			return mdu.ErrStopIteration
+		}
+		return nil
+	}, options.Find().SetSort(bson.M{"n": 1}))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, []int{1, 2, 3}, seen)
+
+	it, err := mdu.Iter[item](context.Background(), coll, bson.M{}, options.Find().SetSort(bson.M{"n": -1}))
+	util.AssertErrIsNil(t, err)
+	seen = nil
+	for it.Next() {
+		assert.True(t, it.Value().loaded)
+		seen = append(seen, it.Value().N)
+	}
+	util.AssertErrIsNil(t, it.Err())
+	util.AssertErrIsNil(t, it.Close())
+	assert.Equal(t, []int{4, 3, 2, 1, 0}, seen)
+}
+
+func TestEachBatch(t *testing.T) {
+	t.Parallel()
+	coll := newItems(t, 5)
+
+	var sizes []int
+	err := mdu.EachBatch[item](context.Background(), coll, bson.M{}, 2, func(batch []*item) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, []int{2, 2, 1}, sizes)
+
+	err = mdu.EachBatch[item](context.Background(), coll, bson.M{}, 2, func(batch []*item) error {
+		return mongo.ErrNilDocument
+	})
+	assert.Equal(t, mongo.ErrNilDocument, err)
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+	coll := newItems(t, 5)
+
+	items, errc := mdu.Stream[item](context.Background(), coll, bson.M{}, 1, options.Find().SetSort(bson.M{"n": 1}))
+	sum := 0
+	for i := range items {
+		sum += i.N
+	}
+	util.AssertErrIsNil(t, <-errc)
+	assert.Equal(t, 10, sum)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	items, errc = mdu.Stream[item](ctx, coll, bson.M{}, 0)
+	<-items
+	cancel()
+	assert.Equal(t, context.Canceled, <-errc)
+	_, ok := <-items
+	assert.False(t, ok)
+}
+
+type part struct {
+	mdu.DefaultModel `bson:",inline"`
+	Name             string `bson:"name"`
+
+	created, deleted int
+}
+
+func (p *part) Creating(ctx context.Context) error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+func (p *part) Created(ctx context.Context) error {
+	p.created++
+	return nil
+}
+
+func (p *part) Deleted(ctx context.Context, result *mongo.DeleteResult) error {
+	p.deleted++
+	return nil
+}
+
+func TestBulk(t *testing.T) {
+	t.Parallel()
+	coll := Coll(&sku{})
+
+	skus := make([]*sku, 5)
+	for i := range skus {
+		skus[i] = &sku{Code: fmt.Sprint("S", i), Stock: i}
+	}
+	res, err := coll.CreateMany(mdu.Models(skus), mdu.BulkOpts().SetChunkSize(2))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(5), res.InsertedCount)
+	assert.Equal(t, 5, coll.Len())
+	for _, s := range skus {
+		assert.NotEmpty(t, s.ID)
+		assert.Equal(t, 1, s.created)
+	}
+
+	// Unchanged models are skipped.
+	skus[1].Stock = 10
+	skus[3].Stock = 30
+	res, err = coll.UpdateMany(mdu.Models(skus))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(2), res.MatchedCount)
+	assert.Equal(t, []int{0, 1, 0, 1, 0}, []int{skus[0].updated, skus[1].updated, skus[2].updated, skus[3].updated, skus[4].updated})
+
+	found := &sku{}
+	util.AssertErrIsNil(t, coll.FindByID(skus[3].ID, found))
+	assert.Equal(t, 30, found.Stock)
+
+	res, err = coll.Bulk().
+		Delete(skus[0], skus[1]).
+		Write(mongo.NewUpdateOneModel().SetFilter(bson.M{"code": "S2"}).SetUpdate(bson.M{"$inc": bson.M{"stock": 5}})).
+		Run()
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(2), res.DeletedCount)
+	assert.Equal(t, int64(1), res.ModifiedCount)
+	assert.Equal(t, 3, coll.Len())
+}
+
+func TestBulkErrors(t *testing.T) {
+	t.Parallel()
+
+	newParts := func() []*part {
+		parts := []*part{{Name: "a"}, {Name: "b"}, {}, {Name: "d"}}
+		parts[0].ID = "1"
+		parts[1].ID = "1"
+		return parts
+	}
+
+	coll := Coll(&part{})
+	parts := newParts()
+	res, err := coll.CreateMany(mdu.Models(parts), mdu.BulkOpts().SetOrdered(false).SetChunkSize(2))
+	var bulkErr *mdu.BulkError
+	assert.True(t, errors.As(err, &bulkErr))
+	assert.Len(t, res.Errors, 2)
+	assert.True(t, mongo.IsDuplicateKeyError(res.Errors[1]))
+	assert.EqualError(t, res.Errors[2], "name is required")
+	assert.Equal(t, int64(2), res.InsertedCount)
+	assert.Equal(t, []int{1, 0, 0, 1}, []int{parts[0].created, parts[1].created, parts[2].created, parts[3].created})
+
+	coll = Coll(&part{})
+	parts = newParts()
+	res, err = coll.CreateMany(mdu.Models(parts))
+	assert.Error(t, err)
+	assert.Len(t, res.Errors, 3)
+	assert.True(t, mongo.IsDuplicateKeyError(res.Errors[1]))
+	assert.EqualError(t, res.Errors[2], "name is required")
+	assert.Equal(t, mdu.ErrNotExecuted, res.Errors[3])
+	assert.Equal(t, 1, coll.Len())
+
+	res, err = coll.DeleteMany(mdu.Models(parts[:1]))
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(1), res.DeletedCount)
+	assert.Equal(t, 1, parts[0].deleted)
+}
@@ -0,0 +1,258 @@
+// Package migrate applies and rolls back versioned database migrations, and records the
+// applied versions in a collection of the database.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/softwok/mongo-util/field"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultCollection is the default name of the collection recording the applied migrations.
+const DefaultCollection = "migrations"
+
+// Migration struct contains a migration. Versions are applied in ascending order, and
+// rolled back in descending order. Down may be nil if the migration can't be rolled back.
+type Migration struct {
+	Version     int64
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+}
+
+// Status struct contains the status of a migration.
+type Status struct {
+	Version     int64      `json:"version"`
+	Description string     `json:"description"`
+	Applied     bool       `json:"applied"`
+	AppliedAt   *time.Time `json:"appliedAt,omitempty"`
+}
+
+// record is the document recording an applied migration.
+type record struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrator applies the migrations to a database.
+type Migrator struct {
+	db         *mongo.Database
+	collection string
+	migrations []Migration
+}
+
+// New returns a migrator of the migrations. It returns an error if two migrations have the same version.
+func New(db *mongo.Database, migrations ...Migration) (*Migrator, error) {
+	sorted := append([]Migration{}, migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", sorted[i].Version)
+		}
+	}
+	return &Migrator{db: db, collection: DefaultCollection, migrations: sorted}, nil
+}
+
+// SetCollection sets the name of the collection recording the applied migrations.
+func (m *Migrator) SetCollection(name string) *Migrator {
+	m.collection = name
+	return m
+}
+
+// Migrations returns the migrations sorted by version.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Status returns the status of each migration, and of the applied versions that are
+// not known by the migrator.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Version: mig.Version, Description: mig.Description}
+		if r, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = &r.AppliedAt
+			delete(applied, mig.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	for _, r := range applied {
+		r := r
+		statuses = append(statuses, Status{Version: r.Version, Description: r.Description, Applied: true, AppliedAt: &r.AppliedAt})
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// Up applies the pending migrations up to the target version included, or all of them if target
+// is 0, and returns the applied migrations. It stops at the first failed migration.
+func (m *Migrator) Up(ctx context.Context, target int64) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range m.migrations {
+		if target > 0 && mig.Version > target {
+			break
+		}
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		if mig.Up != nil {
+			if err = mig.Up(ctx, m.db); err != nil {
+				return done, fmt.Errorf("migration %d up: %w", mig.Version, err)
+			}
+		}
+		r := record{Version: mig.Version, Description: mig.Description, AppliedAt: time.Now().UTC()}
+		if _, err = m.db.Collection(m.collection).InsertOne(ctx, r); err != nil {
+			return done, err
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down rolls back the given number of applied migrations, latest first, and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if mig.Down == nil {
+			return done, fmt.Errorf("migration %d can not be rolled back", mig.Version)
+		}
+		if err = mig.Down(ctx, m.db); err != nil {
+			return done, fmt.Errorf("migration %d down: %w", mig.Version, err)
+		}
+		if _, err = m.db.Collection(m.collection).DeleteOne(ctx, bson.M{field.ID: mig.Version}); err != nil {
+			return done, err
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]record, error) {
+	cur, err := m.db.Collection(m.collection).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{field.ID: 1}))
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	if err = cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]record, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.json$`)
+
+// LoadDir loads the migrations of a directory. Each migration has a `<version>_<description>.up.json`
+// file, and optionally a `<version>_<description>.down.json` file, containing an Extended JSON array of
+// database commands run in order (e.g. `[{"createIndexes": "products", "indexes": [...]}]`).
+func LoadDir(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		groups := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || groups == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(groups[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		commands, err := readCommands(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Description: strings.ReplaceAll(groups[2], "_", " ")}
+			byVersion[version] = mig
+		}
+		if groups[3] == "up" {
+			mig.Up = runCommands(commands)
+		} else {
+			mig.Down = runCommands(commands)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == nil {
+			return nil, fmt.Errorf("migration %d has no up file", mig.Version)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func readCommands(path string) ([]bson.D, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var wrapper struct {
+		Commands []bson.D `bson:"commands"`
+	}
+	doc := append(append([]byte(`{"commands": `), data...), '}')
+	if err = bson.UnmarshalExtJSON(doc, false, &wrapper); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return wrapper.Commands, nil
+}
+
+func runCommands(commands []bson.D) func(ctx context.Context, db *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		for _, cmd := range commands {
+			if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestLoadDir(t *testing.T) {
+	migrations, err := LoadDir("testdata")
+	util.AssertErrIsNil(t, err)
+
+	assert.Len(t, migrations, 2)
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "create products", migrations[0].Description)
+	assert.NotNil(t, migrations[0].Up)
+	assert.NotNil(t, migrations[0].Down)
+	assert.Equal(t, int64(2), migrations[1].Version)
+	assert.Nil(t, migrations[1].Down)
+}
+
+func TestLoadDirErrors(t *testing.T) {
+	dir := t.TempDir()
+	util.AssertErrIsNil(t, os.WriteFile(filepath.Join(dir, "3_x.down.json"), []byte(`[]`), 0o644))
+	_, err := LoadDir(dir)
+	assert.EqualError(t, err, "migration 3 has no up file")
+
+	util.AssertErrIsNil(t, os.WriteFile(filepath.Join(dir, "3_x.up.json"), []byte(`{"not": "an array"}`), 0o644))
+	_, err = LoadDir(dir)
+	assert.NotNil(t, err)
+}
+
+func TestNewDuplicateVersion(t *testing.T) {
+	noop := func(ctx context.Context, db *mongo.Database) error { return nil }
+	_, err := New(nil, Migration{Version: 2, Up: noop}, Migration{Version: 1, Up: noop}, Migration{Version: 2, Up: noop})
+	assert.EqualError(t, err, "duplicate migration version 2")
+
+	m, err := New(nil, Migration{Version: 2, Up: noop}, Migration{Version: 1, Up: noop})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(1), m.Migrations()[0].Version)
+}
@@ -24,16 +24,17 @@ type CollectionNameGetter interface {
 type Model interface {
 	// PrepareID converts the id value if needed, then
 	// returns it (e.g.convert string to objectId).
-	PrepareID(id string) (string, error)
+	PrepareID(id interface{}) (interface{}, error)
 
-	GetID() string
-	SetID(id string)
+	GetID() interface{}
+	SetID(id interface{})
 }
 
-// DefaultModel struct contains a model's default fields.
+// DefaultModel struct contains a model's default fields and tracks its changes.
 type DefaultModel struct {
-	IDField    `bson:",inline"`
-	DateFields `bson:",inline"`
+	IDField       `bson:",inline"`
+	DateFields    `bson:",inline"`
+	ChangeTracker `bson:"-" json:"-"`
 }
 
 // DefaultTenantModel struct contains a model's default fields. This is useful for multi tenant systems.
@@ -41,6 +42,7 @@ type DefaultTenantModel struct {
 	IDField       `bson:",inline"`
 	DateFields    `bson:",inline"`
 	TenantIdField `bson:",inline"`
+	ChangeTracker `bson:"-" json:"-"`
 }
 
 // Creating function calls the inner fields' defined hooks
@@ -0,0 +1,70 @@
+package mdu
+
+import (
+	"reflect"
+
+	"github.com/jinzhu/inflection"
+	"github.com/softwok/mongo-util/internal/util"
+)
+
+// NamingStrategy interface contains a method to infer the collection name of a model type.
+// It is not used for models implementing `CollectionNameGetter`.
+type NamingStrategy interface {
+	CollectionName(t reflect.Type) string
+}
+
+// NamingCase is the case of inferred collection names.
+type NamingCase int
+
+const (
+	// CamelCase names are lowerCamelCase, e.g. `purchaseOrders`.
+	CamelCase NamingCase = iota
+	// SnakeCase names are snake_case, e.g. `purchase_orders`.
+	SnakeCase
+	// KebabCase names are kebab-case, e.g. `purchase-orders`.
+	KebabCase
+)
+
+// Naming struct is the default naming strategy. Its zero value converts type names to
+// pluralized lowerCamelCase (`PurchaseOrder` -> `purchaseOrders`).
+type Naming struct {
+	Case NamingCase
+
+	// Singular disables the pluralization of names.
+	Singular bool
+
+	// Prefix and Suffix are added to the names, including the overridden ones
+	// (e.g. a per-environment prefix such as `staging_`).
+	Prefix string
+	Suffix string
+
+	// Overrides maps Go type names (e.g. `PurchaseOrder`) to collection names.
+	Overrides map[string]string
+}
+
+// CollectionName returns the collection name of the model type.
+func (n *Naming) CollectionName(t reflect.Type) string {
+	name, ok := n.Overrides[t.Name()]
+	if !ok {
+		switch n.Case {
+		case SnakeCase:
+			name = util.ToSnakeCase(t.Name())
+		case KebabCase:
+			name = util.ToKebabCase(t.Name())
+		default:
+			name = util.ToLowerCamelCase(t.Name())
+		}
+		if !n.Singular {
+			name = inflection.Plural(name)
+		}
+	}
+	return n.Prefix + name + n.Suffix
+}
+
+// naming returns the configured naming strategy, or the default one.
+func naming() NamingStrategy {
+	if config != nil && config.NamingStrategy != nil {
+		return config.NamingStrategy
+	}
+	return &Naming{}
+}
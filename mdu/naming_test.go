@@ -0,0 +1,42 @@
+package mdu
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNaming(t *testing.T) {
+	typ := reflect.TypeOf(PurchaseOrder{})
+
+	tests := []struct {
+		naming *Naming
+		want   string
+	}{
+		{&Naming{}, "purchaseOrders"},
+		{&Naming{Case: SnakeCase}, "purchase_orders"},
+		{&Naming{Case: KebabCase}, "purchase-orders"},
+		{&Naming{Case: SnakeCase, Singular: true}, "purchase_order"},
+		{&Naming{Case: SnakeCase, Prefix: "staging_", Suffix: "_v2"}, "staging_purchase_orders_v2"},
+		{&Naming{Prefix: "staging_", Overrides: map[string]string{"PurchaseOrder": "po"}}, "staging_po"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.naming.CollectionName(typ))
+	}
+}
+
+func TestCollNameNamingStrategy(t *testing.T) {
+	old := config.NamingStrategy
+	defer func() {
+		config.NamingStrategy = old
+		_ = refreshRegistry()
+	}()
+
+	assert.Equal(t, "purchaseOrders", CollName(&PurchaseOrder{}))
+
+	config.NamingStrategy = &Naming{Case: SnakeCase, Prefix: "test_"}
+	assert.Nil(t, refreshRegistry())
+	assert.Equal(t, "test_purchase_orders", CollName(&PurchaseOrder{}))
+}
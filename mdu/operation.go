@@ -2,14 +2,17 @@ package mdu
 
 import (
 	"context"
+	"errors"
 	"github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func create(ctx context.Context, c *Collection, model Model, opts ...*options.InsertOneOptions) (interface{}, error) {
 	// Call to saving hook
-	if err := beforeCreateHooks(ctx, model); err != nil {
+	if err := BeforeCreateHooks(ctx, model); err != nil {
 		return nil, err
 	}
 
@@ -19,10 +22,16 @@ func create(ctx context.Context, c *Collection, model Model, opts ...*options.In
 		return nil, err
 	}
 
-	// Set new id
-	model.SetID(res.InsertedID.(string))
+	// Set the id generated by the driver, if any.
+	if isZeroID(model.GetID()) {
+		model.SetID(res.InsertedID)
+	}
+
+	if err = TakeSnapshot(model); err != nil {
+		return nil, err
+	}
 
-	err = afterCreateHooks(ctx, model)
+	err = AfterCreateHooks(ctx, model)
 	if err != nil {
 		return nil, err
 	}
@@ -30,27 +39,56 @@ func create(ctx context.Context, c *Collection, model Model, opts ...*options.In
 }
 
 func first(ctx context.Context, c *Collection, filter interface{}, model Model, opts ...*options.FindOneOptions) error {
-	return c.FindOne(ctx, filter, opts...).Decode(model)
+	if err := c.FindOne(ctx, filter, opts...).Decode(model); err != nil {
+		return err
+	}
+	return loaded(ctx, model)
+}
+
+// loaded takes the snapshot of a model decoded from the database, and calls its loaded hook.
+func loaded(ctx context.Context, model interface{}) error {
+	if err := TakeSnapshot(model); err != nil {
+		return err
+	}
+	return AfterLoadHooks(ctx, model)
 }
 
 func update(ctx context.Context, c *Collection, model Model, opts ...*options.UpdateOptions) error {
+	// Nothing changed since the model was loaded, skip the hooks and the update.
+	if upd, err := UpdateDocument(model); err != nil || upd == nil {
+		return err
+	}
+
 	// Call to saving hook
-	if err := beforeUpdateHooks(ctx, model); err != nil {
+	if err := BeforeUpdateHooks(ctx, model); err != nil {
+		return err
+	}
+
+	// The hooks may have changed the model (e.g. updated_at).
+	upd, err := UpdateDocument(model)
+	if err != nil {
 		return err
 	}
 
-	res, err := c.UpdateOne(ctx, bson.M{field.ID: model.GetID()}, bson.M{"$set": model}, opts...)
+	res, err := c.UpdateOne(ctx, bson.M{field.ID: model.GetID()}, upd, opts...)
 
 	if err != nil {
 		return err
 	}
 
-	return afterUpdateHooks(ctx, res, model)
+	// Keep the snapshot unless the document does not exist.
+	if res.MatchedCount+res.UpsertedCount > 0 {
+		if err = TakeSnapshot(model); err != nil {
+			return err
+		}
+	}
+
+	return AfterUpdateHooks(ctx, res, model)
 }
 
 func patch(ctx context.Context, c *Collection, model Model, fields map[string]interface{}, opts ...*options.UpdateOptions) error {
 	// Call to saving hook
-	if err := beforeUpdateHooks(ctx, model); err != nil {
+	if err := BeforeUpdateHooks(ctx, model); err != nil {
 		return err
 	}
 
@@ -60,11 +98,190 @@ func patch(ctx context.Context, c *Collection, model Model, fields map[string]in
 		return err
 	}
 
-	return afterUpdateHooks(ctx, res, model)
+	return AfterUpdateHooks(ctx, res, model)
+}
+
+func applyUpdate(ctx context.Context, c *Collection, model Model, update bson.D, opts ...*options.UpdateOptions) error {
+	if len(update) == 0 {
+		return nil
+	}
+
+	// Call to saving hook
+	if err := BeforeUpdateHooks(ctx, model); err != nil {
+		return err
+	}
+
+	res, err := c.UpdateOne(ctx, bson.M{field.ID: model.GetID()}, update, opts...)
+
+	if err != nil {
+		return err
+	}
+
+	return AfterUpdateHooks(ctx, res, model)
+}
+
+// saveAttempts is the number of times `save` looks up the document, if it was created or deleted
+// by another writer between the lookup and the write.
+const saveAttempts = 3
+
+func save(ctx context.Context, c *Collection, model Model, opts ...*options.UpdateOptions) error {
+	for attempt := 0; attempt < saveAttempts; attempt++ {
+		done, err := trySave(ctx, c, model, opts...)
+		if done || err != nil {
+			return err
+		}
+	}
+	return ErrSaveConflict
+}
+
+// trySave looks up the model's document, calls the create or update hooks, and writes the model only
+// if the document still exists, or still doesn't. It returns false if it wrote nothing for that reason,
+// so the create or update hooks called after the write always match those called before it.
+func trySave(ctx context.Context, c *Collection, model Model, opts ...*options.UpdateOptions) (bool, error) {
+	filter := NaturalKeyFilter(model)
+	naturalKey := filter != nil
+
+	// Find whether the model's document exists, to call the create or update hooks.
+	exists := false
+	if naturalKey {
+		var found struct {
+			ID interface{} `bson:"_id"`
+		}
+		err := c.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{field.ID: 1})).Decode(&found)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return false, err
+		}
+		if exists = err == nil; exists {
+			model.SetID(found.ID)
+		}
+	} else if !isZeroID(model.GetID()) {
+		count, err := c.CountDocuments(ctx, bson.M{field.ID: model.GetID()}, options.Count().SetLimit(1))
+		if err != nil {
+			return false, err
+		}
+		exists = count > 0
+	}
+
+	if exists {
+		if err := BeforeUpdateHooks(ctx, model); err != nil {
+			return false, err
+		}
+		upd, err := SaveDocument(model)
+		if err != nil {
+			return false, err
+		}
+
+		res, err := c.UpdateOne(ctx, bson.M{field.ID: model.GetID()}, upd, options.MergeUpdateOptions(opts...).SetUpsert(false))
+		if err != nil || res.MatchedCount == 0 {
+			return false, err
+		}
+		if err = TakeSnapshot(model); err != nil {
+			return true, err
+		}
+		return true, AfterUpdateHooks(ctx, res, model)
+	}
+
+	if err := PrepareNewID(model); err != nil {
+		return false, err
+	}
+	if err := BeforeCreateHooks(ctx, model); err != nil {
+		return false, err
+	}
+	upd, err := SaveInsertDocument(model)
+	if err != nil {
+		return false, err
+	}
+	if naturalKey {
+		upd = appendSetOnInsert(upd, bson.E{Key: field.ID, Value: model.GetID()})
+	} else {
+		filter = bson.M{field.ID: model.GetID()}
+	}
+
+	res, err := c.UpdateOne(ctx, filter, upd, options.MergeUpdateOptions(opts...).SetUpsert(true))
+	if err != nil || res.UpsertedCount == 0 {
+		return false, err
+	}
+	if err = TakeSnapshot(model); err != nil {
+		return true, err
+	}
+	return true, AfterCreateHooks(ctx, model)
+}
+
+// appendSetOnInsert adds the field to the `$setOnInsert` operator of the update document.
+func appendSetOnInsert(update bson.D, e bson.E) bson.D {
+	for i, op := range update {
+		if op.Key == o.SetOnInsert {
+			update[i].Value = append(op.Value.(bson.D), e)
+			return update
+		}
+	}
+	return append(update, bson.E{Key: o.SetOnInsert, Value: bson.D{e}})
+}
+
+// returned calls the loaded hook of a model decoded from a find and modify operation. Only the
+// document after the modification is the current state, so the snapshot is dropped otherwise.
+func returned(ctx context.Context, model Model, returnDocument *options.ReturnDocument) error {
+	if returnDocument != nil && *returnDocument == options.After {
+		return loaded(ctx, model)
+	}
+	if tracker, ok := model.(Tracker); ok {
+		tracker.SetSnapshot(nil)
+	}
+	return AfterLoadHooks(ctx, model)
+}
+
+func findOneAndUpdate(ctx context.Context, c *Collection, filter interface{}, update interface{}, model Model, opts ...*options.FindOneAndUpdateOptions) error {
+	update, err := HookedUpdate(ctx, model, update)
+	if err != nil {
+		return err
+	}
+
+	if err = c.Collection.FindOneAndUpdate(ctx, filter, update, opts...).Decode(model); err != nil {
+		return err
+	}
+
+	if err = returned(ctx, model, options.MergeFindOneAndUpdateOptions(opts...).ReturnDocument); err != nil {
+		return err
+	}
+
+	// The driver does not report whether the document was modified or upserted.
+	return AfterUpdateHooks(ctx, &mongo.UpdateResult{MatchedCount: 1}, model)
+}
+
+func findOneAndReplace(ctx context.Context, c *Collection, filter interface{}, model Model, opts ...*options.FindOneAndReplaceOptions) error {
+	if err := BeforeUpdateHooks(ctx, model); err != nil {
+		return err
+	}
+
+	if err := c.Collection.FindOneAndReplace(ctx, filter, model, opts...).Decode(model); err != nil {
+		return err
+	}
+
+	if err := returned(ctx, model, options.MergeFindOneAndReplaceOptions(opts...).ReturnDocument); err != nil {
+		return err
+	}
+
+	return AfterUpdateHooks(ctx, &mongo.UpdateResult{MatchedCount: 1}, model)
+}
+
+func findOneAndDelete(ctx context.Context, c *Collection, filter interface{}, model Model, opts ...*options.FindOneAndDeleteOptions) error {
+	if err := BeforeDeleteHooks(ctx, model); err != nil {
+		return err
+	}
+
+	if err := c.Collection.FindOneAndDelete(ctx, filter, opts...).Decode(model); err != nil {
+		return err
+	}
+
+	if err := AfterLoadHooks(ctx, model); err != nil {
+		return err
+	}
+
+	return AfterDeleteHooks(ctx, &mongo.DeleteResult{DeletedCount: 1}, model)
 }
 
 func deleteByID(ctx context.Context, c *Collection, model Model) error {
-	if err := beforeDeleteHooks(ctx, model); err != nil {
+	if err := BeforeDeleteHooks(ctx, model); err != nil {
 		return err
 	}
 	res, err := c.DeleteOne(ctx, bson.M{field.ID: model.GetID()})
@@ -72,5 +289,5 @@ func deleteByID(ctx context.Context, c *Collection, model Model) error {
 		return err
 	}
 
-	return afterDeleteHooks(ctx, res, model)
+	return AfterDeleteHooks(ctx, res, model)
 }
@@ -0,0 +1,367 @@
+package mdu
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/softwok/mongo-util/builder"
+	"github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidPageToken is returned when a keyset pagination token is malformed, was signed
+// with another secret, or was issued for another sort.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// PageOptions struct contains the options of offset pagination.
+type PageOptions struct {
+	Sort       interface{}
+	Projection interface{}
+
+	// Facet fetches the page and counts the documents in a single aggregation
+	// using `$facet`, rather than with a find and a count.
+	Facet bool
+}
+
+// Page struct contains the details of an offset page.
+type Page struct {
+	Page       int64 `json:"page"`
+	PerPage    int64 `json:"perPage"`
+	Total      int64 `json:"total"`
+	TotalPages int64 `json:"totalPages"`
+}
+
+// KeysetOptions struct contains the options of keyset pagination.
+type KeysetOptions struct {
+	// Sort contains the sort keys (e.g. `bson.D{{Key: "price", Value: -1}}`). The `_id` key
+	// is appended as a tiebreaker if missing. The sort keys should not be null or arrays.
+	Sort bson.D
+
+	// Limit is the maximum number of documents of a page.
+	Limit int64
+
+	// After is the token of the previous page, or empty for the first page.
+	After string
+
+	// Projection must include the sort keys.
+	Projection interface{}
+}
+
+// KeysetPage struct contains the details of a keyset page.
+type KeysetPage struct {
+	// Next is the token of the next page, or empty if this is the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// FindPage finds the documents of the page (starting at 1) matching the filter, decodes them into
+// results, and returns the page details including the total number of matching documents.
+func (c *Collection) FindPage(results interface{}, filter interface{}, page, perPage int64, opts ...*PageOptions) (*Page, error) {
+	return findPage(ctx(), c, results, filter, page, perPage, opts...)
+}
+
+func (c *Collection) FindPageWithCtx(ctx context.Context, results interface{}, filter interface{}, page, perPage int64, opts ...*PageOptions) (*Page, error) {
+	return findPage(ctx, c, results, filter, page, perPage, opts...)
+}
+
+func findPage(ctx context.Context, c *Collection, results interface{}, filter interface{}, page, perPage int64, opts ...*PageOptions) (*Page, error) {
+	if page < 1 || perPage < 1 {
+		return nil, errors.New("page and perPage must be positive")
+	}
+	opt := &PageOptions{}
+	for _, pageOpt := range opts {
+		if pageOpt != nil {
+			opt = pageOpt
+		}
+	}
+	if filter == nil {
+		filter = bson.M{}
+	}
+
+	var total int64
+	var docs []bson.Raw
+	if opt.Facet {
+		var err error
+		if docs, total, err = findFacetPage(ctx, c, filter, page, perPage, opt); err != nil {
+			return nil, err
+		}
+	} else {
+		findOpts := options.Find().SetSkip((page - 1) * perPage).SetLimit(perPage)
+		if opt.Sort != nil {
+			findOpts.SetSort(opt.Sort)
+		}
+		if opt.Projection != nil {
+			findOpts.SetProjection(opt.Projection)
+		}
+		cur, err := c.Find(ctx, filter, findOpts)
+		if err != nil {
+			return nil, err
+		}
+		if err = cur.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+		if total, err = c.CountDocuments(ctx, filter); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := decodeResults(ctx, docs, results); err != nil {
+		return nil, err
+	}
+
+	return &Page{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: (total + perPage - 1) / perPage,
+	}, nil
+}
+
+func findFacetPage(ctx context.Context, c *Collection, filter interface{}, page, perPage int64, opt *PageOptions) ([]bson.Raw, int64, error) {
+	items := bson.A{}
+	if opt.Sort != nil {
+		items = append(items, bson.M{o.Sort: opt.Sort})
+	}
+	items = append(items, bson.M{o.Skip: (page - 1) * perPage}, bson.M{o.Limit: perPage})
+	if opt.Projection != nil {
+		items = append(items, bson.M{o.Project: opt.Projection})
+	}
+
+	var result struct {
+		Items []bson.Raw `bson:"items"`
+		Total []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	found, err := c.SimpleAggregateFirstWithCtx(ctx, &result,
+		builder.New(o.Match, filter),
+		builder.New(o.Facet, bson.M{
+			"items": items,
+			"total": bson.A{bson.M{o.Count: "count"}},
+		}),
+	)
+	if err != nil || !found {
+		return nil, 0, err
+	}
+
+	var total int64
+	if len(result.Total) > 0 {
+		total = result.Total[0].Count
+	}
+	return result.Items, total, nil
+}
+
+// FindKeyset finds a page of the documents matching the filter, starting after the
+// page token (see `KeysetOptions`), and decodes them into results. Keyset pagination
+// does not skip documents, so pages are consistent and fast at any depth.
+func (c *Collection) FindKeyset(results interface{}, filter interface{}, opts KeysetOptions) (*KeysetPage, error) {
+	return findKeyset(ctx(), c, results, filter, opts)
+}
+
+func (c *Collection) FindKeysetWithCtx(ctx context.Context, results interface{}, filter interface{}, opts KeysetOptions) (*KeysetPage, error) {
+	return findKeyset(ctx, c, results, filter, opts)
+}
+
+func findKeyset(ctx context.Context, c *Collection, results interface{}, filter interface{}, opts KeysetOptions) (*KeysetPage, error) {
+	if opts.Limit < 1 {
+		return nil, errors.New("limit must be positive")
+	}
+	sortKeys := keysetSort(opts.Sort)
+
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if opts.After != "" {
+		values, err := decodePageToken(opts.After, sortKeys)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{o.And: bson.A{filter, keysetFilter(sortKeys, values)}}
+	}
+
+	findOpts := options.Find().SetSort(sortKeys).SetLimit(opts.Limit + 1)
+	if opts.Projection != nil {
+		findOpts.SetProjection(opts.Projection)
+	}
+	cur, err := c.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.Raw
+	if err = cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	page := &KeysetPage{}
+	if int64(len(docs)) > opts.Limit {
+		docs = docs[:opts.Limit]
+		if page.Next, err = encodePageToken(sortKeys, docs[len(docs)-1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, decodeResults(ctx, docs, results)
+}
+
+// keysetSort returns the sort keys followed by `_id` if missing.
+func keysetSort(sort bson.D) bson.D {
+	keys := append(bson.D{}, sort...)
+	for _, e := range keys {
+		if e.Key == field.ID {
+			return keys
+		}
+	}
+	return append(keys, bson.E{Key: field.ID, Value: 1})
+}
+
+// keysetFilter returns the filter of the documents sorted after the given sort values:
+// `{$or: [{k1: {$gt: v1}}, {k1: v1, k2: {$gt: v2}}, ...]}` (`$lt` for descending keys).
+func keysetFilter(sortKeys bson.D, values bson.A) bson.M {
+	or := bson.A{}
+	for i, key := range sortKeys {
+		cond := bson.D{}
+		for j := 0; j < i; j++ {
+			cond = append(cond, bson.E{Key: sortKeys[j].Key, Value: values[j]})
+		}
+		op := o.Gt
+		if isDescending(key.Value) {
+			op = o.Lt
+		}
+		or = append(or, append(cond, bson.E{Key: key.Key, Value: bson.M{op: values[i]}}))
+	}
+	return bson.M{o.Or: or}
+}
+
+func isDescending(direction interface{}) bool {
+	switch v := direction.(type) {
+	case int:
+		return v < 0
+	case int32:
+		return v < 0
+	case int64:
+		return v < 0
+	case float64:
+		return v < 0
+	}
+	return false
+}
+
+// pageToken is the payload of a keyset pagination token.
+type pageToken struct {
+	Sort   string `bson:"s"`
+	Values bson.A `bson:"v"`
+}
+
+var (
+	pageTokenSecret     []byte
+	pageTokenSecretOnce sync.Once
+)
+
+// tokenSecret returns the configured page token secret, or a random secret for the process.
+func tokenSecret() []byte {
+	if config != nil && len(config.PageTokenSecret) > 0 {
+		return config.PageTokenSecret
+	}
+	pageTokenSecretOnce.Do(func() {
+		pageTokenSecret = make([]byte, 32)
+		if _, err := rand.Read(pageTokenSecret); err != nil {
+			panic(err)
+		}
+	})
+	return pageTokenSecret
+}
+
+func sortSignature(sortKeys bson.D) string {
+	parts := make([]string, len(sortKeys))
+	for i, e := range sortKeys {
+		dir := "1"
+		if isDescending(e.Value) {
+			dir = "-1"
+		}
+		parts[i] = e.Key + ":" + dir
+	}
+	return strings.Join(parts, ",")
+}
+
+// encodePageToken returns the signed token of the sort values of the document.
+func encodePageToken(sortKeys bson.D, doc bson.Raw) (string, error) {
+	token := pageToken{Sort: sortSignature(sortKeys)}
+	for _, key := range sortKeys {
+		val, err := doc.LookupErr(strings.Split(key.Key, ".")...)
+		if err != nil {
+			return "", fmt.Errorf("the sort key %s is missing in the page documents", key.Key)
+		}
+		token.Values = append(token.Values, val)
+	}
+
+	payload, err := bson.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, tokenSecret())
+	mac.Write(payload)
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// decodePageToken verifies the token and returns its sort values.
+func decodePageToken(s string, sortKeys bson.D) (bson.A, error) {
+	enc := base64.RawURLEncoding
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidPageToken
+	}
+	payload, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	sum, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	mac := hmac.New(sha256.New, tokenSecret())
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrInvalidPageToken
+	}
+
+	var token pageToken
+	if err = bson.Unmarshal(payload, &token); err != nil || token.Sort != sortSignature(sortKeys) || len(token.Values) != len(sortKeys) {
+		return nil, ErrInvalidPageToken
+	}
+	return token.Values, nil
+}
+
+// decodeResults decodes the documents into the slice pointed by results,
+// and calls the loaded hooks.
+func decodeResults(ctx context.Context, docs []bson.Raw, results interface{}) error {
+	resultsVal := reflect.ValueOf(results)
+	if resultsVal.Kind() != reflect.Ptr || resultsVal.Elem().Kind() != reflect.Slice {
+		return errors.New("results argument must be a pointer to a slice")
+	}
+
+	sliceVal := reflect.MakeSlice(resultsVal.Elem().Type(), 0, len(docs))
+	for _, doc := range docs {
+		elem := reflect.New(sliceVal.Type().Elem())
+		if err := bson.Unmarshal(doc, elem.Interface()); err != nil {
+			return err
+		}
+		sliceVal = reflect.Append(sliceVal, elem.Elem())
+	}
+	resultsVal.Elem().Set(sliceVal)
+
+	return eachElem(results, func(elem interface{}) error {
+		return loaded(ctx, elem)
+	})
+}
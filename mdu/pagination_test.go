@@ -0,0 +1,57 @@
+package mdu
+
+import (
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/softwok/mongo-util/match"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPageToken(t *testing.T) {
+	sortKeys := keysetSort(bson.D{{Key: "price", Value: -1}, {Key: "meta.rank", Value: 1}})
+	assert.Equal(t, "_id", sortKeys[2].Key)
+
+	oid := primitive.NewObjectID()
+	doc, err := bson.Marshal(bson.D{{Key: "_id", Value: oid}, {Key: "price", Value: 10.5}, {Key: "meta", Value: bson.D{{Key: "rank", Value: int32(3)}}}})
+	util.AssertErrIsNil(t, err)
+
+	token, err := encodePageToken(sortKeys, doc)
+	util.AssertErrIsNil(t, err)
+
+	values, err := decodePageToken(token, sortKeys)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, bson.A{10.5, int32(3), oid}, values)
+
+	_, err = decodePageToken(token, keysetSort(bson.D{{Key: "price", Value: 1}, {Key: "meta.rank", Value: 1}}))
+	assert.Equal(t, ErrInvalidPageToken, err)
+	_, err = decodePageToken("x"+token, sortKeys)
+	assert.Equal(t, ErrInvalidPageToken, err)
+	_, err = decodePageToken("invalid", sortKeys)
+	assert.Equal(t, ErrInvalidPageToken, err)
+
+	_, err = encodePageToken(keysetSort(bson.D{{Key: "name", Value: 1}}), doc)
+	assert.NotNil(t, err)
+}
+
+func TestKeysetFilter(t *testing.T) {
+	sortKeys := keysetSort(bson.D{{Key: "price", Value: -1}})
+	filter, err := match.New(keysetFilter(sortKeys, bson.A{int32(10), int32(2)}))
+	util.AssertErrIsNil(t, err)
+
+	for _, tc := range []struct {
+		price, id int32
+		after     bool
+	}{
+		{20, 1, false},
+		{10, 1, false},
+		{10, 2, false},
+		{10, 3, true},
+		{5, 1, true},
+	} {
+		ok := filter.MatchDoc(bson.D{{Key: "_id", Value: tc.id}, {Key: "price", Value: tc.price}})
+		assert.Equal(t, tc.after, ok, "price %d, id %d", tc.price, tc.id)
+	}
+}
@@ -0,0 +1,344 @@
+package mdu
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/softwok/mongo-util/field"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IndexesGetter interface contains a method to return the indexes of a model's collection.
+// They are created by `EnsureIndexes` for registered models.
+type IndexesGetter interface {
+	Indexes() []mongo.IndexModel
+}
+
+// Hook is the name of a hook interface.
+type Hook string
+
+const (
+	HookCreating Hook = "Creating"
+	HookCreated  Hook = "Created"
+	HookUpdating Hook = "Updating"
+	HookUpdated  Hook = "Updated"
+	HookSaving   Hook = "Saving"
+	HookSaved    Hook = "Saved"
+	HookDeleting Hook = "Deleting"
+	HookDeleted  Hook = "Deleted"
+	HookLoaded   Hook = "Loaded"
+)
+
+var hookInterfaces = []struct {
+	hook  Hook
+	iface reflect.Type
+}{
+	{HookCreating, reflect.TypeOf((*CreatingHook)(nil)).Elem()},
+	{HookCreated, reflect.TypeOf((*CreatedHook)(nil)).Elem()},
+	{HookUpdating, reflect.TypeOf((*UpdatingHook)(nil)).Elem()},
+	{HookUpdated, reflect.TypeOf((*UpdatedHook)(nil)).Elem()},
+	{HookSaving, reflect.TypeOf((*SavingHook)(nil)).Elem()},
+	{HookSaved, reflect.TypeOf((*SavedHook)(nil)).Elem()},
+	{HookDeleting, reflect.TypeOf((*DeletingHook)(nil)).Elem()},
+	{HookDeleted, reflect.TypeOf((*DeletedHook)(nil)).Elem()},
+	{HookLoaded, reflect.TypeOf((*LoadedHook)(nil)).Elem()},
+}
+
+// FieldInfo struct contains the metadata of a persisted model field.
+type FieldInfo struct {
+	// Name is the Go name of the field, e.g. `CreatedAt`.
+	Name string
+
+	// Path is the dotted bson path of the field, e.g. `details.color`.
+	// The fields of inline structs are at the parent level.
+	Path string
+
+	Type      reflect.Type
+	Tag       reflect.StructTag
+	OmitEmpty bool
+}
+
+// ModelInfo struct contains the metadata of a model type.
+type ModelInfo struct {
+	Type       reflect.Type
+	Collection string
+
+	// IDType is the type of the `_id` field, or nil if the model has none.
+	IDType reflect.Type
+
+	// Fields contains the persisted fields, including those of nested and inline structs, in declaration order.
+	Fields []FieldInfo
+
+	// Embedded contains the types of the embedded structs (e.g. `DefaultModel`, `IDField`), recursively.
+	Embedded []reflect.Type
+
+	// Hooks contains the hook interfaces implemented by the model.
+	Hooks []Hook
+
+	// Indexes contains the indexes returned by `IndexesGetter`, if implemented.
+	Indexes []mongo.IndexModel
+
+	// Tracked tells whether the model implements `Tracker`.
+	Tracked bool
+}
+
+// Field returns the metadata of the field at the bson path.
+func (i *ModelInfo) Field(path string) (FieldInfo, bool) {
+	for _, f := range i.Fields {
+		if f.Path == path {
+			return f, true
+		}
+	}
+	return FieldInfo{}, false
+}
+
+// HasHook tells whether the model implements the hook interface.
+func (i *ModelInfo) HasHook(hook Hook) bool {
+	for _, h := range i.Hooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+// Embeds tells whether the model embeds the struct type, directly or not
+// (e.g. `info.Embeds(reflect.TypeOf(mdu.DateFields{}))`).
+func (i *ModelInfo) Embeds(t reflect.Type) bool {
+	for _, e := range i.Embedded {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+var registry = struct {
+	sync.RWMutex
+	infos      map[reflect.Type]*ModelInfo
+	registered map[string]*ModelInfo
+}{
+	infos:      map[reflect.Type]*ModelInfo{},
+	registered: map[string]*ModelInfo{},
+}
+
+// Register builds and caches the metadata of the models, and registers them by collection.
+// It returns an error if two model types map to the same collection. Registering a model
+// again is a no-op.
+func Register(models ...Model) error {
+	infos := make([]*ModelInfo, len(models))
+	for i, m := range models {
+		infos[i] = Lookup(m)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	for _, info := range infos {
+		if other, ok := registry.registered[info.Collection]; ok && other.Type != info.Type {
+			return fmt.Errorf("the models %s and %s map to the same collection %s", other.Type, info.Type, info.Collection)
+		}
+		registry.registered[info.Collection] = info
+	}
+	return nil
+}
+
+// MustRegister is like `Register` but panics on error.
+func MustRegister(models ...Model) {
+	if err := Register(models...); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the metadata of a model type, building and caching it on the first call.
+// The model does not need to be registered.
+func Lookup(m Model) *ModelInfo {
+	t := reflect.TypeOf(m).Elem()
+
+	registry.RLock()
+	info, ok := registry.infos[t]
+	registry.RUnlock()
+	if ok {
+		return info
+	}
+
+	info = buildModelInfo(t)
+
+	registry.Lock()
+	defer registry.Unlock()
+	if cached, ok := registry.infos[t]; ok {
+		return cached
+	}
+	registry.infos[t] = info
+	return info
+}
+
+// Registered returns the metadata of the registered models, sorted by collection.
+func Registered() []*ModelInfo {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	infos := make([]*ModelInfo, 0, len(registry.registered))
+	for _, info := range registry.registered {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Collection < infos[j].Collection
+	})
+	return infos
+}
+
+// RegisteredByCollection returns the metadata of the model registered for the collection.
+func RegisteredByCollection(name string) (*ModelInfo, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	info, ok := registry.registered[name]
+	return info, ok
+}
+
+// ResetRegistry clears the registered models and the cached metadata.
+func ResetRegistry() {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.infos = map[reflect.Type]*ModelInfo{}
+	registry.registered = map[string]*ModelInfo{}
+}
+
+// refreshRegistry clears the cached metadata and rebuilds the metadata of the registered
+// models, e.g. after the naming strategy changed. It returns an error on collisions.
+func refreshRegistry() error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registered := registry.registered
+	registry.infos = map[reflect.Type]*ModelInfo{}
+	registry.registered = map[string]*ModelInfo{}
+
+	var err error
+	for _, old := range registered {
+		info := buildModelInfo(old.Type)
+		registry.infos[info.Type] = info
+		if other, ok := registry.registered[info.Collection]; ok && err == nil {
+			err = fmt.Errorf("the models %s and %s map to the same collection %s", other.Type, info.Type, info.Collection)
+		}
+		registry.registered[info.Collection] = info
+	}
+	return err
+}
+
+// EnsureIndexes creates the indexes of the registered models implementing `IndexesGetter`.
+func EnsureIndexes(ctx context.Context) error {
+	for _, info := range Registered() {
+		if len(info.Indexes) == 0 {
+			continue
+		}
+		if _, err := CollectionByName(info.Collection).Indexes().CreateMany(ctx, info.Indexes); err != nil {
+			return fmt.Errorf("creating the indexes of %s: %w", info.Collection, err)
+		}
+	}
+	return nil
+}
+
+func buildModelInfo(t reflect.Type) *ModelInfo {
+	model := reflect.New(t).Interface().(Model)
+	info := &ModelInfo{
+		Type:       t,
+		Collection: inferCollName(model),
+	}
+
+	ptr := reflect.PtrTo(t)
+	for _, h := range hookInterfaces {
+		if ptr.Implements(h.iface) {
+			info.Hooks = append(info.Hooks, h.hook)
+		}
+	}
+	if getter, ok := model.(IndexesGetter); ok {
+		info.Indexes = getter.Indexes()
+	}
+	_, info.Tracked = model.(Tracker)
+
+	info.walk(t, "", map[reflect.Type]bool{})
+	if f, ok := info.Field(field.ID); ok {
+		info.IDType = f.Type
+	}
+	return info
+}
+
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	marshalerType      = reflect.TypeOf((*bson.Marshaler)(nil)).Elem()
+	valueMarshalerType = reflect.TypeOf((*bson.ValueMarshaler)(nil)).Elem()
+)
+
+// walk appends the fields of the struct type, following the tag rules of the driver's struct codec.
+func (i *ModelInfo) walk(t reflect.Type, prefix string, visiting map[reflect.Type]bool) {
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for n := 0; n < t.NumField(); n++ {
+		sf := t.Field(n)
+		if sf.Anonymous {
+			if ft := indirect(sf.Type); ft.Kind() == reflect.Struct {
+				i.Embedded = append(i.Embedded, ft)
+			}
+		}
+		if !sf.IsExported() && !sf.Anonymous {
+			continue
+		}
+
+		tags, err := bsoncodec.DefaultStructTagParser(sf)
+		if err != nil || tags.Skip {
+			continue
+		}
+
+		ft := indirect(sf.Type)
+		if tags.Inline && ft.Kind() == reflect.Struct {
+			i.walk(ft, prefix, visiting)
+			continue
+		}
+
+		path := prefix + tags.Name
+		i.Fields = append(i.Fields, FieldInfo{
+			Name:      sf.Name,
+			Path:      path,
+			Type:      sf.Type,
+			Tag:       sf.Tag,
+			OmitEmpty: tags.OmitEmpty,
+		})
+
+		// Nested documents, including the elements of arrays, are queried with dotted paths.
+		elem := ft
+		if elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array {
+			elem = indirect(elem.Elem())
+		}
+		if isDocumentStruct(elem) && !visiting[elem] {
+			i.walk(elem, path+".", visiting)
+		}
+	}
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// isDocumentStruct tells whether a type is a struct encoded as an embedded document.
+func isDocumentStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == timeType || strings.HasPrefix(t.PkgPath(), "go.mongodb.org/") {
+		return false
+	}
+	ptr := reflect.PtrTo(t)
+	return !t.Implements(marshalerType) && !ptr.Implements(marshalerType) &&
+		!t.Implements(valueMarshalerType) && !ptr.Implements(valueMarshalerType)
+}
@@ -0,0 +1,100 @@
+package mdu
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type registryLine struct {
+	SKU string `bson:"sku"`
+	Qty int    `bson:"qty,omitempty"`
+}
+
+type registryInvoice struct {
+	DefaultModel `bson:",inline"`
+	Number       string         `json:"number" bson:"number"`
+	Lines        []registryLine `bson:"lines"`
+	Customer     *struct {
+		Name string `bson:"name"`
+	} `bson:"customer"`
+	PaidAt *time.Time `bson:"paid_at,omitempty"`
+	Note   string
+	Secret string `bson:"-"`
+	cache  string
+}
+
+func (i *registryInvoice) Deleting(ctx context.Context) error {
+	return nil
+}
+
+func (i *registryInvoice) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)}}
+}
+
+type registryEvent struct {
+	ObjectIDField `bson:",inline"`
+}
+
+func (e *registryEvent) CollectionName() string {
+	return "registryInvoices"
+}
+
+func TestLookup(t *testing.T) {
+	info := Lookup(&registryInvoice{})
+	assert.Same(t, info, Lookup(&registryInvoice{}))
+
+	assert.Equal(t, "registryInvoices", info.Collection)
+	assert.Equal(t, reflect.TypeOf(""), info.IDType)
+	assert.True(t, info.Tracked)
+	assert.Equal(t, []Hook{HookCreating, HookSaving, HookDeleting}, info.Hooks)
+	assert.True(t, info.HasHook(HookDeleting))
+	assert.False(t, info.HasHook(HookLoaded))
+	assert.Len(t, info.Indexes, 1)
+	assert.True(t, info.Embeds(reflect.TypeOf(DateFields{})))
+	assert.True(t, info.Embeds(reflect.TypeOf(ChangeTracker{})))
+
+	var paths []string
+	for _, f := range info.Fields {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{
+		"_id", "created_at", "updated_at", "number",
+		"lines", "lines.sku", "lines.qty",
+		"customer", "customer.name",
+		"paid_at", "note",
+	}, paths)
+
+	f, ok := info.Field("lines.qty")
+	assert.True(t, ok)
+	assert.Equal(t, "Qty", f.Name)
+	assert.True(t, f.OmitEmpty)
+	f, _ = info.Field("number")
+	assert.Equal(t, "number", f.Tag.Get("json"))
+
+	assert.Equal(t, reflect.TypeOf(primitive.ObjectID{}), Lookup(&registryEvent{}).IDType)
+}
+
+func TestRegister(t *testing.T) {
+	defer ResetRegistry()
+
+	util.AssertErrIsNil(t, Register(&registryInvoice{}, &registryInvoice{}))
+	info, ok := RegisteredByCollection("registryInvoices")
+	assert.True(t, ok)
+	assert.Equal(t, reflect.TypeOf(registryInvoice{}), info.Type)
+
+	err := Register(&registryEvent{})
+	assert.EqualError(t, err, "the models mdu.registryInvoice and mdu.registryEvent map to the same collection registryInvoices")
+	assert.Panics(t, func() {
+		MustRegister(&registryEvent{})
+	})
+	assert.Len(t, Registered(), 1)
+}
@@ -0,0 +1,64 @@
+package mdu
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Repository interface contains the model operations of a `Collection`. Depend on
+// this interface rather than on `*Collection` to be able to replace the collection
+// with an in-memory one (see the `mdutest` package) in unit tests.
+type Repository interface {
+	// Name returns the name of the collection.
+	Name() string
+
+	FindByID(id interface{}, model Model, opts ...*options.FindOneOptions) error
+	FindByIDWithCtx(ctx context.Context, id interface{}, model Model, opts ...*options.FindOneOptions) error
+	First(filter interface{}, model Model, opts ...*options.FindOneOptions) error
+	FirstWithCtx(ctx context.Context, filter interface{}, model Model, opts ...*options.FindOneOptions) error
+	FindAll(results interface{}, filter interface{}, opts ...*options.FindOptions) error
+	FindAllWithCtx(ctx context.Context, results interface{}, filter interface{}, opts ...*options.FindOptions) error
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	Each(filter interface{}, model Model, fn func() error, opts ...*options.FindOptions) error
+	EachWithCtx(ctx context.Context, filter interface{}, model Model, fn func() error, opts ...*options.FindOptions) error
+	Count(filter interface{}, opts ...*options.CountOptions) (int64, error)
+	CountWithCtx(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	Exists(filter interface{}) (bool, error)
+	ExistsWithCtx(ctx context.Context, filter interface{}) (bool, error)
+	EstimatedCount(opts ...*options.EstimatedDocumentCountOptions) (int64, error)
+	EstimatedCountWithCtx(ctx context.Context, opts ...*options.EstimatedDocumentCountOptions) (int64, error)
+	Distinct(ctx context.Context, fieldName string, filter interface{}, opts ...*options.DistinctOptions) ([]interface{}, error)
+
+	Create(model Model, opts ...*options.InsertOneOptions) (interface{}, error)
+	CreateWithCtx(ctx context.Context, model Model, opts ...*options.InsertOneOptions) (interface{}, error)
+	Update(model Model, opts ...*options.UpdateOptions) error
+	UpdateWithCtx(ctx context.Context, model Model, opts ...*options.UpdateOptions) error
+	Patch(model Model, fields map[string]interface{}, opts ...*options.UpdateOptions) error
+	PatchWithCtx(ctx context.Context, model Model, fields map[string]interface{}, opts ...*options.UpdateOptions) error
+	Save(model Model, opts ...*options.UpdateOptions) error
+	SaveWithCtx(ctx context.Context, model Model, opts ...*options.UpdateOptions) error
+	ApplyUpdate(model Model, update bson.D, opts ...*options.UpdateOptions) error
+	ApplyUpdateWithCtx(ctx context.Context, model Model, update bson.D, opts ...*options.UpdateOptions) error
+	FindOneAndUpdate(filter interface{}, update interface{}, model Model, opts ...*options.FindOneAndUpdateOptions) error
+	FindOneAndUpdateWithCtx(ctx context.Context, filter interface{}, update interface{}, model Model, opts ...*options.FindOneAndUpdateOptions) error
+	FindOneAndReplace(filter interface{}, model Model, opts ...*options.FindOneAndReplaceOptions) error
+	FindOneAndReplaceWithCtx(ctx context.Context, filter interface{}, model Model, opts ...*options.FindOneAndReplaceOptions) error
+	FindOneAndDelete(filter interface{}, model Model, opts ...*options.FindOneAndDeleteOptions) error
+	FindOneAndDeleteWithCtx(ctx context.Context, filter interface{}, model Model, opts ...*options.FindOneAndDeleteOptions) error
+	Delete(model Model) error
+	DeleteWithCtx(ctx context.Context, model Model) error
+
+	Bulk() *Bulk
+	CreateMany(models []Model, opts ...*BulkOptions) (*BulkResult, error)
+	CreateManyWithCtx(ctx context.Context, models []Model, opts ...*BulkOptions) (*BulkResult, error)
+	UpdateMany(models []Model, opts ...*BulkOptions) (*BulkResult, error)
+	UpdateManyWithCtx(ctx context.Context, models []Model, opts ...*BulkOptions) (*BulkResult, error)
+	DeleteMany(models []Model, opts ...*BulkOptions) (*BulkResult, error)
+	DeleteManyWithCtx(ctx context.Context, models []Model, opts ...*BulkOptions) (*BulkResult, error)
+}
+
+// Ensure that the Collection implements the Repository interface
+var _ Repository = &Collection{}
@@ -0,0 +1,91 @@
+package mdu
+
+import (
+	"errors"
+
+	"github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrSaveConflict is returned by `Save` when other writers kept creating or deleting the model's
+// document between its lookup and its write. Nothing was written by the last attempt.
+var ErrSaveConflict = errors.New("the document was created or deleted by another writer during the save")
+
+// NaturalKeyGetter interface contains a method to return the filter identifying a model's
+// document by a natural key (e.g. `bson.M{"sku": p.SKU}`). `Save` upserts by this filter
+// rather than by id. A unique index on the key fields is recommended.
+type NaturalKeyGetter interface {
+	NaturalKey() bson.M
+}
+
+// CreateOnlyFieldsGetter interface contains a method to return the bson names of the top
+// level fields that are only written when a model is created by `Save`, in addition to `created_at`.
+type CreateOnlyFieldsGetter interface {
+	CreateOnlyFields() []string
+}
+
+// NaturalKeyFilter returns the model's natural key, or nil if the model does not
+// implement `NaturalKeyGetter` or its key is empty.
+func NaturalKeyFilter(model Model) bson.M {
+	if getter, ok := model.(NaturalKeyGetter); ok {
+		if key := getter.NaturalKey(); len(key) > 0 {
+			return key
+		}
+	}
+	return nil
+}
+
+// SaveDocument returns the update document used by `Save`: a `$set` of the model's fields
+// and a `$setOnInsert` of its creation-only fields. The `_id` field is not included.
+func SaveDocument(model Model) (bson.D, error) {
+	doc, err := toDoc(model)
+	if err != nil {
+		return nil, err
+	}
+
+	createOnly := map[string]bool{CreatedAtField: true}
+	if getter, ok := model.(CreateOnlyFieldsGetter); ok {
+		for _, name := range getter.CreateOnlyFields() {
+			createOnly[name] = true
+		}
+	}
+
+	set, setOnInsert := bson.D{}, bson.D{}
+	for _, e := range doc {
+		switch {
+		case e.Key == field.ID:
+		case createOnly[e.Key]:
+			setOnInsert = append(setOnInsert, e)
+		default:
+			set = append(set, e)
+		}
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: o.Set, Value: set})
+	}
+	for _, e := range setOnInsert {
+		update = appendSetOnInsert(update, e)
+	}
+	return update, nil
+}
+
+// SaveInsertDocument returns the update document used by `Save` when no document was found:
+// a `$setOnInsert` of all the model's fields, so that nothing is written if another writer
+// created the document in the meantime. The `_id` field is not included.
+func SaveInsertDocument(model Model) (bson.D, error) {
+	update, err := SaveDocument(model)
+	if err != nil {
+		return nil, err
+	}
+
+	insert := bson.D{}
+	for _, op := range update {
+		for _, e := range op.Value.(bson.D) {
+			insert = appendSetOnInsert(insert, e)
+		}
+	}
+	return insert, nil
+}
@@ -0,0 +1,112 @@
+package mdu
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/softwok/mongo-util/field"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// CreatedAtField is the bson name of the `DateFields.CreatedAt` field. Updates never modify it.
+const CreatedAtField = "created_at"
+
+// Tracker interface is implemented by models that keep a snapshot of their last loaded or
+// saved state, so that `Update` only persists the changed fields. Embed `ChangeTracker`
+// (or `DefaultModel`) to implement it.
+type Tracker interface {
+	Snapshot() bson.Raw
+	SetSnapshot(snapshot bson.Raw)
+}
+
+// ChangeTracker struct keeps the snapshot of a model. It is not persisted.
+type ChangeTracker struct {
+	snapshot bson.Raw
+}
+
+// Snapshot returns the model's last loaded or saved state, or nil.
+func (t *ChangeTracker) Snapshot() bson.Raw {
+	return t.snapshot
+}
+
+// SetSnapshot sets the model's last loaded or saved state.
+func (t *ChangeTracker) SetSnapshot(snapshot bson.Raw) {
+	t.snapshot = snapshot
+}
+
+// TakeSnapshot sets the snapshot of a model implementing `Tracker` to its current state.
+// Other models are ignored.
+func TakeSnapshot(model interface{}) error {
+	tracker, ok := model.(Tracker)
+	if !ok {
+		return nil
+	}
+	raw, err := bson.Marshal(model)
+	if err != nil {
+		return err
+	}
+	tracker.SetSnapshot(raw)
+	return nil
+}
+
+// TakeSnapshots calls `TakeSnapshot` for each element of the slice pointed by results.
+func TakeSnapshots(results interface{}) error {
+	return eachElem(results, TakeSnapshot)
+}
+
+// eachElem calls fn with a pointer to each element of the slice pointed by results.
+func eachElem(results interface{}, fn func(elem interface{}) error) error {
+	val := reflect.ValueOf(results)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
+		return errors.New("results argument must be a pointer to a slice")
+	}
+
+	slice := val.Elem()
+	for i := 0; i < slice.Len(); i++ {
+		elem := slice.Index(i)
+		if elem.Kind() != reflect.Ptr {
+			elem = elem.Addr()
+		} else if elem.IsNil() {
+			continue
+		}
+		if err := fn(elem.Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateDocument returns the update document persisting the model's changes. Models with a
+// snapshot get a `$set`/`$unset` of the changed paths, or nil when nothing changed. Other
+// models get a `$set` of all their fields. The `_id` and `created_at` fields are never updated.
+func UpdateDocument(model Model) (bson.D, error) {
+	doc, err := toDoc(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot bson.Raw
+	if tracker, ok := model.(Tracker); ok {
+		snapshot = tracker.Snapshot()
+	}
+
+	if snapshot == nil {
+		set := bson.D{}
+		for _, e := range doc {
+			if e.Key != field.ID && e.Key != CreatedAtField {
+				set = append(set, e)
+			}
+		}
+		return bson.D{{Key: o.Set, Value: set}}, nil
+	}
+
+	var old bson.D
+	if err = bson.Unmarshal(snapshot, &old); err != nil {
+		return nil, err
+	}
+
+	d := &differ{}
+	d.docs("", old, doc)
+	return d.update(), nil
+}
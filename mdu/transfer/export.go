@@ -0,0 +1,222 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportOptions struct contains the options of an export.
+type ExportOptions struct {
+	// Format of the output, `JSONLines` by default.
+	Format Format
+
+	// Filter, Projection and Sort are the query documents of the exported documents.
+	Filter     interface{}
+	Projection interface{}
+	Sort       interface{}
+
+	// Limit is the maximum number of exported documents; all of them if 0.
+	Limit int64
+
+	// Fields are the dotted paths of the CSV columns. By default, they are the flattened
+	// fields of the first document, so fields missing from it are not exported.
+	Fields []string
+}
+
+// Export writes the documents of the source to w in the given format, one at a time, and
+// returns the number of written documents.
+//
+// CSV cells contain the string representation of scalars: dates in RFC 3339 format, ObjectIDs
+// in hex, and null values as empty cells. Arrays and other values are written as relaxed
+// Extended JSON.
+func Export(ctx context.Context, src Source, w io.Writer, opts *ExportOptions) (int64, error) {
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+	format := opts.Format
+	if format == "" {
+		format = JSONLines
+	}
+	if err := format.valid(); err != nil {
+		return 0, err
+	}
+
+	filter := opts.Filter
+	if filter == nil {
+		filter = bson.D{}
+	}
+	findOpts := options.Find()
+	if opts.Projection != nil {
+		findOpts.SetProjection(opts.Projection)
+	}
+	if opts.Sort != nil {
+		findOpts.SetSort(opts.Sort)
+	}
+	if opts.Limit > 0 {
+		findOpts.SetLimit(opts.Limit)
+	}
+
+	cur, err := src.Find(ctx, filter, findOpts)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	bw := bufio.NewWriter(w)
+	enc := newEncoder(format, bw, opts.Fields)
+
+	var n int64
+	for cur.Next(ctx) {
+		if err = enc.encode(cur.Current); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err = cur.Err(); err != nil {
+		return n, err
+	}
+	if err = enc.close(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+type encoder struct {
+	format Format
+	w      *bufio.Writer
+	csv    *csv.Writer
+	fields []string
+	n      int
+}
+
+func newEncoder(format Format, w *bufio.Writer, fields []string) *encoder {
+	e := &encoder{format: format, w: w, fields: fields}
+	if format == CSV {
+		e.csv = csv.NewWriter(w)
+	}
+	return e
+}
+
+func (e *encoder) encode(doc bson.Raw) error {
+	defer func() {
+		e.n++
+	}()
+
+	switch e.format {
+	case ExtJSON:
+		data, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return err
+		}
+		sep := ",\n"
+		if e.n == 0 {
+			sep = "[\n"
+		}
+		e.w.WriteString(sep)
+		_, err = e.w.Write(data)
+		return err
+	case CSV:
+		if e.n == 0 {
+			if e.fields == nil {
+				e.fields = flatten(doc, "", nil)
+			}
+			if err := e.csv.Write(e.fields); err != nil {
+				return err
+			}
+		}
+		row := make([]string, len(e.fields))
+		for i, path := range e.fields {
+			v, err := doc.LookupErr(strings.Split(path, ".")...)
+			if err == nil {
+				row[i] = formatCell(v)
+			}
+		}
+		return e.csv.Write(row)
+	default:
+		data, err := bson.MarshalExtJSON(doc, false, false)
+		if err != nil {
+			return err
+		}
+		e.w.Write(data)
+		return e.w.WriteByte('\n')
+	}
+}
+
+func (e *encoder) close() error {
+	switch e.format {
+	case ExtJSON:
+		if e.n == 0 {
+			_, err := e.w.WriteString("[]\n")
+			return err
+		}
+		_, err := e.w.WriteString("\n]\n")
+		return err
+	case CSV:
+		if e.n == 0 && e.fields != nil {
+			e.csv.Write(e.fields)
+		}
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
+
+// flatten returns the dotted paths of the fields of the document, recursing into embedded documents.
+func flatten(doc bson.Raw, prefix string, paths []string) []string {
+	elems, _ := doc.Elements()
+	for _, elem := range elems {
+		path := prefix + elem.Key()
+		if sub, ok := elem.Value().DocumentOK(); ok {
+			if subElems, _ := sub.Elements(); len(subElems) > 0 {
+				paths = flatten(sub, path+".", paths)
+				continue
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// formatCell returns the CSV representation of a value, which `Import` parses back to the same type.
+func formatCell(v bson.RawValue) string {
+	switch v.Type {
+	case bsontype.String:
+		return v.StringValue()
+	case bsontype.Int32:
+		return strconv.FormatInt(int64(v.Int32()), 10)
+	case bsontype.Int64:
+		return strconv.FormatInt(v.Int64(), 10)
+	case bsontype.Double:
+		f := v.Double()
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		if !math.IsInf(f, 0) && !math.IsNaN(f) && !strings.Contains(s, ".") {
+			// Keep the double type on import.
+			s += ".0"
+		}
+		return s
+	case bsontype.Boolean:
+		return strconv.FormatBool(v.Boolean())
+	case bsontype.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case bsontype.ObjectID:
+		return v.ObjectID().Hex()
+	case bsontype.Null, bsontype.Undefined:
+		return ""
+	}
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: v}}, false, false)
+	if err != nil {
+		return ""
+	}
+	// Strip the `{"v":` wrapper.
+	return string(data[5 : len(data)-1])
+}
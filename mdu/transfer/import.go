@@ -0,0 +1,309 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/softwok/mongo-util/mdu"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ImportOptions struct contains the options of an import.
+type ImportOptions struct {
+	// Format of the input, `JSONLines` by default.
+	Format Format
+
+	// BatchSize is the number of documents inserted by a single bulk write (`DefaultBatchSize` by default).
+	BatchSize int
+
+	// Model, if set, is the model the documents are decoded to. They are inserted using
+	// `mdu.Bulk.Create`, so the model's creating, created, saving and saved hooks are called.
+	// Otherwise the documents are inserted as they are.
+	Model mdu.Model
+}
+
+// Import reads the documents of r in the given format and inserts them into the target in
+// batches, and returns the number of inserted documents. It stops at the first batch with a
+// failed document, and returns an `*mdu.BulkError` indexed by the position of the documents
+// in the input.
+//
+// The types of CSV cells are inferred: integers, decimal numbers, booleans, RFC 3339 dates,
+// ObjectID hex strings and Extended JSON arrays and documents are parsed, and empty cells are
+// skipped. Other cells, and numbers with leading zeros, are strings. Dotted column names are
+// inserted as embedded documents.
+func Import(ctx context.Context, dst Target, r io.Reader, opts *ImportOptions) (int64, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	format := opts.Format
+	if format == "" {
+		format = JSONLines
+	}
+	if err := format.valid(); err != nil {
+		return 0, err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	var modelType reflect.Type
+	if opts.Model != nil {
+		modelType = reflect.TypeOf(opts.Model).Elem()
+	}
+
+	dec, err := newDecoder(format, r)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		inserted int64
+		offset   int
+		bulk     = mdu.NewBulk(dst)
+	)
+	flush := func() error {
+		if bulk.Len() == 0 {
+			return nil
+		}
+		res, err := bulk.RunWithCtx(ctx)
+		if res != nil {
+			inserted += res.InsertedCount
+		}
+		var bulkErr *mdu.BulkError
+		if errors.As(err, &bulkErr) {
+			shifted := make(map[int]error, len(bulkErr.Errors))
+			for i, e := range bulkErr.Errors {
+				shifted[offset+i] = e
+			}
+			err = &mdu.BulkError{Errors: shifted}
+		}
+		offset += bulk.Len()
+		bulk = mdu.NewBulk(dst)
+		return err
+	}
+
+	for {
+		doc, err := dec.decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return inserted, err
+		}
+
+		if modelType == nil {
+			bulk.Write(mongo.NewInsertOneModel().SetDocument(doc))
+		} else {
+			m := reflect.New(modelType).Interface().(mdu.Model)
+			data, err := bson.Marshal(doc)
+			if err != nil {
+				return inserted, err
+			}
+			if err = bson.Unmarshal(data, m); err != nil {
+				return inserted, fmt.Errorf("transfer: document %d: %w", offset+bulk.Len(), err)
+			}
+			bulk.Create(m)
+		}
+
+		if bulk.Len() == batchSize {
+			if err = flush(); err != nil {
+				return inserted, err
+			}
+		}
+	}
+	return inserted, flush()
+}
+
+type decoder struct {
+	format Format
+	lines  *bufio.Scanner
+	line   int
+	json   *json.Decoder
+	array  bool
+	csv    *csv.Reader
+	header []string
+}
+
+func newDecoder(format Format, r io.Reader) (*decoder, error) {
+	d := &decoder{format: format}
+	switch format {
+	case ExtJSON:
+		br := bufio.NewReader(r)
+		d.json = json.NewDecoder(br)
+		// An array of documents, or a stream of documents.
+		for {
+			b, err := br.Peek(1)
+			if err != nil {
+				return d, nil
+			}
+			if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+				br.ReadByte()
+				continue
+			}
+			if b[0] == '[' {
+				d.array = true
+				if _, err = d.json.Token(); err != nil {
+					return nil, err
+				}
+			}
+			return d, nil
+		}
+	case CSV:
+		d.csv = csv.NewReader(r)
+		header, err := d.csv.Read()
+		if err == io.EOF {
+			return d, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = checkHeader(header); err != nil {
+			return nil, err
+		}
+		d.header = header
+	default:
+		d.lines = bufio.NewScanner(r)
+		d.lines.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	}
+	return d, nil
+}
+
+// decode returns the next document, or io.EOF at the end of the input.
+func (d *decoder) decode() (bson.D, error) {
+	var doc bson.D
+	switch d.format {
+	case ExtJSON:
+		if d.array && !d.json.More() {
+			return nil, io.EOF
+		}
+		var raw json.RawMessage
+		if err := d.json.Decode(&raw); err != nil {
+			return nil, err
+		}
+		if err := bson.UnmarshalExtJSON(raw, false, &doc); err != nil {
+			return nil, err
+		}
+	case CSV:
+		if d.header == nil {
+			return nil, io.EOF
+		}
+		row, err := d.csv.Read()
+		if err != nil {
+			return nil, err
+		}
+		for i, cell := range row {
+			if cell != "" && i < len(d.header) {
+				doc = setPath(doc, strings.Split(d.header[i], "."), inferCell(cell))
+			}
+		}
+	default:
+		for {
+			if !d.lines.Scan() {
+				if err := d.lines.Err(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			d.line++
+			if data := bytes.TrimSpace(d.lines.Bytes()); len(data) > 0 {
+				if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+					return nil, fmt.Errorf("transfer: line %d: %w", d.line, err)
+				}
+				break
+			}
+		}
+	}
+	return doc, nil
+}
+
+// checkHeader returns an error if two CSV columns have the same path, or if the path of a column
+// is in the embedded document of another one (e.g. `a` and `a.b`), as they would set the same field.
+func checkHeader(header []string) error {
+	seen := map[string]bool{}
+	for _, column := range header {
+		if seen[column] {
+			return fmt.Errorf("transfer: duplicate CSV column %q", column)
+		}
+		seen[column] = true
+	}
+	for _, column := range header {
+		path := strings.Split(column, ".")
+		for i := 1; i < len(path); i++ {
+			if prefix := strings.Join(path[:i], "."); seen[prefix] {
+				return fmt.Errorf("transfer: the CSV column %q overlaps the column %q", column, prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// setPath sets the value of a dotted path in the document, creating the embedded documents.
+func setPath(doc bson.D, path []string, v interface{}) bson.D {
+	if len(path) == 1 {
+		return append(doc, bson.E{Key: path[0], Value: v})
+	}
+	for i, e := range doc {
+		if e.Key == path[0] {
+			if sub, ok := e.Value.(bson.D); ok {
+				doc[i].Value = setPath(sub, path[1:], v)
+				return doc
+			}
+		}
+	}
+	return append(doc, bson.E{Key: path[0], Value: setPath(nil, path[1:], v)})
+}
+
+var (
+	intCell   = regexp.MustCompile(`^-?(0|[1-9][0-9]*)$`)
+	floatCell = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
+
+// inferCell returns the typed value of a CSV cell.
+func inferCell(s string) interface{} {
+	switch {
+	case s == "true" || s == "false":
+		return s == "true"
+	case intCell.MatchString(s):
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			if n == int64(int32(n)) {
+				return int32(n)
+			}
+			return n
+		}
+	case floatCell.MatchString(s):
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case s[0] == '{' || s[0] == '[':
+		var wrapper struct {
+			V bson.RawValue `bson:"v"`
+		}
+		if err := bson.UnmarshalExtJSON([]byte(`{"v":`+s+`}`), false, &wrapper); err == nil {
+			return wrapper.V
+		}
+	case len(s) == 24:
+		if id, err := primitive.ObjectIDFromHex(s); err == nil {
+			return id
+		}
+	}
+	if len(s) >= 20 && s[4] == '-' {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+	}
+	return s
+}
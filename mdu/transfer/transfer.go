@@ -0,0 +1,59 @@
+// Package transfer streams the documents of collections to and from files in JSON Lines,
+// canonical Extended JSON and CSV formats, to move data between environments.
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultBatchSize is the default number of documents inserted by a single bulk write during imports.
+const DefaultBatchSize = 1000
+
+// Format is the file format of exported and imported documents.
+type Format string
+
+const (
+	// JSONLines writes one relaxed Extended JSON document per line.
+	JSONLines Format = "jsonl"
+	// ExtJSON writes an array of canonical Extended JSON documents, which preserves all bson types.
+	ExtJSON Format = "json"
+	// CSV writes a header of dotted field paths, and a row per document.
+	CSV Format = "csv"
+)
+
+// FormatOf returns the format of a file by its extension (`.jsonl`, `.ndjson`, `.json` or `.csv`).
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return JSONLines, nil
+	case ".json":
+		return ExtJSON, nil
+	case ".csv":
+		return CSV, nil
+	}
+	return "", fmt.Errorf("transfer: unknown format of file %s", path)
+}
+
+func (f Format) valid() error {
+	switch f {
+	case JSONLines, ExtJSON, CSV:
+		return nil
+	}
+	return fmt.Errorf("transfer: unsupported format %q", f)
+}
+
+// Source is implemented by `mdu.Collection` and `mdutest.Collection`.
+type Source interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+}
+
+// Target is implemented by `mdu.Collection` and `mdutest.Collection`.
+type Target interface {
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
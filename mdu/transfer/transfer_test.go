@@ -0,0 +1,225 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/softwok/mongo-util/mdu"
+	"github.com/softwok/mongo-util/mdu/mdutest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type product struct {
+	mdu.DefaultModel `bson:",inline"`
+	Name             string `bson:"name"`
+	Price            int    `bson:"price"`
+}
+
+func (p *product) Saving(ctx context.Context) error {
+	if p.Price < 0 {
+		return errors.New("price must be positive")
+	}
+	return nil
+}
+
+var (
+	oid     = primitive.NewObjectID()
+	created = time.Date(2023, 4, 5, 6, 7, 8, 9000000, time.UTC)
+)
+
+func seed(t *testing.T) *mdutest.Collection {
+	coll := mdutest.NewCollection("items")
+	docs := []interface{}{
+		bson.D{
+			{Key: "_id", Value: oid},
+			{Key: "name", Value: "apple"},
+			{Key: "code", Value: "007"},
+			{Key: "qty", Value: int32(3)},
+			{Key: "big", Value: int64(1) << 40},
+			{Key: "price", Value: 1.0},
+			{Key: "active", Value: true},
+			{Key: "created_at", Value: created},
+			{Key: "tags", Value: bson.A{"red", "fruit"}},
+			{Key: "words", Value: bson.A{"alpha", "beta", "gamma"}},
+			{Key: "details", Value: bson.D{{Key: "color", Value: "red"}, {Key: "size", Value: bson.D{{Key: "w", Value: int32(2)}}}}},
+		},
+		bson.D{{Key: "_id", Value: "second"}, {Key: "name", Value: "pear"}, {Key: "qty", Value: int32(1)}},
+	}
+	var writes []mongo.WriteModel
+	for _, doc := range docs {
+		writes = append(writes, mongo.NewInsertOneModel().SetDocument(doc))
+	}
+	_, err := coll.BulkWrite(context.Background(), writes)
+	util.AssertErrIsNil(t, err)
+	return coll
+}
+
+func find(t *testing.T, coll *mdutest.Collection, id interface{}) bson.M {
+	cur, err := coll.Find(context.Background(), bson.M{"_id": id})
+	util.AssertErrIsNil(t, err)
+	var docs []bson.M
+	util.AssertErrIsNil(t, cur.All(context.Background(), &docs))
+	if !assert.Len(t, docs, 1) {
+		return nil
+	}
+	return docs[0]
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	for _, format := range []Format{JSONLines, ExtJSON, CSV} {
+		src := seed(t)
+		var buf bytes.Buffer
+		n, err := Export(ctx, src, &buf, &ExportOptions{Format: format, Sort: bson.M{"name": 1}})
+		util.AssertErrIsNil(t, err)
+		assert.Equal(t, int64(2), n, format)
+
+		dst := mdutest.NewCollection("items")
+		n, err = Import(ctx, dst, &buf, &ImportOptions{Format: format, BatchSize: 1})
+		util.AssertErrIsNil(t, err)
+		assert.Equal(t, int64(2), n, format)
+
+		doc := find(t, dst, oid)
+		assert.Equal(t, "apple", doc["name"], format)
+		assert.Equal(t, "007", doc["code"], format)
+		assert.Equal(t, int32(3), doc["qty"], format)
+		assert.Equal(t, int64(1)<<40, doc["big"], format)
+		assert.Equal(t, 1.0, doc["price"], format)
+		assert.Equal(t, true, doc["active"], format)
+		assert.Equal(t, primitive.NewDateTimeFromTime(created), doc["created_at"], format)
+		assert.Equal(t, bson.A{"red", "fruit"}, doc["tags"], format)
+		// The CSV cell of words has the length of an ObjectID hex string.
+		assert.Equal(t, bson.A{"alpha", "beta", "gamma"}, doc["words"], format)
+		assert.Equal(t, bson.M{"color": "red", "size": bson.M{"w": int32(2)}}, doc["details"], format)
+
+		second := find(t, dst, "second")
+		assert.Equal(t, "pear", second["name"], format)
+		// CSV columns are the fields of the first document.
+		_, hasCode := second["code"]
+		assert.False(t, hasCode, format)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := Export(context.Background(), seed(t), &buf, &ExportOptions{
+		Format: CSV,
+		Sort:   bson.M{"name": 1},
+		Fields: []string{"_id", "name", "details", "details.size.w", "tags", "missing"},
+	})
+	util.AssertErrIsNil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		"_id,name,details,details.size.w,tags,missing",
+		oid.Hex() + `,apple,"{""color"":""red"",""size"":{""w"":2}}",2,"[""red"",""fruit""]",`,
+		"second,pear,,,,",
+	}, lines)
+}
+
+func TestExportEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := Export(context.Background(), mdutest.NewCollection("items"), &buf, &ExportOptions{Format: ExtJSON})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(0), n)
+	assert.Equal(t, "[]\n", buf.String())
+}
+
+func TestImportModelHooks(t *testing.T) {
+	coll := mdutest.Coll(&product{})
+	input := `{"name": "apple", "price": 1}
+{"name": "pear", "price": 2}
+
+{"name": "bad", "price": -1}
+{"name": "plum", "price": 4}
+`
+	n, err := Import(context.Background(), coll, strings.NewReader(input), &ImportOptions{Model: &product{}, BatchSize: 2})
+	assert.Equal(t, int64(2), n)
+	var bulkErr *mdu.BulkError
+	assert.True(t, errors.As(err, &bulkErr))
+	assert.Equal(t, "price must be positive", bulkErr.Errors[2].Error())
+	assert.Equal(t, mdu.ErrNotExecuted, bulkErr.Errors[3])
+
+	var products []product
+	util.AssertErrIsNil(t, coll.FindAll(&products, bson.M{}))
+	assert.Len(t, products, 2)
+	for _, p := range products {
+		assert.NotEmpty(t, p.ID)
+		assert.False(t, p.CreatedAt.IsZero())
+	}
+}
+
+func TestImportExtJSONStream(t *testing.T) {
+	coll := mdutest.NewCollection("items")
+	input := `{"_id": 1, "n": {"$numberLong": "5"}} {"_id": 2, "d": {"$date": "2023-01-01T00:00:00Z"}}`
+	n, err := Import(context.Background(), coll, strings.NewReader(input), &ImportOptions{Format: ExtJSON})
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, int64(2), n)
+
+	assert.Equal(t, int64(5), find(t, coll, int32(1))["n"])
+}
+
+func TestImportErrors(t *testing.T) {
+	ctx := context.Background()
+	coll := mdutest.NewCollection("items")
+
+	_, err := Import(ctx, coll, strings.NewReader("{}\n{bad\n"), nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+
+	_, err = Import(ctx, coll, strings.NewReader(""), &ImportOptions{Format: "xml"})
+	assert.NotNil(t, err)
+
+	_, err = Import(ctx, coll, strings.NewReader("name,price,name\nx,1,y\n"), &ImportOptions{Format: CSV})
+	assert.EqualError(t, err, `transfer: duplicate CSV column "name"`)
+
+	_, err = Import(ctx, coll, strings.NewReader("details.color,details\nred,x\n"), &ImportOptions{Format: CSV})
+	assert.EqualError(t, err, `transfer: the CSV column "details.color" overlaps the column "details"`)
+	assert.Equal(t, 0, coll.Len())
+}
+
+func TestInferCell(t *testing.T) {
+	tests := []struct {
+		cell string
+		want interface{}
+	}{
+		{"12", int32(12)},
+		{"-12", int32(-12)},
+		{"9999999999", int64(9999999999)},
+		{"007", "007"},
+		{"1.5", 1.5},
+		{"1e3", 1000.0},
+		{"true", true},
+		{"True", "True"},
+		{"NaN", "NaN"},
+		{oid.Hex(), oid},
+		{"abcdefabcdefabcdefabcdeg", "abcdefabcdefabcdefabcdeg"},
+		{"2023-04-05T06:07:08.009Z", created},
+		{"2023-04-05", "2023-04-05"},
+		{"{not json", "{not json"},
+		{"hello", "hello"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, inferCell(tt.cell), tt.cell)
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for path, want := range map[string]Format{"a.jsonl": JSONLines, "a.NDJSON": JSONLines, "a.json": ExtJSON, "a.csv": CSV} {
+		format, err := FormatOf(path)
+		util.AssertErrIsNil(t, err)
+		assert.Equal(t, want, format)
+	}
+	_, err := FormatOf("a.xml")
+	assert.NotNil(t, err)
+}
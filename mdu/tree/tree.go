@@ -0,0 +1,334 @@
+// Package tree queries and maintains hierarchies of documents in which each document
+// references its parent, using `$graphLookup`. Trees can also maintain a materialized path
+// of the ancestors of each document, to find subtrees with an indexed prefix query.
+package tree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/softwok/mongo-util/builder"
+	f "github.com/softwok/mongo-util/field"
+	"github.com/softwok/mongo-util/mdu"
+	o "github.com/softwok/mongo-util/operator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultParentField is the default field referencing the parent of a document.
+	DefaultParentField = "parent_id"
+
+	// Separator separates the ids of materialized paths, e.g. `,root,child,`.
+	// The path of root documents is the separator alone.
+	Separator = ","
+)
+
+// Temporary fields of the pipelines.
+const (
+	nodesField = "__nodes"
+	depthField = "__depth"
+)
+
+// ErrCycle is returned when a document is moved under itself or one of its descendants.
+var ErrCycle = errors.New("tree: a document can not be moved under itself or its descendants")
+
+// ErrNotFound is returned when a document of the tree does not exist.
+var ErrNotFound = errors.New("tree: document not found")
+
+// Collection is implemented by `mdu.Collection` and `mdutest.Collection`.
+type Collection interface {
+	Name() string
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+// Options struct contains the fields of a tree.
+type Options struct {
+	// ParentField is the field referencing the parent id, `DefaultParentField` by default.
+	ParentField string
+
+	// PathField is the field of the materialized path of the document, e.g. `,root,child,`
+	// for a grandchild of root. Paths are not maintained if it is empty.
+	PathField string
+}
+
+// Tree queries and maintains the hierarchy of the documents of a collection.
+type Tree struct {
+	coll        Collection
+	parentField string
+	pathField   string
+}
+
+// New returns a tree of the collection's documents.
+func New(coll Collection, opts *Options) *Tree {
+	t := &Tree{coll: coll, parentField: DefaultParentField}
+	if opts != nil {
+		if opts.ParentField != "" {
+			t.parentField = opts.ParentField
+		}
+		t.pathField = opts.PathField
+	}
+	return t
+}
+
+// Ancestors decodes the ancestors of the document into results, which must be a pointer
+// to a slice, from the root to the parent.
+func (t *Tree) Ancestors(ctx context.Context, id interface{}, results interface{}) error {
+	return t.aggregate(ctx, results,
+		builder.New(o.Match, bson.M{f.ID: id}),
+		builder.GraphLookup(builder.GraphLookupOptions{
+			From:             t.coll.Name(),
+			StartWith:        "$" + t.parentField,
+			ConnectFromField: t.parentField,
+			ConnectToField:   f.ID,
+			As:               nodesField,
+			DepthField:       depthField,
+		}),
+		builder.Unwind("$"+nodesField, nil, nil),
+		bson.M{o.Sort: bson.D{{Key: nodesField + "." + depthField, Value: -1}}},
+		builder.ReplaceRoot("$"+nodesField),
+		bson.M{o.Project: bson.M{depthField: 0}},
+	)
+}
+
+// Descendants decodes the descendants of the document into results, which must be a pointer
+// to a slice, level by level. The depth limits the number of levels, e.g. 1 for the children
+// only; all the descendants are returned if it is 0.
+func (t *Tree) Descendants(ctx context.Context, id interface{}, depth int, results interface{}) error {
+	return t.aggregate(ctx, results, t.descendantsPipeline(id, depth, bson.M{depthField: 0})...)
+}
+
+// Children decodes the children of the document into results, which must be a pointer to a slice.
+func (t *Tree) Children(ctx context.Context, id interface{}, results interface{}) error {
+	return t.aggregate(ctx, results,
+		builder.New(o.Match, bson.M{t.parentField: id}),
+		bson.M{o.Sort: bson.D{{Key: f.ID, Value: 1}}},
+	)
+}
+
+func (t *Tree) descendantsPipeline(id interface{}, depth int, projection bson.M) []interface{} {
+	opts := builder.GraphLookupOptions{
+		From:             t.coll.Name(),
+		StartWith:        "$" + f.ID,
+		ConnectFromField: f.ID,
+		ConnectToField:   t.parentField,
+		As:               nodesField,
+		DepthField:       depthField,
+	}
+	if depth > 0 {
+		opts.MaxDepth = depth - 1
+	}
+	return []interface{}{
+		builder.New(o.Match, bson.M{f.ID: id}),
+		builder.GraphLookup(opts),
+		builder.Unwind("$"+nodesField, nil, nil),
+		builder.ReplaceRoot("$" + nodesField),
+		bson.M{o.Sort: bson.D{{Key: depthField, Value: 1}, {Key: f.ID, Value: 1}}},
+		bson.M{o.Project: projection},
+	}
+}
+
+func (t *Tree) aggregate(ctx context.Context, results interface{}, stages ...interface{}) error {
+	pipeline := bson.A{}
+	for _, stage := range stages {
+		if operator, ok := stage.(builder.Operator); ok {
+			stage = builder.S(operator)
+		}
+		pipeline = append(pipeline, stage)
+	}
+
+	cur, err := t.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return cur.All(ctx, results)
+}
+
+// node contains the hierarchy fields of a document.
+type node struct {
+	ID     interface{} `bson:"_id"`
+	Parent interface{} `bson:"parent"`
+	Depth  int64       `bson:"depth"`
+}
+
+// nodes returns the hierarchy fields of the documents of a pipeline.
+func (t *Tree) nodes(ctx context.Context, stages ...interface{}) ([]node, error) {
+	stages = append(stages, bson.M{o.Project: bson.M{f.ID: 1, "parent": "$" + t.parentField, "depth": "$" + depthField}})
+	var nodes []node
+	err := t.aggregate(ctx, &nodes, stages...)
+	return nodes, err
+}
+
+// Path returns the materialized path of a new child of the parent, or of a root document if
+// the parent id is nil, e.g. to set the path field of a document before creating it.
+func (t *Tree) Path(ctx context.Context, parentID interface{}) (string, error) {
+	if parentID == nil {
+		return Separator, nil
+	}
+
+	found, err := t.nodes(ctx, builder.New(o.Match, bson.M{f.ID: parentID}))
+	if err != nil {
+		return "", err
+	}
+	if len(found) == 0 {
+		return "", fmt.Errorf("%w: %v", ErrNotFound, parentID)
+	}
+
+	var ancestors []node
+	if err = t.Ancestors(ctx, parentID, &ancestors); err != nil {
+		return "", err
+	}
+	ids := make([]string, 0, len(ancestors)+1)
+	for _, a := range ancestors {
+		ids = append(ids, idString(a.ID))
+	}
+	ids = append(ids, idString(parentID))
+	return Separator + strings.Join(ids, Separator) + Separator, nil
+}
+
+// Move moves the document and its subtree under a new parent, or to the root if the parent id
+// is nil, and updates the materialized paths of the subtree. It returns ErrCycle if the parent
+// is the document or one of its descendants.
+//
+// The updates are not atomic: run Move in a transaction (using its context) to update all the
+// documents or none.
+func (t *Tree) Move(ctx context.Context, id, parentID interface{}) error {
+	self, err := t.nodes(ctx, builder.New(o.Match, bson.M{f.ID: id}))
+	if err != nil {
+		return err
+	}
+	if len(self) == 0 {
+		return fmt.Errorf("%w: %v", ErrNotFound, id)
+	}
+
+	var descendants []node
+	if parentID != nil || t.pathField != "" {
+		if descendants, err = t.nodes(ctx, t.descendantsPipeline(id, 0, bson.M{f.ID: 1, t.parentField: 1, depthField: 1})...); err != nil {
+			return err
+		}
+	}
+	if parentID != nil {
+		if equal(parentID, id) {
+			return ErrCycle
+		}
+		for _, d := range descendants {
+			if equal(d.ID, parentID) {
+				return ErrCycle
+			}
+		}
+	}
+
+	set := bson.D{{Key: t.parentField, Value: parentID}}
+	if t.pathField == "" {
+		_, err = mdu.NewBulk(t.coll).Write(
+			mongo.NewUpdateOneModel().SetFilter(bson.M{f.ID: id}).SetUpdate(bson.M{o.Set: set}),
+		).RunWithCtx(ctx)
+		return err
+	}
+
+	path, err := t.Path(ctx, parentID)
+	if err != nil {
+		return err
+	}
+	bulk := mdu.NewBulk(t.coll).Write(
+		mongo.NewUpdateOneModel().SetFilter(bson.M{f.ID: id}).SetUpdate(bson.M{o.Set: append(set, bson.E{Key: t.pathField, Value: path})}),
+	)
+	paths := map[string]string{idString(id): path + idString(id) + Separator}
+	for _, d := range descendants {
+		// Descendants are sorted by depth, so the path of their parent is known.
+		parentPath := paths[idString(d.Parent)]
+		paths[idString(d.ID)] = parentPath + idString(d.ID) + Separator
+		bulk.Write(mongo.NewUpdateOneModel().SetFilter(bson.M{f.ID: d.ID}).SetUpdate(bson.M{o.Set: bson.M{t.pathField: parentPath}}))
+	}
+	_, err = bulk.RunWithCtx(ctx)
+	return err
+}
+
+// RebuildPaths recomputes the materialized paths of all the documents from their parent
+// references. Documents whose parent does not exist are roots. It returns ErrCycle if the
+// parent references contain a cycle.
+func (t *Tree) RebuildPaths(ctx context.Context) error {
+	if t.pathField == "" {
+		return errors.New("tree: the tree has no path field")
+	}
+
+	all, err := t.nodes(ctx)
+	if err != nil {
+		return err
+	}
+	parents := make(map[string]interface{}, len(all))
+	for _, n := range all {
+		parents[idString(n.ID)] = n.Parent
+	}
+
+	paths := make(map[string]string, len(all))
+	var pathOf func(id string, visiting map[string]bool) (string, error)
+	pathOf = func(id string, visiting map[string]bool) (string, error) {
+		if path, ok := paths[id]; ok {
+			return path, nil
+		}
+		if visiting[id] {
+			return "", ErrCycle
+		}
+		visiting[id] = true
+
+		path := Separator
+		if parent := parents[id]; parent != nil {
+			if _, ok := parents[idString(parent)]; ok {
+				parentPath, err := pathOf(idString(parent), visiting)
+				if err != nil {
+					return "", err
+				}
+				path = parentPath + idString(parent) + Separator
+			}
+		}
+		paths[id] = path
+		return path, nil
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return idString(all[i].ID) < idString(all[j].ID)
+	})
+	bulk := mdu.NewBulk(t.coll)
+	for _, n := range all {
+		path, err := pathOf(idString(n.ID), map[string]bool{})
+		if err != nil {
+			return err
+		}
+		bulk.Write(mongo.NewUpdateOneModel().SetFilter(bson.M{f.ID: n.ID}).SetUpdate(bson.M{o.Set: bson.M{t.pathField: path}}))
+	}
+	if bulk.Len() == 0 {
+		return nil
+	}
+	_, err = bulk.RunWithCtx(ctx)
+	return err
+}
+
+// SubtreeFilter returns the filter of the descendants of the document of the given materialized
+// path and id, which uses an index of the path field.
+func (t *Tree) SubtreeFilter(path string, id interface{}) bson.M {
+	prefix := path + idString(id) + Separator
+	return bson.M{t.pathField: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}}
+}
+
+// idString returns the string of an id in materialized paths.
+func idString(id interface{}) string {
+	switch v := id.(type) {
+	case string:
+		return v
+	case primitive.ObjectID:
+		return v.Hex()
+	}
+	return fmt.Sprint(id)
+}
+
+func equal(a, b interface{}) bool {
+	return idString(a) == idString(b)
+}
@@ -0,0 +1,168 @@
+package tree
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/softwok/mongo-util/internal/util"
+	"github.com/softwok/mongo-util/mdu"
+	"github.com/softwok/mongo-util/mdu/mdutest"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type category struct {
+	ID     string      `bson:"_id"`
+	Name   string      `bson:"name"`
+	Parent interface{} `bson:"parent_id"`
+	Path   string      `bson:"path,omitempty"`
+}
+
+// newTree returns a tree of categories:
+//
+//	root
+//	├── a
+//	│   ├── a1
+//	│   └── a2
+//	│       └── a2x
+//	└── b
+func newTree(t *testing.T, opts *Options) (*Tree, *mdutest.Collection) {
+	coll := mdutest.NewCollection("categories")
+	var writes []mongo.WriteModel
+	for _, c := range []category{
+		{ID: "root"},
+		{ID: "a", Parent: "root"},
+		{ID: "b", Parent: "root"},
+		{ID: "a1", Parent: "a"},
+		{ID: "a2", Parent: "a"},
+		{ID: "a2x", Parent: "a2"},
+	} {
+		c.Name = "Category " + c.ID
+		writes = append(writes, mongo.NewInsertOneModel().SetDocument(c))
+	}
+	_, err := mdu.NewBulk(coll).Write(writes...).RunWithCtx(context.Background())
+	util.AssertErrIsNil(t, err)
+	return New(coll, opts), coll
+}
+
+func ids(categories []category) []string {
+	result := make([]string, 0, len(categories))
+	for _, c := range categories {
+		result = append(result, c.ID)
+	}
+	return result
+}
+
+func paths(t *testing.T, coll *mdutest.Collection) map[string]string {
+	var all []category
+	util.AssertErrIsNil(t, coll.FindAll(&all, bson.M{}))
+	result := map[string]string{}
+	for _, c := range all {
+		result[c.ID] = c.Path
+	}
+	return result
+}
+
+func TestAncestors(t *testing.T) {
+	tree, _ := newTree(t, nil)
+	ctx := context.Background()
+
+	var ancestors []category
+	util.AssertErrIsNil(t, tree.Ancestors(ctx, "a2x", &ancestors))
+	assert.Equal(t, []string{"root", "a", "a2"}, ids(ancestors))
+	assert.Equal(t, "Category root", ancestors[0].Name)
+
+	ancestors = nil
+	util.AssertErrIsNil(t, tree.Ancestors(ctx, "root", &ancestors))
+	assert.Empty(t, ancestors)
+}
+
+func TestDescendantsAndChildren(t *testing.T) {
+	tree, _ := newTree(t, nil)
+	ctx := context.Background()
+
+	var descendants []category
+	util.AssertErrIsNil(t, tree.Descendants(ctx, "root", 0, &descendants))
+	assert.Equal(t, []string{"a", "b", "a1", "a2", "a2x"}, ids(descendants))
+
+	descendants = nil
+	util.AssertErrIsNil(t, tree.Descendants(ctx, "root", 2, &descendants))
+	assert.Equal(t, []string{"a", "b", "a1", "a2"}, ids(descendants))
+
+	var children []category
+	util.AssertErrIsNil(t, tree.Children(ctx, "a", &children))
+	assert.Equal(t, []string{"a1", "a2"}, ids(children))
+}
+
+func TestPathAndRebuildPaths(t *testing.T) {
+	tree, coll := newTree(t, &Options{PathField: "path"})
+	ctx := context.Background()
+
+	path, err := tree.Path(ctx, "a2")
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, ",root,a,a2,", path)
+
+	path, err = tree.Path(ctx, nil)
+	util.AssertErrIsNil(t, err)
+	assert.Equal(t, Separator, path)
+
+	_, err = tree.Path(ctx, "missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	util.AssertErrIsNil(t, tree.RebuildPaths(ctx))
+	assert.Equal(t, map[string]string{
+		"root": ",",
+		"a":    ",root,",
+		"b":    ",root,",
+		"a1":   ",root,a,",
+		"a2":   ",root,a,",
+		"a2x":  ",root,a,a2,",
+	}, paths(t, coll))
+
+	var subtree []category
+	util.AssertErrIsNil(t, coll.FindAll(&subtree, tree.SubtreeFilter(",root,", "a")))
+	assert.ElementsMatch(t, []string{"a1", "a2", "a2x"}, ids(subtree))
+}
+
+func TestMove(t *testing.T) {
+	tree, coll := newTree(t, &Options{PathField: "path"})
+	ctx := context.Background()
+	util.AssertErrIsNil(t, tree.RebuildPaths(ctx))
+
+	util.AssertErrIsNil(t, tree.Move(ctx, "a2", "b"))
+	assert.Equal(t, map[string]string{
+		"root": ",",
+		"a":    ",root,",
+		"b":    ",root,",
+		"a1":   ",root,a,",
+		"a2":   ",root,b,",
+		"a2x":  ",root,b,a2,",
+	}, paths(t, coll))
+
+	var children []category
+	util.AssertErrIsNil(t, tree.Children(ctx, "b", &children))
+	assert.Equal(t, []string{"a2"}, ids(children))
+
+	util.AssertErrIsNil(t, tree.Move(ctx, "b", nil))
+	assert.Equal(t, ",", paths(t, coll)["b"])
+	assert.Equal(t, ",b,a2,", paths(t, coll)["a2x"])
+
+	assert.Equal(t, ErrCycle, tree.Move(ctx, "b", "a2x"))
+	assert.Equal(t, ErrCycle, tree.Move(ctx, "b", "b"))
+	assert.True(t, errors.Is(tree.Move(ctx, "missing", "b"), ErrNotFound))
+}
+
+func TestMoveWithoutPaths(t *testing.T) {
+	tree, coll := newTree(t, nil)
+	ctx := context.Background()
+
+	util.AssertErrIsNil(t, tree.Move(ctx, "a", "b"))
+	var ancestors []category
+	util.AssertErrIsNil(t, tree.Ancestors(ctx, "a2x", &ancestors))
+	assert.Equal(t, []string{"root", "b", "a", "a2"}, ids(ancestors))
+	assert.Equal(t, "", paths(t, coll)["a"])
+
+	assert.Equal(t, ErrCycle, tree.Move(ctx, "root", "a1"))
+}
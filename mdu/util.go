@@ -1,8 +1,6 @@
 package mdu
 
 import (
-	"github.com/jinzhu/inflection"
-	"github.com/softwok/mongo-util/internal/util"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"reflect"
 )
@@ -19,16 +17,22 @@ func Coll(m Model, opts ...*options.CollectionOptions) *Collection {
 
 // CollName returns a model's collection name. The `CollectionNameGetter` will be used
 // if the model implements this interface. Otherwise, the collection name is inferred
-// based on the model's type using reflection.
+// based on the model's type by the configured `NamingStrategy`, and cached (see `Lookup`).
 func CollName(m Model) string {
 
 	if collNameGetter, ok := m.(CollectionNameGetter); ok {
 		return collNameGetter.CollectionName()
 	}
 
-	name := reflect.TypeOf(m).Elem().Name()
+	return Lookup(m).Collection
+}
+
+func inferCollName(m Model) string {
+	if collNameGetter, ok := m.(CollectionNameGetter); ok {
+		return collNameGetter.CollectionName()
+	}
 
-	return inflection.Plural(util.ToLowerCamelCase(name))
+	return naming().CollectionName(reflect.TypeOf(m).Elem())
 }
 
 // UpsertTrueOption returns new instance of UpdateOptions with the upsert property set to true.
@@ -55,9 +55,9 @@ func setup() {
 }
 
 func resetCollection() {
-	_, err := Coll(&PurchaseOrder{}).DeleteMany(Ctx(), bson.M{})
-	_, err = Coll(&purchaseOrder{}).DeleteMany(Ctx(), bson.M{})
-	_, err = Coll(&purchase_Order{}).DeleteMany(Ctx(), bson.M{})
+	_, err := Coll(&PurchaseOrder{}).Collection.DeleteMany(Ctx(), bson.M{})
+	_, err = Coll(&purchaseOrder{}).Collection.DeleteMany(Ctx(), bson.M{})
+	_, err = Coll(&purchase_Order{}).Collection.DeleteMany(Ctx(), bson.M{})
 
 	util.PanicErr(err)
 }
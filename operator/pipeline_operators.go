@@ -22,6 +22,7 @@ const (
 
 // Array Expression Operators
 const (
+	ArrayElemAt   = "$arrayElemAt"
 	ArrayToObject = "$arrayToObject"
 	ConcatArrays  = "$concatArrays"
 	Filter        = "$filter"
@@ -192,6 +193,32 @@ const (
 // Sum        = "$sum" // Declared
 )
 
+// Window Operators ($setWindowFields)
+const (
+	CovariancePop  = "$covariancePop"
+	CovarianceSamp = "$covarianceSamp"
+	DenseRank      = "$denseRank"
+	Derivative     = "$derivative"
+	DocumentNumber = "$documentNumber"
+	ExpMovingAvg   = "$expMovingAvg"
+	Integral       = "$integral"
+	LinearFill     = "$linearFill"
+	Locf           = "$locf"
+	Rank           = "$rank"
+	Shift          = "$shift"
+	// AddToSet   = "$addToSet" // Declared
+	// Avg        = "$avg" // Declared
+	// Count      = "$count" // Declared
+	// First      = "$first" // Declared
+	// Last       = "$last" // Declared
+	// Max        = "$max" // Declared
+	// Min        = "$min" // Declared
+	// Push       = "$push" // Declared
+	// StdDevPop  = "$stdDevPop" // Declared
+	// StdDevSamp = "$stdDevSamp" // Declared
+	// Sum        = "$sum" // Declared
+)
+
 // Variable Expression Operators
 const (
 	Let = "$let"
@@ -24,12 +24,16 @@ const (
 	ReplaceRoot    = "$replaceRoot"
 	ReplaceWith    = "$replaceWith"
 	Sample         = "$sample"
+	Search         = "$search"
+	SearchMeta     = "$searchMeta"
 	// Set            = "$set" // Declared
-	Skip = "$skip"
+	SetWindowFields = "$setWindowFields"
+	Skip            = "$skip"
 	// Sort           = "$sort" // Declared
 	SortByCount = "$sortByCount"
 	// Unset          = "$unset" // Declared
-	Unwind = "$unwind"
+	Unwind       = "$unwind"
+	VectorSearch = "$vectorSearch"
 )
 
 // DB Aggregate stages
@@ -44,6 +44,17 @@ const (
 	NearSphere    = "$nearSphere"
 )
 
+// Geometry specifiers
+const (
+	Box          = "$box"
+	Center       = "$center"
+	CenterSphere = "$centerSphere"
+	Geometry     = "$geometry"
+	MaxDistance  = "$maxDistance"
+	MinDistance  = "$minDistance"
+	Polygon      = "$polygon"
+)
+
 // Array
 const (
 	All       = "$all"